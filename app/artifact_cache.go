@@ -0,0 +1,430 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/installer"
+	"github.com/mendersoftware/mender/store"
+)
+
+const (
+	artifactCachePath       = "/artifacts/"
+	artifactSourceHeader    = "X-Mender-Artifact-Source"
+	defaultArtifactCacheMiB = 4096
+)
+
+// cachedArtifact is an artifact kept by the cache, as stored.
+type cachedArtifact struct {
+	Name     string    `json:"name"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// artifactCache downloads artifacts for the other devices on the site, and
+// keeps those which verify, so that each is only downloaded once.
+type artifactCache struct {
+	dir     string
+	maxSize int64
+	keys    []*conf.VerificationKey
+	// The hosts, as in URLs, artifacts may be downloaded from.
+	sourceHosts []string
+	upstream    client.ApiRequester
+	store       store.Store
+
+	// Guards the index of the artifacts kept.
+	lock sync.Mutex
+	// Artifacts are downloaded one at a time, so that a device asking for
+	// an artifact which is being downloaded gets the one kept.
+	fetchLock sync.Mutex
+}
+
+func newArtifactCache(config *conf.MenderConfig, s store.Store) (*artifactCache, error) {
+	keys := config.GetVerificationKeys()
+	if len(keys) == 0 {
+		return nil, errors.New("the artifact cache needs ArtifactVerifyKeys to verify " +
+			"the artifacts with")
+	}
+	upstream, err := client.NewApiClient(artifactCacheHttpConfig(config))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating HTTP client for the artifact cache")
+	}
+	c := &artifactCache{
+		dir:         artifactCacheDir(config),
+		maxSize:     int64(config.ArtifactCache.MaxSizeMiB) * 1024 * 1024,
+		keys:        keys,
+		sourceHosts: artifactSourceHosts(config),
+		upstream:    upstream,
+		store:       s,
+	}
+	if c.maxSize == 0 {
+		c.maxSize = defaultArtifactCacheMiB * 1024 * 1024
+	}
+	return c, nil
+}
+
+func artifactCacheDir(config *conf.MenderConfig) string {
+	if config.ArtifactCache.Dir != "" {
+		return config.ArtifactCache.Dir
+	}
+	return filepath.Join(conf.GetStateDirPath(), "artifact-cache")
+}
+
+// artifactCacheHttpConfig is the configuration of the connections to the
+// servers, without the credentials of the device. The cache downloads on
+// behalf of other devices, which must not get the identity of this one.
+func artifactCacheHttpConfig(config *conf.MenderConfig) conf.HttpConfig {
+	httpConfig := config.GetHttpConfig()
+	httpConfig.HttpsClient = nil
+	httpConfig.MQTT = nil
+	if httpConfig.Security != nil {
+		security := *httpConfig.Security
+		security.PSKIdentity = ""
+		security.PSKKeyFile = ""
+		security.RequestSigningKey = ""
+		httpConfig.Security = &security
+	}
+	return httpConfig
+}
+
+// artifactSourceHosts returns the hosts of the servers, and the other hosts
+// of the configuration artifacts may be downloaded from.
+func artifactSourceHosts(config *conf.MenderConfig) []string {
+	var hosts []string
+	for _, server := range config.Servers {
+		if u, err := url.Parse(server.ServerURL); err == nil && u.Host != "" {
+			hosts = append(hosts, strings.ToLower(u.Host))
+		}
+	}
+	for _, host := range config.ArtifactCache.SourceHosts {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	return hosts
+}
+
+// checkSource fails unless the artifact source is an HTTP or HTTPS URL on one
+// of the source hosts. Otherwise anyone who can reach the cache could make it
+// send requests anywhere.
+func (c *artifactCache) checkSource(source string) error {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.Errorf("invalid artifact source %q", source)
+	}
+	host := strings.ToLower(u.Host)
+	for _, allowed := range c.sourceHosts {
+		if allowed == host || allowed == strings.ToLower(u.Hostname()) {
+			return nil
+		}
+	}
+	return errors.Errorf("the artifact source %s is not a server, nor one of "+
+		"ArtifactCache.SourceHosts", u.Host)
+}
+
+// cacheFileName is the file an artifact is kept in, which doesn't depend on
+// what its name contains.
+func cacheFileName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:]) + ".mender"
+}
+
+func (c *artifactCache) loadIndex() ([]cachedArtifact, error) {
+	data, err := c.store.ReadAll(datastore.ArtifactCacheIndexKey)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var index []cachedArtifact
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "invalid artifact cache index")
+	}
+	return index, nil
+}
+
+func (c *artifactCache) storeIndex(index []cachedArtifact) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return c.store.WriteAll(datastore.ArtifactCacheIndexKey, data)
+}
+
+// lookup returns the artifact kept with the name, nil if there is none, and
+// marks it as used.
+func (c *artifactCache) lookup(name string) *cachedArtifact {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		log.Errorf("Failed to read the artifact cache: %s", err.Error())
+		return nil
+	}
+	for n := range index {
+		if index[n].Name == name {
+			index[n].LastUsed = time.Now()
+			if err = c.storeIndex(index); err != nil {
+				log.Warnf("Failed to update the artifact cache: %s", err.Error())
+			}
+			artifact := index[n]
+			return &artifact
+		}
+	}
+	return nil
+}
+
+// add keeps the artifact, and removes the least recently used others while
+// the artifacts take more than the space given.
+func (c *artifactCache) add(artifact cachedArtifact) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return err
+	}
+	kept := []cachedArtifact{artifact}
+	for _, other := range index {
+		if other.Name != artifact.Name {
+			kept = append(kept, other)
+		}
+	}
+	sort.SliceStable(kept[1:], func(i, j int) bool {
+		return kept[1+i].LastUsed.After(kept[1+j].LastUsed)
+	})
+	var removed []cachedArtifact
+	size := artifact.Size
+	for n := 1; n < len(kept); n++ {
+		size += kept[n].Size
+		if size > c.maxSize {
+			removed = kept[n:]
+			kept = kept[:n]
+			break
+		}
+	}
+	if err = c.storeIndex(kept); err != nil {
+		return err
+	}
+	for _, old := range removed {
+		log.Infof("Removing the artifact %s from the cache", old.Name)
+		os.Remove(filepath.Join(c.dir, old.File))
+	}
+	return nil
+}
+
+// fetch downloads the artifact from the source, and keeps it if it verifies
+// and has the name expected.
+func (c *artifactCache) fetch(ctx context.Context, name, source string) error {
+	c.fetchLock.Lock()
+	defer c.fetchLock.Unlock()
+	if c.lookup(name) != nil {
+		return nil
+	}
+	if err := c.checkSource(source); err != nil {
+		return err
+	}
+	log.Infof("Downloading the artifact %s to the cache", name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := c.upstream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected response %s", rsp.Status)
+	}
+
+	if err = os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(c.dir, "download-*.mender")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	size, err := io.Copy(file, rsp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to download the artifact")
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	actual, err := installer.VerifyArtifact(file, c.keys)
+	if err != nil {
+		return err
+	} else if actual != name {
+		return errors.Errorf("the artifact downloaded is %s, not %s", actual, name)
+	}
+	artifact := cachedArtifact{
+		Name:     name,
+		File:     cacheFileName(name),
+		Size:     size,
+		LastUsed: time.Now(),
+	}
+	if err = os.Rename(file.Name(), filepath.Join(c.dir, artifact.File)); err != nil {
+		return err
+	}
+	return c.add(artifact)
+}
+
+// artifactCacheHandler serves the artifacts kept at their names, and
+// downloads those which aren't from the URL in the source header first.
+func artifactCacheHandler(c *artifactCache) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(artifactCachePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, artifactCachePath)
+		artifact := c.lookup(name)
+		source := r.Header.Get(artifactSourceHeader)
+		if artifact == nil && r.Method == http.MethodGet && source != "" {
+			if err := c.fetch(r.Context(), name, source); err != nil {
+				log.Errorf("Failed to cache the artifact %s: %s", name, err.Error())
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			artifact = c.lookup(name)
+		}
+		if artifact == nil {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := os.Open(filepath.Join(c.dir, artifact.File))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		log.Infof("Serving the artifact %s from the cache to %s", name, r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, file)
+	})
+	return mux
+}
+
+// artifactCacheListener serves the artifact cache to the other devices on
+// the site.
+type artifactCacheListener struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+func newArtifactCacheListener(
+	config *conf.MenderConfig,
+	s store.Store,
+) (*artifactCacheListener, error) {
+	cache, err := newArtifactCache(config, s)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", config.ArtifactCache.ListenAddress)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the artifact cache listener")
+	}
+	return &artifactCacheListener{
+		listener: listener,
+		server: &http.Server{
+			Handler:           artifactCacheHandler(cache),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+func (l *artifactCacheListener) Start() {
+	log.Infof("Serving the artifact cache on %s", l.listener.Addr())
+	go func() {
+		err := l.server.Serve(l.listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Artifact cache listener failed: %s", err.Error())
+		}
+	}()
+}
+
+func (l *artifactCacheListener) Stop() {
+	if err := l.server.Close(); err != nil {
+		log.Warnf("Failed to close the artifact cache listener: %s", err.Error())
+	}
+}
+
+// fetchThroughCache fetches the artifact through the artifact cache of the
+// site, nil if that fails.
+func (m *Mender) fetchThroughCache(
+	ctx context.Context,
+	update *datastore.UpdateInfo,
+) (io.ReadCloser, int64) {
+	image, size, err := m.fetchFromCache(ctx, update)
+	if err != nil {
+		log.Warnf("Failed to fetch the artifact through the cache at %s, "+
+			"fetching it from the server: %s", m.Config.ArtifactCacheURL, err.Error())
+		return nil, -1
+	}
+	log.Infof("Fetching the artifact %s through the cache at %s",
+		update.ArtifactName(), m.Config.ArtifactCacheURL)
+	return image, size
+}
+
+// fetchFromCache asks the cache for the artifact, giving the URL the cache
+// downloads it from if it doesn't have it yet. The signature and the
+// checksums of the payloads are verified when it is installed, like those of
+// artifacts from the server.
+func (m *Mender) fetchFromCache(
+	ctx context.Context,
+	update *datastore.UpdateInfo,
+) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(m.Config.ArtifactCacheURL, "/")+artifactCachePath+
+			url.PathEscape(update.ArtifactName()), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set(artifactSourceHeader, update.URI())
+	rsp, err := m.download.Do(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		rsp.Body.Close()
+		return nil, -1, errors.Errorf("unexpected response %s", rsp.Status)
+	}
+	return rsp.Body, rsp.ContentLength, nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
+)
+
+// newArtifactServer serves a signed and an unsigned artifact, named
+// "TestName", and counts the requests for them.
+func newArtifactServer(t *testing.T, requests *int32) (*httptest.Server, []byte) {
+	art, err := MakeRootfsImageArtifact(3, true)
+	require.NoError(t, err)
+	signed, err := ioutil.ReadAll(art)
+	require.NoError(t, err)
+	art, err = MakeRootfsImageArtifact(3, false)
+	require.NoError(t, err)
+	unsigned, err := ioutil.ReadAll(art)
+	require.NoError(t, err)
+
+	serve := func(artifact []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(requests, 1)
+			w.Header().Set("Content-Length", strconv.Itoa(len(artifact)))
+			w.Write(artifact)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/signed", serve(signed))
+	mux.Handle("/unsigned", serve(unsigned))
+	return httptest.NewServer(mux), signed
+}
+
+func TestArtifactCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "artifact-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	var requests int32
+	upstream, signed := newArtifactServer(t, &requests)
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	cache := &artifactCache{
+		dir:         tempDir,
+		maxSize:     defaultArtifactCacheMiB * 1024 * 1024,
+		keys:        []*conf.VerificationKey{{Data: []byte(PublicRSAKey)}},
+		sourceHosts: []string{upstreamURL.Host},
+		upstream:    http.DefaultClient,
+		store:       store.NewMemStore(),
+	}
+	srv := httptest.NewServer(artifactCacheHandler(cache))
+	defer srv.Close()
+
+	get := func(name, source string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+artifactCachePath+name, nil)
+		require.NoError(t, err)
+		if strings.HasPrefix(source, "/") {
+			source = upstream.URL + source
+		}
+		if source != "" {
+			req.Header.Set(artifactSourceHeader, source)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		data, err := ioutil.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		return rsp.StatusCode, data
+	}
+
+	// The artifact is downloaded once, and served from the cache after.
+	status, data := get("TestName", "/signed")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, signed, data)
+	status, data = get("TestName", "/signed")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, signed, data)
+	status, _ = get("TestName", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	status, _ = get("release-2", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Artifacts which don't verify, or aren't the one asked for, aren't
+	// kept.
+	status, _ = get("release-2", "/unsigned")
+	assert.Equal(t, http.StatusBadGateway, status)
+	status, _ = get("release-2", "/signed")
+	assert.Equal(t, http.StatusBadGateway, status)
+
+	// Nor is anything downloaded from other hosts than the servers.
+	var otherRequests int32
+	other, _ := newArtifactServer(t, &otherRequests)
+	defer other.Close()
+	status, _ = get("release-2", other.URL+"/signed")
+	assert.Equal(t, http.StatusBadGateway, status)
+	status, _ = get("release-2", "file:///etc/passwd")
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&otherRequests))
+
+	index, err := cache.loadIndex()
+	require.NoError(t, err)
+	require.Len(t, index, 1)
+	assert.Equal(t, "TestName", index[0].Name)
+	files, err := ioutil.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestArtifactCacheRemovesLeastRecentlyUsed(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "artifact-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cache := &artifactCache{dir: tempDir, maxSize: 100, store: store.NewMemStore()}
+	add := func(name string, size int64, lastUsed time.Time) {
+		artifact := cachedArtifact{
+			Name:     name,
+			File:     cacheFileName(name),
+			Size:     size,
+			LastUsed: lastUsed,
+		}
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, artifact.File), nil, 0600))
+		require.NoError(t, cache.add(artifact))
+	}
+	now := time.Now()
+	add("release-1", 40, now.Add(-3*time.Hour))
+	add("release-2", 40, now.Add(-time.Hour))
+	add("release-3", 40, now.Add(-2*time.Hour))
+	add("release-4", 40, now)
+
+	assert.Nil(t, cache.lookup("release-1"))
+	assert.Nil(t, cache.lookup("release-3"))
+	assert.NotNil(t, cache.lookup("release-2"))
+	assert.NotNil(t, cache.lookup("release-4"))
+	_, err = os.Stat(filepath.Join(tempDir, cacheFileName("release-3")))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tempDir, cacheFileName("release-2")))
+	assert.NoError(t, err)
+}
+
+func TestMenderFetchUpdateThroughCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "artifact-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	var requests int32
+	upstream, signed := newArtifactServer(t, &requests)
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+	cache := &artifactCache{
+		dir:         tempDir,
+		maxSize:     defaultArtifactCacheMiB * 1024 * 1024,
+		keys:        []*conf.VerificationKey{{Data: []byte(PublicRSAKey)}},
+		sourceHosts: []string{upstreamURL.Host},
+		upstream:    http.DefaultClient,
+		store:       store.NewMemStore(),
+	}
+	srv := httptest.NewServer(artifactCacheHandler(cache))
+	defer srv.Close()
+
+	for _, cacheURL := range []string{srv.URL, "http://127.0.0.1:1"} {
+		mender := newTestMender(conf.MenderConfig{
+			MenderConfigFromFile: conf.MenderConfigFromFile{
+				ArtifactCacheURL: cacheURL,
+			},
+		}, testMenderPieces{})
+		update := updateFrom(upstream.URL + "/signed")
+		update.Artifact.ArtifactName = "TestName"
+		image, _, err := mender.FetchUpdate(update)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(image)
+		image.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, signed, data)
+	}
+	// Fetched through the cache first, and from the server when the cache
+	// can't be reached.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.NotNil(t, cache.lookup("TestName"))
+}
+
+func TestNewArtifactCache(t *testing.T) {
+	config := conf.NewMenderConfig()
+	config.Servers = []conf.MenderServer{{ServerURL: "https://Hosted.mender.io"}}
+	config.ArtifactCache.SourceHosts = []string{"storage.example.com:9000"}
+	config.HttpsClient = conf.HttpsClient{
+		Certificate: "/var/lib/mender/client.crt",
+		Key:         "/var/lib/mender/client.key",
+	}
+	config.Security.PSKIdentity = "device"
+	config.Security.PSKKeyFile = "/var/lib/mender/psk"
+	config.Security.MinTLSVersion = "1.3"
+
+	// Not without keys to verify the artifacts with.
+	_, err := newArtifactCache(config, store.NewMemStore())
+	assert.Error(t, err)
+
+	config.ArtifactVerifyKeys = []string{"/etc/mender/artifact-verify-key.pem"}
+	cache := &artifactCache{sourceHosts: artifactSourceHosts(config)}
+	for source, allowed := range map[string]bool{
+		"https://hosted.mender.io/artifact":          true,
+		"https://storage.example.com:9000/artifact":  true,
+		"https://storage.example.com/artifact":       false,
+		"https://evil.example.com/artifact":          false,
+		"http://169.254.169.254/latest/meta-data/":   false,
+		"ftp://hosted.mender.io/artifact":            false,
+		"https://hosted.mender.io@evil.example.com/": false,
+	} {
+		assert.Equal(t, allowed, cache.checkSource(source) == nil, source)
+	}
+
+	// The artifacts are downloaded without the credentials of the device.
+	httpConfig := artifactCacheHttpConfig(config)
+	assert.Nil(t, httpConfig.HttpsClient)
+	assert.Empty(t, httpConfig.Security.PSKKeyFile)
+	assert.Equal(t, "1.3", httpConfig.Security.MinTLSVersion)
+	assert.Equal(t, "/var/lib/mender/psk", config.Security.PSKKeyFile)
+}
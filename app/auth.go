@@ -15,6 +15,11 @@
 package app
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
@@ -30,18 +35,23 @@ import (
 	"github.com/mendersoftware/mender/dbus"
 	"github.com/mendersoftware/mender/device"
 	"github.com/mendersoftware/mender/store"
+	"github.com/mendersoftware/mender/system"
 )
 
 // Constants for auth manager request actions
 const (
 	ActionFetchAuthToken = "FETCH_AUTH_TOKEN"
 	ActionGetAuthToken   = "GET_AUTH_TOKEN"
+	ActionSetTenantToken = "SET_TENANT_TOKEN"
+	ActionReauthorize    = "REAUTHORIZE"
 )
 
 // Constants for auth manager response events
 const (
 	EventFetchAuthToken       = "FETCH_AUTH_TOKEN"
 	EventGetAuthToken         = "GET_AUTH_TOKEN"
+	EventSetTenantToken       = "SET_TENANT_TOKEN"
+	EventReauthorize          = "REAUTHORIZE"
 	EventAuthTokenStateChange = "AUTH_TOKEN_STATE_CHANGE"
 )
 
@@ -60,6 +70,13 @@ const (
 		<method name="FetchJwtToken">
 			<arg type="b" name="success" direction="out"/>
 		</method>
+		<method name="SetTenantToken">
+			<arg type="s" name="token" direction="in"/>
+			<arg type="b" name="success" direction="out"/>
+		</method>
+		<method name="Reauthorize">
+			<arg type="b" name="success" direction="out"/>
+		</method>
 		<signal name="JwtTokenStateChange">
 			<arg type="s" name="token"/>
 			<arg type="s" name="server_url"/>
@@ -79,10 +96,24 @@ const (
 	authManagerWorkerQueueSize = 1
 )
 
+var errClockNotSynchronized = errors.New("waiting for the system clock to be synchronized")
+
+// The entries of the authorization state which are sealed, when
+// SealAuthState is enabled.
+var sealedAuthEntries = []string{
+	datastore.AuthServerURLKey,
+	datastore.TenantTokenKey,
+	datastore.OIDCRefreshTokenKey,
+	datastore.BootstrapTokenUsedKey,
+	datastore.AuthRejectionsKey,
+}
+
 // AuthManagerRequest stores a request to the Mender authorization manager
 type AuthManagerRequest struct {
 	Action          string
 	ResponseChannel chan<- AuthManagerResponse
+	// The new tenant token, for ActionSetTenantToken.
+	TenantToken client.AuthToken
 }
 
 // AuthManagerResponse stores a response from the Mender authorization manager
@@ -107,6 +138,11 @@ type AuthManager interface {
 	HasKey() bool
 	// generate device key (will overwrite an already existing key)
 	GenerateKey() error
+	// generate a new device key, which replaces the current one once the
+	// server accepts it
+	RotateKey() error
+	// sign a payload with the device key
+	SignPayload(payload []byte) ([]byte, error)
 
 	client.AuthDataMessenger
 }
@@ -143,6 +179,40 @@ type menderAuthManagerService struct {
 	authToken      client.AuthToken
 	serverURL      client.ServerURL
 	tenantToken    client.AuthToken
+	serverHealth   *serverHealth
+	// Seeds the weighted order of the servers, derived from the identity
+	// data when first needed.
+	serverSeed    int64
+	serverSeedSet bool
+	// The server last authorized with, kept also when the token is
+	// cleared, and across restarts.
+	lastServerURL client.ServerURL
+	dataStore     store.Store
+	// The tenant token from the configuration, which a token set at
+	// runtime replaces.
+	configTenantToken client.AuthToken
+	// The tenant tokens of the configuration, in the order they are tried,
+	// unless a token has been set at runtime.
+	tenantTokens []client.AuthToken
+	// The new key of a rotation, until the server accepts it, nil if the
+	// key isn't being rotated.
+	rotationKeyStore *store.Keystore
+	// Discovered on the first authorization, when OIDC is configured.
+	oidcProvider *client.OIDCProvider
+	// The bootstrap token of the configuration, until the device has been
+	// authorized with it.
+	bootstrapToken string
+	// Held while the device key is used or replaced, since payloads are
+	// signed outside of the auth manager.
+	keyLock sync.Mutex
+	// Collects the evidence sent with auth requests, nil if attestation
+	// is not enabled.
+	attestation *device.Attestation
+	// Consecutive rejections of the device by the server, kept across
+	// restarts.
+	authRejections authRejections
+	// Tells the time, when the rejection backoff ends.
+	now func() time.Time
 
 	localProxy *proxy.ProxyController
 }
@@ -199,7 +269,10 @@ func NewAuthManager(config AuthManagerConfig) *MenderAuthManager {
 			keyStore:       config.KeyStore,
 			idSrc:          config.IdentitySource,
 			tenantToken:    tenantToken,
+			serverHealth:   newServerHealth(),
+			dataStore:      config.AuthDataStore,
 			localProxy:     proxy,
+			now:            time.Now,
 		},
 	}
 
@@ -220,6 +293,33 @@ func NewAuthManager(config AuthManagerConfig) *MenderAuthManager {
 		return nil
 	})
 
+	if config.Config != nil && config.Config.Security.SealAuthState {
+		mgr.dataStore = store.NewSealedStore(config.AuthDataStore, config.KeyStore,
+			sealedAuthEntries...)
+	}
+
+	if serverURL, err := mgr.dataStore.ReadAll(datastore.AuthServerURLKey); err == nil {
+		mgr.lastServerURL = client.ServerURL(serverURL)
+	}
+	mgr.loadAuthRejections()
+
+	if config.Config != nil {
+		mgr.attestation = device.NewAttestation(config.Config.Attestation)
+	}
+
+	mgr.configTenantToken = tenantToken
+	if config.Config != nil && len(config.Config.TenantTokens) > 0 {
+		if tenantToken != noAuthToken {
+			mgr.tenantTokens = append(mgr.tenantTokens, tenantToken)
+		}
+		for _, token := range config.Config.TenantTokens {
+			mgr.tenantTokens = append(mgr.tenantTokens, client.AuthToken(token))
+		}
+		mgr.tenantToken = mgr.tenantTokens[0]
+	}
+	mgr.loadTenantToken()
+	mgr.loadBootstrapToken()
+
 	return mgr
 }
 
@@ -307,7 +407,70 @@ func (m *menderAuthManagerService) registerDBusCallbacks() (unregisterFunc func(
 		},
 	)
 
+	// SetTenantToken
+	m.dbus.RegisterMethodCallCallback(
+		AuthManagerDBusPath,
+		AuthManagerDBusInterfaceName,
+		"SetTenantToken",
+		func(objectPath, interfaceName, methodName string, parameters string) (interface{}, error) {
+			respChan := make(chan AuthManagerResponse, 1)
+			m.inChan <- AuthManagerRequest{
+				Action:          ActionSetTenantToken,
+				ResponseChannel: respChan,
+				TenantToken:     client.AuthToken(parameters),
+			}
+			timeout := timers.Get(time.Second * 5)
+			select {
+			case message, ok := <-respChan:
+				if !ok {
+					// (race): AuthManagerService timed out.
+					break
+				}
+				return message.Event == EventSetTenantToken, message.Error
+			case <-timeout.C:
+				timers.Put(timeout)
+			}
+			return false, errors.New("timeout when calling SetTenantToken")
+		},
+	)
+
+	// Reauthorize
+	m.dbus.RegisterMethodCallCallback(
+		AuthManagerDBusPath,
+		AuthManagerDBusInterfaceName,
+		"Reauthorize",
+		func(objectPath, interfaceName, methodName string, parameters string) (interface{}, error) {
+			respChan := make(chan AuthManagerResponse, 1)
+			m.inChan <- AuthManagerRequest{
+				Action:          ActionReauthorize,
+				ResponseChannel: respChan,
+			}
+			timeout := timers.Get(time.Second * 5)
+			select {
+			case message, ok := <-respChan:
+				if !ok {
+					// (race): AuthManagerService timed out.
+					break
+				}
+				return message.Event == EventReauthorize, message.Error
+			case <-timeout.C:
+				timers.Put(timeout)
+			}
+			return false, errors.New("timeout when calling Reauthorize")
+		},
+	)
+
 	return func() {
+		m.dbus.UnregisterMethodCallCallback(
+			AuthManagerDBusPath,
+			AuthManagerDBusInterfaceName,
+			"Reauthorize",
+		)
+		m.dbus.UnregisterMethodCallCallback(
+			AuthManagerDBusPath,
+			AuthManagerDBusInterfaceName,
+			"SetTenantToken",
+		)
 		m.dbus.UnregisterMethodCallCallback(
 			AuthManagerDBusPath,
 			AuthManagerDBusInterfaceName,
@@ -425,6 +588,12 @@ mainloop:
 				default:
 					// Already a request in the queue, nothing to do.
 				}
+			case ActionSetTenantToken:
+				log.Debug("received the SET_TENANT_TOKEN action")
+				m.setTenantToken(msg)
+			case ActionReauthorize:
+				log.Debug("received the REAUTHORIZE action")
+				m.reauthorize(msg)
 			}
 		case <-m.quitReq:
 			running = false
@@ -483,6 +652,148 @@ func (m *menderAuthManagerService) getAuthToken(responseChannel chan<- AuthManag
 	}
 }
 
+// setTenantToken replaces the tenant token, and authorizes again with it,
+// since the current auth token belongs to the old tenant. The new token is
+// stored, so that it is used also after a restart.
+func (m *menderAuthManagerService) setTenantToken(msg AuthManagerRequest) {
+	token := client.AuthToken(strings.TrimSpace(string(msg.TenantToken)))
+	resp := AuthManagerResponse{Event: EventSetTenantToken}
+	if err := m.storeTenantToken(token); err != nil {
+		log.Errorf("Failed to store the new tenant token: %s", err.Error())
+		resp.Error = errors.Wrap(err, "failed to store the new tenant token")
+	} else {
+		log.Info("The tenant token was replaced, authorizing again.")
+		m.tenantToken = token
+		m.tenantTokens = nil
+		m.authToken = noAuthToken
+		m.serverURL = ""
+		m.clearAuthRejections()
+	}
+
+	timeout := timers.Get(time.Second * 5)
+	select {
+	case msg.ResponseChannel <- resp:
+
+	case <-timeout.C:
+		timers.Put(timeout)
+		close(msg.ResponseChannel)
+	}
+
+	if resp.Error != nil {
+		return
+	}
+	select {
+	case m.workerChan <- AuthManagerRequest{Action: ActionFetchAuthToken}:
+	default:
+		// A fetch is already queued, and will use the new token.
+	}
+}
+
+// reauthorize drops the current token, and authorizes again right away, for
+// when the server doesn't accept the device the way it used to, after it was
+// moved to another tenant, or its key was changed, for example.
+func (m *menderAuthManagerService) reauthorize(msg AuthManagerRequest) {
+	log.Info("Dropping the authorization token, and authorizing again.")
+	m.authToken = noAuthToken
+	m.serverURL = ""
+	m.clearAuthRejections()
+
+	resp := AuthManagerResponse{Event: EventReauthorize}
+	timeout := timers.Get(time.Second * 5)
+	select {
+	case msg.ResponseChannel <- resp:
+	case <-timeout.C:
+		timers.Put(timeout)
+		close(msg.ResponseChannel)
+	}
+
+	select {
+	case m.workerChan <- AuthManagerRequest{Action: ActionFetchAuthToken}:
+	default:
+		// A fetch is already queued, and will get a new token.
+	}
+}
+
+type storedTenantToken struct {
+	Token    client.AuthToken `json:"token"`
+	Replaces client.AuthToken `json:"replaces"`
+}
+
+func (m *menderAuthManagerService) storeTenantToken(token client.AuthToken) error {
+	if token == m.configTenantToken {
+		err := m.dataStore.Remove(datastore.TenantTokenKey)
+		if err != nil && err != os.ErrNotExist {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(storedTenantToken{
+		Token:    token,
+		Replaces: m.configTenantToken,
+	})
+	if err != nil {
+		return err
+	}
+	return m.dataStore.WriteAll(datastore.TenantTokenKey, data)
+}
+
+// loadTenantToken uses the tenant token set at runtime, unless the
+// configured token has been changed since, in which case the configuration
+// wins.
+func (m *menderAuthManagerService) loadTenantToken() {
+	data, err := m.dataStore.ReadAll(datastore.TenantTokenKey)
+	if err != nil {
+		return
+	}
+	var stored storedTenantToken
+	if err = json.Unmarshal(data, &stored); err != nil {
+		log.Warnf("Ignoring the stored tenant token: %s", err.Error())
+		return
+	}
+	if stored.Replaces != m.configTenantToken {
+		log.Info("The configured tenant token has changed, " +
+			"dropping the one set at runtime.")
+		_ = m.dataStore.Remove(datastore.TenantTokenKey)
+		return
+	}
+	m.tenantToken = stored.Token
+	m.tenantTokens = nil
+}
+
+func bootstrapTokenHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(hash[:]))
+}
+
+// loadBootstrapToken uses the bootstrap token of the configuration, unless
+// the device has already been authorized with it.
+func (m *menderAuthManagerService) loadBootstrapToken() {
+	if m.config == nil || m.config.BootstrapToken == "" {
+		return
+	}
+	used, err := m.dataStore.ReadAll(datastore.BootstrapTokenUsedKey)
+	if err == nil && bytes.Equal(used, bootstrapTokenHash(m.config.BootstrapToken)) {
+		return
+	}
+	m.bootstrapToken = m.config.BootstrapToken
+}
+
+// bootstrapTokenUsed records that the device has been authorized, so that
+// the bootstrap token isn't sent anymore.
+func (m *menderAuthManagerService) bootstrapTokenUsed() {
+	if m.bootstrapToken == "" {
+		return
+	}
+	err := m.dataStore.WriteAll(datastore.BootstrapTokenUsedKey,
+		bootstrapTokenHash(m.bootstrapToken))
+	if err != nil {
+		log.Errorf("Failed to record that the bootstrap token was used: %s", err.Error())
+		return
+	}
+	log.Info("The device was authorized, the bootstrap token won't be used anymore.")
+	m.bootstrapToken = ""
+}
+
 // broadcast broadcasts the notification to all the subscribers
 func (m *menderAuthManagerService) broadcast(message AuthManagerResponse) {
 	m.broadcastChansMutex.Lock()
@@ -508,8 +819,10 @@ func (m *menderAuthManagerService) broadcast(message AuthManagerResponse) {
 	}
 }
 
-// broadcastAuthTokenStateChange broadcasts the notification to all the subscribers
-func (m *menderAuthManagerService) broadcastAuthTokenStateChange() {
+// broadcastAuthTokenStateChange broadcasts the notification to all the
+// subscribers, with the error, if any, which they should know the token was
+// not fetched because of.
+func (m *menderAuthManagerService) broadcastAuthTokenStateChange(err error) {
 	m.localProxy.Stop()
 	if m.authToken != "" {
 		// reconfigure proxy
@@ -533,6 +846,7 @@ func (m *menderAuthManagerService) broadcastAuthTokenStateChange() {
 		Event:     EventAuthTokenStateChange,
 		AuthToken: m.authToken,
 		ServerURL: client.ServerURL(m.localProxy.GetServerUrl()),
+		Error:     err,
 	})
 }
 
@@ -542,9 +856,12 @@ func (m *menderAuthManagerService) fetchAuthToken() {
 	var err error
 	var server *conf.MenderServer
 	resp := AuthManagerResponse{Event: EventFetchAuthToken}
+	// Passed on to the subscribers, for failures which need a different
+	// remedy than the server being unreachable.
+	var reportedErr error
 
 	defer func() {
-		m.broadcastAuthTokenStateChange()
+		m.broadcastAuthTokenStateChange(reportedErr)
 	}()
 
 	if err := m.Bootstrap(); err != nil {
@@ -552,9 +869,17 @@ func (m *menderAuthManagerService) fetchAuthToken() {
 		resp.Error = err
 		return
 	}
+	m.loadRotationKey()
 
 	// Cycle through servers and attempt to authorize.
-	serverIterator := nextServerIterator(*m.config)
+	// Don't move to another server in the middle of a deployment, since
+	// the deployment only exists on the server it came from.
+	var stickyServerURL string
+	if m.deploymentInProgress() {
+		stickyServerURL = string(m.lastServerURL)
+	}
+	serverIterator := nextServerIterator(*m.config, m.serverHealth, stickyServerURL,
+		m.serverOrderSeed())
 	if serverIterator == nil {
 		log.Debug("empty server list in mender.conf, serverIterator is nil")
 		err := NewFatalError(errors.New("empty server list in mender.conf"))
@@ -569,17 +894,93 @@ func (m *menderAuthManagerService) fetchAuthToken() {
 		return
 	}
 
+	if m.config.Security.WaitForClockSync {
+		if synced, err := system.ClockSynchronized(); err != nil {
+			log.Warnf("Can't tell whether the system clock is synchronized: %s",
+				err.Error())
+		} else if !synced {
+			log.Warn("Not authorizing until the system clock is synchronized.")
+			reportedErr = errClockNotSynchronized
+			resp.Error = NewTransientError(reportedErr)
+			return
+		}
+	}
+
+	if probeURL := m.config.Connectivity.CaptivePortalProbeURL; probeURL != "" {
+		err = client.ProbeCaptivePortal(m.api, probeURL)
+		if errors.Cause(err) == client.ErrCaptivePortal {
+			log.Errorf("Not authorizing, %s. The device must be let through "+
+				"by the network before it can reach the server.", err.Error())
+			reportedErr = err
+			resp.Error = NewTransientError(err)
+			return
+		} else if err != nil {
+			log.Warnf("Skipping the captive portal probe: %s", err.Error())
+		}
+	}
+
+	if m.mtlsOnly() {
+		// The server takes the identity of the device from the client
+		// certificate, so there is nothing to authorize.
+		m.setAuthToken(client.MTLSAuthToken, server.ServerURL)
+		return
+	}
+
+	if m.config.OIDC.Issuer != "" {
+		// The token is issued by the provider, not by the server.
+		token, err := m.fetchOIDCToken()
+		if err != nil {
+			log.Errorf("Failed to authorize through the OIDC provider: %s", err.Error())
+			resp.Error = NewTransientError(errors.Wrap(err, "OIDC authorization failed"))
+			return
+		}
+		m.setAuthToken(token, server.ServerURL)
+		return
+	}
+
+	if backoff := m.authRejectionBackoff(); backoff > 0 {
+		log.Warnf("Not authorizing for another %s, since the server keeps "+
+			"rejecting the device.", backoff.Round(time.Second))
+		reportedErr = errAuthRejectionBackoff
+		resp.Error = NewTransientError(reportedErr)
+		return
+	}
+
 	var serverURL string
 	for {
 		serverURL = server.ServerURL
-		rsp, err = m.authReq.Request(m.api, serverURL, m)
+		var final bool
+		if rsp, final, err = m.authorizeRotationKey(serverURL); !final {
+			rsp, err = m.requestAuthorization(serverURL)
+		}
 
 		if err == nil {
 			// SUCCESS!
+			m.serverHealth.recordSuccess(serverURL)
+			break
+		}
+		if reportedErr = client.CaptivePortalError(err); reportedErr != nil {
+			// The other servers would be intercepted just the same,
+			// and none of them is at fault.
+			log.Errorf("Failed to authorize with %q, %s", server.ServerURL,
+				reportedErr.Error())
+			err = reportedErr
+			break
+		}
+		if errors.Is(err, client.ErrClockSkew) {
+			// Same for all servers, and none of them is at fault.
+			log.Errorf("Failed to authorize with %q, the system clock needs to "+
+				"be set: %s", server.ServerURL, err.Error())
+			reportedErr = err
 			break
 		}
 		log.Errorf("Failed to authorize with %q: %s",
 			server.ServerURL, err.Error())
+		if errors.Cause(err) != client.AuthErrorUnauthorized {
+			// The server answered, so it isn't down, it just
+			// doesn't accept the device.
+			m.serverHealth.recordFailure(serverURL)
+		}
 		server = serverIterator()
 		if server == nil {
 			break
@@ -593,6 +994,8 @@ func (m *menderAuthManagerService) fetchAuthToken() {
 		if errCause == client.AuthErrorUnauthorized {
 			m.authToken = ""
 			m.serverURL = ""
+			m.reloadKey()
+			m.recordAuthRejection()
 		}
 		err := NewTransientError(errors.Wrap(err, "authorization request failed"))
 		resp.Error = err
@@ -604,12 +1007,72 @@ func (m *menderAuthManagerService) fetchAuthToken() {
 		return
 	}
 
-	m.authToken = client.AuthToken(rsp)
+	m.bootstrapTokenUsed()
+	m.clearAuthRejections()
+	m.setAuthToken(client.AuthToken(rsp), serverURL)
+}
+
+// mtlsOnly tells whether the device is authenticated by its TLS client
+// certificate alone.
+func (m *menderAuthManagerService) mtlsOnly() bool {
+	return m.config != nil && m.config.AuthMode == conf.AuthModeMTLS
+}
+
+// setAuthToken keeps the token, and the server it is for.
+func (m *menderAuthManagerService) setAuthToken(token client.AuthToken, serverURL string) {
+	m.authToken = token
 	m.serverURL = client.ServerURL(serverURL)
+	if m.serverURL != m.lastServerURL {
+		m.lastServerURL = m.serverURL
+		if m.dataStore != nil {
+			err := m.dataStore.WriteAll(datastore.AuthServerURLKey, []byte(serverURL))
+			if err != nil {
+				log.Warnf("Failed to store the URL of the authorized server: %s",
+					err.Error())
+			}
+		}
+	}
 
 	log.Infof("successfully received new authorization data from server %s", m.serverURL)
 }
 
+// requestAuthorization authorizes with the current tenant token, and if the
+// server rejects it, with the other tenant tokens of the configuration, in
+// order. The first one which the server accepts becomes the current one.
+func (m *menderAuthManagerService) requestAuthorization(serverURL string) ([]byte, error) {
+	rsp, err := m.authReq.Request(m.api, serverURL, m)
+	if errors.Cause(err) != client.AuthErrorUnauthorized || len(m.tenantTokens) < 2 {
+		return rsp, err
+	}
+
+	current := m.tenantToken
+	for i, token := range m.tenantTokens {
+		if token == current {
+			continue
+		}
+		m.tenantToken = token
+		rsp, err = m.authReq.Request(m.api, serverURL, m)
+		if err == nil {
+			log.Infof("Authorized with tenant token %d of the configuration.", i+1)
+			return rsp, nil
+		} else if errors.Cause(err) != client.AuthErrorUnauthorized {
+			break
+		}
+	}
+	m.tenantToken = current
+	return rsp, err
+}
+
+// deploymentInProgress tells whether there is state data stored, which
+// is only the case while a deployment is being handled.
+func (m *menderAuthManagerService) deploymentInProgress() bool {
+	if m.dataStore == nil {
+		return false
+	}
+	_, err := m.dataStore.ReadAll(datastore.StateDataKey)
+	return err == nil
+}
+
 // ForceBootstrap forces the bootstrap
 func (m *menderAuthManagerService) ForceBootstrap() {
 	m.forceBootstrap = true
@@ -625,6 +1088,11 @@ func (m *menderAuthManagerService) Bootstrap() menderError {
 }
 
 func (m *menderAuthManagerService) needsBootstrap() bool {
+	if m.mtlsOnly() {
+		// The device key isn't used.
+		return false
+	}
+
 	if m.forceBootstrap {
 		return true
 	}
@@ -655,8 +1123,40 @@ func (m *menderAuthManagerService) doBootstrap() menderError {
 	return nil
 }
 
+// serverOrderSeed returns the seed of the weighted order of the servers,
+// which stays the same as long as the identity data does. If the identity
+// data can't be read, it is tried again next time. The identity data is only
+// read if any server has a weight.
+func (m *menderAuthManagerService) serverOrderSeed() int64 {
+	if m.serverSeedSet {
+		return m.serverSeed
+	}
+	weighted := false
+	for _, server := range m.config.Servers {
+		weighted = weighted || server.Weight > 0
+	}
+	if !weighted {
+		return 0
+	}
+	idata, err := m.idSrc.Get()
+	if err != nil {
+		log.Warnf("Failed to obtain identity data for ordering the servers: %s",
+			err.Error())
+		return 0
+	}
+	m.serverSeed = serverOrderSeed(idata)
+	m.serverSeedSet = true
+	return m.serverSeed
+}
+
 // MakeAuthRequest makes an auth request
 func (m *menderAuthManagerService) MakeAuthRequest() (*client.AuthRequest, error) {
+	return m.makeAuthRequest(m.keyStore)
+}
+
+func (m *menderAuthManagerService) makeAuthRequest(
+	keyStore *store.Keystore,
+) (*client.AuthRequest, error) {
 
 	var err error
 	authd := client.AuthReqData{}
@@ -669,7 +1169,7 @@ func (m *menderAuthManagerService) MakeAuthRequest() (*client.AuthRequest, error
 	authd.IdData = idata
 
 	// fill device public key
-	authd.Pubkey, err = m.keyStore.PublicPEM()
+	authd.Pubkey, err = keyStore.PublicPEM()
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to obtain device public key")
 	}
@@ -680,6 +1180,18 @@ func (m *menderAuthManagerService) MakeAuthRequest() (*client.AuthRequest, error
 
 	// fill tenant token
 	authd.TenantToken = string(tentok)
+	authd.BootstrapToken = m.bootstrapToken
+
+	if m.attestation != nil {
+		// Quoting a hash of the key ties the evidence to the key the
+		// request is signed with. Without the evidence, it is up to the
+		// server whether to accept the device.
+		nonce := sha256.Sum256([]byte(authd.Pubkey))
+		authd.Attestation, err = m.attestation.Collect(nonce[:], true)
+		if err != nil {
+			log.Errorf("Failed to collect attestation evidence: %s", err)
+		}
+	}
 
 	log.Debugf("Authorization data: %v", authd)
 
@@ -689,7 +1201,9 @@ func (m *menderAuthManagerService) MakeAuthRequest() (*client.AuthRequest, error
 	}
 
 	// generate signature
-	sig, err := m.keyStore.Sign(reqdata)
+	m.keyLock.Lock()
+	sig, err := keyStore.Sign(reqdata)
+	m.keyLock.Unlock()
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to sign auth request")
 	}
@@ -701,6 +1215,16 @@ func (m *menderAuthManagerService) MakeAuthRequest() (*client.AuthRequest, error
 	}, nil
 }
 
+// SignPayload signs the payload with the device key.
+func (m *menderAuthManagerService) SignPayload(payload []byte) ([]byte, error) {
+	m.keyLock.Lock()
+	defer m.keyLock.Unlock()
+	if m.keyStore.Private() == nil {
+		return nil, errors.New("no device key to sign with")
+	}
+	return m.keyStore.Sign(payload)
+}
+
 // HasKey check if device key is available
 func (m *menderAuthManagerService) HasKey() bool {
 	return m.keyStore.Private() != nil
@@ -708,17 +1232,125 @@ func (m *menderAuthManagerService) HasKey() bool {
 
 // GenerateKey generate device key (will overwrite an already existing key)
 func (m *menderAuthManagerService) GenerateKey() error {
-	if err := m.keyStore.Generate(); err != nil {
-		if store.IsStaticKey(err) {
-			return err
+	return m.replaceKey(func() error {
+		if err := m.keyStore.Generate(); err != nil {
+			if store.IsStaticKey(err) {
+				return err
+			}
+			log.Errorf("Failed to generate device key: %v", err)
+			return errors.Wrapf(err, "failed to generate device key")
 		}
-		log.Errorf("Failed to generate device key: %v", err)
-		return errors.Wrapf(err, "failed to generate device key")
+
+		if err := m.keyStore.Save(); err != nil {
+			log.Errorf("Failed to save device key: %s", err)
+			return NewFatalError(err)
+		}
+		return nil
+	})
+}
+
+// replaceKey runs the function, which replaces the device key, and seals the
+// authorization state again with the new key, if it is sealed.
+func (m *menderAuthManagerService) replaceKey(replace func() error) error {
+	m.keyLock.Lock()
+	defer m.keyLock.Unlock()
+	if sealed, ok := m.dataStore.(*store.SealedStore); ok {
+		return sealed.Reseal(replace)
 	}
+	return replace()
+}
 
-	if err := m.keyStore.Save(); err != nil {
-		log.Errorf("Failed to save device key: %s", err)
-		return NewFatalError(err)
+// RotateKey generates a new device key, and stores it next to the current
+// key. Until the server accepts the new key, authorization is attempted with
+// it first, and then with the current key, which remains valid meanwhile.
+func (m *menderAuthManagerService) RotateKey() error {
+	keyStore, err := m.keyStore.RotationKeystore()
+	if store.IsStaticKey(err) {
+		return errors.New("the device key is static, and can't be rotated")
+	} else if err != nil {
+		return err
+	}
+	if err = keyStore.Generate(); err != nil {
+		return errors.Wrap(err, "failed to generate the new device key")
 	}
+	if err = keyStore.Save(); err != nil {
+		return errors.Wrap(err, "failed to save the new device key")
+	}
+	m.rotationKeyStore = keyStore
+	log.Info("Generated a new device key, which will replace the current one " +
+		"once the server accepts it.")
 	return nil
 }
+
+// loadRotationKey picks up the new key of a rotation, which may have been
+// started by another process.
+func (m *menderAuthManagerService) loadRotationKey() {
+	keyStore, err := m.keyStore.RotationKeystore()
+	if err != nil {
+		m.rotationKeyStore = nil
+		return
+	}
+	if err = keyStore.Load(); err != nil {
+		if !store.IsNoKeys(err) {
+			log.Errorf("Failed to load the new device key: %s", err.Error())
+		}
+		m.rotationKeyStore = nil
+		return
+	}
+	m.rotationKeyStore = keyStore
+}
+
+// rotationAuthDataMessenger makes auth requests signed by the new key of a
+// rotation.
+type rotationAuthDataMessenger struct {
+	m *menderAuthManagerService
+}
+
+func (r rotationAuthDataMessenger) MakeAuthRequest() (*client.AuthRequest, error) {
+	return r.m.makeAuthRequest(r.m.rotationKeyStore)
+}
+
+// authorizeRotationKey authorizes with the new key of a rotation, if there
+// is one, and replaces the current key with it once the server accepts it.
+// Tells whether the result is final, which it isn't if the server has yet
+// to accept the new key, in which case the current key is to be used.
+func (m *menderAuthManagerService) authorizeRotationKey(
+	serverURL string,
+) ([]byte, bool, error) {
+	if m.rotationKeyStore == nil {
+		return nil, false, nil
+	}
+	rsp, err := m.authReq.Request(m.api, serverURL, rotationAuthDataMessenger{m})
+	if errors.Cause(err) == client.AuthErrorUnauthorized {
+		log.Infof("The new device key is not accepted by %q yet.", serverURL)
+		return nil, false, nil
+	} else if err != nil {
+		return nil, true, err
+	}
+
+	err = m.replaceKey(func() error {
+		return m.keyStore.Replace(m.rotationKeyStore)
+	})
+	if err != nil {
+		// The new key is tried again next time.
+		log.Errorf("The new device key was accepted, but failed to replace "+
+			"the current key: %s", err.Error())
+	} else {
+		log.Info("The new device key was accepted, and has replaced the current key.")
+		m.rotationKeyStore = nil
+	}
+	return rsp, true, nil
+}
+
+// reloadKey picks up a key which has replaced the current key, in case a
+// rotation was finished by another process, since the current key may not
+// be accepted anymore.
+func (m *menderAuthManagerService) reloadKey() {
+	m.keyLock.Lock()
+	defer m.keyLock.Unlock()
+	if changed, err := m.keyStore.Reload(); err != nil {
+		log.Debugf("Failed to reload the device key: %s", err.Error())
+	} else if changed {
+		log.Info("The device key has been replaced, using the new key.")
+	}
+}
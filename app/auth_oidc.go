@@ -0,0 +1,135 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+)
+
+var (
+	// Used when the provider doesn't give a polling interval, RFC 8628,
+	// section 3.2.
+	oidcDefaultPollInterval = 5 * time.Second
+	// Added to the interval each time the provider asks to slow down.
+	oidcSlowDownIncrement = 5 * time.Second
+)
+
+// fetchOIDCToken returns the token to use with the server, obtained from the
+// OIDC provider, with the stored refresh token if there is one, and with the
+// device authorization grant otherwise, which waits until someone has
+// approved the device at the provider.
+func (m *menderAuthManagerService) fetchOIDCToken() (client.AuthToken, error) {
+	config := m.config.OIDC
+	if m.oidcProvider == nil {
+		provider, err := client.DiscoverOIDCProvider(m.api, config.Issuer)
+		if err != nil {
+			return noAuthToken, err
+		}
+		m.oidcProvider = provider
+	}
+
+	var tokens *client.OIDCTokens
+	refreshToken, err := m.dataStore.ReadAll(datastore.OIDCRefreshTokenKey)
+	if err == nil {
+		tokens, err = m.oidcProvider.RefreshTokens(m.api, config.ClientID,
+			string(refreshToken))
+		if err == client.ErrOIDCInvalidGrant {
+			log.Warn("The OIDC refresh token is not valid anymore, the device " +
+				"needs to be approved again.")
+			_ = m.dataStore.Remove(datastore.OIDCRefreshTokenKey)
+		} else if err != nil {
+			return noAuthToken, errors.Wrap(err, "failed to refresh the OIDC token")
+		}
+	} else if err != os.ErrNotExist {
+		log.Warnf("Failed to read the OIDC refresh token: %s", err.Error())
+	}
+	if tokens == nil {
+		if tokens, err = m.authorizeOIDCDevice(); err != nil {
+			return noAuthToken, err
+		}
+	}
+
+	if tokens.RefreshToken != "" {
+		err = m.dataStore.WriteAll(datastore.OIDCRefreshTokenKey, []byte(tokens.RefreshToken))
+		if err != nil {
+			log.Errorf("Failed to store the OIDC refresh token: %s", err.Error())
+		}
+	}
+
+	token := tokens.AccessToken
+	if config.TokenType == "id_token" {
+		token = tokens.IDToken
+	}
+	if token == "" {
+		return noAuthToken, errors.Errorf("the OIDC provider issued no %s",
+			config.TokenType)
+	}
+	return client.AuthToken(token), nil
+}
+
+// authorizeOIDCDevice runs the device authorization grant, polling the
+// provider until the device is approved, or the code expires.
+func (m *menderAuthManagerService) authorizeOIDCDevice() (*client.OIDCTokens, error) {
+	config := m.config.OIDC
+	scope := config.Scope
+	if scope == "" {
+		scope = "openid"
+	}
+	auth, err := m.oidcProvider.StartDeviceAuthorization(m.api, config.ClientID, scope)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start the OIDC device authorization")
+	}
+
+	if auth.VerificationURIComplete != "" {
+		log.Warnf("The device needs to be approved: visit %s, or enter the code %s at %s",
+			auth.VerificationURIComplete, auth.UserCode, auth.VerificationURI)
+	} else {
+		log.Warnf("The device needs to be approved: enter the code %s at %s",
+			auth.UserCode, auth.VerificationURI)
+	}
+
+	interval := oidcDefaultPollInterval
+	if auth.Interval > 0 {
+		interval = time.Duration(auth.Interval) * time.Second
+	}
+	var deadline time.Time
+	if auth.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	}
+	for {
+		time.Sleep(interval)
+		tokens, err := m.oidcProvider.PollDeviceToken(m.api, config.ClientID,
+			auth.DeviceCode)
+		switch err {
+		case nil:
+			log.Info("The device was approved by the OIDC provider.")
+			return tokens, nil
+		case client.ErrOIDCSlowDown:
+			interval += oidcSlowDownIncrement
+		case client.ErrOIDCAuthorizationPending:
+		default:
+			return nil, errors.Wrap(err, "OIDC device authorization failed")
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, client.ErrOIDCExpiredToken
+		}
+	}
+}
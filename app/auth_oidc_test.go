@@ -0,0 +1,165 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	dev "github.com/mendersoftware/mender/device"
+	"github.com/mendersoftware/mender/store"
+	stest "github.com/mendersoftware/mender/system/testing"
+)
+
+func TestAuthManagerOIDC(t *testing.T) {
+	defer func(interval, increment time.Duration) {
+		oidcDefaultPollInterval, oidcSlowDownIncrement = interval, increment
+	}(oidcDefaultPollInterval, oidcSlowDownIncrement)
+	oidcDefaultPollInterval = time.Millisecond
+	oidcSlowDownIncrement = time.Millisecond
+
+	// The device is approved after polling answers of these errors.
+	var pending []string
+	var deviceAuthorizations int
+	var refreshError string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		reply := func(status int, body interface{}) {
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(body)
+		}
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			reply(http.StatusOK, map[string]string{
+				"device_authorization_endpoint": srv.URL + "/device",
+				"token_endpoint":                srv.URL + "/token",
+			})
+		case "/device":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "mender", r.Form.Get("client_id"))
+			assert.Equal(t, "openid devices", r.Form.Get("scope"))
+			deviceAuthorizations++
+			reply(http.StatusOK, map[string]interface{}{
+				"device_code":      "device-code",
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": srv.URL + "/verify",
+				"expires_in":       60,
+			})
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "mender", r.Form.Get("client_id"))
+			switch r.Form.Get("grant_type") {
+			case "urn:ietf:params:oauth:grant-type:device_code":
+				assert.Equal(t, "device-code", r.Form.Get("device_code"))
+				if len(pending) > 0 {
+					reply(http.StatusBadRequest, map[string]string{"error": pending[0]})
+					pending = pending[1:]
+					return
+				}
+				reply(http.StatusOK, map[string]interface{}{
+					"access_token":  "access",
+					"id_token":      "id",
+					"refresh_token": "refresh",
+				})
+			case "refresh_token":
+				assert.Equal(t, "refresh", r.Form.Get("refresh_token"))
+				if refreshError != "" {
+					reply(http.StatusBadRequest, map[string]string{"error": refreshError})
+					return
+				}
+				reply(http.StatusOK, map[string]interface{}{
+					"access_token": "refreshed-access",
+					"id_token":     "refreshed-id",
+				})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ms := store.NewMemStore()
+	config := &conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			Servers: []conf.MenderServer{{ServerURL: "https://server.example.com"}},
+			OIDC: conf.OIDC{
+				Issuer:   srv.URL + "/",
+				ClientID: "mender",
+				Scope:    "openid devices",
+			},
+		},
+	}
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		Config:   config,
+	})
+
+	// The device authorization grant is used first, and polled until the
+	// device is approved.
+	pending = []string{"authorization_pending", "slow_down", "authorization_pending"}
+	am.fetchAuthToken()
+	assert.Equal(t, client.AuthToken("access"), am.authToken)
+	assert.Equal(t, client.ServerURL("https://server.example.com"), am.serverURL)
+	assert.Empty(t, pending)
+	assert.Equal(t, 1, deviceAuthorizations)
+	refresh, err := ms.ReadAll(datastore.OIDCRefreshTokenKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh", string(refresh))
+
+	// Then the refresh token, which is kept when the provider issues
+	// no new one.
+	config.OIDC.TokenType = "id_token"
+	am.fetchAuthToken()
+	assert.Equal(t, client.AuthToken("refreshed-id"), am.authToken)
+	assert.Equal(t, 1, deviceAuthorizations)
+	refresh, err = ms.ReadAll(datastore.OIDCRefreshTokenKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh", string(refresh))
+
+	// A refresh token which isn't valid anymore needs a new approval.
+	refreshError = "invalid_grant"
+	am.fetchAuthToken()
+	assert.Equal(t, client.AuthToken("id"), am.authToken)
+	assert.Equal(t, 2, deviceAuthorizations)
+
+	// Other refresh failures don't.
+	refreshError = "server_error"
+	am.authToken = noAuthToken
+	am.fetchAuthToken()
+	assert.Equal(t, noAuthToken, am.authToken)
+	assert.Equal(t, 2, deviceAuthorizations)
+
+	// Neither does a device which isn't approved.
+	assert.NoError(t, ms.Remove(datastore.OIDCRefreshTokenKey))
+	pending = []string{"authorization_pending", "access_denied"}
+	am.fetchAuthToken()
+	assert.Equal(t, noAuthToken, am.authToken)
+	assert.Equal(t, 3, deviceAuthorizations)
+}
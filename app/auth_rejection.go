@@ -0,0 +1,146 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/datastore"
+)
+
+const (
+	defaultAuthRejectionBackoffThreshold = 10
+	defaultAuthRejectionBackoffMax       = 24 * time.Hour
+	authRejectionBackoffBase             = time.Minute
+)
+
+var errAuthRejectionBackoff = errors.New("the server keeps rejecting the device, " +
+	"waiting before authorizing again")
+
+// authRejections is how many times in a row the server rejected the device,
+// and until when no authorization is attempted because of it.
+type authRejections struct {
+	Count int       `json:"count"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+// loadAuthRejections picks up the rejections recorded before a restart.
+func (m *menderAuthManagerService) loadAuthRejections() {
+	data, err := m.dataStore.ReadAll(datastore.AuthRejectionsKey)
+	if err != nil {
+		if err != os.ErrNotExist {
+			log.Warnf("Failed to read the authorization rejections: %s", err.Error())
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &m.authRejections); err != nil {
+		log.Warnf("Ignoring the stored authorization rejections: %s", err.Error())
+		m.authRejections = authRejections{}
+	}
+}
+
+// authRejectionBackoff returns the time left before authorization may be
+// attempted again, zero if it may be attempted right away. It is never more
+// than the maximum backoff, even if the clock has been set back since.
+func (m *menderAuthManagerService) authRejectionBackoff() time.Duration {
+	if m.authRejections.Until.IsZero() {
+		return 0
+	}
+	left := m.authRejections.Until.Sub(m.now())
+	if left < 0 {
+		return 0
+	}
+	if maxBackoff := m.authRejectionBackoffMax(); left > maxBackoff {
+		return maxBackoff
+	}
+	return left
+}
+
+// authRejectionBackoffMax returns the longest wait after rejections, zero if
+// the backoff is disabled.
+func (m *menderAuthManagerService) authRejectionBackoffMax() time.Duration {
+	if m.config == nil || m.config.AuthRejectionBackoffMaxSeconds == 0 {
+		return defaultAuthRejectionBackoffMax
+	} else if m.config.AuthRejectionBackoffMaxSeconds < 0 {
+		return 0
+	}
+	return time.Duration(m.config.AuthRejectionBackoffMaxSeconds) * time.Second
+}
+
+// recordAuthRejection counts a rejection of the device by every server, and
+// once there have been enough in a row, sets how long to wait before the
+// next attempt. The server rejects pending devices as well, so only devices
+// which have been authorized before, and have since been declined or
+// decommissioned, are counted. The others keep retrying as usual.
+func (m *menderAuthManagerService) recordAuthRejection() {
+	maxBackoff := m.authRejectionBackoffMax()
+	if maxBackoff == 0 || m.lastServerURL == "" {
+		return
+	}
+	threshold := defaultAuthRejectionBackoffThreshold
+	if m.config != nil && m.config.AuthRejectionBackoffThreshold > 0 {
+		threshold = m.config.AuthRejectionBackoffThreshold
+	}
+
+	m.authRejections.Count++
+	if m.authRejections.Count >= threshold {
+		backoff := maxBackoff
+		if shift := m.authRejections.Count - threshold; shift < 32 {
+			if b := authRejectionBackoffBase << uint(shift); b < maxBackoff {
+				backoff = b
+			}
+		}
+		m.authRejections.Until = m.now().Add(backoff)
+		log.Warnf("The server has rejected the device %d times in a row, "+
+			"not authorizing again until %s.", m.authRejections.Count,
+			m.authRejections.Until.Format(time.RFC3339))
+	}
+	m.storeAuthRejections()
+}
+
+// clearAuthRejections ends the backoff, when the device has been authorized,
+// or is told to authorize again.
+func (m *menderAuthManagerService) clearAuthRejections() {
+	if m.authRejections.Count == 0 {
+		return
+	}
+	m.authRejections = authRejections{}
+	m.storeAuthRejections()
+}
+
+func (m *menderAuthManagerService) storeAuthRejections() {
+	if m.dataStore == nil {
+		return
+	}
+	var err error
+	if m.authRejections.Count == 0 {
+		err = m.dataStore.Remove(datastore.AuthRejectionsKey)
+		if err == os.ErrNotExist {
+			err = nil
+		}
+	} else {
+		var data []byte
+		if data, err = json.Marshal(m.authRejections); err == nil {
+			err = m.dataStore.WriteAll(datastore.AuthRejectionsKey, data)
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to store the authorization rejections: %s", err.Error())
+	}
+}
@@ -15,6 +15,9 @@ package app
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"runtime"
 	"testing"
 	"time"
@@ -25,6 +28,7 @@ import (
 	"github.com/mendersoftware/mender/client"
 	cltest "github.com/mendersoftware/mender/client/test"
 	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
 	"github.com/mendersoftware/mender/dbus"
 	"github.com/mendersoftware/mender/dbus/mocks"
 	dev "github.com/mendersoftware/mender/device"
@@ -106,6 +110,546 @@ func TestAuthManager(t *testing.T) {
 
 }
 
+func TestAuthManagerStickyServer(t *testing.T) {
+	ms := store.NewMemStore()
+	cmdr := stest.NewTestOSCalls("", 0)
+	newManager := func() *MenderAuthManager {
+		return NewAuthManager(AuthManagerConfig{
+			AuthDataStore: ms,
+			IdentitySource: &dev.IdentityDataRunner{
+				Cmdr: cmdr,
+			},
+			KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		})
+	}
+
+	am := newManager()
+	assert.Equal(t, client.ServerURL(""), am.lastServerURL)
+	assert.False(t, am.deploymentInProgress())
+
+	assert.NoError(t, ms.WriteAll(datastore.AuthServerURLKey, []byte("https://onprem")))
+	assert.NoError(t, ms.WriteAll(datastore.StateDataKey, []byte("{}")))
+	am = newManager()
+	assert.Equal(t, client.ServerURL("https://onprem"), am.lastServerURL)
+	assert.True(t, am.deploymentInProgress())
+}
+
+func TestAuthManagerSetTenantToken(t *testing.T) {
+	srv := cltest.NewClientTestServer()
+	defer srv.Close()
+	srv.Auth.Authorize = true
+	srv.Auth.Token = []byte("authorized")
+
+	ms := store.NewMemStore()
+	cmdr := stest.NewTestOSCalls("mac=foobar", 0)
+	newManager := func(tenantToken string) *MenderAuthManager {
+		return NewAuthManager(AuthManagerConfig{
+			AuthDataStore: ms,
+			IdentitySource: dev.IdentityDataRunner{
+				Cmdr: cmdr,
+			},
+			KeyStore:    store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+			TenantToken: []byte(tenantToken),
+			Config: &conf.MenderConfig{
+				MenderConfigFromFile: conf.MenderConfigFromFile{
+					Servers: []conf.MenderServer{{ServerURL: srv.URL}},
+				},
+			},
+		})
+	}
+
+	am := newManager("old")
+	defer am.Stop()
+	inChan := am.GetInMessageChan()
+	broadcastChan := am.GetBroadcastMessageChan(authManagerTestChannelName)
+	respChan := make(chan AuthManagerResponse)
+
+	inChan <- AuthManagerRequest{
+		Action:          ActionFetchAuthToken,
+		ResponseChannel: respChan,
+	}
+	<-respChan
+	message := <-broadcastChan
+	assert.Equal(t, client.AuthToken("authorized"), message.AuthToken)
+
+	// Replacing the token authorizes again.
+	srv.Auth.Called = false
+	srv.Auth.Token = []byte("authorized-new")
+	inChan <- AuthManagerRequest{
+		Action:          ActionSetTenantToken,
+		ResponseChannel: respChan,
+		TenantToken:     "new\n",
+	}
+	message = <-respChan
+	assert.NoError(t, message.Error)
+	assert.Equal(t, EventSetTenantToken, message.Event)
+	message = <-broadcastChan
+	assert.Equal(t, client.AuthToken("authorized-new"), message.AuthToken)
+	assert.True(t, srv.Auth.Called)
+
+	req, err := am.MakeAuthRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, client.AuthToken("new"), req.Token)
+	am.Stop()
+
+	// The new token survives a restart...
+	am = newManager("old")
+	assert.Equal(t, client.AuthToken("new"), am.tenantToken)
+
+	// ...but not a change of the configured token.
+	am = newManager("other")
+	assert.Equal(t, client.AuthToken("other"), am.tenantToken)
+	_, err = ms.ReadAll(datastore.TenantTokenKey)
+	assert.Error(t, err)
+
+	// Setting the configured token again doesn't need to store anything.
+	assert.NoError(t, am.storeTenantToken("new"))
+	_, err = ms.ReadAll(datastore.TenantTokenKey)
+	assert.NoError(t, err)
+	assert.NoError(t, am.storeTenantToken("other"))
+	_, err = ms.ReadAll(datastore.TenantTokenKey)
+	assert.Error(t, err)
+}
+
+func TestAuthManagerRotateKey(t *testing.T) {
+	// The server accepts the keys in this set, and returns them as token.
+	accepted := map[string]bool{}
+	var submitted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req client.AuthReqData
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		submitted = append(submitted, req.Pubkey)
+		if !accepted[req.Pubkey] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(req.Pubkey))
+	}))
+	defer srv.Close()
+
+	ms := store.NewMemStore()
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		Config: &conf.MenderConfig{
+			MenderConfigFromFile: conf.MenderConfigFromFile{
+				Servers: []conf.MenderServer{{ServerURL: srv.URL}},
+			},
+		},
+	})
+	assert.NoError(t, am.Bootstrap())
+	oldKey, err := am.keyStore.PublicPEM()
+	assert.NoError(t, err)
+	accepted[oldKey] = true
+
+	fetch := func() client.AuthToken {
+		submitted = nil
+		am.fetchAuthToken()
+		return am.authToken
+	}
+
+	assert.NoError(t, am.RotateKey())
+	_, err = ms.ReadAll("key" + store.RotationKeySuffix)
+	assert.NoError(t, err)
+	newKey, err := am.rotationKeyStore.PublicPEM()
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldKey, newKey)
+
+	// Until the new key is accepted, the old one is used.
+	assert.Equal(t, client.AuthToken(oldKey), fetch())
+	assert.Equal(t, []string{newKey, oldKey}, submitted)
+	assert.NotNil(t, am.rotationKeyStore)
+
+	// Once accepted, the new key replaces the old one.
+	accepted[newKey] = true
+	assert.Equal(t, client.AuthToken(newKey), fetch())
+	assert.Equal(t, []string{newKey}, submitted)
+	assert.Nil(t, am.rotationKeyStore)
+	_, err = ms.ReadAll("key" + store.RotationKeySuffix)
+	assert.Error(t, err)
+	key, err := am.keyStore.PublicPEM()
+	assert.NoError(t, err)
+	assert.Equal(t, newKey, key)
+
+	// The rotation is picked up from the store, as when started by another
+	// process, and so is a key replaced by another process.
+	other := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		Config:   am.config,
+	})
+	assert.NoError(t, other.RotateKey())
+	rotatedKey, err := other.rotationKeyStore.PublicPEM()
+	assert.NoError(t, err)
+	assert.Equal(t, client.AuthToken(newKey), fetch())
+	assert.Equal(t, []string{rotatedKey, newKey}, submitted)
+
+	accepted[rotatedKey] = true
+	other.fetchAuthToken()
+	assert.Equal(t, client.AuthToken(rotatedKey), other.authToken)
+	delete(accepted, newKey)
+	fetch()
+	assert.Equal(t, client.AuthToken(rotatedKey), fetch())
+
+	// Static keys can't be rotated.
+	static := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", true, defaultKeyPassphrase),
+		Config:   am.config,
+	})
+	assert.Error(t, static.RotateKey())
+}
+
+func TestAuthManagerBootstrapToken(t *testing.T) {
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req client.AuthReqData
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sent = append(sent, req.BootstrapToken)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("authorized"))
+	}))
+	defer srv.Close()
+
+	ms := store.NewMemStore()
+	newManager := func(bootstrapToken string) *MenderAuthManager {
+		return NewAuthManager(AuthManagerConfig{
+			AuthDataStore: ms,
+			IdentitySource: dev.IdentityDataRunner{
+				Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+			},
+			KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+			Config: &conf.MenderConfig{
+				MenderConfigFromFile: conf.MenderConfigFromFile{
+					Servers:        []conf.MenderServer{{ServerURL: srv.URL}},
+					BootstrapToken: bootstrapToken,
+				},
+			},
+		})
+	}
+
+	// The token is only sent until the device is authorized, also across
+	// restarts.
+	am := newManager("enroll")
+	am.fetchAuthToken()
+	am.fetchAuthToken()
+	newManager("enroll").fetchAuthToken()
+	assert.Equal(t, []string{"enroll", "", ""}, sent)
+
+	// A new token is sent again.
+	sent = nil
+	am = newManager("enroll-again")
+	am.fetchAuthToken()
+	am.fetchAuthToken()
+	assert.Equal(t, []string{"enroll-again", ""}, sent)
+
+	sent = nil
+	newManager("").fetchAuthToken()
+	assert.Equal(t, []string{""}, sent)
+}
+
+func TestAuthManagerTenantTokens(t *testing.T) {
+	accepted := "b"
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req client.AuthReqData
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sent = append(sent, req.TenantToken)
+		if req.TenantToken != accepted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("authorized-" + req.TenantToken))
+	}))
+	defer srv.Close()
+
+	ms := store.NewMemStore()
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore:    store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		TenantToken: []byte("a"),
+		Config: &conf.MenderConfig{
+			MenderConfigFromFile: conf.MenderConfigFromFile{
+				Servers:      []conf.MenderServer{{ServerURL: srv.URL}},
+				TenantToken:  "a",
+				TenantTokens: []string{"b", "c"},
+			},
+		},
+	})
+
+	am.fetchAuthToken()
+	assert.Equal(t, client.AuthToken("authorized-b"), am.authToken)
+	assert.Equal(t, []string{"a", "b"}, sent)
+
+	// The accepted token is used from then on.
+	sent = nil
+	am.fetchAuthToken()
+	assert.Equal(t, []string{"b"}, sent)
+
+	// Until the device moves to another tenant.
+	sent = nil
+	accepted = "c"
+	am.fetchAuthToken()
+	assert.Equal(t, client.AuthToken("authorized-c"), am.authToken)
+	assert.Equal(t, []string{"b", "a", "c"}, sent)
+
+	// If none is accepted, the current one stays.
+	sent = nil
+	accepted = ""
+	am.fetchAuthToken()
+	assert.Equal(t, noAuthToken, am.authToken)
+	assert.Equal(t, []string{"c", "a", "b"}, sent)
+	assert.Equal(t, client.AuthToken("c"), am.tenantToken)
+
+	// A token set at runtime replaces them all.
+	assert.NoError(t, am.storeTenantToken("d"))
+	am.loadTenantToken()
+	sent = nil
+	am.fetchAuthToken()
+	assert.Equal(t, []string{"d"}, sent)
+}
+
+func TestAuthManagerRejectionBackoff(t *testing.T) {
+	accept := false
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if !accept {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("authorized"))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	ms := store.NewMemStore()
+	newManager := func() *MenderAuthManager {
+		am := NewAuthManager(AuthManagerConfig{
+			AuthDataStore: ms,
+			IdentitySource: dev.IdentityDataRunner{
+				Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+			},
+			KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+			Config: &conf.MenderConfig{
+				MenderConfigFromFile: conf.MenderConfigFromFile{
+					Servers:                        []conf.MenderServer{{ServerURL: srv.URL}},
+					AuthRejectionBackoffThreshold:  2,
+					AuthRejectionBackoffMaxSeconds: 90,
+				},
+			},
+		})
+		am.now = func() time.Time { return now }
+		return am
+	}
+
+	// A device which has never been authorized may just be pending, and
+	// keeps retrying.
+	am := newManager()
+	for i := 0; i < 3; i++ {
+		am.fetchAuthToken()
+	}
+	assert.Equal(t, time.Duration(0), am.authRejectionBackoff())
+	assert.Equal(t, 3, requests)
+	requests = 0
+
+	// No backoff until the threshold is reached.
+	assert.NoError(t, ms.WriteAll(datastore.AuthServerURLKey, []byte(srv.URL)))
+	am = newManager()
+	am.fetchAuthToken()
+	assert.Equal(t, time.Duration(0), am.authRejectionBackoff())
+	am.fetchAuthToken()
+	assert.Equal(t, time.Minute, am.authRejectionBackoff())
+	assert.Equal(t, 2, requests)
+
+	// The server isn't asked during the backoff, also after a restart.
+	am = newManager()
+	am.fetchAuthToken()
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, time.Minute, am.authRejectionBackoff())
+
+	// The backoff doubles, up to the maximum.
+	now = now.Add(time.Minute)
+	am.fetchAuthToken()
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, 90*time.Second, am.authRejectionBackoff())
+
+	// The backoff never exceeds the maximum, also when the clock is set
+	// back.
+	now = now.Add(-time.Hour)
+	assert.Equal(t, 90*time.Second, am.authRejectionBackoff())
+	now = now.Add(time.Hour)
+
+	// Being told to authorize again ends it.
+	am.reauthorize(AuthManagerRequest{ResponseChannel: make(chan AuthManagerResponse, 1)})
+	assert.Equal(t, time.Duration(0), am.authRejectionBackoff())
+	<-am.workerChan
+
+	// And so does being authorized.
+	am.fetchAuthToken()
+	am.fetchAuthToken()
+	assert.NotEqual(t, time.Duration(0), am.authRejectionBackoff())
+	now = now.Add(time.Hour)
+	accept = true
+	am.fetchAuthToken()
+	assert.Equal(t, client.AuthToken("authorized"), am.authToken)
+	assert.Equal(t, 0, am.authRejections.Count)
+	_, err := ms.ReadAll(datastore.AuthRejectionsKey)
+	assert.Equal(t, os.ErrNotExist, err)
+}
+
+func TestAuthManagerSealAuthState(t *testing.T) {
+	ms := store.NewMemStore()
+	ks := store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase)
+	assert.NoError(t, ks.SetKeyType(store.KeyTypeED25519))
+	assert.NoError(t, ks.Generate())
+	assert.NoError(t, ks.Save())
+
+	newManager := func() *MenderAuthManager {
+		return NewAuthManager(AuthManagerConfig{
+			AuthDataStore: ms,
+			IdentitySource: dev.IdentityDataRunner{
+				Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+			},
+			KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+			Config: &conf.MenderConfig{
+				MenderConfigFromFile: conf.MenderConfigFromFile{
+					Security: conf.Security{SealAuthState: true},
+				},
+			},
+		})
+	}
+
+	am := newManager()
+	assert.NoError(t, am.storeTenantToken("runtime-token"))
+	raw, err := ms.ReadAll(datastore.TenantTokenKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "runtime-token")
+
+	am = newManager()
+	assert.Equal(t, client.AuthToken("runtime-token"), am.tenantToken)
+
+	// The state survives a new device key.
+	assert.NoError(t, am.GenerateKey())
+	am = newManager()
+	assert.Equal(t, client.AuthToken("runtime-token"), am.tenantToken)
+}
+
+func TestAuthManagerMTLS(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	ms := store.NewMemStore()
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		Config: &conf.MenderConfig{
+			MenderConfigFromFile: conf.MenderConfigFromFile{
+				Servers:  []conf.MenderServer{{ServerURL: srv.URL}},
+				AuthMode: conf.AuthModeMTLS,
+			},
+		},
+	})
+
+	am.fetchAuthToken()
+	assert.Equal(t, client.MTLSAuthToken, am.authToken)
+	assert.Equal(t, client.ServerURL(srv.URL), am.serverURL)
+	assert.Equal(t, 0, requests)
+	// No device key is needed.
+	assert.False(t, am.HasKey())
+}
+
+func TestAuthManagerReauthorize(t *testing.T) {
+	srv := cltest.NewClientTestServer()
+	defer srv.Close()
+	srv.Auth.Authorize = true
+	srv.Auth.Token = []byte("authorized")
+
+	ms := store.NewMemStore()
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+		Config: &conf.MenderConfig{
+			MenderConfigFromFile: conf.MenderConfigFromFile{
+				Servers: []conf.MenderServer{{ServerURL: srv.URL}},
+			},
+		},
+	})
+	defer am.Stop()
+	inChan := am.GetInMessageChan()
+	broadcastChan := am.GetBroadcastMessageChan(authManagerTestChannelName)
+	respChan := make(chan AuthManagerResponse)
+
+	inChan <- AuthManagerRequest{
+		Action:          ActionFetchAuthToken,
+		ResponseChannel: respChan,
+	}
+	<-respChan
+	message := <-broadcastChan
+	assert.Equal(t, client.AuthToken("authorized"), message.AuthToken)
+
+	// The device has been moved to another tenant.
+	srv.Auth.Called = false
+	srv.Auth.Token = []byte("authorized-elsewhere")
+	inChan <- AuthManagerRequest{
+		Action:          ActionReauthorize,
+		ResponseChannel: respChan,
+	}
+	message = <-respChan
+	assert.NoError(t, message.Error)
+	assert.Equal(t, EventReauthorize, message.Event)
+	message = <-broadcastChan
+	assert.Equal(t, client.AuthToken("authorized-elsewhere"), message.AuthToken)
+	assert.True(t, srv.Auth.Called)
+}
+
+func TestAuthManagerSignPayload(t *testing.T) {
+	ms := store.NewMemStore()
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("mac=foobar", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+	})
+
+	_, err := am.SignPayload([]byte("payload"))
+	assert.Error(t, err)
+
+	assert.NoError(t, am.keyStore.SetKeyType(store.KeyTypeED25519))
+	assert.NoError(t, am.GenerateKey())
+	sig, err := am.SignPayload([]byte("payload"))
+	assert.NoError(t, err)
+	expected, err := am.keyStore.Sign([]byte("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, sig)
+}
+
 func TestAuthManagerRequest(t *testing.T) {
 	ms := store.NewMemStore()
 
@@ -327,6 +871,20 @@ func TestMenderAuthorize(t *testing.T) {
 		mock.Anything,
 	)
 
+	dbusAPI.On("RegisterMethodCallCallback",
+		AuthManagerDBusPath,
+		AuthManagerDBusInterfaceName,
+		"SetTenantToken",
+		mock.Anything,
+	)
+
+	dbusAPI.On("RegisterMethodCallCallback",
+		AuthManagerDBusPath,
+		AuthManagerDBusInterfaceName,
+		"Reauthorize",
+		mock.Anything,
+	)
+
 	dbusAPI.On("EmitSignal",
 		dbusConn,
 		"",
@@ -350,6 +908,18 @@ func TestMenderAuthorize(t *testing.T) {
 		"GetJwtToken",
 	)
 
+	dbusAPI.On("UnregisterMethodCallCallback",
+		AuthManagerDBusPath,
+		AuthManagerDBusInterfaceName,
+		"SetTenantToken",
+	)
+
+	dbusAPI.On("UnregisterMethodCallCallback",
+		AuthManagerDBusPath,
+		AuthManagerDBusInterfaceName,
+		"Reauthorize",
+	)
+
 	dbusAPI.On("BusUnregisterInterface",
 		dbusConn,
 		uint(2),
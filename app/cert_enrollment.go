@@ -0,0 +1,205 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
+)
+
+// How often the daemon checks whether the client certificate is due for
+// renewal.
+const defaultCertEnrollmentCheckInterval = time.Hour
+
+// EnrollCertificate enrolls the client certificate of the configuration with
+// the EST server, if there is no certificate, it has expired, or it is due
+// for renewal, and always with force. A valid certificate is renewed with a
+// re-enrollment, authenticated with it. The new certificate replaces the
+// file atomically, and the HTTP clients of the daemon pick it up from there.
+// Returns whether a certificate was enrolled.
+func EnrollCertificate(config *conf.MenderConfig, force bool) (bool, error) {
+	est := config.EST
+	if est.ServerURL == "" {
+		return false, errors.New("no EST server is configured")
+	}
+
+	reenroll := false
+	current, err := readCertificate(config.HttpsClient.Certificate)
+	if err == nil {
+		if time.Now().After(current.NotAfter) {
+			log.Warnf("The client certificate expired at %s, enrolling a new one",
+				current.NotAfter.Format(time.RFC3339))
+		} else if !force && time.Now().Before(certRenewalTime(current, est.RenewBeforeSeconds)) {
+			return false, nil
+		} else {
+			reenroll = true
+		}
+	} else if !os.IsNotExist(err) {
+		log.Warnf("Enrolling a new client certificate, the current one can't be used: %s",
+			err.Error())
+	}
+
+	keyStore := store.NewKeystore(store.NewDirStore(conf.GetDataDirPath()),
+		config.HttpsClient.Key, config.HttpsClient.SSLEngine, true, "")
+	if err = keyStore.Load(); err != nil {
+		return false, errors.Wrap(err, "failed to load the client key")
+	}
+	commonName := est.CommonName
+	if commonName == "" {
+		if commonName, err = os.Hostname(); err != nil {
+			return false, errors.Wrap(err, "failed to get the host name")
+		}
+	}
+	csr, err := keyStore.CertificateRequest(commonName)
+	if err != nil {
+		return false, err
+	}
+
+	httpConfig := conf.HttpConfig{
+		ServerCert:   est.ServerCertificate,
+		Connectivity: &config.Connectivity,
+	}
+	if reenroll {
+		httpConfig.HttpsClient = &config.HttpsClient
+	}
+	api, err := client.NewApiClient(httpConfig)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to set up the EST client")
+	}
+	certs, err := client.ESTEnroll(api, est.ServerURL, csr, reenroll, est.Username,
+		est.Password)
+	if err != nil {
+		return false, err
+	}
+
+	issued, err := parseCertificate(certs)
+	if err != nil {
+		return false, errors.Wrap(err, "the EST server returned an invalid certificate")
+	}
+	publicKey, err := keyStore.Private().MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(issued.RawSubjectPublicKeyInfo, publicKey) {
+		return false, errors.New("the EST server returned a certificate for another key")
+	}
+	if err = writeCertificate(config.HttpsClient.Certificate, certs); err != nil {
+		return false, errors.Wrap(err, "failed to store the client certificate")
+	}
+	log.Infof("Enrolled a new client certificate, valid until %s",
+		issued.NotAfter.Format(time.RFC3339))
+	return true, nil
+}
+
+// certRenewalTime returns when the certificate is due for renewal,
+// renewBeforeSeconds before it expires, or when a third of its validity is
+// left.
+func certRenewalTime(cert *x509.Certificate, renewBeforeSeconds int) time.Time {
+	renewBefore := time.Duration(renewBeforeSeconds) * time.Second
+	if renewBefore <= 0 {
+		renewBefore = cert.NotAfter.Sub(cert.NotBefore) / 3
+	}
+	return cert.NotAfter.Add(-renewBefore)
+}
+
+func readCertificate(file string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(data)
+}
+
+// parseCertificate parses the first certificate of the PEM data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// writeCertificate replaces the certificate file atomically, so that the
+// HTTP clients never read it half written.
+func writeCertificate(file string, data []byte) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// certEnroller has the daemon enroll the client certificate, and renew it
+// before it expires.
+type certEnroller struct {
+	config   *conf.MenderConfig
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newCertEnroller(config *conf.MenderConfig) *certEnroller {
+	return &certEnroller{
+		config:   config,
+		interval: defaultCertEnrollmentCheckInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (e *certEnroller) Start() {
+	go func() {
+		defer close(e.done)
+		for {
+			if _, err := EnrollCertificate(e.config, false); err != nil {
+				log.Errorf("Failed to enroll the client certificate: %s", err.Error())
+			}
+			select {
+			case <-e.stop:
+				return
+			case <-time.After(e.interval):
+			}
+		}
+	}()
+}
+
+func (e *certEnroller) Stop() {
+	close(e.stop)
+	<-e.done
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
+)
+
+// pkcs7CertsOnly wraps the certificate in a PKCS#7 certs-only structure, as
+// EST servers return them.
+func pkcs7CertsOnly(t *testing.T, cert []byte) []byte {
+	emptySet := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo: struct{ ContentType asn1.ObjectIdentifier }{
+			asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0,
+			IsCompound: true, Bytes: cert},
+		SignerInfos: emptySet,
+	})
+	require.NoError(t, err)
+	data, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0,
+			IsCompound: true, Bytes: signedData},
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func TestEnrollCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mender-est-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ks := store.NewKeystore(store.NewDirStore(dir), "client.key", "", false, "")
+	require.NoError(t, ks.SetKeyType(store.KeyTypeECDSA))
+	require.NoError(t, ks.Generate())
+	require.NoError(t, ks.Save())
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	var requests []string
+	validity := 30 * 24 * time.Hour
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, path.Base(r.URL.Path))
+		body, _ := ioutil.ReadAll(r.Body)
+		der, err := base64.StdEncoding.DecodeString(string(body))
+		require.NoError(t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		require.NoError(t, err)
+		require.NoError(t, csr.CheckSignature())
+		cert, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(requests))),
+			Subject:      csr.Subject,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(validity),
+		}, &x509.Certificate{Subject: pkix.Name{CommonName: "CA"}},
+			csr.PublicKey, caKey)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(pkcs7CertsOnly(t, cert))))
+	}))
+	defer ts.Close()
+
+	config := conf.NewMenderConfig()
+	config.HttpsClient.Certificate = path.Join(dir, "client.crt")
+	config.HttpsClient.Key = path.Join(dir, "client.key")
+	config.EST.ServerURL = ts.URL + "/.well-known/est"
+	config.EST.CommonName = "device-1"
+
+	// No certificate yet.
+	enrolled, err := EnrollCertificate(config, false)
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+	cert, err := readCertificate(config.HttpsClient.Certificate)
+	require.NoError(t, err)
+	assert.Equal(t, "device-1", cert.Subject.CommonName)
+
+	// Not due for renewal.
+	enrolled, err = EnrollCertificate(config, false)
+	require.NoError(t, err)
+	assert.False(t, enrolled)
+	assert.Equal(t, []string{"simpleenroll"}, requests)
+
+	// Due for renewal.
+	config.EST.RenewBeforeSeconds = int(validity / time.Second)
+	enrolled, err = EnrollCertificate(config, false)
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+	config.EST.RenewBeforeSeconds = 0
+	enrolled, err = EnrollCertificate(config, true)
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+	assert.Equal(t, []string{"simpleenroll", "simplereenroll", "simplereenroll"}, requests)
+
+	// Expired, it can't be used to authenticate a re-enrollment.
+	validity = -time.Second
+	_, err = EnrollCertificate(config, true)
+	require.NoError(t, err)
+	enrolled, err = EnrollCertificate(config, false)
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+	assert.Equal(t, "simpleenroll", requests[len(requests)-1])
+
+	// A certificate for another key is refused.
+	config.HttpsClient.Key = path.Join(dir, "other.key")
+	other := store.NewKeystore(store.NewDirStore(dir), "other.key", "", false, "")
+	require.NoError(t, other.SetKeyType(store.KeyTypeECDSA))
+	require.NoError(t, other.Generate())
+	require.NoError(t, other.Save())
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(pkcs7CertsOnly(t, cert.Raw))))
+	})
+	_, err = EnrollCertificate(config, true)
+	assert.Error(t, err)
+
+	config.EST.ServerURL = ""
+	_, err = EnrollCertificate(config, false)
+	assert.Error(t, err)
+}
@@ -15,16 +15,145 @@
 package app
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/mendersoftware/mender/conf"
 )
 
+// Failures older than this are forgotten, so that a server which has been
+// down is tried first again once it has had time to recover.
+var serverFailureMemory = 10 * time.Minute
+
+// serverHealth remembers recent failures to reach each of the configured
+// servers, so that the servers known to work are tried first.
+type serverHealth struct {
+	lock     sync.Mutex
+	failures map[string]serverFailures
+}
+
+type serverFailures struct {
+	count int
+	last  time.Time
+}
+
+func newServerHealth() *serverHealth {
+	return &serverHealth{failures: map[string]serverFailures{}}
+}
+
+func (h *serverHealth) recordFailure(serverURL string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	f := h.failures[serverURL]
+	f.count++
+	f.last = time.Now()
+	h.failures[serverURL] = f
+}
+
+func (h *serverHealth) recordSuccess(serverURL string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.failures, serverURL)
+}
+
+// order returns the servers with the ones that have failed recently moved
+// to the back, fewest failures first. Servers which are equally healthy
+// keep their order from the configuration.
+func (h *serverHealth) order(servers []conf.MenderServer) []conf.MenderServer {
+	ordered := append([]conf.MenderServer{}, servers...)
+	if h == nil {
+		return ordered
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := time.Now()
+	recentFailures := func(serverURL string) int {
+		f := h.failures[serverURL]
+		if now.Sub(f.last) > serverFailureMemory {
+			return 0
+		}
+		return f.count
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return recentFailures(ordered[i].ServerURL) < recentFailures(ordered[j].ServerURL)
+	})
+	return ordered
+}
+
+// serverOrderSeed derives the seed of the weighted order of the servers from
+// the identity data, so that each device keeps choosing the same server, while
+// the devices are spread over the servers by their weights.
+func serverOrderSeed(identityData string) int64 {
+	sum := sha256.Sum256([]byte(identityData))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// weightedOrder returns the servers in an order drawn with the given seed,
+// where the chance of coming before the others is proportional to the weight
+// of each server. The same seed and weights always give the same order.
+// Servers without a weight come last, in their configured order. If no
+// server has a weight, the order is unchanged.
+func weightedOrder(servers []conf.MenderServer, seed int64) []conf.MenderServer {
+	var weighted, unweighted []conf.MenderServer
+	total := 0
+	for _, server := range servers {
+		if server.Weight > 0 {
+			weighted = append(weighted, server)
+			total += server.Weight
+		} else {
+			unweighted = append(unweighted, server)
+		}
+	}
+	if len(weighted) == 0 {
+		return append([]conf.MenderServer{}, servers...)
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	ordered := make([]conf.MenderServer, 0, len(servers))
+	for len(weighted) > 0 {
+		pick := random.Intn(total)
+		i := 0
+		for ; pick >= weighted[i].Weight; i++ {
+			pick -= weighted[i].Weight
+		}
+		ordered = append(ordered, weighted[i])
+		total -= weighted[i].Weight
+		weighted = append(weighted[:i], weighted[i+1:]...)
+	}
+	return append(ordered, unweighted...)
+}
+
+// stickyOrder moves the server with the given URL to the front.
+func stickyOrder(servers []conf.MenderServer, serverURL string) []conf.MenderServer {
+	for i, server := range servers {
+		if server.ServerURL == serverURL {
+			ordered := append([]conf.MenderServer{server}, servers[:i]...)
+			return append(ordered, servers[i+1:]...)
+		}
+	}
+	return servers
+}
+
 // see client.go: ApiRequest.Do()
 
 // nextServerIterator returns an iterator like function that cycles through the
-// list of available servers in mender.conf.MenderConfig.Servers
-func nextServerIterator(config conf.MenderConfig) func() *conf.MenderServer {
+// list of available servers in mender.conf.MenderConfig.Servers. The servers
+// are ordered by their weights, drawn with seed, then the healthiest are
+// moved first according to health, which may be nil. stickyServerURL, if
+// given, comes first regardless.
+func nextServerIterator(
+	config conf.MenderConfig,
+	health *serverHealth,
+	stickyServerURL string,
+	seed int64,
+) func() *conf.MenderServer {
 	numServers := len(config.Servers)
 	if config.Servers == nil || numServers == 0 {
 		log.Error("Empty server list! Make sure at least one server " +
@@ -32,11 +161,15 @@ func nextServerIterator(config conf.MenderConfig) func() *conf.MenderServer {
 		return nil
 	}
 
+	servers := health.order(weightedOrder(config.Servers, seed))
+	if stickyServerURL != "" {
+		servers = stickyOrder(servers, stickyServerURL)
+	}
 	idx := 0
 	return func() (server *conf.MenderServer) {
 		var ret *conf.MenderServer
 		if idx < numServers {
-			ret = &config.Servers[idx]
+			ret = &servers[idx]
 			idx++
 		} else {
 			// return nil which terminates Do()
@@ -0,0 +1,138 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func iteratedServers(config conf.MenderConfig, health *serverHealth) []string {
+	return iteratedStickyServers(config, health, "")
+}
+
+func iteratedStickyServers(
+	config conf.MenderConfig,
+	health *serverHealth,
+	sticky string,
+) []string {
+	var urls []string
+	next := nextServerIterator(config, health, sticky, 0)
+	for server := next(); server != nil; server = next() {
+		urls = append(urls, server.ServerURL)
+	}
+	return urls
+}
+
+func TestServerHealthOrdering(t *testing.T) {
+	config := conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			Servers: []conf.MenderServer{
+				{ServerURL: "https://primary"},
+				{ServerURL: "https://secondary"},
+				{ServerURL: "https://tertiary"},
+			},
+		},
+	}
+
+	assert.Equal(t,
+		[]string{"https://primary", "https://secondary", "https://tertiary"},
+		iteratedServers(config, nil))
+
+	health := newServerHealth()
+	assert.Equal(t,
+		[]string{"https://primary", "https://secondary", "https://tertiary"},
+		iteratedServers(config, health))
+
+	health.recordFailure("https://primary")
+	assert.Equal(t,
+		[]string{"https://secondary", "https://tertiary", "https://primary"},
+		iteratedServers(config, health))
+
+	health.recordFailure("https://secondary")
+	health.recordFailure("https://secondary")
+	assert.Equal(t,
+		[]string{"https://tertiary", "https://primary", "https://secondary"},
+		iteratedServers(config, health))
+
+	health.recordSuccess("https://secondary")
+	assert.Equal(t,
+		[]string{"https://secondary", "https://tertiary", "https://primary"},
+		iteratedServers(config, health))
+
+	// Old failures are forgotten.
+	prevMemory := serverFailureMemory
+	serverFailureMemory = 0
+	defer func() {
+		serverFailureMemory = prevMemory
+	}()
+	time.Sleep(time.Millisecond)
+	assert.Equal(t,
+		[]string{"https://primary", "https://secondary", "https://tertiary"},
+		iteratedServers(config, health))
+
+	// The configuration itself is not reordered.
+	assert.Equal(t, "https://primary", config.Servers[0].ServerURL)
+}
+
+func TestWeightedServerOrdering(t *testing.T) {
+	config := conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			Servers: []conf.MenderServer{
+				{ServerURL: "https://fallback"},
+				{ServerURL: "https://onprem", Weight: 1},
+				{ServerURL: "https://hosted", Weight: 3},
+			},
+		},
+	}
+
+	// Each device sticks to its order.
+	first := iteratedServers(config, nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, iteratedServers(config, nil))
+	}
+
+	firsts := map[string]int{}
+	const rounds = 4000
+	for i := 0; i < rounds; i++ {
+		next := nextServerIterator(config, nil, "", serverOrderSeed(fmt.Sprintf("device-%d", i)))
+		var urls []string
+		for server := next(); server != nil; server = next() {
+			urls = append(urls, server.ServerURL)
+		}
+		assert.Len(t, urls, 3)
+		assert.Equal(t, "https://fallback", urls[2])
+		firsts[urls[0]]++
+	}
+	assert.Equal(t, rounds, firsts["https://onprem"]+firsts["https://hosted"])
+	// Expected 1000 and 3000 devices, allow for some randomness.
+	assert.InDelta(t, rounds/4, firsts["https://onprem"], rounds/20)
+	assert.InDelta(t, 3*rounds/4, firsts["https://hosted"], rounds/20)
+
+	// The server of an ongoing deployment comes first, regardless of
+	// weights and of failures.
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "https://onprem",
+			iteratedStickyServers(config, nil, "https://onprem")[0])
+	}
+	health := newServerHealth()
+	health.recordFailure("https://onprem")
+	assert.Equal(t, "https://onprem",
+		iteratedStickyServers(config, health, "https://onprem")[0])
+}
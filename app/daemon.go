@@ -14,6 +14,7 @@
 package app
 
 import (
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -31,11 +32,25 @@ import (
 type MenderDaemon struct {
 	AuthManager          AuthManager
 	UpdateControlManager *UpdateManager
+	InventoryManager     *InventoryManager
 	Mender               Controller
 	Sctx                 StateContext
 	Store                store.Store
 	ForceToState         chan State
 	stop                 bool
+	// Has the state machine drop its token before the next state.
+	reauthorize      chan struct{}
+	pushListener     *pushListener
+	metricsListener  *metricsListener
+	localAPIListener *localAPIListener
+	peerListener     *peerSharingListener
+	cacheListener    *artifactCacheListener
+	certEnroller     *certEnroller
+	inventoryEvents  *inventoryEventWatcher
+
+	// The state being handled, for the local API.
+	stateLock    sync.Mutex
+	currentState State
 }
 
 func NewDaemon(
@@ -46,17 +61,21 @@ func NewDaemon(
 
 	updmgr := NewUpdateManager(mender.GetControlMapPool(),
 		config.GetUpdateControlMapExpirationTimeSeconds())
+	updmgr.cancelDownload = mender.CancelDownload
+	var invmgr *InventoryManager
 	if config.DBus.Enabled {
 		api, err := dbus.GetDBusAPI()
 		if err != nil {
 			return nil, errors.Wrap(err, "DBus API support not available, but DBus is enabled")
 		}
 		updmgr.EnableDBus(api)
+		invmgr = NewInventoryManager(api, store)
 	}
 
 	daemon := MenderDaemon{
 		AuthManager:          authManager,
 		UpdateControlManager: updmgr,
+		InventoryManager:     invmgr,
 		Mender:               mender,
 		Sctx: StateContext{
 			Store:         store,
@@ -66,12 +85,155 @@ func NewDaemon(
 		},
 		Store:        store,
 		ForceToState: make(chan State, 1),
+		reauthorize:  make(chan struct{}, 1),
+	}
+	updmgr.pauseDeployment = daemon.PauseDeployment
+	updmgr.resumeDeployment = daemon.ResumeDeployment
+	if config.DeploymentPushNotifications && authManager != nil {
+		listener, err := newPushListener(config, authManager, daemon.deploymentAnnounced)
+		if err != nil {
+			return nil, err
+		}
+		daemon.pushListener = listener
+	}
+	if config.MetricsListenAddress != "" {
+		listener, err := newMetricsListener(config.MetricsListenAddress)
+		if err != nil {
+			return nil, err
+		}
+		daemon.metricsListener = listener
+	}
+	if config.EST.ServerURL != "" {
+		daemon.certEnroller = newCertEnroller(config)
+	}
+	if config.InventoryUpdateOnEvents {
+		daemon.inventoryEvents = newInventoryEventWatcher(
+			time.Duration(config.InventoryEventDebounceSeconds)*time.Second,
+			func() { daemon.forceState(States.InventoryUpdate) })
+	}
+	if config.LocalAPISocket != "" {
+		listener, err := newLocalAPIListener(config.LocalAPISocket,
+			config.LocalAPISocketGroup, &daemon)
+		if err != nil {
+			return nil, err
+		}
+		daemon.localAPIListener = listener
+	}
+	if config.PeerSharing.Enabled && store != nil {
+		listener, err := newPeerSharingListener(config, store)
+		if err != nil {
+			return nil, err
+		}
+		daemon.peerListener = listener
+	}
+	if config.ArtifactCache.ListenAddress != "" && store != nil {
+		listener, err := newArtifactCacheListener(config, store)
+		if err != nil {
+			return nil, err
+		}
+		daemon.cacheListener = listener
 	}
 	return &daemon, nil
 }
 
+// deploymentAnnounced forces an update check, the same way SIGUSR1 does.
+func (d *MenderDaemon) deploymentAnnounced() {
+	d.forceState(States.UpdateCheck)
+}
+
+// Reauthorize has the daemon drop its token, and authorize again right away,
+// for when the server doesn't accept the device the way it used to, after it
+// was moved to another tenant, or its key was changed, for example. The
+// inventory is sent once authorized, if the daemon is idle.
+func (d *MenderDaemon) Reauthorize() {
+	select {
+	case d.reauthorize <- struct{}{}:
+	default:
+	}
+	d.forceState(States.InventoryUpdate)
+}
+
+// PauseDeployment pauses the deployment in progress before its next state,
+// or in the middle of downloading the artifact, until ResumeDeployment is
+// called. The deployment stays paused if the daemon is restarted.
+func (d *MenderDaemon) PauseDeployment() error {
+	d.stateLock.Lock()
+	state := d.currentState
+	d.stateLock.Unlock()
+	update := deploymentOfState(state)
+	if update == nil || update.ID == "" {
+		return errNoDeploymentInProgress
+	}
+	return d.Mender.PauseDeployment(update.ID)
+}
+
+// ResumeDeployment resumes the paused deployment, and returns whether there
+// was one.
+func (d *MenderDaemon) ResumeDeployment() bool {
+	if !d.Mender.ResumeDeployment() {
+		return false
+	}
+	select {
+	case d.Sctx.WakeupChan <- true:
+	default:
+	}
+	return true
+}
+
+// forceState has the state machine go to the given state, if it is idle,
+// without blocking if a forced state is pending already.
+func (d *MenderDaemon) forceState(state State) {
+	select {
+	case d.ForceToState <- state:
+	default:
+	}
+	select {
+	case d.Sctx.WakeupChan <- true:
+	default:
+	}
+}
+
+func (d *MenderDaemon) setCurrentState(state State) {
+	d.stateLock.Lock()
+	d.currentState = state
+	d.stateLock.Unlock()
+}
+
+func (d *MenderDaemon) localAPIState() localAPIState {
+	d.stateLock.Lock()
+	state := d.currentState
+	d.stateLock.Unlock()
+	if state == nil {
+		state = d.Mender.GetCurrentState()
+	}
+
+	rsp := localAPIState{State: state.Id().String()}
+	if us, ok := state.(UpdateState); ok {
+		rsp.DeploymentID = us.Update().ID
+	}
+	if name, err := d.Mender.GetCurrentArtifactName(); err == nil {
+		rsp.ArtifactName = name
+	}
+	if d.AuthManager != nil {
+		respChan := make(chan AuthManagerResponse, 1)
+		d.AuthManager.GetInMessageChan() <- AuthManagerRequest{
+			Action:          ActionGetAuthToken,
+			ResponseChannel: respChan,
+		}
+		select {
+		case message, ok := <-respChan:
+			rsp.Authorized = ok && message.AuthToken != noAuthToken
+		case <-time.After(5 * time.Second):
+		}
+	}
+	return rsp
+}
+
+// StopDaemon has the daemon stop after the current state. An artifact
+// download in progress is torn down, instead of being waited for.
 func (d *MenderDaemon) StopDaemon() {
 	d.stop = true
+	d.Mender.CancelDownload()
 }
 
 func (d *MenderDaemon) Cleanup() {
@@ -94,11 +256,39 @@ func (d *MenderDaemon) Run() error {
 		log.Errorf("Error while handling bootstrap Artifact, continuing: %s", err.Error())
 	}
 
+	if d.certEnroller != nil {
+		d.certEnroller.Start()
+		defer d.certEnroller.Stop()
+	}
 	// Start the auth Manager in a different go routine, if set
 	if d.AuthManager != nil {
 		d.AuthManager.Start()
 		defer d.AuthManager.Stop()
 	}
+	if d.pushListener != nil {
+		d.pushListener.Start()
+		defer d.pushListener.Stop()
+	}
+	if d.metricsListener != nil {
+		d.metricsListener.Start()
+		defer d.metricsListener.Stop()
+	}
+	if d.localAPIListener != nil {
+		d.localAPIListener.Start()
+		defer d.localAPIListener.Stop()
+	}
+	if d.peerListener != nil {
+		d.peerListener.Start()
+		defer d.peerListener.Stop()
+	}
+	if d.cacheListener != nil {
+		d.cacheListener.Start()
+		defer d.cacheListener.Stop()
+	}
+	if d.inventoryEvents != nil {
+		d.inventoryEvents.Start()
+		defer d.inventoryEvents.Stop()
+	}
 	if d.UpdateControlManager != nil {
 		cancel, err := d.UpdateControlManager.Start()
 		if err != nil {
@@ -107,6 +297,14 @@ func (d *MenderDaemon) Run() error {
 			defer cancel()
 		}
 	}
+	if d.InventoryManager != nil {
+		cancel, err := d.InventoryManager.Start()
+		if err != nil {
+			log.Error(err)
+		} else {
+			defer cancel()
+		}
+	}
 
 	// set the first state transition
 	var toState State = d.Mender.GetCurrentState()
@@ -115,6 +313,12 @@ func (d *MenderDaemon) Run() error {
 		// If signal SIGUSR1 or SIGUSR2 is received, force the state-machine to the correct state.
 		updateLastCheckAttempt := true
 		select {
+		case <-d.reauthorize:
+			log.Info("Dropping the authorization token, and authorizing again.")
+			d.Mender.ClearAuthorization()
+		default:
+		}
+		select {
 		case nState := <-d.ForceToState:
 			switch toState.(type) {
 			case *idleState,
@@ -142,6 +346,17 @@ func (d *MenderDaemon) Run() error {
 				d.Sctx.lastInventoryUpdateAttempt = time.Now()
 			}
 		}
+		switch toState.(type) {
+		case *idleState:
+			// A pause ends with its deployment, if it failed meanwhile.
+			d.Mender.ResumeDeployment()
+		default:
+			us, ok := pausableState(toState)
+			if ok && d.Mender.DeploymentPaused(us.Update().ID) {
+				toState = NewDeploymentPausedState(us)
+			}
+		}
+		d.setCurrentState(toState)
 		toState, cancelled = d.Mender.TransitionState(toState, &d.Sctx)
 		if toState.Id() == datastore.MenderStateError {
 			es, ok := toState.(*errorState)
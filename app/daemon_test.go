@@ -188,6 +188,7 @@ func TestDaemon(t *testing.T) {
 func TestDaemonCleanup(t *testing.T) {
 	mstore := &store.MockStore{}
 	mstore.On("ReadAll", "update-control-maps").Return(nil, os.ErrNotExist)
+	mstore.On("ReadAll", "deployment-paused").Return(nil, os.ErrNotExist)
 	mstore.On("Close").Return(nil)
 	mender, err := NewMender(&conf.MenderConfig{}, MenderPieces{Store: mstore})
 	require.NoError(t, err)
@@ -197,6 +198,23 @@ func TestDaemonCleanup(t *testing.T) {
 	mstore.AssertExpectations(t)
 }
 
+func TestDaemonPauseDeployment(t *testing.T) {
+	mender := newDefaultTestMender()
+	d, err := NewDaemon(&conf.MenderConfig{}, mender, store.NewMemStore(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, errNoDeploymentInProgress, d.PauseDeployment())
+	d.setCurrentState(NewControlMapPauseState(
+		NewUpdateInstallState(&datastore.UpdateInfo{ID: "foo"})))
+	require.NoError(t, d.PauseDeployment())
+	assert.True(t, mender.DeploymentPaused("foo"))
+
+	assert.True(t, d.ResumeDeployment())
+	assert.True(t, <-d.Sctx.WakeupChan)
+	assert.False(t, mender.DeploymentPaused("foo"))
+	assert.False(t, d.ResumeDeployment())
+}
+
 type daemonTestController struct {
 	stateTestController
 	updateCheckCount int
@@ -0,0 +1,55 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// DecommissionFiles returns what is removed from the disk, with
+// datastore.WipeDecommissionedFiles, when the device is decommissioned: the
+// deployment logs in dataStore, the partially downloaded artifact, the
+// artifact shared with other devices, the artifacts cached for them, and the
+// client certificate and key enrolled with the EST server. Directories stand
+// for their contents.
+func DecommissionFiles(config *conf.MenderConfig, dataStore string) ([]string, error) {
+	files, err := NewDeploymentLogManager(dataStore).getSortedLogFiles()
+	if err != nil {
+		return nil, err
+	}
+	if config.DownloadResumeDir != "" {
+		files = append(files, config.DownloadResumeDir)
+	}
+	peerDir := peerSharingDir(config)
+	files = append(files,
+		filepath.Join(peerDir, sharedArtifactFile),
+		filepath.Join(peerDir, peerDownloadFile),
+		artifactCacheDir(config))
+
+	if config.EST.ServerURL != "" {
+		files = append(files, config.HttpsClient.Certificate)
+		// A key in an engine, or a hardware token, isn't a file.
+		key := config.HttpsClient.Key
+		if config.HttpsClient.SSLEngine == "" && !strings.HasPrefix(key, conf.Pkcs11URIPrefix) {
+			if !filepath.IsAbs(key) {
+				key = filepath.Join(conf.GetDataDirPath(), key)
+			}
+			files = append(files, key)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestDecommissionFiles(t *testing.T) {
+	tdir := t.TempDir()
+	logManager := NewDeploymentLogManager(tdir)
+	require.NoError(t, logManager.Enable("1234"))
+	require.NoError(t, logManager.Disable())
+
+	config := conf.NewMenderConfig()
+	config.DownloadResumeDir = filepath.Join(tdir, "resume")
+	config.PeerSharing.Dir = filepath.Join(tdir, "peers")
+	config.ArtifactCache.Dir = filepath.Join(tdir, "cache")
+	files, err := DecommissionFiles(config, tdir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(tdir, "deployments.0001.1234.log"),
+		filepath.Join(tdir, "resume"),
+		filepath.Join(tdir, "peers", sharedArtifactFile),
+		filepath.Join(tdir, "peers", peerDownloadFile),
+		filepath.Join(tdir, "cache"),
+	}, files)
+
+	// The certificate and key enrolled with the EST server go too, unless
+	// the key isn't a file.
+	config.EST.ServerURL = "https://est.example.com/.well-known/est"
+	config.HttpsClient.Certificate = filepath.Join(tdir, "client.crt")
+	config.HttpsClient.Key = filepath.Join(tdir, "client.key")
+	files, err = DecommissionFiles(config, tdir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{config.HttpsClient.Certificate, config.HttpsClient.Key},
+		files[len(files)-2:])
+	config.HttpsClient.Key = "pkcs11:token=mender;object=client"
+	files, err = DecommissionFiles(config, tdir)
+	require.NoError(t, err)
+	assert.Equal(t, config.HttpsClient.Certificate, files[len(files)-1])
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/datastore"
+)
+
+var errNoDeploymentInProgress = errors.New("there is no deployment in progress")
+
+// deploymentPause is the deployment paused locally, if any.
+type deploymentPause struct {
+	lock sync.Mutex
+	id   string
+	// Closed when the deployment is resumed.
+	resumed chan struct{}
+}
+
+// loadDeploymentPause picks up the deployment paused before a restart.
+func (m *Mender) loadDeploymentPause() {
+	if m.Store == nil {
+		return
+	}
+	data, err := m.Store.ReadAll(datastore.DeploymentPausedKey)
+	if err != nil {
+		if err != os.ErrNotExist {
+			log.Warnf("Failed to read the paused deployment: %s", err.Error())
+		}
+		return
+	}
+	m.pause.id = string(data)
+	m.pause.resumed = make(chan struct{})
+	log.Infof("Deployment %s is paused", m.pause.id)
+}
+
+// PauseDeployment pauses the deployment with the given ID, until
+// ResumeDeployment is called.
+func (m *Mender) PauseDeployment(id string) error {
+	m.pause.lock.Lock()
+	defer m.pause.lock.Unlock()
+	if m.pause.id == id {
+		return nil
+	}
+	if m.Store != nil {
+		err := m.Store.WriteAll(datastore.DeploymentPausedKey, []byte(id))
+		if err != nil {
+			return errors.Wrap(err, "failed to store the paused deployment")
+		}
+	}
+	if m.pause.id == "" {
+		m.pause.resumed = make(chan struct{})
+	}
+	m.pause.id = id
+	log.Infof("Pausing deployment %s", id)
+	return nil
+}
+
+// ResumeDeployment resumes the paused deployment, and returns whether there
+// was one.
+func (m *Mender) ResumeDeployment() bool {
+	m.pause.lock.Lock()
+	defer m.pause.lock.Unlock()
+	if m.pause.id == "" {
+		return false
+	}
+	if m.Store != nil {
+		err := m.Store.Remove(datastore.DeploymentPausedKey)
+		if err != nil && err != os.ErrNotExist {
+			log.Errorf("Failed to remove the paused deployment: %s", err.Error())
+		}
+	}
+	log.Infof("Resuming deployment %s", m.pause.id)
+	m.pause.id = ""
+	close(m.pause.resumed)
+	return true
+}
+
+// DeploymentPaused returns whether the deployment with the given ID is
+// paused.
+func (m *Mender) DeploymentPaused(id string) bool {
+	m.pause.lock.Lock()
+	defer m.pause.lock.Unlock()
+	return m.pause.id != "" && m.pause.id == id
+}
+
+// waitWhileDeploymentPaused blocks the artifact download while the
+// deployment is paused, or until the download is torn down.
+func (m *Mender) waitWhileDeploymentPaused(ctx context.Context) {
+	m.pause.lock.Lock()
+	id, resumed := m.pause.id, m.pause.resumed
+	m.pause.lock.Unlock()
+	if id == "" {
+		return
+	}
+	log.Infof("Deployment %s is paused, waiting to continue the download", id)
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+	}
+}
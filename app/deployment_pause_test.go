@@ -0,0 +1,84 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestDeploymentPause(t *testing.T) {
+	ms := store.NewMemStore()
+	config := conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			Servers: []conf.MenderServer{{}},
+		},
+	}
+	mender := newTestMender(config, testMenderPieces{
+		MenderPieces: MenderPieces{Store: ms},
+	})
+
+	assert.False(t, mender.DeploymentPaused("foo"))
+	assert.False(t, mender.ResumeDeployment())
+	// Not paused, so the download goes on.
+	mender.waitWhileDeploymentPaused(context.Background())
+
+	require.NoError(t, mender.PauseDeployment("foo"))
+	assert.True(t, mender.DeploymentPaused("foo"))
+	assert.False(t, mender.DeploymentPaused("bar"))
+	data, err := ms.ReadAll(datastore.DeploymentPausedKey)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(data))
+
+	// Still paused after a restart.
+	mender = newTestMender(config, testMenderPieces{
+		MenderPieces: MenderPieces{Store: ms},
+	})
+	assert.True(t, mender.DeploymentPaused("foo"))
+
+	// The download waits until the deployment is resumed...
+	done := make(chan struct{})
+	go func() {
+		mender.waitWhileDeploymentPaused(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the download went on while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.True(t, mender.ResumeDeployment())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the download didn't go on when resumed")
+	}
+	assert.False(t, mender.DeploymentPaused("foo"))
+	_, err = ms.ReadAll(datastore.DeploymentPausedKey)
+	assert.Error(t, err)
+
+	// ... or is torn down.
+	require.NoError(t, mender.PauseDeployment("foo"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mender.waitWhileDeploymentPaused(ctx)
+}
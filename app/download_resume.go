@@ -0,0 +1,265 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+const downloadResumeFile = "artifact.partial"
+
+// The state of the download is stored every time this many more bytes have
+// been written, by default.
+const defaultDownloadResumeInterval = 4 * 1024 * 1024
+
+// downloadResumeState is how far the artifact download has got, as stored.
+type downloadResumeState struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	Validator string `json:"validator"`
+	Path      string `json:"path"`
+	Offset    int64  `json:"offset"`
+	// SHA-256 of the first Offset bytes of the file, hex encoded.
+	Checksum string `json:"checksum"`
+}
+
+func loadDownloadResumeState(s store.Store) (*downloadResumeState, error) {
+	data, err := s.ReadAll(datastore.DownloadResumeKey)
+	if err != nil {
+		return nil, err
+	}
+	state := &downloadResumeState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrap(err, "invalid download resume state")
+	}
+	return state, nil
+}
+
+// canResumeDownload returns whether the download of the update can continue
+// where it stopped before a restart.
+func canResumeDownload(s store.Store, update *datastore.UpdateInfo) bool {
+	state, err := loadDownloadResumeState(s)
+	return err == nil && state.URL == update.URI()
+}
+
+// removePartialDownload forgets the download kept for resuming, if any.
+func removePartialDownload(s store.Store) {
+	if s == nil {
+		return
+	}
+	state, err := loadDownloadResumeState(s)
+	if err != nil {
+		if err != os.ErrNotExist {
+			log.Warnf("Failed to read the download resume state: %s", err.Error())
+		}
+		return
+	}
+	if err = os.Remove(state.Path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove the partly downloaded artifact: %s", err.Error())
+	}
+	if err = s.Remove(datastore.DownloadResumeKey); err != nil {
+		log.Errorf("Failed to remove the download resume state: %s", err.Error())
+	}
+}
+
+// fetchResumableUpdate fetches the update, continuing the download kept in
+// the DownloadResumeDir if it is of the same link, and keeps what is
+// downloaded there.
+func (m *Mender) fetchResumableUpdate(
+	ctx context.Context,
+	url string,
+) (io.ReadCloser, int64, error) {
+	if state, err := loadDownloadResumeState(m.Store); err == nil && state.URL == url {
+		d, err := m.resumeDownload(ctx, state)
+		if err == nil {
+			return d, state.Size, nil
+		}
+		log.Warnf("Failed to resume the download, starting over: %s", err.Error())
+	}
+	removePartialDownload(m.Store)
+
+	image, size, err := m.updater.FetchUpdate(ctx, m.download, url, m.GetRetryPollInterval())
+	if err != nil {
+		return nil, -1, err
+	}
+	validator := ""
+	if v, ok := image.(client.RangeValidator); ok {
+		validator = v.RangeValidator()
+	}
+	if validator == "" {
+		log.Info("The server doesn't identify the artifact, " +
+			"the download can't be resumed after a restart")
+		return image, size, nil
+	}
+	path := filepath.Join(m.Config.DownloadResumeDir, downloadResumeFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Warnf("Failed to keep the artifact for resuming the download: %s", err.Error())
+		return image, size, nil
+	}
+	return &resumableDownload{
+		ReadCloser: image,
+		ctx:        ctx,
+		store:      m.Store,
+		file:       file,
+		hash:       sha256.New(),
+		state: downloadResumeState{
+			URL:       url,
+			Size:      size,
+			Validator: validator,
+			Path:      path,
+		},
+		interval: m.downloadResumeInterval,
+	}, size, nil
+}
+
+// resumeDownload verifies the part downloaded before the restart, which is
+// read again, and continues with the rest from the server.
+func (m *Mender) resumeDownload(
+	ctx context.Context,
+	state *downloadResumeState,
+) (io.ReadCloser, error) {
+	file, err := os.OpenFile(state.Path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, io.NewSectionReader(file, 0, state.Offset))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != state.Checksum {
+		file.Close()
+		return nil, errors.New("the partly downloaded artifact is corrupted")
+	}
+
+	var rest io.ReadCloser = ioutil.NopCloser(strings.NewReader(""))
+	if state.Offset < state.Size {
+		rest, err = m.updater.ResumeUpdate(ctx, m.download, state.URL,
+			state.Offset, state.Size, state.Validator, m.GetRetryPollInterval())
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	log.Infof("Resuming the download of the artifact at %d of %d bytes",
+		state.Offset, state.Size)
+	return &resumableDownload{
+		ReadCloser: rest,
+		ctx:        ctx,
+		store:      m.Store,
+		file:       file,
+		hash:       hash,
+		state:      *state,
+		kept:       io.NewSectionReader(file, 0, state.Offset),
+		interval:   m.downloadResumeInterval,
+	}, nil
+}
+
+// resumableDownload writes what is downloaded to a file, and stores how far
+// it has got now and then, so that the download can continue after a
+// restart. What was kept before the restart is read from the file first.
+type resumableDownload struct {
+	io.ReadCloser
+	ctx      context.Context
+	store    store.Store
+	file     *os.File
+	hash     hash.Hash
+	state    downloadResumeState
+	kept     io.Reader
+	interval int64
+	unsynced int64
+}
+
+func (d *resumableDownload) Read(b []byte) (int, error) {
+	if d.kept != nil {
+		n, err := d.kept.Read(b)
+		if err != io.EOF {
+			return n, err
+		}
+		d.kept = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+
+	n, err := d.ReadCloser.Read(b)
+	if n > 0 && d.file != nil {
+		d.keep(b[:n])
+	}
+	return n, err
+}
+
+// keep writes the downloaded data to the file, and stores the state once
+// enough has been written. Failing to only means the download can't be
+// resumed.
+func (d *resumableDownload) keep(b []byte) {
+	if _, err := d.file.WriteAt(b, d.state.Offset); err != nil {
+		log.Warnf("Failed to keep the artifact for resuming the download: %s", err.Error())
+		d.file.Close()
+		d.file = nil
+		removePartialDownload(d.store)
+		return
+	}
+	d.hash.Write(b)
+	d.state.Offset += int64(len(b))
+	d.unsynced += int64(len(b))
+	if d.unsynced < d.interval && d.state.Offset < d.state.Size {
+		return
+	}
+	d.unsynced = 0
+	// The data has to be on disk before the state says so.
+	err := d.file.Sync()
+	if err == nil {
+		d.state.Checksum = hex.EncodeToString(d.hash.Sum(nil))
+		var data []byte
+		if data, err = json.Marshal(d.state); err == nil {
+			err = d.store.WriteAll(datastore.DownloadResumeKey, data)
+		}
+	}
+	if err != nil {
+		log.Warnf("Failed to store the download resume state: %s", err.Error())
+	}
+}
+
+// Close keeps the artifact if the download was torn down, as when the client
+// is stopped, and removes it otherwise, since the payloads have been stored,
+// or failed to.
+func (d *resumableDownload) Close() error {
+	err := d.ReadCloser.Close()
+	if d.file != nil {
+		d.file.Close()
+		if d.ctx.Err() == nil {
+			removePartialDownload(d.store)
+		}
+	}
+	return err
+}
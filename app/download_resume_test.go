@@ -0,0 +1,146 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestResumeDownloadAfterRestart(t *testing.T) {
+	artifact := make([]byte, 8192)
+	_, err := rand.Read(artifact)
+	require.NoError(t, err)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"artifact"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(artifact))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "mender-download-resume")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ms := store.NewMemStore()
+	config := conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			ServerURL:         srv.URL,
+			DownloadResumeDir: dir,
+		},
+	}
+	update := &datastore.UpdateInfo{}
+	update.Artifact.Source.URI = srv.URL + "/artifact"
+
+	mender := newTestMender(config, testMenderPieces{MenderPieces: MenderPieces{Store: ms}})
+	mender.downloadResumeInterval = 1024
+	img, size, err := mender.FetchUpdate(update)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(artifact), size)
+	_, err = io.CopyN(ioutil.Discard, img, 3000)
+	require.NoError(t, err)
+	// The client is stopped, so the artifact is kept.
+	assert.True(t, mender.CancelDownload())
+	img.Close()
+	assert.True(t, canResumeDownload(ms, update))
+	state, err := loadDownloadResumeState(ms)
+	require.NoError(t, err)
+	assert.True(t, state.Offset >= 1024 && state.Offset <= 3000)
+
+	// After the restart the download continues from what was stored.
+	mender = newTestMender(config, testMenderPieces{MenderPieces: MenderPieces{Store: ms}})
+	mender.downloadResumeInterval = 1024
+	img, size, err = mender.FetchUpdate(update)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(artifact), size)
+	data, err := ioutil.ReadAll(img)
+	require.NoError(t, err)
+	assert.Equal(t, artifact, data)
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", state.Offset)}, ranges)
+
+	// Once read, the artifact isn't kept anymore.
+	require.NoError(t, img.Close())
+	assert.False(t, canResumeDownload(ms, update))
+	_, err = os.Stat(filepath.Join(dir, downloadResumeFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// A corrupted artifact is downloaded again from the start.
+	ranges = nil
+	img, _, err = mender.FetchUpdate(update)
+	require.NoError(t, err)
+	_, err = io.CopyN(ioutil.Discard, img, 3000)
+	require.NoError(t, err)
+	mender.CancelDownload()
+	img.Close()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, downloadResumeFile),
+		make([]byte, 3000), 0600))
+	img, _, err = mender.FetchUpdate(update)
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(img)
+	require.NoError(t, err)
+	assert.Equal(t, artifact, data)
+	assert.Equal(t, []string{"", ""}, ranges)
+	img.Close()
+}
+
+func TestInitStateResumesDownload(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "logs")
+	DeploymentLogger = NewDeploymentLogManager(tempDir)
+	defer func() {
+		DeploymentLogger = nil
+		os.RemoveAll(tempDir)
+	}()
+
+	ms := store.NewMemStore()
+	update := &datastore.UpdateInfo{ID: "foo"}
+	update.Artifact.Source.URI = "https://example.com/artifact"
+	require.NoError(t, datastore.StoreStateData(ms, datastore.StateData{
+		Name:       datastore.MenderStateUpdateStore,
+		UpdateInfo: *update,
+	}, true))
+	ctx := &StateContext{Store: ms}
+
+	next, _ := States.Init.Handle(ctx, &stateTestController{})
+	assert.IsType(t, &updateCleanupState{}, next)
+
+	require.NoError(t, ms.WriteAll(datastore.DownloadResumeKey,
+		[]byte(`{"url": "https://example.com/artifact"}`)))
+	next, _ = States.Init.Handle(ctx, &stateTestController{})
+	assert.IsType(t, &updateFetchState{}, next)
+
+	// Cleaning up forgets about the download.
+	c := &stateTestController{controlMap: NewControlMap(ms,
+		conf.DefaultUpdateControlMapBootExpirationTimeSeconds,
+		conf.DefaultUpdateControlMapBootExpirationTimeSeconds)}
+	NewUpdateCleanupState(update, client.StatusFailure).Handle(ctx, c)
+	_, err := ms.ReadAll(datastore.DownloadResumeKey)
+	assert.Equal(t, os.ErrNotExist, err)
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const defaultInventoryEventDebounce = 10 * time.Second
+
+// inventoryEventSource is a file descriptor which polls ready on events
+// which may change the inventory.
+type inventoryEventSource struct {
+	name   string
+	fd     int
+	events int16
+	// Whether the messages must be read, so that it doesn't stay ready.
+	drain bool
+}
+
+// inventoryEventWatcher calls changed after the network interfaces or
+// addresses, the hostname or the mounted filesystems change. Events within
+// the debounce time of the first lead to a single call.
+type inventoryEventWatcher struct {
+	debounce time.Duration
+	changed  func()
+	// The files which poll ready when what they are named after changes.
+	files   map[string]string
+	sources []inventoryEventSource
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newInventoryEventWatcher(debounce time.Duration, changed func()) *inventoryEventWatcher {
+	if debounce <= 0 {
+		debounce = defaultInventoryEventDebounce
+	}
+	return &inventoryEventWatcher{
+		debounce: debounce,
+		changed:  changed,
+		files: map[string]string{
+			"hostname": "/proc/sys/kernel/hostname",
+			"mounts":   "/proc/self/mountinfo",
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// openInventoryEventSources opens the sources of the events, the network and
+// the files. Those which can't be opened are left out.
+func openInventoryEventSources(files map[string]string) []inventoryEventSource {
+	var sources []inventoryEventSource
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK,
+		unix.NETLINK_ROUTE)
+	if err == nil {
+		err = unix.Bind(fd, &unix.SockaddrNetlink{
+			Family: unix.AF_NETLINK,
+			Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+		})
+		if err != nil {
+			unix.Close(fd)
+		}
+	}
+	if err == nil {
+		sources = append(sources, inventoryEventSource{
+			name:   "network",
+			fd:     fd,
+			events: unix.POLLIN,
+			drain:  true,
+		})
+	} else {
+		log.Warnf("Failed to watch the network for inventory changes: %s", err.Error())
+	}
+
+	// These poll ready with POLLPRI when the hostname or the mounts change.
+	for name, path := range files {
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			log.Warnf("Failed to watch the %s for inventory changes: %s", name, err.Error())
+			continue
+		}
+		sources = append(sources, inventoryEventSource{
+			name:   name,
+			fd:     fd,
+			events: unix.POLLPRI,
+		})
+	}
+	return sources
+}
+
+func (w *inventoryEventWatcher) Start() {
+	if w.sources == nil {
+		w.sources = openInventoryEventSources(w.files)
+	}
+	go w.run()
+}
+
+func (w *inventoryEventWatcher) Stop() {
+	close(w.stop)
+	<-w.done
+	for _, source := range w.sources {
+		unix.Close(source.fd)
+	}
+}
+
+func (w *inventoryEventWatcher) run() {
+	defer close(w.done)
+	fds := make([]unix.PollFd, len(w.sources))
+	for i, source := range w.sources {
+		fds[i] = unix.PollFd{Fd: int32(source.fd), Events: source.events}
+	}
+	buf := make([]byte, 65536)
+	var deadline time.Time
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		// Wake up every second to see if the watcher is stopped.
+		timeout := time.Second
+		if !deadline.IsZero() {
+			if left := time.Until(deadline); left < timeout {
+				timeout = left
+			}
+		}
+		if timeout < 0 {
+			timeout = 0
+		}
+		_, err := unix.Poll(fds, int(timeout/time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			log.Errorf("Failed to watch for inventory changes: %s", err.Error())
+			return
+		}
+
+		for i := range fds {
+			if fds[i].Revents == 0 {
+				continue
+			}
+			source := w.sources[i]
+			if source.drain {
+				for {
+					if n, err := unix.Read(source.fd, buf); n <= 0 || err != nil {
+						break
+					}
+				}
+			}
+			if deadline.IsZero() {
+				log.Debugf("The %s changed, submitting the inventory in %s",
+					source.name, w.debounce)
+				deadline = time.Now().Add(w.debounce)
+			}
+		}
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			deadline = time.Time{}
+			w.changed()
+		}
+	}
+}
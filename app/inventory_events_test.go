@@ -0,0 +1,75 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestInventoryEventWatcher(t *testing.T) {
+	var pipe [2]int
+	require.NoError(t, unix.Pipe2(pipe[:], unix.O_NONBLOCK|unix.O_CLOEXEC))
+	defer unix.Close(pipe[1])
+
+	changed := make(chan struct{}, 10)
+	w := newInventoryEventWatcher(100*time.Millisecond, func() { changed <- struct{}{} })
+	w.sources = []inventoryEventSource{
+		{name: "test", fd: pipe[0], events: unix.POLLIN, drain: true},
+	}
+	w.Start()
+	defer w.Stop()
+
+	// A burst of events leads to a single call.
+	for i := 0; i < 3; i++ {
+		_, err := unix.Write(pipe[1], []byte("event"))
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no call after the events")
+	}
+	select {
+	case <-changed:
+		t.Fatal("more than one call after a burst of events")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	_, err := unix.Write(pipe[1], []byte("event"))
+	require.NoError(t, err)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no call after the second event")
+	}
+}
+
+func TestOpenInventoryEventSources(t *testing.T) {
+	sources := openInventoryEventSources(map[string]string{
+		"hostname": "/proc/sys/kernel/hostname",
+		"mounts":   "/nonexistent/mountinfo",
+	})
+	names := []string{}
+	for _, source := range sources {
+		names = append(names, source.name)
+		unix.Close(source.fd)
+	}
+	assert.NotContains(t, names, "mounts")
+}
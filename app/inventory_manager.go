@@ -0,0 +1,169 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/dbus"
+	"github.com/mendersoftware/mender/inventory"
+	"github.com/mendersoftware/mender/store"
+)
+
+const (
+	inventoryManagerSetAttribute      = "SetInventoryAttribute"
+	inventoryManagerRemoveAttribute   = "RemoveInventoryAttribute"
+	InventoryManagerDBusPath          = "/io/mender/InventoryManager"
+	InventoryManagerDBusObjectName    = "io.mender.InventoryManager"
+	InventoryManagerDBusInterfaceName = "io.mender.Inventory1"
+	InventoryManagerDBusInterface     = `
+	    <node>
+	      <interface name="io.mender.Inventory1">
+		<method name="SetInventoryAttribute">
+		  <arg type="s" name="attribute" direction="in"/>
+		  <arg type="b" name="set" direction="out"/>
+		</method>
+		<method name="RemoveInventoryAttribute">
+		  <arg type="s" name="name" direction="in"/>
+		  <arg type="b" name="removed" direction="out"/>
+		</method>
+	      </interface>
+	    </node>`
+)
+
+// InventoryManager lets local applications set inventory attributes over
+// D-Bus, which are submitted with the next inventory. The attribute is JSON,
+// e.g. {"name": "app_version", "value": "1.2"}, and the value may be a list
+// of strings.
+type InventoryManager struct {
+	dbus  dbus.DBusAPI
+	store store.Store
+}
+
+func NewInventoryManager(api dbus.DBusAPI, dbStore store.Store) *InventoryManager {
+	return &InventoryManager{
+		dbus:  api,
+		store: dbStore,
+	}
+}
+
+func (i *InventoryManager) Start() (context.CancelFunc, error) {
+	if i.dbus == nil {
+		return nil, errors.New("DBus not enabled")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = i.run(ctx)
+	}()
+	return cancel, nil
+}
+
+// parseRuntimeAttribute parses the attribute of SetInventoryAttribute.
+func parseRuntimeAttribute(attribute string) (string, []string, error) {
+	var attr struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(attribute), &attr); err != nil {
+		return "", nil, errors.Wrap(err, "invalid inventory attribute")
+	}
+	var value string
+	if err := json.Unmarshal(attr.Value, &value); err == nil {
+		return attr.Name, []string{value}, nil
+	}
+	var values []string
+	if err := json.Unmarshal(attr.Value, &values); err != nil {
+		return "", nil, errors.New("the value of an inventory attribute must be " +
+			"a string or a list of strings")
+	}
+	return attr.Name, values, nil
+}
+
+func (i *InventoryManager) run(ctx context.Context) error {
+	dbusConn, err := i.dbus.BusGet(dbus.GBusTypeSystem)
+	if err != nil {
+		return errors.Wrap(err, "Failed to start the inventory manager")
+	}
+	nameGID, err := i.dbus.BusOwnNameOnConnection(
+		dbusConn,
+		InventoryManagerDBusObjectName,
+		dbus.DBusNameOwnerFlagsAllowReplacement|dbus.DBusNameOwnerFlagsReplace,
+	)
+	if err != nil {
+		return errors.Wrap(err, "Failed to start the inventory manager")
+	}
+	defer i.dbus.BusUnownName(nameGID)
+	intGID, err := i.dbus.BusRegisterInterface(
+		dbusConn,
+		InventoryManagerDBusPath,
+		InventoryManagerDBusInterface,
+	)
+	if err != nil {
+		log.Errorf("Failed to register the DBus interface name %q at path %q: %s",
+			InventoryManagerDBusInterface,
+			InventoryManagerDBusPath,
+			err,
+		)
+		return err
+	}
+	defer i.dbus.BusUnregisterInterface(dbusConn, intGID)
+
+	i.dbus.RegisterMethodCallCallback(
+		InventoryManagerDBusPath,
+		InventoryManagerDBusInterfaceName,
+		inventoryManagerSetAttribute,
+		func(_ string, _ string, _ string, attribute string) (interface{}, error) {
+			name, values, err := parseRuntimeAttribute(attribute)
+			if err == nil {
+				err = inventory.SetRuntimeAttribute(i.store, name, values)
+			}
+			if err != nil {
+				log.Errorf("Failed to set an inventory attribute via D-Bus: %s", err)
+				return false, err
+			}
+			log.Infof("Inventory attribute %s set via D-Bus", name)
+			return true, nil
+		})
+	defer i.dbus.UnregisterMethodCallCallback(
+		InventoryManagerDBusPath,
+		InventoryManagerDBusInterfaceName,
+		inventoryManagerSetAttribute)
+
+	i.dbus.RegisterMethodCallCallback(
+		InventoryManagerDBusPath,
+		InventoryManagerDBusInterfaceName,
+		inventoryManagerRemoveAttribute,
+		func(_ string, _ string, _ string, name string) (interface{}, error) {
+			removed, err := inventory.RemoveRuntimeAttribute(i.store, name)
+			if err != nil {
+				log.Errorf("Failed to remove the inventory attribute %s via D-Bus: %s",
+					name, err)
+				return false, err
+			}
+			if removed {
+				log.Infof("Inventory attribute %s removed via D-Bus", name)
+			}
+			return removed, nil
+		})
+	defer i.dbus.UnregisterMethodCallCallback(
+		InventoryManagerDBusPath,
+		InventoryManagerDBusInterfaceName,
+		inventoryManagerRemoveAttribute)
+	<-ctx.Done()
+	return nil
+}
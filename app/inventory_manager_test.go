@@ -0,0 +1,42 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRuntimeAttribute(t *testing.T) {
+	name, values, err := parseRuntimeAttribute(`{"name": "app_version", "value": "1.2"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "app_version", name)
+	assert.Equal(t, []string{"1.2"}, values)
+
+	name, values, err = parseRuntimeAttribute(`{"name": "sensors", "value": ["a", "b"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, "sensors", name)
+	assert.Equal(t, []string{"a", "b"}, values)
+
+	for _, attribute := range []string{
+		`app_version=1.2`,
+		`{"name": "app_version", "value": 1.2}`,
+		`{"name": "app_version"}`,
+	} {
+		_, _, err = parseRuntimeAttribute(attribute)
+		assert.Error(t, err, attribute)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	localAPIStatePath           = "/state"
+	localAPICheckUpdatePath     = "/check-update"
+	localAPIInventoryUpdatePath = "/update-inventory"
+	localAPIReauthorizePath     = "/reauthorize"
+)
+
+// localAPIState is the answer to GET /state.
+type localAPIState struct {
+	State        string `json:"state"`
+	ArtifactName string `json:"artifact_name,omitempty"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Authorized   bool   `json:"authorized"`
+}
+
+// localAPIListener serves a small HTTP API on a Unix domain socket, for
+// applications which can't reach the system D-Bus, such as those running in
+// containers. Access is controlled by the permissions of the socket.
+type localAPIListener struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// newLocalAPIListener opens the socket. It is only accessible by the owner,
+// unless a group is given, in which case the group is given access too.
+func newLocalAPIListener(path, group string, d *MenderDaemon) (*localAPIListener, error) {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid LocalAPISocketGroup %q", group)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, errors.Wrapf(err, "invalid LocalAPISocketGroup %q", group)
+		}
+	}
+
+	// A socket left behind by a crashed client would fail the listen.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the local API socket")
+	}
+	mode := os.FileMode(0600)
+	if gid >= 0 {
+		mode = 0660
+		err = os.Chown(path, -1, gid)
+	}
+	if err == nil {
+		err = os.Chmod(path, mode)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "failed to set the permissions of the local API socket")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(localAPIStatePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d.localAPIState())
+	})
+	mux.HandleFunc(localAPICheckUpdatePath, forceStateHandler(d, States.UpdateCheck))
+	mux.HandleFunc(localAPIInventoryUpdatePath, forceStateHandler(d, States.InventoryUpdate))
+	mux.HandleFunc(localAPIReauthorizePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		d.Reauthorize()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return &localAPIListener{
+		listener: listener,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// forceStateHandler triggers the state, the same way the SIGUSR signals do.
+func forceStateHandler(d *MenderDaemon, state State) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		d.forceState(state)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (l *localAPIListener) Start() {
+	log.Infof("Serving the local API on %s", l.listener.Addr())
+	go func() {
+		err := l.server.Serve(l.listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Local API listener failed: %s", err.Error())
+		}
+	}()
+}
+
+// Stop closes the listener, which also removes the socket.
+func (l *localAPIListener) Stop() {
+	if err := l.server.Close(); err != nil {
+		log.Warnf("Failed to close the local API listener: %s", err.Error())
+	}
+}
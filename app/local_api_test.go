@@ -0,0 +1,126 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestLocalAPIListener(t *testing.T) {
+	tdir := t.TempDir()
+	socket := path.Join(tdir, "mender.sock")
+
+	ctrl := &stateTestController{
+		artifactName: "release-1",
+		state:        States.Idle,
+	}
+	config := &conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{LocalAPISocket: socket},
+	}
+	d, err := NewDaemon(config, ctrl, store.NewMemStore(), nil)
+	require.NoError(t, err)
+	d.localAPIListener.Start()
+	defer d.localAPIListener.Stop()
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	cl := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	getState := func() localAPIState {
+		rsp, err := cl.Get("http://mender" + localAPIStatePath)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var state localAPIState
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&state))
+		return state
+	}
+	assert.Equal(t, localAPIState{State: "idle", ArtifactName: "release-1"}, getState())
+
+	update := &datastore.UpdateInfo{ID: "deployment"}
+	d.setCurrentState(NewUpdateFetchState(update))
+	assert.Equal(t, localAPIState{
+		State:        "update-fetch",
+		ArtifactName: "release-1",
+		DeploymentID: "deployment",
+	}, getState())
+
+	rsp, err := cl.Post("http://mender"+localAPIStatePath, "", nil)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+
+	rsp, err = cl.Get("http://mender" + localAPICheckUpdatePath)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+
+	rsp, err = cl.Post("http://mender"+localAPICheckUpdatePath, "", nil)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	assert.Equal(t, States.UpdateCheck, <-d.ForceToState)
+
+	rsp, err = cl.Post("http://mender"+localAPIReauthorizePath, "", nil)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	assert.Equal(t, States.InventoryUpdate, <-d.ForceToState)
+	assert.Len(t, d.reauthorize, 1)
+
+	rsp, err = cl.Post("http://mender"+localAPIInventoryUpdatePath, "", nil)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	assert.Equal(t, States.InventoryUpdate, <-d.ForceToState)
+
+	// A socket left behind is replaced.
+	d.localAPIListener.Stop()
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	d, err = NewDaemon(config, ctrl, store.NewMemStore(), nil)
+	require.NoError(t, err)
+	d.localAPIListener.Stop()
+
+	_, err = NewDaemon(&conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			LocalAPISocket:      socket,
+			LocalAPISocketGroup: "no-such-group-here",
+		},
+	}, ctrl, store.NewMemStore(), nil)
+	assert.Error(t, err)
+}
@@ -14,10 +14,13 @@
 package app
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -45,6 +48,7 @@ type Controller interface {
 	GetControlMapPool() *ControlMapPool
 
 	GetCurrentArtifactName() (string, error)
+	GetAntiRollbackProvide() string
 	GetUpdatePollInterval() time.Duration
 	GetInventoryPollInterval() time.Duration
 	GetRetryPollInterval() time.Duration
@@ -53,13 +57,20 @@ type Controller interface {
 	HandleBootstrapArtifact(s store.Store) error
 
 	CheckUpdate() (*datastore.UpdateInfo, menderError)
-	FetchUpdate(url string) (io.ReadCloser, int64, error)
+	FetchUpdate(update *datastore.UpdateInfo) (io.ReadCloser, int64, error)
+	CancelDownload() bool
+	PauseDeployment(id string) error
+	ResumeDeployment() bool
+	DeploymentPaused(id string) bool
 	RefreshServerUpdateControlMap(deploymentID string) error
+	UntilMaintenanceWindow(t Transition) time.Duration
 
 	NewStatusReportWrapper(updateId string,
 		stateId datastore.MenderState) *client.StatusReportWrapper
 	ReportUpdateStatus(update *datastore.UpdateInfo, status string) menderError
+	ReportUpdateProgress(update *datastore.UpdateInfo, report client.StatusReport) menderError
 	UploadLog(update *datastore.UpdateInfo, logs []byte) menderError
+	QueueStatusReport(update *datastore.UpdateInfo, status string, statusSent bool)
 	InventoryRefresh() error
 
 	CheckScriptsCompatibility() error
@@ -127,8 +138,26 @@ type Mender struct {
 	api client.AuthorizedApiRequester
 	// Used for downloading artifacts.
 	download client.ApiRequester
+	// The artifact download in progress, if any.
+	activeDownload *downloadStream
+	downloadLock   sync.Mutex
+	// The deployment paused locally, if any.
+	pause deploymentPause
+	// Nil unless artifacts are shared with other devices.
+	peers *peerSharing
 
 	controlMapPool *ControlMapPool
+
+	// Reports which couldn't be delivered, waiting for the server.
+	offlineQueue *offlineQueue
+	inventory    client.InventorySubmitter
+	// Kept between inventory updates, for the scripts and providers which
+	// aren't run on every one.
+	inventoryRunner *inv.InventoryDataRunner
+	// Tells the time, when checking the time windows.
+	now func() time.Time
+	// How often the state of a resumable download is stored.
+	downloadResumeInterval int64
 }
 
 type MenderPieces struct {
@@ -140,38 +169,79 @@ type MenderPieces struct {
 func NewMender(config *conf.MenderConfig, pieces MenderPieces) (*Mender, error) {
 	stateScrExec := dev.NewStateScriptExecutor(config)
 
+	client.ConfigureExponentialBackoff(
+		time.Duration(config.RetryPollBaseIntervalSeconds)*time.Second,
+		config.RetryPollJitterPercent,
+	)
+
+	client.ConfigureUserAgent(!config.DisableUserAgentMetadata)
+	if err := client.ConfigureTracing(config.TracingCollectorURL); err != nil {
+		return nil, err
+	}
+
 	controlMapPool := NewControlMap(
 		pieces.Store,
 		config.GetUpdateControlMapBootExpirationTimeSeconds(),
 		config.GetUpdateControlMapExpirationTimeSeconds(),
 	)
 
+	updater := client.NewUpdate()
+	updater.DownloadConnections = config.DownloadConnections
+	updater.DownloadChunkSize = int64(config.DownloadChunkSizeKiB) * 1024
+	updater.ResponseCache = pieces.Store
+
 	m := &Mender{
 		DeviceManager:       dev.NewDeviceManager(pieces.DualRootfsDevice, config, pieces.Store),
-		updater:             client.NewUpdate(),
+		updater:             updater,
 		state:               States.Init,
 		stateScriptExecutor: stateScrExec,
 		authManager:         pieces.AuthManager,
 		controlMapPool:      controlMapPool,
+		now:                 time.Now,
+
+		downloadResumeInterval: defaultDownloadResumeInterval,
 	}
+	if pieces.Store != nil {
+		m.offlineQueue = newOfflineQueue(pieces.Store)
+	}
+	m.loadDeploymentPause()
+	m.peers = newPeerSharing(config, pieces.Store)
 
 	api, err := client.NewReauthorizingClient(config.GetHttpConfig(), m.Authorize)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating HTTP API client")
 	}
 	m.api = api
+	if config.Security.SignPayloads && pieces.AuthManager != nil {
+		api.SetPayloadSigner(pieces.AuthManager.SignPayload)
+	}
 
 	m.download, err = client.NewApiClient(config.GetHttpConfig())
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating HTTP download client")
 	}
 
+	// The artifact name is added with the first update check, or inventory
+	// submission.
+	if deviceType, err := m.GetDeviceType(); err == nil {
+		client.SetUserAgentMetadata(deviceType, "")
+	}
+
 	return m, nil
 }
 
 func (m *Mender) Authorize() (client.AuthToken, client.ServerURL, error) {
-	inChan := m.authManager.GetInMessageChan()
-	broadcastChan := m.authManager.GetBroadcastMessageChan(authManagerChannelName)
+	return authorize(m.authManager, authManagerChannelName)
+}
+
+// authorize has the auth manager fetch a new token, and waits for it on the
+// named broadcast channel.
+func authorize(
+	authManager AuthManager,
+	channelName string,
+) (client.AuthToken, client.ServerURL, error) {
+	inChan := authManager.GetInMessageChan()
+	broadcastChan := authManager.GetBroadcastMessageChan(channelName)
 	respChan := make(chan AuthManagerResponse, 1)
 
 	// drain the broadcast channel
@@ -219,8 +289,106 @@ func (m *Mender) GetControlMapPool() *ControlMapPool {
 	return m.controlMapPool
 }
 
-func (m *Mender) FetchUpdate(url string) (io.ReadCloser, int64, error) {
-	return m.updater.FetchUpdate(m.download, url, m.GetRetryPollInterval())
+func (m *Mender) FetchUpdate(update *datastore.UpdateInfo) (io.ReadCloser, int64, error) {
+	url := update.URI()
+	ctx, cancel := context.WithCancel(context.Background())
+	download := &downloadStream{mender: m, ctx: ctx, cancel: cancel}
+	m.downloadLock.Lock()
+	m.activeDownload = download
+	m.downloadLock.Unlock()
+
+	var image io.ReadCloser
+	var imageSize int64
+	var err error
+	// Another device on the local network may have the artifact already.
+	if m.peers != nil {
+		if deviceType, err := m.GetDeviceType(); err != nil {
+			log.Warnf("Not fetching the artifact from other devices: %s", err.Error())
+		} else {
+			image, imageSize = m.peers.fetch(ctx, update.ArtifactName(), deviceType,
+				update.CompatibleDevices())
+		}
+	}
+	// Otherwise it is fetched through the artifact cache of the site, if any.
+	if image == nil && m.Config.ArtifactCacheURL != "" {
+		image, imageSize = m.fetchThroughCache(ctx, update)
+		if image != nil && m.peers != nil {
+			image = m.peers.keep(update.ArtifactName(), update.CompatibleDevices(),
+				image, imageSize)
+		}
+	}
+	if image == nil {
+		if m.Config.DownloadResumeDir != "" && m.Store != nil {
+			image, imageSize, err = m.fetchResumableUpdate(ctx, url)
+		} else {
+			image, imageSize, err = m.updater.FetchUpdate(ctx, m.download, url,
+				m.GetRetryPollInterval())
+		}
+		if err == nil && m.peers != nil {
+			image = m.peers.keep(update.ArtifactName(), update.CompatibleDevices(),
+				image, imageSize)
+		}
+	}
+	if err != nil {
+		download.release()
+		return image, imageSize, err
+	}
+	download.ReadCloser = image
+	if m.Config.DownloadRateLimitKiB <= 0 {
+		return download, imageSize, nil
+	}
+	log.Infof("Limiting the download rate to %d KiB/s", m.Config.DownloadRateLimitKiB)
+	return client.NewRateLimitedReader(
+		download,
+		int64(m.Config.DownloadRateLimitKiB)*1024,
+	), imageSize, nil
+}
+
+// CancelDownload tears down the artifact download in progress, so that
+// reading the stream returned by FetchUpdate fails right away, instead of
+// when the connection times out. It returns whether there was a download to
+// cancel.
+func (m *Mender) CancelDownload() bool {
+	m.downloadLock.Lock()
+	download := m.activeDownload
+	m.downloadLock.Unlock()
+	if download == nil {
+		return false
+	}
+	log.Info("Cancelling the artifact download")
+	download.release()
+	return true
+}
+
+// downloadStream is the artifact download in progress, its context is
+// released once the artifact has been read.
+type downloadStream struct {
+	io.ReadCloser
+	mender *Mender
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Read waits while the deployment is paused. The connection may time out
+// meanwhile, in which case the download is resumed where it stopped.
+func (d *downloadStream) Read(b []byte) (int, error) {
+	d.mender.waitWhileDeploymentPaused(d.ctx)
+	return d.ReadCloser.Read(b)
+}
+
+func (d *downloadStream) release() {
+	d.cancel()
+	d.mender.downloadLock.Lock()
+	if d.mender.activeDownload == d {
+		d.mender.activeDownload = nil
+	}
+	d.mender.downloadLock.Unlock()
+}
+
+func (d *downloadStream) Close() error {
+	err := d.ReadCloser.Close()
+	d.release()
+	return err
 }
 
 func verifyArtifactDependencies(
@@ -294,6 +462,7 @@ func (m *Mender) CheckUpdate() (*datastore.UpdateInfo, menderError) {
 		log.Errorf("Unable to verify the existing hardware. Update will continue anyways: %v : %v",
 			m.Config.DeviceTypeFile, err)
 	}
+	client.SetUserAgentMetadata(deviceType, currentArtifactName)
 	provides, err := m.DeviceManager.GetProvides()
 	if err != nil {
 		log.Errorf(
@@ -313,6 +482,11 @@ func (m *Mender) CheckUpdate() (*datastore.UpdateInfo, menderError) {
 
 	ur, urOk := haveUpdate.(client.UpdateResponse)
 
+	if err == nil || errors.Is(err, client.ErrNoDeploymentAvailable) {
+		// The server can be reached again.
+		m.flushOfflineQueue()
+	}
+
 	if err != nil {
 		if errors.Is(err, client.ErrNoDeploymentAvailable) {
 			return ur.UpdateInfo, NewTransientError(err)
@@ -336,6 +510,11 @@ func (m *Mender) CheckUpdate() (*datastore.UpdateInfo, menderError) {
 	}
 
 	log.Debugf("Received update response: %v", ur)
+	if !m.inDownloadWindow() {
+		log.Infof("Leaving deployment %s until the next update check in one of the "+
+			"DownloadWindows", ur.ID)
+		return nil, nil
+	}
 	if err = m.HandleControlMap(ur.ID, ur.UpdateControlMap); err != nil {
 		return ur.UpdateInfo, NewTransientError(err)
 	}
@@ -343,6 +522,56 @@ func (m *Mender) CheckUpdate() (*datastore.UpdateInfo, menderError) {
 	return ur.UpdateInfo, nil
 }
 
+// untilTimeWindow returns how long it is from now, in local time, until one
+// of the windows opens: zero if one is open, or if there are none.
+func untilTimeWindow(specs []string, now time.Time) time.Duration {
+	if len(specs) == 0 {
+		return 0
+	}
+	var next time.Time
+	found := false
+	for _, spec := range specs {
+		window, err := conf.ParseTimeWindow(spec)
+		if err != nil {
+			log.Errorf("Ignoring the time window %q: %s", spec, err.Error())
+			continue
+		}
+		if window.Contains(now) {
+			return 0
+		}
+		found = true
+		if start, ok := window.Next(now); ok && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	if !found {
+		return 0
+	}
+	if next.IsZero() {
+		// None opens within a year, check again tomorrow.
+		return 24 * time.Hour
+	}
+	return next.Sub(now)
+}
+
+// inDownloadWindow tells whether artifacts may be downloaded now.
+func (m *Mender) inDownloadWindow() bool {
+	return untilTimeWindow(m.Config.DownloadWindows, m.now()) == 0
+}
+
+// UntilMaintenanceWindow returns how long it is until the device may reboot
+// into the update, or commit it, depending on the transition, zero if it may
+// right away.
+func (m *Mender) UntilMaintenanceWindow(t Transition) time.Duration {
+	switch t {
+	case ToArtifactReboot_Enter:
+		return untilTimeWindow(m.Config.RebootWindows, m.now())
+	case ToArtifactCommit_Enter:
+		return untilTimeWindow(m.Config.CommitWindows, m.now())
+	}
+	return 0
+}
+
 // RefreshServerUpdateControlMap updates the control maps from the server during
 // a deployment.
 func (m *Mender) RefreshServerUpdateControlMap(deploymentID string) error {
@@ -397,14 +626,28 @@ func (m *Mender) NewStatusReportWrapper(updateId string,
 }
 
 func (m *Mender) ReportUpdateStatus(update *datastore.UpdateInfo, status string) menderError {
+	return m.reportStatus(client.StatusReport{
+		DeploymentID: update.ID,
+		Status:       status,
+	})
+}
+
+// ReportUpdateProgress reports the status of the update together with how far
+// the device has got in it.
+func (m *Mender) ReportUpdateProgress(
+	update *datastore.UpdateInfo,
+	report client.StatusReport,
+) menderError {
+	report.DeploymentID = update.ID
+	return m.reportStatus(report)
+}
+
+func (m *Mender) reportStatus(report client.StatusReport) menderError {
 	s := client.NewStatus()
 	err := s.Report(
 		m.api,
 		m.Config.Servers[0].ServerURL,
-		client.StatusReport{
-			DeploymentID: update.ID,
-			Status:       status,
-		},
+		report,
 	)
 	if err != nil {
 		log.Error("error reporting update status: ", err)
@@ -418,7 +661,10 @@ func (m *Mender) ReportUpdateStatus(update *datastore.UpdateInfo, status string)
 }
 
 func (m *Mender) UploadLog(update *datastore.UpdateInfo, logs []byte) menderError {
-	s := client.NewLog()
+	s := &client.LogUploadClient{
+		ChunkSize: m.Config.DeploymentLogChunkSizeKiB * 1024,
+		MaxWait:   m.GetRetryPollInterval(),
+	}
 	err := s.Upload(
 		m.api,
 		m.Config.Servers[0].ServerURL,
@@ -440,7 +686,7 @@ func (m *Mender) GetUpdatePollInterval() time.Duration {
 		log.Warn("UpdatePollIntervalSeconds is not defined")
 		t = 30 * time.Minute
 	}
-	return t
+	return m.hintedPollInterval(t, client.UpdatePollIntervalHint())
 }
 
 func (m *Mender) GetInventoryPollInterval() time.Duration {
@@ -449,7 +695,25 @@ func (m *Mender) GetInventoryPollInterval() time.Duration {
 		log.Warn("InventoryPollIntervalSeconds is not defined")
 		t = 30 * time.Minute
 	}
-	return t
+	return m.hintedPollInterval(t, client.InventoryPollIntervalHint())
+}
+
+// hintedPollInterval returns the poll interval hinted by the server, within
+// the configured bounds, instead of the local interval, if there is a hint
+// and hints are enabled.
+func (m *Mender) hintedPollInterval(local, hint time.Duration) time.Duration {
+	max := time.Duration(m.Config.PollIntervalHintMaxSeconds) * time.Second
+	if hint <= 0 || max <= 0 {
+		return local
+	}
+	min := time.Duration(m.Config.PollIntervalHintMinSeconds) * time.Second
+	if hint < min {
+		hint = min
+	}
+	if hint > max {
+		hint = max
+	}
+	return hint
 }
 
 func (m *Mender) GetRetryPollInterval() time.Duration {
@@ -584,9 +848,35 @@ func transitionState(to State, ctx *StateContext, c Controller) (State, bool) {
 	return to.Handle(ctx, c)
 }
 
+// inventoryClient returns the client used for all inventory submissions,
+// which remembers what was last submitted.
+func (m *Mender) inventoryClient() client.InventorySubmitter {
+	if m.inventory == nil {
+		m.inventory = &client.InventoryClient{
+			GzipThresholdBytes: m.Config.InventoryGzipThresholdBytes,
+			MaxAge:             time.Duration(m.Config.InventoryMaxAgeSeconds) * time.Second,
+			Delta:              m.Config.InventoryDeltaSubmission,
+			MaxSizeBytes:       m.Config.InventoryMaxSizeBytes,
+			Cache:              m.Store,
+		}
+	}
+	return m.inventory
+}
+
 func (m *Mender) InventoryRefresh() error {
-	ic := client.NewInventory()
-	idg := inv.NewInventoryDataRunner(path.Join(conf.GetDataDirPath(), "inventory"))
+	ic := m.inventoryClient()
+	if m.inventoryRunner == nil {
+		dirs := append([]string{path.Join(conf.GetDataDirPath(), "inventory")},
+			m.Config.InventoryScriptsDirs...)
+		idg := inv.NewInventoryDataRunner(dirs...)
+		idg.SetBuiltinProviders(&m.Config)
+		if m.Store != nil {
+			idg.SetProvides(m.GetProvides)
+			idg.SetRuntimeStore(m.Store)
+		}
+		m.inventoryRunner = &idg
+	}
+	idg := m.inventoryRunner
 
 	artifactName, err := m.GetCurrentArtifactName()
 	if err != nil || artifactName == "" {
@@ -615,6 +905,7 @@ func (m *Mender) InventoryRefresh() error {
 			err,
 		)
 	}
+	client.SetUserAgentMetadata(deviceType, artifactName)
 	reqAttr := []client.InventoryAttribute{
 		{Name: "device_type", Value: deviceType},
 		{Name: "artifact_name", Value: artifactName},
@@ -625,6 +916,23 @@ func (m *Mender) InventoryRefresh() error {
 		idata = make(client.InventoryData, 0, len(reqAttr))
 	}
 	_ = idata.ReplaceAttributes(reqAttr)
+	if m.Config.Attestation.Inventory {
+		if attestation := dev.NewAttestation(m.Config.Attestation); attestation != nil {
+			nonce := make([]byte, 32)
+			_, err = rand.Read(nonce)
+			if err == nil {
+				var evidence *client.AttestationEvidence
+				if evidence, err = attestation.Collect(nonce, false); err == nil {
+					_ = idata.ReplaceAttributes(dev.AttestationInventory(evidence))
+				}
+			}
+			if err != nil {
+				log.Errorf("Failed to collect attestation evidence: %s", err)
+			}
+		}
+	}
+	idata = filterInventory(idata, utils.NewAttributeFilter(m.Config.HashedAttributes,
+		m.Config.OmittedAttributes, m.Config.AttributeHashKey))
 
 	if idata == nil {
 		log.Infof("No inventory data to submit")
@@ -633,12 +941,57 @@ func (m *Mender) InventoryRefresh() error {
 
 	err = ic.Submit(m.api, m.Config.Servers[0].ServerURL, idata)
 	if err != nil {
+		if m.offlineQueue != nil {
+			m.offlineQueue.add(offlineQueueEntry{
+				Kind:      offlineQueueInventory,
+				Inventory: idata,
+			})
+		}
 		return errors.Wrapf(err, "failed to submit inventory data")
 	}
 
+	// Any queued inventory is older than what was just sent.
+	if m.offlineQueue != nil {
+		m.offlineQueue.remove(offlineQueueInventory)
+	}
+	m.flushOfflineQueue()
+
 	return nil
 }
 
+// ReportDecommission authorizes and sets the mender_decommissioned inventory
+// attribute of the device to the current time, so that the server knows the
+// device is being decommissioned, and it can be removed there.
+func (m *Mender) ReportDecommission() error {
+	if _, _, err := m.Authorize(); err != nil {
+		return err
+	}
+	return client.UpdateInventoryAttributes(m.api, m.Config.Servers[0].ServerURL,
+		client.InventoryData{
+			{Name: "mender_decommissioned", Value: time.Now().UTC().Format(time.RFC3339)},
+		})
+}
+
+// filterInventory hashes or omits the attributes the filter says to.
+func filterInventory(
+	idata client.InventoryData,
+	filter *utils.AttributeFilter,
+) client.InventoryData {
+	if filter == nil {
+		return idata
+	}
+	filtered := make(client.InventoryData, 0, len(idata))
+	for _, attr := range idata {
+		if value, ok := filter.Filter(attr.Name, attr.Value); ok {
+			filtered = append(filtered, client.InventoryAttribute{
+				Name:  attr.Name,
+				Value: value,
+			})
+		}
+	}
+	return filtered
+}
+
 func (m *Mender) CheckScriptsCompatibility() error {
 	return m.stateScriptExecutor.CheckRootfsScriptsVersion()
 }
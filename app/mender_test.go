@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"syscall"
@@ -46,6 +48,7 @@ import (
 	"github.com/mendersoftware/mender/store"
 	stest "github.com/mendersoftware/mender/system/testing"
 	"github.com/mendersoftware/mender/tests"
+	"github.com/mendersoftware/mender/utils"
 )
 
 const defaultKeyPassphrase = ""
@@ -173,6 +176,31 @@ func Test_CheckUpdateSimple(t *testing.T) {
 		assert.Equal(t, *up, srv.Update.Data)
 	}
 
+	// Outside of the download windows, the deployment is left for later.
+	mender.now = func() time.Time {
+		return time.Date(2023, 5, 17, 12, 30, 0, 0, time.Local)
+	}
+	mender.Config.DownloadWindows = []string{"* 1-4 * * *", "* 22-23 * * 3"}
+	up, err = mender.CheckUpdate()
+	assert.NoError(t, err)
+	assert.Nil(t, up)
+	mender.now = func() time.Time {
+		return time.Date(2023, 5, 17, 22, 30, 0, 0, time.Local)
+	}
+	up, err = mender.CheckUpdate()
+	assert.NoError(t, err)
+	assert.NotNil(t, up)
+	mender.Config.DownloadWindows = nil
+
+	mender.Config.RebootWindows = []string{"0 3 * * *"}
+	mender.Config.CommitWindows = []string{"* 22-23 * * *", "* 20 * * *"}
+	assert.Equal(t, 4*time.Hour+30*time.Minute,
+		mender.UntilMaintenanceWindow(ToArtifactReboot_Enter))
+	assert.Equal(t, time.Duration(0), mender.UntilMaintenanceWindow(ToArtifactCommit_Enter))
+	assert.Equal(t, time.Duration(0), mender.UntilMaintenanceWindow(ToArtifactInstall))
+	mender.Config.RebootWindows = nil
+	mender.Config.CommitWindows = nil
+
 	// pretend that we got 204 No Content from the server, i.e empty response body
 	srv.Update.Has = false
 	up, err = mender.CheckUpdate()
@@ -273,6 +301,20 @@ func TestMenderGetInventoryPollInterval(t *testing.T) {
 	assert.Equal(t, time.Duration(10)*time.Second, intvl)
 }
 
+func TestMenderHintedPollInterval(t *testing.T) {
+	mender := newTestMender(conf.MenderConfig{}, testMenderPieces{})
+
+	// Hints are ignored unless enabled.
+	assert.Equal(t, time.Minute, mender.hintedPollInterval(time.Minute, time.Hour))
+
+	mender.Config.PollIntervalHintMinSeconds = 300
+	mender.Config.PollIntervalHintMaxSeconds = 7200
+	assert.Equal(t, time.Hour, mender.hintedPollInterval(time.Minute, time.Hour))
+	assert.Equal(t, time.Minute, mender.hintedPollInterval(time.Minute, 0))
+	assert.Equal(t, 5*time.Minute, mender.hintedPollInterval(time.Minute, time.Second))
+	assert.Equal(t, 2*time.Hour, mender.hintedPollInterval(time.Minute, 24*time.Hour))
+}
+
 type testAuthDataMessenger struct {
 	reqData  []byte
 	sigData  []byte
@@ -594,6 +636,25 @@ echo foo=bar`),
 		assert.Contains(t, srv.Inventory.Attrs, a)
 	}
 
+	// 2a. attributes hashed or omitted as configured
+	mender.Config.HashedAttributes = []string{"foo"}
+	mender.Config.OmittedAttributes = []string{"mender_client_version"}
+	srv.Reset()
+	srv.Auth.Verify = true
+	srv.Auth.Token = []byte("tokendata")
+	err = mender.InventoryRefresh()
+	assert.Nil(t, err)
+	hashed, _ := utils.NewAttributeFilter([]string{"foo"}, nil, "").Filter("foo", "bar")
+	assert.Contains(t, srv.Inventory.Attrs,
+		client.InventoryAttribute{Name: "foo", Value: hashed})
+	assert.Contains(t, srv.Inventory.Attrs,
+		client.InventoryAttribute{Name: "device_type", Value: "foo-bar"})
+	for _, a := range srv.Inventory.Attrs {
+		assert.NotEqual(t, "mender_client_version", a.Name)
+	}
+	mender.Config.HashedAttributes = nil
+	mender.Config.OmittedAttributes = nil
+
 	// no artifact name should error
 	mender.Store.WriteAll(datastore.ArtifactNameKey, []byte(""))
 	err = mender.InventoryRefresh()
@@ -783,6 +844,13 @@ func TestMenderStoreUpdate(t *testing.T) {
 
 }
 
+// updateFrom returns an update with the artifact at the URL.
+func updateFrom(url string) *datastore.UpdateInfo {
+	update := &datastore.UpdateInfo{}
+	update.Artifact.Source.URI = url
+	return update
+}
+
 func TestMenderFetchUpdate(t *testing.T) {
 	srv := cltest.NewClientTestServer()
 	defer srv.Close()
@@ -815,7 +883,7 @@ func TestMenderFetchUpdate(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, rcount, len(rbytes))
 
-	img, sz, err := mender.FetchUpdate(srv.URL + "/api/devices/v1/download")
+	img, sz, err := mender.FetchUpdate(updateFrom(srv.URL + "/api/devices/v1/download"))
 	assert.NoError(t, err)
 	assert.NotNil(t, img)
 	assert.EqualValues(t, len(rbytes), sz)
@@ -826,6 +894,58 @@ func TestMenderFetchUpdate(t *testing.T) {
 	assert.EqualValues(t, sz, dl.Len())
 
 	assert.True(t, bytes.Equal(rbytes, dl.Bytes()))
+
+	// With a rate limit configured the download is throttled.
+	mender.Config.DownloadRateLimitKiB = 64
+	_, err = io.Copy(&srv.UpdateDownload.Data, bytes.NewReader(rbytes))
+	assert.NoError(t, err)
+	img, _, err = mender.FetchUpdate(updateFrom(srv.URL + "/api/devices/v1/download"))
+	assert.NoError(t, err)
+	_, ok := img.(*client.RateLimitedReader)
+	assert.True(t, ok)
+	dl.Reset()
+	_, err = io.Copy(&dl, img)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(rbytes, dl.Bytes()))
+}
+
+func TestMenderCancelDownload(t *testing.T) {
+	// Sends half the artifact, and then stalls until the client goes away.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8192")
+		w.WriteHeader(http.StatusOK)
+		w.Write(make([]byte, 4096))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	mender := newTestMender(conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			ServerURL: srv.URL,
+		},
+	},
+		testMenderPieces{
+			MenderPieces: MenderPieces{
+				Store: store.NewMemStore(),
+			},
+		})
+	assert.False(t, mender.CancelDownload())
+
+	img, _, err := mender.FetchUpdate(updateFrom(srv.URL))
+	require.NoError(t, err)
+	defer img.Close()
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		assert.True(t, mender.CancelDownload())
+	}()
+	start := time.Now()
+	_, err = io.Copy(ioutil.Discard, img)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	// Nothing left to cancel.
+	assert.False(t, mender.CancelDownload())
 }
 
 // TestReauthorization triggers the reauthorization mechanic when
@@ -1096,7 +1216,7 @@ func TestMutualTLSClientConnection(t *testing.T) {
 			defer eraseLastErrorLogHook()
 
 			test.conf.ServerURL = srv.URL
-			test.conf.Servers = []conf.MenderServer{{srv.URL}}
+			test.conf.Servers = []conf.MenderServer{{ServerURL: srv.URL}}
 
 			ms := store.NewMemStore()
 			mender := newTestMender(conf.MenderConfig{
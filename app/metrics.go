@@ -0,0 +1,76 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+)
+
+const metricsPath = "/metrics"
+
+// metricsListener serves the HTTP client metrics on a local address, for
+// node_exporter or Prometheus to scrape.
+type metricsListener struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// newMetricsListener opens the listener. Only loopback addresses are
+// accepted, since the metrics are served without authentication.
+func newMetricsListener(address string) (*metricsListener, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid MetricsListenAddress %q", address)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, errors.Errorf(
+			"MetricsListenAddress %q is not a loopback address", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the metrics listener")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, client.MetricsHandler())
+	return &metricsListener{
+		listener: listener,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+func (l *metricsListener) Start() {
+	log.Infof("Serving metrics on http://%s%s", l.listener.Addr(), metricsPath)
+	go func() {
+		err := l.server.Serve(l.listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics listener failed: %s", err.Error())
+		}
+	}()
+}
+
+func (l *metricsListener) Stop() {
+	if err := l.server.Close(); err != nil {
+		log.Warnf("Failed to close the metrics listener: %s", err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsListener(t *testing.T) {
+	_, err := newMetricsListener("0.0.0.0:0")
+	assert.Error(t, err)
+	_, err = newMetricsListener("localhost")
+	assert.Error(t, err)
+
+	listener, err := newMetricsListener("127.0.0.1:0")
+	require.NoError(t, err)
+	listener.Start()
+	defer listener.Stop()
+
+	rsp, err := http.Get("http://" + listener.listener.Addr().String() + metricsPath)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	body, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "# TYPE mender_http_requests_total counter")
+}
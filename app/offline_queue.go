@@ -0,0 +1,259 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+const (
+	offlineQueueStatus    = "status"
+	offlineQueueLogs      = "logs"
+	offlineQueueInventory = "inventory"
+)
+
+var (
+	// Entries beyond this are dropped, oldest first.
+	maxOfflineQueueEntries = 50
+	// An entry which has failed to be delivered this many times, while
+	// the server could be reached, is dropped.
+	maxOfflineQueueAttempts = 10
+)
+
+// offlineQueueEntry is something that should have been sent to the server,
+// but couldn't be, because the server couldn't be reached.
+type offlineQueueEntry struct {
+	Kind         string               `json:"kind"`
+	DeploymentID string               `json:"deployment_id,omitempty"`
+	Status       string               `json:"status,omitempty"`
+	Logs         []byte               `json:"logs,omitempty"`
+	Inventory    client.InventoryData `json:"inventory,omitempty"`
+	QueuedAt     time.Time            `json:"queued_at"`
+	Attempts     int                  `json:"attempts,omitempty"`
+}
+
+// offlineQueue keeps status reports, deployment logs and inventory data
+// which couldn't be delivered in the store, so that they survive restarts,
+// until the server can be reached again.
+type offlineQueue struct {
+	store store.Store
+	lock  sync.Mutex
+}
+
+func newOfflineQueue(s store.Store) *offlineQueue {
+	return &offlineQueue{store: s}
+}
+
+func (q *offlineQueue) load() ([]offlineQueueEntry, error) {
+	data, err := q.store.ReadAll(datastore.OfflineQueueKey)
+	if err == os.ErrNotExist {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []offlineQueueEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (q *offlineQueue) save(entries []offlineQueueEntry) error {
+	if len(entries) == 0 {
+		err := q.store.Remove(datastore.OfflineQueueKey)
+		if err != nil && err != os.ErrNotExist {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return q.store.WriteAll(datastore.OfflineQueueKey, data)
+}
+
+// add queues the entry. A status report, or logs, replace any queued earlier
+// for the same deployment, and inventory data replaces earlier inventory
+// data, since only the latest is of interest to the server.
+func (q *offlineQueue) add(entry offlineQueueEntry) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	entries, err := q.load()
+	if err != nil {
+		log.Errorf("Failed to load the offline queue, starting a new one: %s", err.Error())
+		entries = nil
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Kind == entry.Kind && e.DeploymentID == entry.DeploymentID {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	entry.QueuedAt = time.Now()
+	entries = append(kept, entry)
+	if len(entries) > maxOfflineQueueEntries {
+		log.Warnf("The offline queue is full, dropping the %d oldest entries",
+			len(entries)-maxOfflineQueueEntries)
+		entries = entries[len(entries)-maxOfflineQueueEntries:]
+	}
+
+	if err = q.save(entries); err != nil {
+		log.Errorf("Failed to store the offline queue: %s", err.Error())
+		return
+	}
+	log.Infof("Queued %s for sending when the server can be reached again", entry.Kind)
+}
+
+// remove drops all entries of the given kind.
+func (q *offlineQueue) remove(kind string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	entries, err := q.load()
+	if err != nil || len(entries) == 0 {
+		return
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Kind != kind {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(entries) {
+		return
+	}
+	if err = q.save(kept); err != nil {
+		log.Errorf("Failed to store the offline queue: %s", err.Error())
+	}
+}
+
+// flush sends the queued entries in the order they were queued. It stops at
+// the first entry which fails to be sent, and keeps it and the following
+// entries for the next flush. Entries which the server refuses, or which
+// have failed too many times, are dropped.
+func (q *offlineQueue) flush(send func(entry *offlineQueueEntry) menderError) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	entries, err := q.load()
+	if err != nil {
+		log.Errorf("Failed to load the offline queue, dropping it: %s", err.Error())
+		_ = q.save(nil)
+		return
+	} else if len(entries) == 0 {
+		return
+	}
+
+	log.Infof("Sending %d entries queued while the server couldn't be reached",
+		len(entries))
+	for len(entries) > 0 {
+		entry := &entries[0]
+		merr := send(entry)
+		if merr == nil {
+			entries = entries[1:]
+			continue
+		}
+		if merr.IsFatal() {
+			log.Warnf("Dropping queued %s, the server refused it: %s",
+				entry.Kind, merr.Error())
+			entries = entries[1:]
+			continue
+		}
+		entry.Attempts++
+		if entry.Attempts >= maxOfflineQueueAttempts {
+			log.Warnf("Dropping queued %s after %d failed attempts: %s",
+				entry.Kind, entry.Attempts, merr.Error())
+			entries = entries[1:]
+			continue
+		}
+		log.Warnf("Failed to send queued %s, trying again later: %s",
+			entry.Kind, merr.Error())
+		break
+	}
+
+	if err = q.save(entries); err != nil {
+		log.Errorf("Failed to store the offline queue: %s", err.Error())
+	}
+}
+
+// QueueStatusReport keeps a status report, together with the deployment logs
+// if the deployment failed, for delivery once the server can be reached.
+func (m *Mender) QueueStatusReport(update *datastore.UpdateInfo, status string,
+	statusSent bool) {
+	if m.offlineQueue == nil {
+		return
+	}
+	if !statusSent {
+		m.offlineQueue.add(offlineQueueEntry{
+			Kind:         offlineQueueStatus,
+			DeploymentID: update.ID,
+			Status:       status,
+		})
+	}
+	if status == client.StatusFailure {
+		logs, err := DeploymentLogger.GetLogs(update.ID)
+		if err != nil {
+			log.Errorf("Failed to get deployment logs for deployment [%v]: %v",
+				update.ID, err)
+			return
+		}
+		m.offlineQueue.add(offlineQueueEntry{
+			Kind:         offlineQueueLogs,
+			DeploymentID: update.ID,
+			Logs:         logs,
+		})
+	}
+}
+
+func (m *Mender) sendQueuedEntry(entry *offlineQueueEntry) menderError {
+	update := &datastore.UpdateInfo{ID: entry.DeploymentID}
+	switch entry.Kind {
+	case offlineQueueStatus:
+		return m.ReportUpdateStatus(update, entry.Status)
+	case offlineQueueLogs:
+		return m.UploadLog(update, entry.Logs)
+	case offlineQueueInventory:
+		err := m.inventoryClient().Submit(m.api, m.Config.Servers[0].ServerURL,
+			entry.Inventory)
+		if err != nil {
+			return NewTransientError(err)
+		}
+		return nil
+	default:
+		return NewFatalError(errors.Errorf("unknown entry type %q", entry.Kind))
+	}
+}
+
+// flushOfflineQueue sends what was queued while the server couldn't be
+// reached. Called after the server has been reached successfully.
+func (m *Mender) flushOfflineQueue() {
+	if m.offlineQueue == nil {
+		return
+	}
+	m.offlineQueue.flush(m.sendQueuedEntry)
+}
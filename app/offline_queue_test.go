@@ -0,0 +1,115 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestOfflineQueue(t *testing.T) {
+	ms := store.NewMemStore()
+	q := newOfflineQueue(ms)
+
+	var sent []offlineQueueEntry
+	sendAll := func(entry *offlineQueueEntry) menderError {
+		sent = append(sent, *entry)
+		return nil
+	}
+
+	// Nothing queued.
+	q.flush(sendAll)
+	assert.Empty(t, sent)
+
+	q.add(offlineQueueEntry{Kind: offlineQueueStatus, DeploymentID: "1",
+		Status: client.StatusDownloading})
+	q.add(offlineQueueEntry{Kind: offlineQueueInventory,
+		Inventory: client.InventoryData{{Name: "a", Value: "1"}}})
+	// Replaces the earlier status of the same deployment.
+	q.add(offlineQueueEntry{Kind: offlineQueueStatus, DeploymentID: "1",
+		Status: client.StatusFailure})
+	q.add(offlineQueueEntry{Kind: offlineQueueLogs, DeploymentID: "1",
+		Logs: []byte(`{"messages":[]}`)})
+	// Replaces the earlier inventory.
+	q.add(offlineQueueEntry{Kind: offlineQueueInventory,
+		Inventory: client.InventoryData{{Name: "a", Value: "2"}}})
+
+	// The queue survives a restart.
+	q = newOfflineQueue(ms)
+
+	// A failure stops the flush, and keeps the rest.
+	q.flush(func(entry *offlineQueueEntry) menderError {
+		if entry.Kind == offlineQueueLogs {
+			return NewTransientError(errors.New("connection refused"))
+		}
+		return sendAll(entry)
+	})
+	require.Len(t, sent, 1)
+	assert.Equal(t, client.StatusFailure, sent[0].Status)
+
+	sent = nil
+	q.flush(sendAll)
+	require.Len(t, sent, 2)
+	assert.Equal(t, offlineQueueLogs, sent[0].Kind)
+	assert.Equal(t, 1, sent[0].Attempts)
+	assert.Equal(t, offlineQueueInventory, sent[1].Kind)
+	assert.Equal(t, "2", sent[1].Inventory[0].Value)
+
+	// Everything was delivered.
+	_, err := ms.ReadAll(datastore.OfflineQueueKey)
+	assert.Equal(t, os.ErrNotExist, err)
+
+	// Refused entries and entries failing too often are dropped.
+	q.add(offlineQueueEntry{Kind: offlineQueueStatus, DeploymentID: "2",
+		Status: client.StatusSuccess})
+	q.add(offlineQueueEntry{Kind: offlineQueueInventory})
+	for i := 0; i < maxOfflineQueueAttempts; i++ {
+		q.flush(func(entry *offlineQueueEntry) menderError {
+			if entry.Kind == offlineQueueStatus {
+				return NewFatalError(client.ErrDeploymentAborted)
+			}
+			return NewTransientError(errors.New("server error"))
+		})
+	}
+	_, err = ms.ReadAll(datastore.OfflineQueueKey)
+	assert.Equal(t, os.ErrNotExist, err)
+
+	// Removing inventory keeps other entries.
+	q.add(offlineQueueEntry{Kind: offlineQueueStatus, DeploymentID: "3",
+		Status: client.StatusSuccess})
+	q.add(offlineQueueEntry{Kind: offlineQueueInventory})
+	q.remove(offlineQueueInventory)
+	sent = nil
+	q.flush(sendAll)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "3", sent[0].DeploymentID)
+
+	// The queue is bounded.
+	for i := 0; i < maxOfflineQueueEntries+5; i++ {
+		q.add(offlineQueueEntry{Kind: offlineQueueStatus,
+			DeploymentID: string(rune('a' + i)), Status: client.StatusSuccess})
+	}
+	sent = nil
+	q.flush(sendAll)
+	assert.Len(t, sent, maxOfflineQueueEntries)
+	assert.Equal(t, string(rune('a'+5)), sent[0].DeploymentID)
+}
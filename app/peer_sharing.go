@@ -0,0 +1,434 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+const (
+	peerSharingService       = "_mender-artifact._tcp.local"
+	peerArtifactPath         = "/artifacts/"
+	peerChecksumHeader       = "X-Mender-Artifact-Checksum"
+	peerDeviceTypeQuery      = "device_type"
+	defaultPeerSharingPort   = 8585
+	defaultPeerDiscoveryTime = 2 * time.Second
+
+	sharedArtifactFile = "shared.mender"
+	peerDownloadFile   = "download.mender"
+)
+
+var errPeerWithoutArtifact = errors.New("the peer doesn't have the artifact")
+
+// sharedArtifact is the artifact shared with other devices, as stored.
+type sharedArtifact struct {
+	Name string `json:"name"`
+	// The device types the artifact is compatible with, according to the
+	// server it was downloaded for.
+	DeviceTypes []string `json:"device_types"`
+	Size        int64    `json:"size"`
+	Checksum    string   `json:"checksum"`
+}
+
+func (a *sharedArtifact) compatibleWith(deviceType string) bool {
+	for _, t := range a.DeviceTypes {
+		if t == deviceType {
+			return true
+		}
+	}
+	return false
+}
+
+func loadSharedArtifact(s store.Store) (*sharedArtifact, error) {
+	data, err := s.ReadAll(datastore.PeerSharedArtifactKey)
+	if err != nil {
+		return nil, err
+	}
+	artifact := &sharedArtifact{}
+	if err = json.Unmarshal(data, artifact); err != nil {
+		return nil, errors.Wrap(err, "invalid shared artifact")
+	}
+	return artifact, nil
+}
+
+func peerSharingDir(config *conf.MenderConfig) string {
+	if config.PeerSharing.Dir != "" {
+		return config.PeerSharing.Dir
+	}
+	return filepath.Join(conf.GetStateDirPath(), "peer-sharing")
+}
+
+func peerSharingPort(config *conf.MenderConfig) int {
+	if config.PeerSharing.Port != 0 {
+		return config.PeerSharing.Port
+	}
+	return defaultPeerSharingPort
+}
+
+// peerInstance is the name this device shares artifacts under.
+func peerInstance() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "mender"
+	}
+	return strings.ReplaceAll(hostname, ".", "-")
+}
+
+// peerSharing fetches artifacts from the other devices on the local network
+// which have them, and keeps the artifacts downloaded, for them.
+type peerSharing struct {
+	dir      string
+	wait     time.Duration
+	instance string
+	store    store.Store
+	browse   func(context.Context, string, time.Duration) ([]client.MDNSPeer, error)
+}
+
+// newPeerSharing returns nil if peer sharing isn't enabled. Since anyone on
+// the local network can answer, it is never enabled without keys to verify
+// the signature of the artifacts with.
+func newPeerSharing(config *conf.MenderConfig, s store.Store) *peerSharing {
+	if !config.PeerSharing.Enabled || s == nil {
+		return nil
+	}
+	if config.ArtifactVerifyKey == "" && len(config.ArtifactVerifyKeys) == 0 {
+		log.Error("Not fetching artifacts from other devices, since there are no " +
+			"ArtifactVerifyKeys to verify them with.")
+		return nil
+	}
+	p := &peerSharing{
+		dir:      peerSharingDir(config),
+		wait:     defaultPeerDiscoveryTime,
+		instance: peerInstance(),
+		store:    s,
+		browse:   client.MDNSBrowse,
+	}
+	if config.PeerSharing.DiscoverySeconds > 0 {
+		p.wait = time.Duration(config.PeerSharing.DiscoverySeconds) * time.Second
+	}
+	return p
+}
+
+// fetch returns the artifact from another device which has it for the device
+// type, nil if none has. deviceTypes are the device types the artifact is
+// compatible with, which it is shared for in turn.
+func (p *peerSharing) fetch(
+	ctx context.Context,
+	name, deviceType string,
+	deviceTypes []string,
+) (io.ReadCloser, int64) {
+	peers, err := p.browse(ctx, peerSharingService, p.wait)
+	if err != nil {
+		log.Warnf("Failed to look for devices sharing artifacts: %s", err.Error())
+	}
+	for _, peer := range peers {
+		if peer.Instance == p.instance {
+			continue
+		}
+		image, size, err := p.fetchFrom(ctx, peer.Addr, name, deviceType, deviceTypes)
+		if err == nil {
+			log.Infof("Fetched the artifact %s from the device at %s", name, peer.Addr)
+			return image, size
+		} else if err != errPeerWithoutArtifact {
+			log.Warnf("Failed to fetch the artifact from the device at %s: %s",
+				peer.Addr, err.Error())
+		}
+	}
+	return nil, -1
+}
+
+// fetchFrom fetches the artifact from the device at the address to the
+// directory, checks that it is what the device has, and shares it in turn.
+// The checksum only guards against transfer errors, since it comes from the
+// same device. What makes the artifact safe to install is its signature,
+// which is verified with the ArtifactVerifyKeys when it is installed.
+func (p *peerSharing) fetchFrom(
+	ctx context.Context,
+	addr, name, deviceType string,
+	deviceTypes []string,
+) (io.ReadCloser, int64, error) {
+	query := url.Values{peerDeviceTypeQuery: {deviceType}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("http://%s%s%s?%s", addr, peerArtifactPath, url.PathEscape(name),
+			query.Encode()), nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, -1, errPeerWithoutArtifact
+	} else if rsp.StatusCode != http.StatusOK {
+		return nil, -1, errors.Errorf("unexpected response %s", rsp.Status)
+	}
+	checksum := rsp.Header.Get(peerChecksumHeader)
+	if checksum == "" {
+		return nil, -1, errors.New("the artifact comes without checksum")
+	}
+
+	download := &peerDownload{
+		peers:       p,
+		name:        name,
+		deviceTypes: deviceTypes,
+		hash:        sha256.New(),
+	}
+	if err = download.create(); err != nil {
+		return nil, -1, err
+	}
+	_, err = io.Copy(download, rsp.Body)
+	if err == nil && hex.EncodeToString(download.hash.Sum(nil)) != checksum {
+		err = errors.New("the artifact doesn't match its checksum")
+	}
+	if err != nil {
+		download.discard()
+		return nil, -1, err
+	}
+	if err = download.finish(); err != nil {
+		return nil, -1, err
+	}
+	image, err := os.Open(filepath.Join(p.dir, sharedArtifactFile))
+	if err != nil {
+		return nil, -1, err
+	}
+	return image, download.size, nil
+}
+
+// keep returns the stream of the artifact downloaded, which writes it to the
+// directory as it is read, so that it can be shared once it is complete.
+func (p *peerSharing) keep(
+	name string,
+	deviceTypes []string,
+	image io.ReadCloser,
+	size int64,
+) io.ReadCloser {
+	if size <= 0 {
+		return image
+	}
+	download := &peerDownload{
+		peers:       p,
+		name:        name,
+		deviceTypes: deviceTypes,
+		hash:        sha256.New(),
+	}
+	if err := download.create(); err != nil {
+		log.Warnf("Failed to keep the artifact for other devices: %s", err.Error())
+		return image
+	}
+	return &sharingReader{ReadCloser: image, download: download, expected: size}
+}
+
+// peerDownload is an artifact being written to the directory, which is
+// shared when finished.
+type peerDownload struct {
+	peers       *peerSharing
+	name        string
+	deviceTypes []string
+	file        *os.File
+	hash        hash.Hash
+	size        int64
+}
+
+func (d *peerDownload) create() error {
+	if err := os.MkdirAll(d.peers.dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(d.peers.dir, peerDownloadFile),
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	d.file = file
+	return nil
+}
+
+func (d *peerDownload) Write(b []byte) (int, error) {
+	n, err := d.file.Write(b)
+	d.hash.Write(b[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+func (d *peerDownload) discard() {
+	d.file.Close()
+	os.Remove(d.file.Name())
+}
+
+// finish replaces the shared artifact with the one written.
+func (d *peerDownload) finish() error {
+	err := d.file.Sync()
+	if cerr := d.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(d.file.Name(), filepath.Join(d.peers.dir, sharedArtifactFile))
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(sharedArtifact{
+			Name:        d.name,
+			DeviceTypes: d.deviceTypes,
+			Size:        d.size,
+			Checksum:    hex.EncodeToString(d.hash.Sum(nil)),
+		})
+	}
+	if err == nil {
+		err = d.peers.store.WriteAll(datastore.PeerSharedArtifactKey, data)
+	}
+	if err != nil {
+		os.Remove(d.file.Name())
+		return errors.Wrap(err, "failed to share the artifact")
+	}
+	return nil
+}
+
+// sharingReader writes what is read to the download, and shares it if all
+// of the artifact was read. Failing to write only means it isn't shared.
+type sharingReader struct {
+	io.ReadCloser
+	download *peerDownload
+	expected int64
+	failed   bool
+}
+
+func (r *sharingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 && !r.failed {
+		if _, werr := r.download.Write(b[:n]); werr != nil {
+			log.Warnf("Failed to keep the artifact for other devices: %s", werr.Error())
+			r.failed = true
+		}
+	}
+	return n, err
+}
+
+func (r *sharingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.failed || r.download.size != r.expected {
+		r.download.discard()
+	} else if ferr := r.download.finish(); ferr != nil {
+		log.Warn(ferr.Error())
+	} else {
+		log.Infof("Sharing the artifact %s with other devices", r.download.name)
+	}
+	return err
+}
+
+// peerSharingListener shares the artifact kept with the other devices on
+// the local network, and answers their mDNS queries for it.
+type peerSharingListener struct {
+	listener  net.Listener
+	server    *http.Server
+	port      int
+	responder *client.MDNSResponder
+}
+
+func newPeerSharingListener(
+	config *conf.MenderConfig,
+	s store.Store,
+) (*peerSharingListener, error) {
+	port := peerSharingPort(config)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the peer sharing listener")
+	}
+	return &peerSharingListener{
+		listener: listener,
+		server: &http.Server{
+			Handler:           peerSharingHandler(peerSharingDir(config), s),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		port: port,
+	}, nil
+}
+
+// peerSharingHandler serves the shared artifact at its name, to devices of a
+// device type it is compatible with, since names are only unique per device
+// type.
+func peerSharingHandler(dir string, s store.Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(peerArtifactPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, peerArtifactPath)
+		artifact, err := loadSharedArtifact(s)
+		if err != nil || artifact.Name != name ||
+			!artifact.compatibleWith(r.URL.Query().Get(peerDeviceTypeQuery)) {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := os.Open(filepath.Join(dir, sharedArtifactFile))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		log.Infof("Sharing the artifact %s with the device at %s", name, r.RemoteAddr)
+		w.Header().Set(peerChecksumHeader, artifact.Checksum)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, file)
+	})
+	return mux
+}
+
+func (l *peerSharingListener) Start() {
+	log.Infof("Sharing artifacts with other devices on %s", l.listener.Addr())
+	go func() {
+		err := l.server.Serve(l.listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Peer sharing listener failed: %s", err.Error())
+		}
+	}()
+	responder, err := client.NewMDNSResponder(peerSharingService, peerInstance(), l.port)
+	if err != nil {
+		log.Warnf("Other devices won't find the artifacts shared: %s", err.Error())
+		return
+	}
+	l.responder = responder
+	go responder.Serve()
+}
+
+func (l *peerSharingListener) Stop() {
+	if l.responder != nil {
+		l.responder.Close()
+	}
+	if err := l.server.Close(); err != nil {
+		log.Warnf("Failed to close the peer sharing listener: %s", err.Error())
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestPeerSharing(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "peer-sharing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	artifact := make([]byte, 64*1024)
+	_, err = rand.Read(artifact)
+	require.NoError(t, err)
+
+	// Device a downloads the artifact, and shares it.
+	a := &peerSharing{
+		dir:      filepath.Join(tempDir, "a"),
+		instance: "a",
+		store:    store.NewMemStore(),
+	}
+	image := a.keep("release-2", []string{"beaglebone", "raspberrypi4"},
+		ioutil.NopCloser(bytes.NewReader(artifact)), int64(len(artifact)))
+	_, err = io.Copy(ioutil.Discard, image)
+	require.NoError(t, err)
+	require.NoError(t, image.Close())
+	shared, err := loadSharedArtifact(a.store)
+	require.NoError(t, err)
+	assert.Equal(t, "release-2", shared.Name)
+	assert.Equal(t, int64(len(artifact)), shared.Size)
+
+	srv := httptest.NewServer(peerSharingHandler(a.dir, a.store))
+	defer srv.Close()
+	srvURL, _ := url.Parse(srv.URL)
+	empty := httptest.NewServer(peerSharingHandler(filepath.Join(tempDir, "empty"),
+		store.NewMemStore()))
+	defer empty.Close()
+	emptyURL, _ := url.Parse(empty.URL)
+
+	browse := func(context.Context, string, time.Duration) ([]client.MDNSPeer, error) {
+		return []client.MDNSPeer{
+			{Instance: "b", Addr: "127.0.0.1:1"},
+			{Instance: "empty", Addr: emptyURL.Host},
+			{Instance: "a", Addr: srvURL.Host},
+		}, nil
+	}
+
+	// Device b fetches it from a, and shares it in turn.
+	b := &peerSharing{
+		dir:      filepath.Join(tempDir, "b"),
+		instance: "b",
+		store:    store.NewMemStore(),
+		browse:   browse,
+	}
+	compatible := []string{"beaglebone", "raspberrypi4"}
+	image, size := b.fetch(context.Background(), "release-2", "raspberrypi4", compatible)
+	require.NotNil(t, image)
+	assert.Equal(t, int64(len(artifact)), size)
+	data, err := ioutil.ReadAll(image)
+	require.NoError(t, err)
+	image.Close()
+	assert.True(t, bytes.Equal(artifact, data))
+	shared, err = loadSharedArtifact(b.store)
+	require.NoError(t, err)
+	assert.Equal(t, "release-2", shared.Name)
+	assert.Equal(t, compatible, shared.DeviceTypes)
+
+	// Nobody has other artifacts.
+	image, _ = b.fetch(context.Background(), "release-3", "raspberrypi4", compatible)
+	assert.Nil(t, image)
+
+	// Nor the artifact for other device types, which may have an artifact
+	// of the same name.
+	image, _ = b.fetch(context.Background(), "release-2", "qemux86-64",
+		[]string{"qemux86-64"})
+	assert.Nil(t, image)
+
+	// An artifact which doesn't match its checksum isn't used.
+	c := &peerSharing{
+		dir:      filepath.Join(tempDir, "c"),
+		instance: "c",
+		store:    store.NewMemStore(),
+		browse:   browse,
+	}
+	artifact[0]++
+	require.NoError(t, ioutil.WriteFile(filepath.Join(a.dir, sharedArtifactFile),
+		artifact, 0600))
+	image, _ = c.fetch(context.Background(), "release-2", "raspberrypi4", compatible)
+	assert.Nil(t, image)
+	_, err = os.Stat(filepath.Join(c.dir, peerDownloadFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// An artifact not read to the end isn't shared.
+	image = c.keep("release-2", compatible, ioutil.NopCloser(bytes.NewReader(artifact)),
+		int64(len(artifact)))
+	_, err = io.CopyN(ioutil.Discard, image, 1024)
+	require.NoError(t, err)
+	require.NoError(t, image.Close())
+	_, err = loadSharedArtifact(c.store)
+	assert.Equal(t, os.ErrNotExist, err)
+}
+
+func TestMenderFetchUpdateFromPeer(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "peer-sharing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	peer := &peerSharing{dir: filepath.Join(tempDir, "peer"), store: store.NewMemStore()}
+	image := peer.keep("release-2", []string{"beaglebone"},
+		ioutil.NopCloser(bytes.NewReader([]byte("artifact"))), 8)
+	_, err = io.Copy(ioutil.Discard, image)
+	require.NoError(t, err)
+	require.NoError(t, image.Close())
+	srv := httptest.NewServer(peerSharingHandler(peer.dir, peer.store))
+	defer srv.Close()
+	srvURL, _ := url.Parse(srv.URL)
+
+	deviceType := filepath.Join(tempDir, "device_type")
+	require.NoError(t, ioutil.WriteFile(deviceType, []byte("device_type=beaglebone\n"), 0644))
+	config := conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			PeerSharing: conf.PeerSharing{
+				Enabled: true,
+				Dir:     filepath.Join(tempDir, "device"),
+			},
+			DeviceTypeFile: deviceType,
+		},
+	}
+
+	// Not without keys to verify the artifact with, since any host on the
+	// network can answer.
+	mender := newTestMender(config, testMenderPieces{})
+	assert.Nil(t, mender.peers)
+
+	config.ArtifactVerifyKeys = []string{"/etc/mender/artifact-verify-key.pem"}
+	mender = newTestMender(config, testMenderPieces{})
+	require.NotNil(t, mender.peers)
+	mender.peers.browse = func(context.Context, string, time.Duration) ([]client.MDNSPeer, error) {
+		return []client.MDNSPeer{{Instance: "peer", Addr: srvURL.Host}}, nil
+	}
+	update := updateFrom("http://127.0.0.1:1/artifact")
+	update.Artifact.ArtifactName = "release-2"
+	update.Artifact.CompatibleDevices = []string{"beaglebone"}
+	image, size, err := mender.FetchUpdate(update)
+	require.NoError(t, err)
+	defer image.Close()
+	assert.Equal(t, int64(8), size)
+	data, err := ioutil.ReadAll(image)
+	assert.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/installer"
+)
+
+// The download progress is reported to the server every this many percent.
+const downloadProgressStep = 10
+
+// progressReader calls report each time another step percent of size has
+// been read.
+type progressReader struct {
+	io.ReadCloser
+	size   int64
+	read   int64
+	step   int
+	next   int
+	report func(percent int)
+}
+
+func newProgressReader(
+	in io.ReadCloser,
+	size int64,
+	step int,
+	report func(percent int),
+) io.ReadCloser {
+	if size <= 0 || step <= 0 {
+		return in
+	}
+	return &progressReader{
+		ReadCloser: in,
+		size:       size,
+		step:       step,
+		next:       step,
+		report:     report,
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.read += int64(n)
+	percent := int(p.read * 100 / p.size)
+	if percent > 100 {
+		percent = 100
+	}
+	if percent >= p.next {
+		percent -= percent % p.step
+		p.next = percent + p.step
+		p.report(percent)
+	}
+	return n, err
+}
+
+// payloadProgress returns the progress of each payload, when those before
+// current are done and those after it not started.
+func payloadProgress(
+	installers []installer.PayloadUpdatePerformer,
+	current int,
+	percent int,
+) []client.PayloadProgress {
+	payloads := make([]client.PayloadProgress, len(installers))
+	for n, i := range installers {
+		payloads[n].Type = i.GetType()
+		if n < current {
+			payloads[n].Progress = 100
+		} else if n == current {
+			payloads[n].Progress = percent
+		}
+	}
+	return payloads
+}
+
+// reportProgress reports the progress of the update, which is only
+// informational, so failing to is not an error in itself.
+func reportProgress(
+	c Controller,
+	update *datastore.UpdateInfo,
+	status string,
+	subState string,
+	percent int,
+	payloads []client.PayloadProgress,
+) menderError {
+	merr := c.ReportUpdateProgress(update, client.StatusReport{
+		Status:   status,
+		SubState: subState,
+		Progress: &percent,
+		Payloads: payloads,
+	})
+	if merr != nil && !merr.IsFatal() {
+		log.Warnf("Failed to report the progress of the update: %s", merr.Error())
+	}
+	return merr
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/installer"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestProgressReader(t *testing.T) {
+	in := ioutil.NopCloser(bytes.NewReader(make([]byte, 100)))
+	assert.Equal(t, in, newProgressReader(in, 0, 10, nil))
+	assert.Equal(t, in, newProgressReader(in, 100, 0, nil))
+
+	var reported []int
+	r := newProgressReader(in, 100, 10, func(percent int) {
+		reported = append(reported, percent)
+	})
+	buf := make([]byte, 25)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, []int{20, 50, 70, 100}, reported)
+}
+
+func TestStateUpdateInstallProgress(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "logs")
+	DeploymentLogger = NewDeploymentLogManager(tempDir)
+	defer func() {
+		DeploymentLogger = nil
+		os.RemoveAll(tempDir)
+	}()
+
+	update := &datastore.UpdateInfo{ID: "foo"}
+	update.RebootRequested = datastore.RebootRequestedType{
+		datastore.RebootTypeNone, datastore.RebootTypeNone,
+	}
+	ctx := &StateContext{Store: store.NewMemStore()}
+	noReboot := installer.RebootAction(installer.NoReboot)
+	payload := FakeDevice{NeedsRebootReturnValue: &noReboot}
+	c := &stateTestController{
+		installers: []installer.PayloadUpdatePerformer{payload, payload},
+	}
+
+	NewUpdateInstallState(update).Handle(ctx, c)
+	require.Len(t, c.progressReports, 3)
+	for n, percent := range []int{0, 50, 100} {
+		report := c.progressReports[n]
+		assert.Equal(t, client.StatusInstalling, report.Status)
+		assert.Equal(t, "ArtifactInstall", report.SubState)
+		require.NotNil(t, report.Progress)
+		assert.Equal(t, percent, *report.Progress)
+	}
+	assert.Equal(t, []client.PayloadProgress{
+		{Type: "rootfs-image", Progress: 100},
+		{Type: "rootfs-image", Progress: 0},
+	}, c.progressReports[1].Payloads)
+
+	// An aborted deployment stops the install.
+	c.progressReports = nil
+	c.reportError = NewFatalError(client.ErrDeploymentAborted)
+	NewUpdateInstallState(update).Handle(ctx, c)
+	assert.Len(t, c.progressReports, 1)
+}
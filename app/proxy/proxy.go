@@ -311,6 +311,10 @@ func (pc *proxyControllerInner) checkAuthorizationHook(f http.HandlerFunc) http.
 			http.Error(w, "invalid JWT token in Authorization header", http.StatusUnauthorized)
 			return
 		}
+		if token == string(client.MTLSAuthToken) {
+			// The server knows the device by its client certificate.
+			r.Header.Del("Authorization")
+		}
 		f(w, r)
 	}
 }
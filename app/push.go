@@ -0,0 +1,169 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	pushAuthManagerChannelName = "push-notifications"
+	maxPushReconnectInterval   = 10 * time.Minute
+)
+
+// pushListener keeps the push notification connection open, reconnecting
+// when it's lost, and calls notify when the server announces a deployment.
+type pushListener struct {
+	authManager AuthManager
+	client      *client.PushClient
+	notify      func()
+
+	lock sync.Mutex
+	conn *client.PushConnection
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newPushListener(
+	config *conf.MenderConfig,
+	authManager AuthManager,
+	notify func(),
+) (*pushListener, error) {
+	pushClient, err := client.NewPushClient(config.GetHttpConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &pushListener{
+		authManager: authManager,
+		client:      pushClient,
+		notify:      notify,
+	}, nil
+}
+
+func (p *pushListener) Start() {
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run()
+}
+
+func (p *pushListener) Stop() {
+	p.lock.Lock()
+	close(p.quit)
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.lock.Unlock()
+	<-p.done
+}
+
+func (p *pushListener) stopping() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pushListener) run() {
+	defer close(p.done)
+
+	attempt := 0
+	fetchToken := false
+	for {
+		connected, err := p.listen(fetchToken)
+		if p.stopping() {
+			return
+		}
+		fetchToken = errors.Cause(err) == client.ErrPushUnauthorized
+		if connected {
+			attempt = 0
+		}
+		interval, _ := client.GetExponentialBackoffTime(attempt, maxPushReconnectInterval, 0)
+		attempt++
+		log.Warnf("Push notifications unavailable, reconnecting in %s: %s",
+			interval, err.Error())
+		select {
+		case <-time.After(interval):
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// listen connects and handles notifications until the connection is lost.
+// It returns whether the connection was established.
+func (p *pushListener) listen(fetchToken bool) (bool, error) {
+	token, serverURL, err := p.authToken(fetchToken)
+	if err != nil {
+		return false, err
+	}
+	conn, err := p.client.Connect(string(serverURL), token)
+	if err != nil {
+		return false, err
+	}
+
+	p.lock.Lock()
+	if p.stopping() {
+		p.lock.Unlock()
+		conn.Close()
+		return true, nil
+	}
+	p.conn = conn
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		p.conn = nil
+		p.lock.Unlock()
+		conn.Close()
+	}()
+
+	log.Info("Connected to the server for push notifications")
+	for {
+		notification, err := conn.Next()
+		if err != nil {
+			return true, err
+		}
+		if notification.Event == client.PushEventDeploymentAvailable {
+			log.Infof("The server announced a new deployment %s", notification.DeploymentID)
+			p.notify()
+		} else {
+			log.Debugf("Ignoring push notification %q", notification.Event)
+		}
+	}
+}
+
+// authToken returns the current token from the auth manager, having it fetch
+// a new one first if fetch is set or there is none.
+func (p *pushListener) authToken(fetch bool) (client.AuthToken, client.ServerURL, error) {
+	if !fetch {
+		respChan := make(chan AuthManagerResponse, 1)
+		p.authManager.GetInMessageChan() <- AuthManagerRequest{
+			Action:          ActionGetAuthToken,
+			ResponseChannel: respChan,
+		}
+		resp, ok := <-respChan
+		if ok && resp.Error == nil && len(resp.AuthToken) > 0 && len(resp.ServerURL) > 0 {
+			return resp.AuthToken, resp.ServerURL, nil
+		}
+	}
+	return authorize(p.authManager, pushAuthManagerChannelName)
+}
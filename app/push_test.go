@@ -0,0 +1,85 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	dev "github.com/mendersoftware/mender/device"
+	"github.com/mendersoftware/mender/store"
+	stest "github.com/mendersoftware/mender/system/testing"
+)
+
+func TestPushListener(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.WriteJSON(client.PushNotification{Event: "something_else"})
+		_ = conn.WriteJSON(client.PushNotification{
+			Event:        client.PushEventDeploymentAvailable,
+			DeploymentID: "foo",
+		})
+		// Wait for the client to hang up.
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	ms := store.NewMemStore()
+	am := NewAuthManager(AuthManagerConfig{
+		AuthDataStore: ms,
+		IdentitySource: &dev.IdentityDataRunner{
+			Cmdr: stest.NewTestOSCalls("", 0),
+		},
+		KeyStore: store.NewKeystore(ms, "key", "", false, defaultKeyPassphrase),
+	})
+	am.authToken = "token"
+	am.serverURL = client.ServerURL(ts.URL)
+	am.Start()
+	defer am.Stop()
+
+	config := &conf.MenderConfig{
+		MenderConfigFromFile: conf.MenderConfigFromFile{
+			DeploymentPushNotifications: true,
+		},
+	}
+	d, err := NewDaemon(config, &stateTestController{}, ms, am)
+	require.NoError(t, err)
+	require.NotNil(t, d.pushListener)
+
+	d.pushListener.Start()
+	select {
+	case <-d.Sctx.WakeupChan:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no wake up after a deployment was announced")
+	}
+	assert.Equal(t, States.UpdateCheck, <-d.ForceToState)
+	d.pushListener.Stop()
+
+	config.DeploymentPushNotifications = false
+	d, err = NewDaemon(config, &stateTestController{}, ms, am)
+	require.NoError(t, err)
+	assert.Nil(t, d.pushListener)
+}
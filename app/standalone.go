@@ -15,6 +15,7 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -46,6 +47,10 @@ type standaloneData struct {
 	artifactTypeInfoProvides map[string]string
 	artifactClearsProvides   []string
 	installers               []installer.PayloadUpdatePerformer
+	// Indices of the installers in the order their payloads are installed
+	// in, and how many have been, or zero if not known.
+	installOrder []int
+	installed    int
 }
 
 // This will be run manually from command line ONLY
@@ -74,7 +79,7 @@ func DoStandaloneInstall(device *dev.DeviceManager, updateURI string,
 
 		log.Debug("Client initialized. Start downloading image.")
 
-		image, imageSize, err = upclient.FetchUpdate(ac, updateURI, 0)
+		image, imageSize, err = upclient.FetchUpdate(context.Background(), ac, updateURI, 0)
 		log.Debugf("Image downloaded: %d [%v] [%v]", imageSize, image, err)
 	} else {
 		// perform update from local file
@@ -127,6 +132,7 @@ func doStandaloneInstallStatesDownload(art io.ReadCloser,
 	}
 
 	standaloneData.artifactName = installer.GetArtifactName()
+	standaloneData.installOrder = installer.InstallOrder()
 	standaloneData.artifactTypeInfoProvides, err = installer.GetArtifactProvides()
 	if err != nil {
 		return nil, err
@@ -160,6 +166,13 @@ func doStandaloneInstallStatesDownload(art io.ReadCloser,
 		}
 	}
 
+	err = datastore.CheckAntiRollbackFloor(device.Store, device.GetAntiRollbackProvide(),
+		standaloneData.artifactTypeInfoProvides)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
 	standaloneData.artifactClearsProvides = installer.GetArtifactClearsProvides()
 
 	err = installer.StorePayloads()
@@ -206,7 +219,8 @@ func doStandaloneInstallStates(art io.ReadCloser,
 		_ = doStandaloneFailureStates(device, standaloneData, stateExec, true, true, true)
 		return err
 	}
-	for _, inst := range installers {
+	for n, inst := range installOrder(standaloneData.installOrder, installers) {
+		standaloneData.installed = n + 1
 		err = inst.InstallUpdate()
 		if err != nil {
 			log.Errorf("Installation failed: %s", err.Error())
@@ -358,7 +372,8 @@ func doStandaloneRollbackState(
 		firstErr = err
 		log.Errorf("Error when executing ArtifactRollback_Enter scripts: %s", err.Error())
 	}
-	for _, inst := range standaloneData.installers {
+	for _, inst := range rollbackOrder(standaloneData.installOrder, standaloneData.installed,
+		standaloneData.installers) {
 		err = inst.Rollback()
 		if err != nil {
 			if firstErr == nil {
@@ -486,9 +501,17 @@ func doStandaloneCleanup(device *dev.DeviceManager, standaloneData *standaloneDa
 			return err
 		}
 		if standaloneData.artifactName != "" {
-			return datastore.CommitArtifactData(txn, standaloneData.artifactName,
+			err = datastore.CommitArtifactData(txn, standaloneData.artifactName,
 				standaloneData.artifactGroup, standaloneData.artifactTypeInfoProvides,
 				standaloneData.artifactClearsProvides)
+			if err != nil {
+				return err
+			}
+			if !strings.HasSuffix(standaloneData.artifactName, conf.BrokenArtifactSuffix) {
+				return datastore.RaiseAntiRollbackFloor(txn,
+					device.GetAntiRollbackProvide(),
+					standaloneData.artifactTypeInfoProvides)
+			}
 		}
 		return nil
 	})
@@ -569,6 +592,7 @@ func storeStandaloneData(store store.Store, sd *standaloneData) error {
 		ArtifactTypeInfoProvides: sd.artifactTypeInfoProvides,
 		ArtifactClearsProvides:   sd.artifactClearsProvides,
 		PayloadTypes:             list,
+		InstallOrder:             sd.installOrder,
 	}
 
 	data, err := json.Marshal(stateData)
@@ -651,5 +675,6 @@ func restoreStandaloneData(device *dev.DeviceManager) (*standaloneData, error) {
 		artifactTypeInfoProvides: stateData.ArtifactTypeInfoProvides,
 		artifactClearsProvides:   stateData.ArtifactClearsProvides,
 		installers:               installers,
+		installOrder:             stateData.InstallOrder,
 	}, nil
 }
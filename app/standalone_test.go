@@ -100,7 +100,7 @@ func Test_doManualUpdate_noParams_fail(t *testing.T) {
 	deviceType := zeroLengthDeviceTypeFile(t)
 	defer os.Remove(deviceType)
 
-	dualRootfsDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{})
+	dualRootfsDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{}, nil)
 	if err := DoStandaloneInstall(getTestDeviceManager(dualRootfsDevice, &config, deviceType, dbdir),
 		"", conf.HttpConfig{}, dev.NewStateScriptExecutor(&config), false); err == nil {
 
@@ -121,7 +121,7 @@ func Test_doManualUpdate_invalidHttpsClientConfig_updateFails(t *testing.T) {
 	defer os.Remove(deviceType)
 
 	config := conf.MenderConfig{}
-	dualRootfsDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{})
+	dualRootfsDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{}, nil)
 	if err := DoStandaloneInstall(getTestDeviceManager(
 		dualRootfsDevice, &config, deviceType, dbdir),
 		imageFile, runOptions,
@@ -136,7 +136,7 @@ func Test_doManualUpdate_nonExistingFile_fail(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(dbdir)
 
-	fakeDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{})
+	fakeDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{}, nil)
 	imageFile := "non-existing"
 	deviceType := zeroLengthDeviceTypeFile(t)
 	defer os.Remove(deviceType)
@@ -156,7 +156,7 @@ func Test_doManualUpdate_networkUpdateNoClient_fail(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(dbdir)
 
-	fakeDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{})
+	fakeDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{}, nil)
 	imageFile := "http://non-existing"
 	deviceType := zeroLengthDeviceTypeFile(t)
 	defer os.Remove(deviceType)
@@ -174,7 +174,7 @@ func Test_doManualUpdate_networkClientExistsNoServer_fail(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(dbdir)
 
-	fakeDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{})
+	fakeDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{}, nil)
 	imageFile := "http://non-existing"
 	deviceType := zeroLengthDeviceTypeFile(t)
 	defer os.Remove(deviceType)
@@ -1076,7 +1076,7 @@ func TestStandaloneStoreAndRestore(t *testing.T) {
 	deviceType := zeroLengthDeviceTypeFile(t)
 	defer os.Remove(deviceType)
 
-	dualRootfsDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{})
+	dualRootfsDevice := installer.NewDualRootfsDevice(nil, nil, conf.DualRootfsDeviceConfig{}, nil)
 
 	tmgr := getTestDeviceManager(dualRootfsDevice, &config, deviceType, dbdir)
 
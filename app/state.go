@@ -376,10 +376,23 @@ func (i *initState) getNextState(ctx *StateContext, sd *datastore.StateData,
 
 	// Go straight to cleanup if we rebooted from Download state. This is
 	// important so that artifact scripts from that state do not get to run,
-	// since they have not yet been signature checked.
+	// since they have not yet been signature checked. If the download was
+	// kept, or the update was partly written to the inactive partition, it
+	// is started again instead, and continues where it stopped.
 	case datastore.MenderStateUpdateStore,
 		datastore.MenderStateUpdateAfterStore:
 
+		if sd.Name == datastore.MenderStateUpdateStore {
+			if canResumeDownload(ctx.Store, &sd.UpdateInfo) {
+				log.Info("Resuming the interrupted download")
+				return NewUpdateFetchState(&sd.UpdateInfo), false
+			}
+			if installer.WriteInterrupted(ctx.Store, sd.UpdateInfo.ArtifactName()) {
+				log.Info("Resuming the interrupted write of the update")
+				return NewUpdateFetchState(&sd.UpdateInfo), false
+			}
+		}
+
 		return NewUpdateCleanupState(&sd.UpdateInfo, client.StatusFailure), false
 
 	// After reboot into new update.
@@ -521,8 +534,13 @@ func (uc *updateCommitState) Handle(ctx *StateContext, c Controller) (State, boo
 		true,
 		func(txn store.Transaction) error {
 			ud := uc.Update()
-			return datastore.CommitArtifactData(txn, ud.ArtifactName(), ud.ArtifactGroup(),
+			err := datastore.CommitArtifactData(txn, ud.ArtifactName(), ud.ArtifactGroup(),
 				ud.ArtifactTypeInfoProvides(), ud.ArtifactClearsProvides())
+			if err != nil {
+				return err
+			}
+			return datastore.RaiseAntiRollbackFloor(txn, c.GetAntiRollbackProvide(),
+				ud.ArtifactTypeInfoProvides())
 		})
 	if err != nil {
 		log.Error("Could not write state data to persistent storage: ", err.Error())
@@ -718,12 +736,19 @@ func (u *updateFetchState) Handle(ctx *StateContext, c Controller) (State, bool)
 		return NewUpdateStatusReportState(&u.update, client.StatusFailure), false
 	}
 
-	in, _, err := c.FetchUpdate(u.update.URI())
+	in, size, err := c.FetchUpdate(&u.update)
 	if err != nil {
 		log.Errorf("Update fetch failed: %s", err)
 		return NewFetchStoreRetryState(u, &u.update, err), false
 	}
 
+	// Artifacts have a single payload, so its progress is that of the
+	// download.
+	in = newProgressReader(in, size, downloadProgressStep, func(percent int) {
+		reportProgress(c, &u.update, client.StatusDownloading, "Download",
+			percent, payloadProgress(c.GetInstallers(), 0, percent))
+	})
+
 	return NewUpdateStoreState(in, &u.update), false
 }
 
@@ -806,6 +831,7 @@ func (u *updateStoreState) Handle(ctx *StateContext, c Controller) (State, bool)
 	for n, i := range installers {
 		u.update.Artifact.PayloadTypes[n] = i.GetType()
 	}
+	u.update.InstallOrder = installer.InstallOrder()
 
 	// Verify that response from update request matches artifact header.
 	if err := u.verifyUpdateResponseAndHeader(installer); err != nil {
@@ -908,6 +934,12 @@ func (u *updateStoreState) maybeVerifyArtifactDependsAndProvides(
 		u.update.Artifact.TypeInfoProvides = provides
 	}
 
+	if err := datastore.CheckAntiRollbackFloor(ctx.Store, c.GetAntiRollbackProvide(),
+		u.update.Artifact.TypeInfoProvides); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
 	u.update.Artifact.ClearsArtifactProvides = installer.GetArtifactClearsProvides()
 
 	return nil
@@ -991,6 +1023,41 @@ func (s *updateAfterStoreState) HandleError(
 	return NewUpdateCleanupState(s.Update(), client.StatusFailure), false
 }
 
+// installOrder returns the installers in the order their payloads are
+// installed in, given as indices of the installers.
+func installOrder(
+	order []int,
+	installers []installer.PayloadUpdatePerformer,
+) []installer.PayloadUpdatePerformer {
+	if len(order) != len(installers) {
+		return installers
+	}
+	ordered := make([]installer.PayloadUpdatePerformer, len(installers))
+	for n, index := range order {
+		ordered[n] = installers[index]
+	}
+	return ordered
+}
+
+// rollbackOrder returns the installers of the first installed payloads, or
+// of all of them if installed is zero, in the reverse order of installing
+// them.
+func rollbackOrder(
+	order []int,
+	installed int,
+	installers []installer.PayloadUpdatePerformer,
+) []installer.PayloadUpdatePerformer {
+	ordered := installOrder(order, installers)
+	if installed > 0 && installed < len(ordered) {
+		ordered = ordered[:installed]
+	}
+	reversed := make([]installer.PayloadUpdatePerformer, len(ordered))
+	for n, i := range ordered {
+		reversed[len(ordered)-1-n] = i
+	}
+	return reversed
+}
+
 type updateInstallState struct {
 	*updateState
 }
@@ -1008,17 +1075,28 @@ func (is *updateInstallState) Handle(ctx *StateContext, c Controller) (State, bo
 		return NewUpdateErrorState(NewTransientError(err), is.Update()), false
 	}
 
-	merr := c.ReportUpdateStatus(is.Update(), client.StatusInstalling)
+	installers := installOrder(is.Update().InstallOrder, c.GetInstallers())
+	merr := reportProgress(c, is.Update(), client.StatusInstalling,
+		"ArtifactInstall", 0, payloadProgress(installers, 0, 0))
 	if merr != nil && merr.IsFatal() {
 		return is.HandleError(ctx, c, merr)
 	}
 
 	// If download was successful, install update, which for dual rootfs
 	// means marking inactive partition as the active one.
-	for _, i := range c.GetInstallers() {
+	for n, i := range installers {
+		// A payload failing to install may be partly installed, so it
+		// counts as installed when rolling back.
+		is.Update().PayloadsInstalled = n + 1
 		if err := i.InstallUpdate(); err != nil {
 			return is.HandleError(ctx, c, NewTransientError(err))
 		}
+		merr = reportProgress(c, is.Update(), client.StatusInstalling,
+			"ArtifactInstall", (n+1)*100/len(installers),
+			payloadProgress(installers, n+1, 0))
+		if merr != nil && merr.IsFatal() {
+			return is.HandleError(ctx, c, merr)
+		}
 	}
 
 	ok, state, cancelled := is.handleRebootType(ctx, c)
@@ -1411,6 +1489,9 @@ func (s *updateCleanupState) Handle(ctx *StateContext, c Controller) (State, boo
 	// Remove Update Control Maps that match this deployment
 	c.GetControlMapPool().DeleteAllPriorities(s.Update().ID)
 
+	// The download won't be resumed.
+	removePartialDownload(ctx.Store)
+
 	// Zero-time forces an inventory update on next wait
 	ctx.lastInventoryUpdateAttempt = time.Time{}
 
@@ -1582,8 +1663,14 @@ func (usr *updateStatusReportRetryState) Handle(ctx *StateContext, c Controller)
 	if usr.triesSending < maxTrySending {
 		return usr.Wait(usr.reportState, usr, c.GetRetryPollInterval(), ctx.WakeupChan)
 	}
-	// If we have exhausted every attempt, there is nothing more we can
-	// do. The update is over.
+	// If we have exhausted every attempt, the update is over. Keep the
+	// report, to deliver it when the server can be reached again.
+	statusSent := false
+	if reportState, ok := usr.reportState.(*updateStatusReportState); ok {
+		// Logs are only sent after the status went through.
+		statusSent = reportState.triesSendingLogs > 0
+	}
+	c.QueueStatusReport(&usr.update, usr.status, statusSent)
 	return States.Idle, false
 }
 
@@ -1749,13 +1836,22 @@ func (rs *updateRollbackState) Handle(ctx *StateContext, c Controller) (State, b
 
 	log.Info("Performing rollback")
 
-	// Roll back to original partition and perform reboot
-	for _, i := range c.GetInstallers() {
+	// Roll back to original partition and perform reboot. The payloads
+	// are installed together, so all of those installed are rolled back,
+	// even if rolling one back fails.
+	var firstErr error
+	for _, i := range rollbackOrder(rs.Update().InstallOrder,
+		rs.Update().PayloadsInstalled, c.GetInstallers()) {
 		if err := i.Rollback(); err != nil {
 			log.Errorf("Rollback failed: %s", err)
-			return rs.HandleError(ctx, c, NewFatalError(err))
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
+	if firstErr != nil {
+		return rs.HandleError(ctx, c, NewFatalError(firstErr))
+	}
 
 	// Query the rollback parameter from the Update Module, in case it has
 	// not been called previously (MEN-4882)
@@ -2060,7 +2156,7 @@ func (c *controlMapState) Handle(ctx *StateContext, controller Controller) (Stat
 	log.Debugf("controlMapState action: %s", action)
 	switch action {
 	case "continue":
-		return c.wrappedState, false
+		return c.continueInMaintenanceWindow(controller)
 	case "pause":
 		if c.pauseState != nil {
 			log.Debug("Going to pause state")
@@ -2086,8 +2182,21 @@ func (c *controlMapState) Handle(ctx *StateContext, controller Controller) (Stat
 			NewTransientError(errors.New("Forced a failed update")))
 	default:
 		log.Warnf("Unknown Action: %s, continuing", action)
+		return c.continueInMaintenanceWindow(controller)
+	}
+}
+
+// continueInMaintenanceWindow goes on to the wrapped state if its
+// maintenance window is open, and waits for it otherwise. As with a pause,
+// the pause state, if any, comes first.
+func (c *controlMapState) continueInMaintenanceWindow(controller Controller) (State, bool) {
+	if controller.UntilMaintenanceWindow(c.wrappedState.Transition()) <= 0 {
 		return c.wrappedState, false
 	}
+	if c.pauseState != nil {
+		return c.pauseState, false
+	}
+	return NewMaintenanceWindowWaitState(c.wrappedState), false
 }
 
 type fetchControlMapState struct {
@@ -2258,6 +2367,111 @@ func (c *controlMapPauseState) Handle(ctx *StateContext, controller Controller)
 	)
 }
 
+type maintenanceWindowWaitState struct {
+	waitState
+	wrappedState UpdateState
+}
+
+func (m *maintenanceWindowWaitState) PermitLooping() bool { return true }
+
+func NewMaintenanceWindowWaitState(wrappedState UpdateState) State {
+	return &maintenanceWindowWaitState{
+		waitState: waitState{
+			baseState: baseState{
+				id: datastore.MenderStateMaintenanceWindowWait,
+				t:  ToNone,
+			},
+			cancel: make(chan bool),
+		},
+		wrappedState: wrappedState,
+	}
+}
+
+// Wait for the window of the RebootWindows or CommitWindows to open. The
+// control maps are fetched again at least every update poll interval, so
+// that an aborted deployment, or a map failing it, is noticed meanwhile.
+func (m *maintenanceWindowWaitState) Handle(ctx *StateContext, c Controller) (State, bool) {
+	wait := c.UntilMaintenanceWindow(m.wrappedState.Transition())
+	log.Infof("Waiting %s for the maintenance window before entering %s state",
+		wait.Round(time.Second), m.wrappedState.Id())
+	if poll := c.GetUpdatePollInterval(); wait > poll {
+		wait = poll
+	}
+	return m.Wait(NewFetchControlMapState(m.wrappedState, nil), m, wait, ctx.WakeupChan)
+}
+
+type deploymentPausedState struct {
+	waitState
+	wrappedState UpdateState
+}
+
+func (p *deploymentPausedState) PermitLooping() bool { return true }
+
+func (p *deploymentPausedState) Update() *datastore.UpdateInfo {
+	return p.wrappedState.Update()
+}
+
+func NewDeploymentPausedState(wrappedState UpdateState) State {
+	return &deploymentPausedState{
+		waitState: waitState{
+			baseState: baseState{
+				id: datastore.MenderStateDeploymentPaused,
+				t:  ToNone,
+			},
+			cancel: make(chan bool),
+		},
+		wrappedState: wrappedState,
+	}
+}
+
+// Wait for the deployment paused locally to be resumed, before entering the
+// next state of it. Being resumed wakes the state up.
+func (p *deploymentPausedState) Handle(ctx *StateContext, c Controller) (State, bool) {
+	if !c.DeploymentPaused(p.Update().ID) {
+		log.Infof("Deployment resumed, entering %s state", p.wrappedState.Id())
+		return p.wrappedState, false
+	}
+	log.Infof("Deployment paused before entering %s state", p.wrappedState.Id())
+	return p.Wait(NewDeploymentPausedState(p.wrappedState), p,
+		c.GetUpdatePollInterval(), ctx.WakeupChan)
+}
+
+// pausableState returns whether a paused deployment waits before entering
+// the state, which are those update control maps may pause at, and the
+// download.
+func pausableState(state State) (UpdateState, bool) {
+	us, ok := state.(UpdateState)
+	if !ok {
+		return nil, false
+	}
+	switch state.Transition() {
+	case ToDownload_Enter, ToArtifactInstall, ToArtifactReboot_Enter, ToArtifactCommit_Enter:
+		return us, true
+	}
+	return nil, false
+}
+
+// deploymentOfState returns the deployment the state is part of, if any,
+// including the states waiting for update control maps or maintenance
+// windows.
+func deploymentOfState(state State) *datastore.UpdateInfo {
+	switch s := state.(type) {
+	case UpdateState:
+		return s.Update()
+	case *fetchControlMapState:
+		return s.wrappedState.Update()
+	case *fetchRetryControlMapState:
+		return s.wrappedState.Update()
+	case *controlMapState:
+		return s.wrappedState.Update()
+	case *controlMapPauseState:
+		return s.wrappedState.Update()
+	case *maintenanceWindowWaitState:
+		return s.wrappedState.Update()
+	}
+	return nil
+}
+
 type UpdateControlMapWaitState struct {
 	waitState
 }
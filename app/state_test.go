@@ -65,10 +65,21 @@ type stateTestController struct {
 	reportUpdate           datastore.UpdateInfo
 	logUpdate              datastore.UpdateInfo
 	logs                   []byte
+	queuedUpdate           datastore.UpdateInfo
+	queuedStatus           string
+	queuedStatusSent       bool
 	inventoryErr           error
 	controlMap             *ControlMapPool
 	installers             []installer.PayloadUpdatePerformer
 	refreshControlMapError error
+	antiRollbackProvide    string
+	maintenanceWindowWait  map[Transition]time.Duration
+	progressReports        []client.StatusReport
+	pausedDeployment       string
+}
+
+func (s *stateTestController) GetAntiRollbackProvide() string {
+	return s.antiRollbackProvide
 }
 
 func (s *stateTestController) GetCurrentArtifactName() (string, error) {
@@ -98,14 +109,39 @@ func (s *stateTestController) CheckUpdate() (*datastore.UpdateInfo, menderError)
 	return s.updateResp, s.updateRespErr
 }
 
-func (s *stateTestController) FetchUpdate(url string) (io.ReadCloser, int64, error) {
-	return s.updater.FetchUpdate(nil, url)
+func (s *stateTestController) FetchUpdate(
+	update *datastore.UpdateInfo,
+) (io.ReadCloser, int64, error) {
+	return s.updater.FetchUpdate(nil, update.URI())
+}
+
+func (s *stateTestController) CancelDownload() bool {
+	return false
+}
+
+func (s *stateTestController) PauseDeployment(id string) error {
+	s.pausedDeployment = id
+	return nil
+}
+
+func (s *stateTestController) ResumeDeployment() bool {
+	paused := s.pausedDeployment != ""
+	s.pausedDeployment = ""
+	return paused
+}
+
+func (s *stateTestController) DeploymentPaused(id string) bool {
+	return s.pausedDeployment != "" && s.pausedDeployment == id
 }
 
 func (s *stateTestController) RefreshServerUpdateControlMap(deploymentID string) error {
 	return s.refreshControlMapError
 }
 
+func (s *stateTestController) UntilMaintenanceWindow(t Transition) time.Duration {
+	return s.maintenanceWindowWait[t]
+}
+
 func (s *stateTestController) GetCurrentState() State {
 	return s.state
 }
@@ -147,12 +183,32 @@ func (s *stateTestController) ReportUpdateStatus(
 	return s.reportError
 }
 
+func (s *stateTestController) ReportUpdateProgress(
+	update *datastore.UpdateInfo,
+	report client.StatusReport,
+) menderError {
+	s.reportUpdate = *update
+	s.reportStatus = report.Status
+	s.progressReports = append(s.progressReports, report)
+	return s.reportError
+}
+
 func (s *stateTestController) UploadLog(update *datastore.UpdateInfo, logs []byte) menderError {
 	s.logUpdate = *update
 	s.logs = logs
 	return s.logSendingError
 }
 
+func (s *stateTestController) QueueStatusReport(
+	update *datastore.UpdateInfo,
+	status string,
+	statusSent bool,
+) {
+	s.queuedUpdate = *update
+	s.queuedStatus = status
+	s.queuedStatusSent = statusSent
+}
+
 func (s *stateTestController) InventoryRefresh() error {
 	return s.inventoryErr
 }
@@ -391,6 +447,10 @@ func TestStateUpdateReportStatus(t *testing.T) {
 	s, c = s.Handle(&ctx, sc)
 	assert.IsType(t, States.Idle, s)
 	assert.False(t, c)
+	// the report is kept for when the server is back
+	assert.Equal(t, *update, sc.queuedUpdate)
+	assert.Equal(t, client.StatusSuccess, sc.queuedStatus)
+	assert.False(t, sc.queuedStatusSent)
 
 	// error sending logs
 	sc = &stateTestController{
@@ -422,6 +482,9 @@ func TestStateUpdateReportStatus(t *testing.T) {
 	s, c = s.Handle(&ctx, sc)
 	assert.IsType(t, s, States.Idle)
 	assert.False(t, c)
+	// the status went through, only the logs are kept
+	assert.Equal(t, client.StatusFailure, sc.queuedStatus)
+	assert.True(t, sc.queuedStatusSent)
 
 	// pretend update was aborted at the backend, but was applied
 	// successfully on the device
@@ -730,7 +793,7 @@ func TestStateUpdateFetch(t *testing.T) {
 	assert.Equal(t, client.StatusDownloading, sc.reportStatus)
 	assert.Equal(t, *update, sc.reportUpdate)
 	uis := s.(*updateStoreState)
-	assert.Equal(t, stream, uis.imagein)
+	assert.Equal(t, stream, uis.imagein.(*progressReader).ReadCloser)
 	s, c = transitionState(s, &ctx, sc)
 	assert.IsType(t, &updateStatusReportState{}, s)
 	assert.False(t, c)
@@ -1036,6 +1099,77 @@ func TestStateUpdateInstallFailed(t *testing.T) {
 	assert.False(t, c)
 }
 
+// orderedPayload records when its payload is installed and rolled back.
+type orderedPayload struct {
+	FakeDevice
+	name string
+	log  *[]string
+}
+
+func (p *orderedPayload) GetType() string {
+	return p.name
+}
+
+func (p *orderedPayload) InstallUpdate() error {
+	*p.log = append(*p.log, "install "+p.name)
+	return p.RetEnablePart
+}
+
+func (p *orderedPayload) Rollback() error {
+	*p.log = append(*p.log, "rollback "+p.name)
+	return p.RetRollback
+}
+
+func TestStateUpdateInstallOrderedPayloads(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "logs")
+	DeploymentLogger = NewDeploymentLogManager(tempDir)
+	defer func() {
+		DeploymentLogger = nil
+		os.RemoveAll(tempDir)
+	}()
+
+	var log []string
+	payloads := []*orderedPayload{
+		{name: "a", log: &log},
+		{name: "b", log: &log},
+		{name: "c", log: &log},
+	}
+	stc := &stateTestController{}
+	for _, p := range payloads {
+		stc.installers = append(stc.installers, p)
+	}
+	ctx := &StateContext{Store: store.NewMemStore()}
+	update := &datastore.UpdateInfo{
+		ID:               "foo",
+		InstallOrder:     []int{1, 2, 0},
+		SupportsRollback: datastore.RollbackSupported,
+	}
+
+	// All the payloads are installed, in order.
+	s, _ := NewUpdateInstallState(update).Handle(ctx, stc)
+	assert.IsType(t, &fetchControlMapState{}, s)
+	assert.Equal(t, []string{"install b", "install c", "install a"}, log)
+
+	// If one fails, those installed before it are rolled back with it, and
+	// the others aren't.
+	log = nil
+	payloads[2].RetEnablePart = errors.New("install failed")
+	s, _ = NewUpdateInstallState(update).Handle(ctx, stc)
+	require.IsType(t, &updateRollbackState{}, s)
+	assert.Equal(t, 2, s.(*updateRollbackState).Update().PayloadsInstalled)
+	s.Handle(ctx, stc)
+	assert.Equal(t, []string{"install b", "install c",
+		"rollback c", "rollback b"}, log)
+
+	// Rolling back continues when one fails to.
+	log = nil
+	payloads[2].RetRollback = errors.New("rollback failed")
+	s = NewUpdateRollbackState(update)
+	s, _ = s.Handle(ctx, stc)
+	assert.IsType(t, &updateErrorState{}, s)
+	assert.Equal(t, []string{"rollback a", "rollback c", "rollback b"}, log)
+}
+
 func TestStateFinal(t *testing.T) {
 	rs := finalState{}
 
@@ -1134,8 +1268,17 @@ func (m *menderWithCustomUpdater) ReportUpdateStatus(
 	return nil
 }
 
-func (m *menderWithCustomUpdater) FetchUpdate(url string) (io.ReadCloser, int64, error) {
-	return m.updater.FetchUpdate(nil, url)
+func (m *menderWithCustomUpdater) ReportUpdateProgress(
+	update *datastore.UpdateInfo,
+	report client.StatusReport,
+) menderError {
+	return m.ReportUpdateStatus(update, report.Status)
+}
+
+func (m *menderWithCustomUpdater) FetchUpdate(
+	update *datastore.UpdateInfo,
+) (io.ReadCloser, int64, error) {
+	return m.updater.FetchUpdate(nil, update.URI())
 }
 
 func (m *menderWithCustomUpdater) UploadLog(update *datastore.UpdateInfo, logs []byte) menderError {
@@ -4749,8 +4892,10 @@ var stateTransitionsWithUpdateModulesTestCases []stateTransitionsWithUpdateModul
 			"installing",
 			"success",
 		},
+		// Fails the installing report, the progress report after the
+		// payload is installed, and the first report before committing.
+		failStatusReportCount:  3,
 		installOutcome:         tests.SuccessfulInstall,
-		failStatusReportCount:  2,
 		failStatusReportStatus: client.StatusInstalling,
 	},
 
@@ -5567,6 +5712,73 @@ func TestControlMapPauseState(t *testing.T) {
 	assert.IsType(t, &controlMapState{}, next)
 }
 
+func TestMaintenanceWindowWaitState(t *testing.T) {
+	ms := store.NewMemStore()
+	ctx := &StateContext{
+		Store:         ms,
+		pauseReported: make(map[string]bool),
+	}
+	c := &stateTestController{
+		updatePollIntvl: 10 * time.Millisecond,
+		maintenanceWindowWait: map[Transition]time.Duration{
+			ToArtifactReboot_Enter: time.Hour,
+		},
+	}
+	u := &datastore.UpdateInfo{ID: "foo"}
+
+	// As with a pause, the reboot state is stored first.
+	next, _ := NewControlMapState(NewUpdateRebootState(u),
+		NewUpdateRebootPauseRequestedState(u)).Handle(ctx, c)
+	assert.IsType(t, &updateRebootPauseRequestedState{}, next)
+	next, _ = next.Handle(ctx, c)
+	assert.IsType(t, &controlMapState{}, next)
+	next, _ = next.Handle(ctx, c)
+	assert.IsType(t, &maintenanceWindowWaitState{}, next)
+
+	// The maps are fetched again after the update poll interval at most.
+	next, _ = next.Handle(ctx, c)
+	assert.IsType(t, &fetchControlMapState{}, next)
+
+	// Commit has no window.
+	next, _ = NewControlMapState(NewUpdateCommitState(u), nil).Handle(ctx, c)
+	assert.IsType(t, &updateCommitState{}, next)
+
+	c.maintenanceWindowWait = nil
+	next, _ = NewControlMapState(NewUpdateRebootState(u), nil).Handle(ctx, c)
+	assert.IsType(t, &updateRebootState{}, next)
+}
+
+func TestDeploymentPausedState(t *testing.T) {
+	ctx := &StateContext{
+		Store:      store.NewMemStore(),
+		WakeupChan: make(chan bool, 1),
+	}
+	c := &stateTestController{updatePollIntvl: time.Hour}
+	u := &datastore.UpdateInfo{ID: "foo"}
+
+	us, ok := pausableState(NewUpdateInstallState(u))
+	require.True(t, ok)
+	_, ok = pausableState(NewUpdateCleanupState(u, client.StatusFailure))
+	assert.False(t, ok)
+	_, ok = pausableState(States.Idle)
+	assert.False(t, ok)
+
+	paused := NewDeploymentPausedState(us)
+	assert.Equal(t, u.ID, deploymentOfState(paused).ID)
+	assert.Equal(t, u.ID, deploymentOfState(NewControlMapPauseState(us)).ID)
+	assert.Nil(t, deploymentOfState(States.Idle))
+
+	// Resuming wakes the state up, which goes on with the wrapped state.
+	require.NoError(t, c.PauseDeployment("foo"))
+	ctx.WakeupChan <- true
+	next, cancelled := paused.Handle(ctx, c)
+	assert.False(t, cancelled)
+	assert.IsType(t, &deploymentPausedState{}, next)
+	assert.True(t, c.ResumeDeployment())
+	next, _ = next.Handle(ctx, c)
+	assert.IsType(t, &updateInstallState{}, next)
+}
+
 func TestControlMapFetch(t *testing.T) {
 
 	tests := map[string]struct {
@@ -5700,3 +5912,32 @@ func TestArtifactRollbackRebootUpdateModuleRebootHandling(t *testing.T) {
 
 	}
 }
+
+func TestInitStateResumesWrite(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "logs")
+	DeploymentLogger = NewDeploymentLogManager(tempDir)
+	defer func() {
+		DeploymentLogger = nil
+		os.RemoveAll(tempDir)
+	}()
+
+	ms := store.NewMemStore()
+	update := &datastore.UpdateInfo{ID: "foo"}
+	update.Artifact.ArtifactName = "release-2"
+	require.NoError(t, datastore.StoreStateData(ms, datastore.StateData{
+		Name:       datastore.MenderStateUpdateStore,
+		UpdateInfo: *update,
+	}, true))
+	ctx := &StateContext{Store: ms}
+
+	require.NoError(t, ms.WriteAll(datastore.RootfsWriteProgressKey,
+		[]byte(`{"artifact_name": "release-1", "offset": 1024}`)))
+	next, _ := States.Init.Handle(ctx, &stateTestController{})
+	assert.IsType(t, &updateCleanupState{}, next)
+
+	require.NoError(t, ms.WriteAll(datastore.RootfsWriteProgressKey,
+		[]byte(`{"artifact_name": "release-2", "offset": 1024}`)))
+	next, _ = States.Init.Handle(ctx, &stateTestController{})
+	assert.IsType(t, &updateFetchState{}, next)
+	assert.True(t, installer.WriteInterrupted(ms, "release-2"))
+}
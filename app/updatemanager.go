@@ -35,6 +35,9 @@ import (
 
 const (
 	updateManagerSetUpdateControlMap = "SetUpdateControlMap"
+	updateManagerCancelDownload      = "CancelDownload"
+	updateManagerPauseDeployment     = "PauseDeployment"
+	updateManagerResumeDeployment    = "ResumeDeployment"
 	UpdateManagerDBusPath            = "/io/mender/UpdateManager"
 	UpdateManagerDBusObjectName      = "io.mender.UpdateManager"
 	UpdateManagerDBusInterfaceName   = "io.mender.Update1"
@@ -45,6 +48,15 @@ const (
 		  <arg type="s" name="update_control_map" direction="in"/>
 		  <arg type="i" name="refresh_timeout" direction="out"/>
 		</method>
+		<method name="CancelDownload">
+		  <arg type="b" name="cancelled" direction="out"/>
+		</method>
+		<method name="PauseDeployment">
+		  <arg type="b" name="paused" direction="out"/>
+		</method>
+		<method name="ResumeDeployment">
+		  <arg type="b" name="resumed" direction="out"/>
+		</method>
 	      </interface>
 	    </node>`
 )
@@ -55,6 +67,11 @@ type UpdateManager struct {
 	dbus                        dbus.DBusAPI
 	controlMapPool              *ControlMapPool
 	updateControlTimeoutSeconds int
+	// Tears down the artifact download in progress, may be nil.
+	cancelDownload func() bool
+	// Pause and resume the deployment in progress, may be nil.
+	pauseDeployment  func() error
+	resumeDeployment func() bool
 }
 
 func NewUpdateManager(
@@ -147,6 +164,57 @@ func (u *UpdateManager) run(ctx context.Context) error {
 		UpdateManagerDBusPath,
 		UpdateManagerDBusInterfaceName,
 		updateManagerSetUpdateControlMap)
+
+	u.dbus.RegisterMethodCallCallback(
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerCancelDownload,
+		func(_ string, _ string, _ string, _ string) (interface{}, error) {
+			log.Info("Received a request to cancel the artifact download via D-Bus")
+			if u.cancelDownload == nil {
+				return false, nil
+			}
+			return u.cancelDownload(), nil
+		})
+	defer u.dbus.UnregisterMethodCallCallback(
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerCancelDownload)
+
+	u.dbus.RegisterMethodCallCallback(
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerPauseDeployment,
+		func(_ string, _ string, _ string, _ string) (interface{}, error) {
+			log.Info("Received a request to pause the deployment via D-Bus")
+			if u.pauseDeployment == nil {
+				return false, errNoDeploymentInProgress
+			}
+			if err := u.pauseDeployment(); err != nil {
+				return false, err
+			}
+			return true, nil
+		})
+	defer u.dbus.UnregisterMethodCallCallback(
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerPauseDeployment)
+
+	u.dbus.RegisterMethodCallCallback(
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerResumeDeployment,
+		func(_ string, _ string, _ string, _ string) (interface{}, error) {
+			log.Info("Received a request to resume the deployment via D-Bus")
+			if u.resumeDeployment == nil {
+				return false, nil
+			}
+			return u.resumeDeployment(), nil
+		})
+	defer u.dbus.UnregisterMethodCallCallback(
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerResumeDeployment)
 	<-ctx.Done()
 	return nil
 }
@@ -97,6 +97,45 @@ func setupTestUpdateManager() dbus.DBusAPI {
 		updateManagerSetUpdateControlMap,
 	)
 
+	dbusAPI.On("RegisterMethodCallCallback",
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerCancelDownload,
+		mock.Anything,
+	)
+
+	dbusAPI.On("UnregisterMethodCallCallback",
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerCancelDownload,
+	)
+
+	dbusAPI.On("RegisterMethodCallCallback",
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerPauseDeployment,
+		mock.Anything,
+	)
+
+	dbusAPI.On("UnregisterMethodCallCallback",
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerPauseDeployment,
+	)
+
+	dbusAPI.On("RegisterMethodCallCallback",
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerResumeDeployment,
+		mock.Anything,
+	)
+
+	dbusAPI.On("UnregisterMethodCallCallback",
+		UpdateManagerDBusPath,
+		UpdateManagerDBusInterfaceName,
+		updateManagerResumeDeployment,
+	)
+
 	dbusAPI.On("BusUnregisterInterface",
 		dbusConn,
 		uint(2),
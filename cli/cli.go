@@ -138,7 +138,92 @@ func SetupCLI(args []string) error {
 					Usage:       "Force bootstrap.",
 					Destination: &runOptions.bootstrapForce,
 				},
+				&cli.StringFlag{
+					Name:        "key-type",
+					Usage:       "Type of the generated device key: rsa, ecdsa or ed25519.",
+					Destination: &runOptions.keyOptions.keyType,
+				},
+				&cli.StringFlag{
+					Name:        "key-curve",
+					Usage:       "Curve of a generated ECDSA key: P-256, P-384 or P-521.",
+					Destination: &runOptions.keyOptions.keyCurve,
+				},
+				&cli.IntFlag{
+					Name:        "key-length",
+					Usage:       "Length in bits of a generated RSA key.",
+					Destination: &runOptions.keyOptions.keyLength,
+				},
+			},
+			Action: runOptions.handleCLIOptions,
+		},
+		{
+			Name: "migrate-key",
+			Usage: "Move the device key from a file to a TPM 2.0 or a PKCS#11 token, " +
+				"and update the configuration file to use it.",
+			Action: runOptions.handleCLIOptions,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "to",
+					Usage:       "Where to move the key: \"tpm2\" or \"pkcs11\".",
+					Required:    true,
+					Destination: &runOptions.migrateKeyOptions.to,
+				},
+				&cli.StringFlag{
+					Name:        "token",
+					Usage:       "PKCS#11 `URI` of the token to move the key to.",
+					Destination: &runOptions.migrateKeyOptions.token,
+				},
+				&cli.StringFlag{
+					Name:        "label",
+					Usage:       "Label of the key in the PKCS#11 token.",
+					Value:       defaultMigrateKeyLabel,
+					Destination: &runOptions.migrateKeyOptions.label,
+				},
+				&cli.StringFlag{
+					Name:        "engine",
+					Usage:       "OpenSSL engine to use the key in the PKCS#11 token with.",
+					Value:       defaultMigrateKeyEngine,
+					Destination: &runOptions.migrateKeyOptions.engine,
+				},
+				&cli.BoolFlag{
+					Name: "generate",
+					Usage: "Generate a new key in the PKCS#11 token, and authorize " +
+						"with it, instead of writing the current key to it.",
+					Destination: &runOptions.migrateKeyOptions.generate,
+				},
+			},
+		},
+		{
+			Name: "enroll-certificate",
+			Usage: "Enroll the client certificate with the EST server of the " +
+				"configuration, if it is missing or due for renewal.",
+			Action: runOptions.handleCLIOptions,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "force",
+					Usage:       "Renew the certificate even if it isn't due for renewal.",
+					Destination: &runOptions.enrollCertificateForce,
+				},
 			},
+		},
+		{
+			Name: "decommission",
+			Usage: "Remove the device key, tokens and server state from the device, " +
+				"so that it enrolls as a new device. Stop the daemon first.",
+			Action: runOptions.handleCLIOptions,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name: "notify-server",
+					Usage: "Set the mender_decommissioned inventory attribute " +
+						"first, and remove nothing if that fails.",
+					Destination: &runOptions.decommissionNotify,
+				},
+			},
+		},
+		{
+			Name: "rotate-key",
+			Usage: "Generate a new device key and submit it for authorization. " +
+				"The current key stays in use until the server accepts the new key.",
 			Action: runOptions.handleCLIOptions,
 		},
 		{
@@ -184,13 +269,42 @@ func SetupCLI(args []string) error {
 				},
 				&cli.StringFlag{
 					Name: "passphrase-file",
-					Usage: "Passphrase file for decrypting an encrypted private key." +
+					Usage: "Passphrase file of an encrypted private key, generated keys are encrypted with it." +
 						" '-' loads passphrase from stdin.",
 					Value:       "",
 					Destination: &runOptions.keyPassphrase,
 				},
 			},
 		},
+		{
+			Name: "pause-deployment",
+			Usage: "Pause the deployment in progress until it is resumed, " +
+				"also across restarts. Requires D-Bus to be enabled.",
+			Action: func(_ *cli.Context) error {
+				return callUpdateManager(updateManagerMethod("PauseDeployment"),
+					"failed to pause the deployment")
+			},
+		},
+		{
+			Name: "reauthorize",
+			Usage: "Have the daemon drop its authorization token, and " +
+				"authorize again right away.",
+			Action: func(_ *cli.Context) error {
+				return sendSignalToProcess(
+					system.Command("kill", "-HUP"),
+					system.Command("systemctl",
+						"show", "-p",
+						"MainPID", "mender-client"))
+			},
+		},
+		{
+			Name:  "resume-deployment",
+			Usage: "Resume the paused deployment. Requires D-Bus to be enabled.",
+			Action: func(_ *cli.Context) error {
+				return callUpdateManager(updateManagerMethod("ResumeDeployment"),
+					"there is no paused deployment")
+			},
+		},
 		{
 			Name: "rollback",
 			Usage: "Rollback current Artifact. Returns (2) " +
@@ -431,7 +545,7 @@ func SetupCLI(args []string) error {
 		},
 		&cli.StringFlag{
 			Name: "passphrase-file",
-			Usage: "Passphrase file for decrypting an encrypted private key." +
+			Usage: "Passphrase file of an encrypted private key, generated keys are encrypted with it." +
 				" '-' loads passphrase from stdin.",
 			Value:       "",
 			Destination: &runOptions.keyPassphrase,
@@ -532,6 +646,18 @@ func (runOptions *runOptionsType) handleCLIOptions(ctx *cli.Context) error {
 	case "bootstrap":
 		return doBootstrapAuthorize(config, runOptions)
 
+	case "rotate-key":
+		return doRotateKey(config, runOptions)
+
+	case "migrate-key":
+		return doMigrateKey(config, runOptions)
+
+	case "decommission":
+		return doDecommission(config, runOptions)
+
+	case "enroll-certificate":
+		return doEnrollCertificate(config, runOptions)
+
 	case "daemon":
 		if !ctx.IsSet("log-level") && config.DaemonLogLevel != "" {
 			if lvl, err := log.ParseLevel(config.DaemonLogLevel); err == nil {
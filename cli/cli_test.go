@@ -113,7 +113,7 @@ func TestRunDaemon(t *testing.T) {
 	pieces := app.MenderPieces{
 		Store: store.NewMemStore(),
 		DualRootfsDevice: installer.NewDualRootfsDevice(
-			nil, nil, conf.DualRootfsDeviceConfig{}),
+			nil, nil, conf.DualRootfsDeviceConfig{}, nil),
 	}
 
 	pieces.AuthManager = app.NewAuthManager(app.AuthManagerConfig{
@@ -479,6 +479,13 @@ func TestGetMenderDaemonPID(t *testing.T) {
 	assert.Error(t, sendSignalToProcess(cmdKill, cmdPID))
 }
 
+func TestCallUpdateManager(t *testing.T) {
+	assert.NoError(t, callUpdateManager(system.Command("echo", "b true"), "failed"))
+	assert.EqualError(t,
+		callUpdateManager(system.Command("echo", "b false"), "failed"), "failed")
+	assert.Error(t, callUpdateManager(system.Command("false"), "failed"))
+}
+
 // Tests that the client will boot with an error message in the case of an invalid server certificate.
 func TestInvalidServerCertificateBoot(t *testing.T) {
 	tdir, err := ioutil.TempDir("", "invalidcert-test")
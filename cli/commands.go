@@ -52,10 +52,37 @@ type runOptionsType struct {
 	imageFile      string
 	keyPassphrase  string
 	bootstrapForce bool
+	keyOptions     keyOptionsType // Key generation options for bootstrap
 	conf.HttpConfig
 	logOptions     logOptionsType
 	setupOptions   setupOptionsType // Options for setup subcommand
 	rebootExitCode bool
+	// Options for migrate-key subcommand
+	migrateKeyOptions migrateKeyOptionsType
+	// Notify the server in the decommission subcommand
+	decommissionNotify bool
+	// Renew the certificate in the enroll-certificate subcommand even if
+	// it isn't due
+	enrollCertificateForce bool
+}
+
+// keyOptionsType overrides the key generation options of the configuration.
+type keyOptionsType struct {
+	keyType   string
+	keyCurve  string
+	keyLength int
+}
+
+func (o keyOptionsType) apply(config *conf.MenderConfig) {
+	if o.keyType != "" {
+		config.Security.KeyType = o.keyType
+	}
+	if o.keyCurve != "" {
+		config.Security.KeyCurve = o.keyCurve
+	}
+	if o.keyLength != 0 {
+		config.Security.KeyLength = o.keyLength
+	}
 }
 
 var out io.Writer = os.Stdout
@@ -66,12 +93,15 @@ var (
 	)
 )
 
-func initDualRootfsDevice(config *conf.MenderConfig) installer.DualRootfsDevice {
+func initDualRootfsDevice(
+	config *conf.MenderConfig,
+	store store.Store,
+) installer.DualRootfsDevice {
 	env := installer.NewEnvironment(new(system.OsCalls), config.BootUtilitiesSetActivePart,
 		config.BootUtilitiesGetNextActivePart)
 
 	dualRootfsDevice := installer.NewDualRootfsDevice(
-		env, new(system.OsCalls), config.GetDeviceConfig())
+		env, new(system.OsCalls), config.GetDeviceConfig(), store)
 	if dualRootfsDevice == nil {
 		log.Info("No dual rootfs configuration present")
 	} else {
@@ -120,36 +150,17 @@ func commonInit(
 		return nil, nil, errors.New("failed to initialize DB store")
 	}
 
-	var privateKey string
-	var sslEngine string
-	var static bool
-
 	if !initDbOnly {
-		if config.HttpsClient.Key != "" {
-			privateKey = config.HttpsClient.Key
-			sslEngine = config.HttpsClient.SSLEngine
-			static = true
-		}
-		if config.Security.AuthPrivateKey != "" {
-			privateKey = config.Security.AuthPrivateKey
-			sslEngine = config.Security.SSLEngine
-			static = true
-		}
-		if config.HttpsClient.Key == "" && config.Security.AuthPrivateKey == "" {
-			privateKey = conf.DefaultKeyFile
-			sslEngine = config.HttpsClient.SSLEngine
-			static = false
-		}
-
-		ks = store.NewKeystore(dirstore, privateKey, sslEngine, static, opts.keyPassphrase)
-		if ks == nil {
-			return nil, nil, errors.New("failed to setup key storage")
+		if ks, err = newKeystore(config, opts, dirstore); err != nil {
+			// close DB store explicitly
+			dbstore.Close()
+			return nil, nil, err
 		}
 
 		authmgr = app.NewAuthManager(app.AuthManagerConfig{
 			AuthDataStore:  dbstore,
 			KeyStore:       ks,
-			IdentitySource: dev.NewIdentityDataGetter(),
+			IdentitySource: dev.NewConfiguredIdentityDataGetter(config),
 			TenantToken:    tentok,
 			Config:         config,
 		})
@@ -178,7 +189,7 @@ func commonInit(
 		AuthManager: authmgr,
 	}
 
-	mp.DualRootfsDevice = initDualRootfsDevice(config)
+	mp.DualRootfsDevice = initDualRootfsDevice(config, dbstore)
 
 	m, err := app.NewMender(config, mp)
 	if err != nil {
@@ -190,6 +201,61 @@ func commonInit(
 	return m, &mp, nil
 }
 
+// newKeystore sets up the keystore of the device key from the configuration.
+func newKeystore(
+	config *conf.MenderConfig,
+	opts *runOptionsType,
+	dirstore *store.DirStore,
+) (*store.Keystore, error) {
+	var privateKey string
+	var sslEngine string
+	var static bool
+
+	if config.HttpsClient.Key != "" {
+		privateKey = config.HttpsClient.Key
+		sslEngine = config.HttpsClient.SSLEngine
+		static = true
+	}
+	if config.Security.AuthPrivateKey != "" {
+		privateKey = config.Security.AuthPrivateKey
+		sslEngine = config.Security.SSLEngine
+		static = true
+	}
+	if config.HttpsClient.Key == "" && config.Security.AuthPrivateKey == "" {
+		privateKey = conf.DefaultKeyFile
+		sslEngine = config.HttpsClient.SSLEngine
+		static = false
+	}
+
+	passphraseFile := opts.keyPassphrase
+	if passphraseFile == "" {
+		passphraseFile = config.Security.AuthPrivateKeyPassphraseFile
+	}
+	ks := store.NewKeystore(dirstore, privateKey, sslEngine, static, passphraseFile)
+	if ks == nil {
+		return nil, errors.New("failed to setup key storage")
+	}
+	if opts.keyPassphrase == "" && len(config.Security.AuthPrivateKeyPassphraseCommand) > 0 {
+		ks.SetPassphraseCommand(config.Security.AuthPrivateKeyPassphraseCommand)
+	}
+	if err := ks.SetKeyBackend(config.Security.KeyBackend); err != nil {
+		return nil, err
+	}
+	if err := ks.SetKeyType(config.Security.KeyType); err != nil {
+		return nil, err
+	}
+	if err := ks.SetKeyCurve(config.Security.KeyCurve); err != nil {
+		return nil, err
+	}
+	if err := ks.SetKeyLength(config.Security.KeyLength); err != nil {
+		return nil, err
+	}
+	if err := ks.SetFIPSMode(config.Security.FIPSMode); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
 func doHandleBootstrapArtifact(config *conf.MenderConfig, opts *runOptionsType) error {
 	controller, mp, err := commonInit(config, opts, true)
 	if err != nil {
@@ -204,6 +270,7 @@ func doHandleBootstrapArtifact(config *conf.MenderConfig, opts *runOptionsType)
 }
 
 func doBootstrapAuthorize(config *conf.MenderConfig, opts *runOptionsType) error {
+	opts.keyOptions.apply(config)
 	controller, mp, err := commonInit(config, opts, false)
 	if err != nil {
 		return err
@@ -230,6 +297,50 @@ func doBootstrapAuthorize(config *conf.MenderConfig, opts *runOptionsType) error
 	return err
 }
 
+// doRotateKey generates a new device key, and authorizes with it, which
+// submits it to the server. If the server accepts it right away, it replaces
+// the current key, otherwise that happens when the daemon authorizes once it
+// has been accepted.
+func doRotateKey(config *conf.MenderConfig, opts *runOptionsType) error {
+	controller, mp, err := commonInit(config, opts, false)
+	if err != nil {
+		return err
+	}
+
+	// need to close DB store manually, since we're not running under a
+	// daemonized version
+	defer mp.Store.Close()
+
+	authManager := mp.AuthManager
+	if merr := authManager.Bootstrap(); merr != nil {
+		return merr.Cause()
+	}
+	if err = authManager.RotateKey(); err != nil {
+		return err
+	}
+
+	authManager.Start()
+	defer authManager.Stop()
+
+	_, _, err = controller.Authorize()
+
+	return err
+}
+
+// doEnrollCertificate enrolls the client certificate with the EST server,
+// if it is missing or due for renewal, or with --force.
+func doEnrollCertificate(config *conf.MenderConfig, opts *runOptionsType) error {
+	enrolled, err := app.EnrollCertificate(config, opts.enrollCertificateForce)
+	if err != nil {
+		return err
+	}
+	if !enrolled {
+		log.Infof("The client certificate %s is not due for renewal.",
+			config.HttpsClient.Certificate)
+	}
+	return nil
+}
+
 func getMenderDaemonPID(cmd *system.Cmd) (string, error) {
 	buf := bytes.NewBuffer(nil)
 	cmd.Stdout = buf
@@ -252,7 +363,7 @@ func handleArtifactOperations(ctx *cli.Context, runOptions runOptionsType,
 		return errors.New("failed to initialize DB store")
 	}
 
-	dualRootfsDevice := initDualRootfsDevice(config)
+	dualRootfsDevice := initDualRootfsDevice(config, dbstore)
 
 	stateExec := dev.NewStateScriptExecutor(config)
 	deviceManager := dev.NewDeviceManager(dualRootfsDevice, config, dbstore)
@@ -265,8 +376,15 @@ func handleArtifactOperations(ctx *cli.Context, runOptions runOptionsType,
 		return PrintProvides(deviceManager)
 
 	case "install":
+		// Use the same TLS, proxy and client certificate settings as the
+		// daemon, with the server certificate from the command line
+		// taking precedence.
+		httpConfig := config.GetHttpConfig()
+		if runOptions.HttpConfig.ServerCert != "" {
+			httpConfig.ServerCert = runOptions.HttpConfig.ServerCert
+		}
 		return app.DoStandaloneInstall(deviceManager, runOptions.imageFile,
-			runOptions.HttpConfig, stateExec, runOptions.rebootExitCode)
+			httpConfig, stateExec, runOptions.rebootExitCode)
 
 	case "commit":
 		return app.DoStandaloneCommit(deviceManager, stateExec)
@@ -364,6 +482,10 @@ func runDaemon(d *app.MenderDaemon) error {
 			} else if s == syscall.SIGUSR2 {
 				log.Debug("SIGUSR2 signal received.")
 				d.ForceToState <- app.States.InventoryUpdate
+			} else if s == syscall.SIGHUP {
+				log.Debug("SIGHUP signal received.")
+				d.Reauthorize()
+				continue
 			}
 			d.Sctx.WakeupChan <- true
 			log.Debug("Sent wake up!")
@@ -372,7 +494,32 @@ func runDaemon(d *app.MenderDaemon) error {
 	return d.Run()
 }
 
-// sendSignalToProcess sends a SIGUSR{1,2} signal to the running mender daemon.
+// callUpdateManager calls a method of the D-Bus API of the running mender
+// daemon, which returns a boolean, and fails with failure if it is false.
+func callUpdateManager(cmd *system.Cmd, failure string) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to call the mender daemon: %s",
+			strings.TrimSpace(string(out)))
+	}
+	if strings.TrimSpace(string(out)) != "b true" {
+		return errors.New(failure)
+	}
+	return nil
+}
+
+// updateManagerMethod returns the command calling the method of the D-Bus
+// API of the running mender daemon.
+func updateManagerMethod(method string) *system.Cmd {
+	return system.Command("busctl", "call",
+		app.UpdateManagerDBusObjectName,
+		app.UpdateManagerDBusPath,
+		app.UpdateManagerDBusInterfaceName,
+		method)
+}
+
+// sendSignalToProcess sends a SIGUSR{1,2} or SIGHUP signal to the running
+// mender daemon.
 func sendSignalToProcess(cmdKill, cmdGetPID *system.Cmd) error {
 	pid, err := getMenderDaemonPID(cmdGetPID)
 	if err != nil {
@@ -0,0 +1,100 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/app"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+// doDecommission returns the device to the state it was in before it was
+// first enrolled: the generated device key, the tokens, the deployment logs,
+// the downloaded artifacts and everything else tying it to a server is
+// removed, and it enrolls as a new device the next time the daemon starts. With notifyServer, the device first tells the
+// server that it is being decommissioned, and nothing is removed if that
+// fails. The daemon must not be running.
+func doDecommission(config *conf.MenderConfig, opts *runOptionsType) error {
+	controller, mp, err := commonInit(config, opts, !opts.decommissionNotify)
+	if err != nil {
+		return err
+	}
+	// need to close DB store manually, since we're not running under a
+	// daemonized version
+	defer mp.Store.Close()
+
+	for _, key := range []string{datastore.StateDataKey, datastore.StandaloneStateKey} {
+		if _, err = mp.Store.ReadAll(key); err == nil {
+			return errors.New("an update is in progress, commit or roll it back " +
+				"before decommissioning the device")
+		} else if err != os.ErrNotExist {
+			return errors.Wrap(err, "failed to check for an update in progress")
+		}
+	}
+
+	if opts.decommissionNotify {
+		authManager := mp.AuthManager
+		if merr := authManager.Bootstrap(); merr != nil {
+			return merr.Cause()
+		}
+		authManager.Start()
+		err = controller.ReportDecommission()
+		authManager.Stop()
+		if err != nil {
+			return errors.Wrap(err, "failed to notify the server, nothing was removed")
+		}
+		log.Info("The server has been notified that the device is decommissioned.")
+	}
+
+	if err = datastore.WipeDecommissioned(mp.Store); err != nil {
+		return err
+	}
+	files, err := app.DecommissionFiles(config, opts.dataStore)
+	if err == nil {
+		err = datastore.WipeDecommissionedFiles(files)
+	}
+	if err != nil {
+		return err
+	}
+
+	if config.Security.AuthPrivateKey == "" && config.HttpsClient.Key == "" {
+		dirstore := store.NewDirStore(opts.dataStore)
+		for _, name := range []string{conf.DefaultKeyFile,
+			conf.DefaultKeyFile + store.RotationKeySuffix, tpm2KeyFile} {
+			if err = dirstore.Remove(name); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "failed to remove the device key %s", name)
+			}
+		}
+		log.Info("The device key has been removed, a new one is generated " +
+			"when the device is enrolled again.")
+	} else if config.EST.ServerURL != "" && config.Security.AuthPrivateKey == "" {
+		log.Info("The client certificate and key enrolled with the EST server " +
+			"have been removed, new ones are enrolled when the daemon starts.")
+	} else {
+		log.Warn("The device key is configured explicitly and has been left in " +
+			"place. Replace it for the device to enroll with a new identity.")
+	}
+
+	log.Infof("The device has been decommissioned. Cached inventory data, "+
+		"tenant tokens set at runtime and authorization state have been removed "+
+		"from %s, as well as the deployment logs and the downloaded artifacts.",
+		opts.dataStore)
+	return nil
+}
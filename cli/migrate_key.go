@@ -0,0 +1,244 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cli
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
+)
+
+const (
+	migrateKeyToTPM2   = "tpm2"
+	migrateKeyToPKCS11 = "pkcs11"
+
+	defaultMigrateKeyLabel  = "mender-device-key"
+	defaultMigrateKeyEngine = "pkcs11"
+	// Where the blob of a key generated in the TPM is kept, in the data
+	// directory.
+	tpm2KeyFile = "mender-agent.tpm2"
+)
+
+// migrateKeyOptionsType are the options of the migrate-key command.
+type migrateKeyOptionsType struct {
+	to       string
+	token    string
+	label    string
+	engine   string
+	generate bool
+}
+
+// doMigrateKey moves the device key from a file to a TPM 2.0 or a PKCS#11
+// token. A key in a file can be written to a PKCS#11 token as it is. A TPM
+// can't take in an existing key, so a new one is generated in it, as it is
+// in a token with --generate, and the new key is authorized with the server
+// before anything is changed. If the server doesn't accept it right away,
+// the command can be run again once it has, and picks up the key generated
+// the first time. The configuration file is then updated atomically to use
+// the new key, and the generated key file is removed.
+func doMigrateKey(config *conf.MenderConfig, opts *runOptionsType) error {
+	if (config.Security.KeyBackend != "" && config.Security.KeyBackend != store.KeyBackendFile) ||
+		strings.HasPrefix(config.Security.AuthPrivateKey, conf.Pkcs11URIPrefix) {
+		return errors.New("the device key is not kept in a file, there is nothing to migrate")
+	}
+	if config.AuthMode == conf.AuthModeMTLS {
+		return errors.New("with AuthMode \"mtls\" the device key is the TLS client " +
+			"key, which can't be migrated")
+	}
+
+	mopts := opts.migrateKeyOptions
+	if mopts.label == "" {
+		mopts.label = defaultMigrateKeyLabel
+	}
+	if mopts.engine == "" {
+		mopts.engine = defaultMigrateKeyEngine
+	}
+
+	dirstore := store.NewDirStore(opts.dataStore)
+	var fields map[string]interface{}
+	var reauthorize bool
+	switch mopts.to {
+	case migrateKeyToTPM2:
+		blob, err := generateTPM2Key(config, dirstore, path.Join(opts.dataStore, tpm2KeyFile))
+		if err != nil {
+			return err
+		}
+		fields = map[string]interface{}{
+			"KeyBackend":     store.KeyBackendTPM2,
+			"AuthPrivateKey": blob,
+		}
+		reauthorize = true
+
+	case migrateKeyToPKCS11:
+		if mopts.token == "" {
+			return errors.New("--token, the PKCS#11 URI of the token, is needed")
+		}
+		current, err := newKeystore(config, opts, dirstore)
+		if err != nil {
+			return err
+		}
+		uri := store.PKCS11KeyURI(mopts.token, mopts.label)
+		if mopts.generate {
+			existing := store.NewKeystore(dirstore, uri, mopts.engine, true, "")
+			if err = existing.Load(); err == nil {
+				log.Infof("Using the key generated earlier in the token: %s", uri)
+			} else if uri, err = current.GenerateInPKCS11(mopts.token, mopts.label); err != nil {
+				return err
+			}
+			reauthorize = true
+		} else {
+			if err = current.Load(); err != nil {
+				return errors.Wrap(err, "failed to load the device key")
+			}
+			if uri, err = current.ExportToPKCS11(mopts.token, mopts.label); err != nil {
+				return err
+			}
+		}
+		fields = map[string]interface{}{
+			"KeyBackend":     store.KeyBackendFile,
+			"AuthPrivateKey": uri,
+			"SSLEngine":      mopts.engine,
+		}
+		if !reauthorize {
+			if err = checkMigratedKey(current, uri, mopts.engine, dirstore); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errors.Errorf("--to must be %q or %q", migrateKeyToTPM2, migrateKeyToPKCS11)
+	}
+
+	migrated := *config
+	migrated.Security.KeyBackend = fields["KeyBackend"].(string)
+	migrated.Security.AuthPrivateKey = fields["AuthPrivateKey"].(string)
+	if engine, ok := fields["SSLEngine"].(string); ok {
+		migrated.Security.SSLEngine = engine
+	}
+	if reauthorize {
+		if err := authorizeMigratedKey(&migrated, opts); err != nil {
+			return errors.Wrap(err, "the new device key was not accepted by the server; "+
+				"once it is, run migrate-key again")
+		}
+	}
+
+	if err := conf.UpdateSecurityConfig(opts.config, fields); err != nil {
+		return errors.Wrap(err, "failed to update the configuration to use the new key")
+	}
+	log.Infof("The device key has been migrated, and %s has been updated to use it. "+
+		"Restart the Mender client for the daemon to use it.", opts.config)
+
+	if config.Security.AuthPrivateKey == "" && config.HttpsClient.Key == "" {
+		// The generated key, and any rotation of it, is now unused.
+		for _, name := range []string{conf.DefaultKeyFile,
+			conf.DefaultKeyFile + store.RotationKeySuffix} {
+			if err := dirstore.Remove(name); err != nil && !os.IsNotExist(err) {
+				log.Warnf("Failed to remove the old device key %s: %s", name, err)
+			}
+		}
+	} else {
+		log.Infof("The old device key is left in place, since it is configured explicitly.")
+	}
+	return nil
+}
+
+// generateTPM2Key has the TPM generate a key, unless one was generated by a
+// run which didn't finish, and stores its blob in the file.
+func generateTPM2Key(
+	config *conf.MenderConfig,
+	dirstore *store.DirStore,
+	file string,
+) (string, error) {
+	ks := store.NewKeystore(dirstore, file, "", false, "")
+	if err := ks.SetKeyBackend(store.KeyBackendTPM2); err != nil {
+		return "", err
+	}
+	if err := ks.SetKeyType(config.Security.KeyType); err != nil {
+		return "", err
+	}
+	if err := ks.SetKeyCurve(config.Security.KeyCurve); err != nil {
+		return "", err
+	}
+	if err := ks.SetKeyLength(config.Security.KeyLength); err != nil {
+		return "", err
+	}
+	if err := ks.SetFIPSMode(config.Security.FIPSMode); err != nil {
+		return "", err
+	}
+	err := ks.Load()
+	if err == nil {
+		log.Infof("Using the key generated earlier in the TPM: %s", file)
+		return file, nil
+	} else if !store.IsNoKeys(err) {
+		return "", err
+	}
+	if err = ks.Generate(); err != nil {
+		return "", err
+	}
+	if err = ks.Save(); err != nil {
+		return "", errors.Wrap(err, "failed to save the TPM key blob")
+	}
+	return file, nil
+}
+
+// checkMigratedKey makes sure that the key in the token is the key which was
+// written to it.
+func checkMigratedKey(
+	current *store.Keystore,
+	uri, engine string,
+	dirstore *store.DirStore,
+) error {
+	migrated := store.NewKeystore(dirstore, uri, engine, true, "")
+	if err := migrated.Load(); err != nil {
+		return errors.Wrap(err, "failed to load the key from the PKCS#11 token")
+	}
+	before, err := current.PublicPEM()
+	if err != nil {
+		return err
+	}
+	after, err := migrated.PublicPEM()
+	if err != nil {
+		return err
+	}
+	if before != after {
+		return errors.Errorf("the key %s in the PKCS#11 token is not the device key", uri)
+	}
+	return nil
+}
+
+// authorizeMigratedKey authorizes with the key of the migrated
+// configuration, which submits it to the server.
+func authorizeMigratedKey(config *conf.MenderConfig, opts *runOptionsType) error {
+	controller, mp, err := commonInit(config, opts, false)
+	if err != nil {
+		return err
+	}
+	defer mp.Store.Close()
+
+	authManager := mp.AuthManager
+	if merr := authManager.Bootstrap(); merr != nil {
+		return merr.Cause()
+	}
+	authManager.Start()
+	defer authManager.Stop()
+
+	_, _, err = controller.Authorize()
+	return err
+}
@@ -15,18 +15,50 @@ package client
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 const (
 	EmptyAuthToken = AuthToken("")
+	// Stands in for the token when the device is authenticated by its TLS
+	// client certificate alone. It is never sent to the server.
+	MTLSAuthToken = AuthToken("mtls")
 )
 
 type AuthToken string
 type ServerURL string
 
+// Lifetime returns when the token was issued, and when it expires, from the
+// "iat" and "exp" claims of the JWT. The signature isn't verified, the
+// server does that. ok is false if the token has no expiry. A zero issued
+// time means the token has no "iat" claim.
+func (t AuthToken) Lifetime() (issued, expires time.Time, ok bool) {
+	parts := strings.Split(string(t), ".")
+	if len(parts) != 3 {
+		return time.Time{}, time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	var claims struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	if claims.IssuedAt != 0 {
+		issued = time.Unix(claims.IssuedAt, 0)
+	}
+	return issued, time.Unix(claims.ExpiresAt, 0), true
+}
+
 // Structure representing authorization request data. The caller must fill each
 // field.
 type AuthReqData struct {
@@ -36,6 +68,26 @@ type AuthReqData struct {
 	TenantToken string `json:"tenant_token"`
 	// client's public key
 	Pubkey string `json:"pubkey"`
+	// one-time token, with which the server accepts the device right away
+	BootstrapToken string `json:"bootstrap_token,omitempty"`
+	// evidence of the boot state of the device, if attestation is enabled
+	Attestation *AttestationEvidence `json:"attestation,omitempty"`
+}
+
+// AttestationEvidence is a quote of PCRs of the TPM of the device, with the
+// measured boot event log, from which the server can tell how the device
+// booted. The binary fields are base64 encoded.
+type AttestationEvidence struct {
+	// TPMS_ATTEST structure, signed by the attestation key
+	Quote     string `json:"quote"`
+	Signature string `json:"signature"`
+	// quoted PCRs, e.g. "sha256:0,1,2,3,4,5,6,7"
+	PCRSelection string `json:"pcr_selection"`
+	// values of the quoted PCRs, as written by tpm2_quote
+	PCRs string `json:"pcrs"`
+	// qualifying data of the quote
+	Nonce    string `json:"nonce"`
+	EventLog string `json:"event_log,omitempty"`
 }
 
 // Produce a raw byte sequence with authorization data encoded in a format
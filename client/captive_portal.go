@@ -0,0 +1,77 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCaptivePortal is the cause of the errors returned when requests are
+// intercepted before they reach the internet, typically by the captive
+// portal of a guest network, which wants a login, or terms accepted, first.
+var ErrCaptivePortal = errors.New(
+	"the network intercepts requests, possibly a captive portal waiting for a login")
+
+// ProbeCaptivePortal requests the probe URL, which must answer with an empty
+// 204 No Content. Any other answer, after following redirects, means that
+// something on the way intercepts the request, and an error with the cause
+// ErrCaptivePortal is returned. A probe which fails without an answer isn't
+// a sign of a captive portal, only of no network.
+func ProbeCaptivePortal(api ApiRequester, probeURL string) error {
+	req, err := http.NewRequest(http.MethodGet, probeURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "invalid captive portal probe URL")
+	}
+	rsp, err := api.Do(req)
+	if err != nil {
+		if portalErr := CaptivePortalError(err); portalErr != nil {
+			return portalErr
+		}
+		log.Debugf("Captive portal probe of %s failed: %s", probeURL, err.Error())
+		return nil
+	}
+	defer rsp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 512))
+	if rsp.StatusCode == http.StatusNoContent && len(body) == 0 {
+		return nil
+	}
+	if rsp.StatusCode == http.StatusNoContent {
+		return errors.Wrapf(ErrCaptivePortal,
+			"the probe of %s was answered with a body", probeURL)
+	}
+	return errors.Wrapf(ErrCaptivePortal,
+		"the probe of %s was answered with %q instead of 204 No Content",
+		probeURL, rsp.Status)
+}
+
+// CaptivePortalError returns an error with the cause ErrCaptivePortal if the
+// error, from a request to the server, shows signs of interception: the
+// certificate presented isn't for the host name connected to. Otherwise it
+// returns nil.
+func CaptivePortalError(err error) error {
+	var hostnameErr x509.HostnameError
+	if errors.Is(err, openssl.ValidationError) || errors.As(err, &hostnameErr) {
+		return errors.Wrapf(ErrCaptivePortal,
+			"the certificate presented is for another host (%s)", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestProbeCaptivePortal(t *testing.T) {
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/generate_204":
+				w.WriteHeader(http.StatusNoContent)
+			case "/redirect":
+				http.Redirect(w, r, "/login", http.StatusFound)
+			case "/login":
+				w.Write([]byte("<html>Please accept the terms</html>"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer ts.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+
+	assert.NoError(t, ProbeCaptivePortal(cl, ts.URL+"/generate_204"))
+
+	for _, path := range []string{"/redirect", "/login", "/elsewhere"} {
+		err = ProbeCaptivePortal(cl, ts.URL+path)
+		assert.Equal(t, ErrCaptivePortal, errors.Cause(err), path)
+	}
+
+	// No answer at all is no network, not a captive portal.
+	assert.NoError(t, ProbeCaptivePortal(cl, "http://127.0.0.1:1/generate_204"))
+
+	err = ProbeCaptivePortal(cl, "://")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrCaptivePortal, errors.Cause(err))
+}
+
+func TestCaptivePortalError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://mender.example.com", Err: err}
+	}
+
+	err := CaptivePortalError(urlErr(errors.Wrap(openssl.ValidationError, "dial")))
+	assert.Equal(t, ErrCaptivePortal, errors.Cause(err))
+
+	err = CaptivePortalError(urlErr(x509.HostnameError{
+		Certificate: &x509.Certificate{},
+		Host:        "mender.example.com",
+	}))
+	assert.Equal(t, ErrCaptivePortal, errors.Cause(err))
+
+	assert.NoError(t, CaptivePortalError(urlErr(errors.New("connection refused"))))
+}
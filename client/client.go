@@ -16,16 +16,19 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"io/ioutil"
@@ -33,8 +36,10 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
 
 	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/utils"
 
 	"github.com/mendersoftware/openssl"
 )
@@ -72,6 +77,9 @@ var (
 
 	// connection keepalive options
 	connectionKeepaliveTime = 10 * time.Second
+	// Authorization tokens are renewed when they expire within this time,
+	// or a tenth of their lifetime, whichever is shorter.
+	jwtRenewalMargin = 5 * time.Minute
 
 	ErrClientUnauthorized = errors.New("Client is unauthorized")
 )
@@ -141,6 +149,16 @@ func (a *APIError) Unwrap() error {
 
 type ApiClient struct {
 	http.Client
+	// Used for the per request kind timeouts, may be nil.
+	connectivity *conf.Connectivity
+	// Used for the request kinds sent through an MQTT broker, may be nil.
+	mqtt *mqttTransport
+	// Signs every request, may be nil.
+	signer *requestSigner
+	// Signs the bodies of inventory and status requests, may be nil.
+	payloadSigner PayloadSigner
+	// Limits the rate of all requests but artifact downloads, may be nil.
+	limiter *apiRateLimiter
 }
 
 type ReauthorizingClient struct {
@@ -196,10 +214,12 @@ func (c *ReauthorizingClient) reconstructRequest(req *http.Request) (*http.Reque
 	//
 	// see: https://github.com/golang/go/issues/19653
 	// Error message: http: ContentLength=52 with Body length 0
-	newReq, _ := http.NewRequest(req.Method, newURL.String(), body)
+	newReq, _ := http.NewRequestWithContext(req.Context(), req.Method, newURL.String(), body)
 	newReq.Header = req.Header
 	newReq.GetBody = req.GetBody
-	newReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.auth))
+	if c.auth != MTLSAuthToken {
+		newReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.auth))
+	}
 
 	return newReq, nil
 }
@@ -209,7 +229,7 @@ func (c *ReauthorizingClient) reconstructRequest(req *http.Request) (*http.Reque
 // reauthorization, as well as attempting failover servers (if given) whenever
 // the server "refuse" to serve the request.
 func (c *ReauthorizingClient) Do(req *http.Request) (*http.Response, error) {
-	fetchedNewToken := false
+	fetchedNewToken := c.renewExpiringToken()
 	for {
 		var r *http.Response
 		newReq, err := c.reconstructRequest(req)
@@ -229,6 +249,7 @@ func (c *ReauthorizingClient) Do(req *http.Request) (*http.Response, error) {
 			jwt, serverURL, e := c.revoke()
 			if e == nil {
 				// retry API request with new JWT token
+				recordRetry(metricsRetryReauthorization)
 				c.auth = jwt
 				c.serverURL = serverURL
 				log.Info("Reauthorization successful")
@@ -243,6 +264,39 @@ func (c *ReauthorizingClient) Do(req *http.Request) (*http.Response, error) {
 	}
 }
 
+// renewExpiringToken fetches a new token if the current one is about to
+// expire, rather than waiting for the server to reject it, which costs an
+// extra request in the middle of a deployment. If that fails, the current
+// token is used until it does expire. Returns true if a new token was
+// fetched.
+func (c *ReauthorizingClient) renewExpiringToken() bool {
+	if c.auth == "" {
+		return false
+	}
+	issued, expires, ok := c.auth.Lifetime()
+	if !ok {
+		return false
+	}
+	margin := jwtRenewalMargin
+	if !issued.IsZero() && expires.Sub(issued)/10 < margin {
+		margin = expires.Sub(issued) / 10
+	}
+	if time.Until(expires) > margin {
+		return false
+	}
+
+	log.Info("Authorization token about to expire; attempting reauthorization")
+	jwt, serverURL, err := c.revoke()
+	if err != nil {
+		log.Warnf("Failed to renew the authorization token: %s", err.Error())
+		return false
+	}
+	c.auth = jwt
+	c.serverURL = serverURL
+	log.Info("Reauthorization successful")
+	return true
+}
+
 func (c *ReauthorizingClient) ClearAuthorization() {
 	c.auth = ""
 	c.serverURL = ""
@@ -263,6 +317,14 @@ func NewReauthorizingClient(
 }
 
 func NewApiClient(config conf.HttpConfig) (*ApiClient, error) {
+	if config.Connectivity != nil {
+		if err := validateIPPreference(config.Connectivity.IPPreference); err != nil {
+			return nil, err
+		}
+		if err := validateStaticHeaders(config.Connectivity.HTTPHeaders); err != nil {
+			return nil, err
+		}
+	}
 
 	var client *http.Client
 	if config == (conf.HttpConfig{}) {
@@ -277,19 +339,46 @@ func NewApiClient(config conf.HttpConfig) (*ApiClient, error) {
 
 	if client.Transport == nil {
 		client.Transport = &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+			Proxy: newProxyFunc(&config, false),
+			// Negotiate HTTP/2 through ALPN, falling back to
+			// HTTP/1.1 if the server doesn't support it.
+			ForceAttemptHTTP2: true,
 		}
 	}
 	// set connection timeout
 	client.Timeout = defaultClientReadingTimeout
+	if config.Connectivity != nil {
+		// Don't let the overall timeout cut a download short which has
+		// been given a longer timeout of its own.
+		downloadTimeout := time.Duration(config.Connectivity.DownloadTimeoutSeconds) *
+			time.Second
+		if downloadTimeout > client.Timeout {
+			client.Timeout = downloadTimeout
+		}
+	}
 
-	transport := client.Transport.(*http.Transport)
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		transport = client.Transport.(*openSSLTransport).http1
+	}
 	//set keepalive options
-	transport.DialContext = (&net.Dialer{
-		KeepAlive: connectionKeepaliveTime,
-	}).DialContext
+	dialer := newNetDialer(config.Connectivity)
+	transport.DialContext = dialer.DialContext
 
-	return &ApiClient{*client}, nil
+	signer, err := newRequestSigner(config.Security)
+	if err != nil {
+		return nil, err
+	}
+	apiClient := &ApiClient{
+		Client:       *client,
+		connectivity: config.Connectivity,
+		signer:       signer,
+		limiter:      getAPIRateLimiter(config.Connectivity),
+	}
+	if config.MQTT != nil {
+		apiClient.mqtt = getMQTTTransport(config)
+	}
+	return apiClient, nil
 }
 
 func newHttpClient() *http.Client {
@@ -374,8 +463,8 @@ func loadServerTrust(ctx *openssl.Ctx, conf *conf.HttpConfig) (*openssl.Ctx, err
 		)
 	}
 	if conf.ServerCert != "" {
-		// Load the server certificate into the OpenSSL context
-		err = ctx.LoadVerifyLocations(conf.ServerCert, "")
+		// Load the server certificate(s) into the OpenSSL context
+		err = loadVerifyLocations(ctx, conf.ServerCert)
 		if err != nil {
 			if strings.Contains(strings.ToLower(err.Error()), "no such file") {
 				log.Warnf(errMissingServerCertF, conf.ServerCert)
@@ -394,6 +483,42 @@ func loadServerTrust(ctx *openssl.Ctx, conf *conf.HttpConfig) (*openssl.Ctx, err
 	return ctx, err
 }
 
+// loadVerifyLocations adds the certificates in certPath to the trusted
+// certificates of the context. certPath is either a PEM file, which may hold
+// several certificates, or a directory of such files. The latter makes it
+// possible to trust both the old and the new CA while migrating between them.
+func loadVerifyLocations(ctx *openssl.Ctx, certPath string) error {
+	info, err := os.Stat(certPath)
+	if err != nil || !info.IsDir() {
+		return ctx.LoadVerifyLocations(certPath, "")
+	}
+
+	files, err := ioutil.ReadDir(certPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the server certificate directory %s", certPath)
+	}
+	loaded := 0
+	for _, certFile := range files {
+		filePath := path.Join(certPath, certFile.Name())
+		// Need to re-stat here because ReadDir does not resolve
+		// symlinks.
+		if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+			log.Debugf("Not a regular file, skipping: %s", filePath)
+			continue
+		}
+		if err := ctx.LoadVerifyLocations(filePath, ""); err != nil {
+			log.Warnf("Failed to load the server certificate %s: %s", filePath, err.Error())
+			continue
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return errors.Errorf("no server certificates found in %s", certPath)
+	}
+	log.Debugf("Loaded %d server certificate file(s) from %s", loaded, certPath)
+	return nil
+}
+
 func loadPrivateKey(keyFile string, engineId string) (key openssl.PrivateKey, err error) {
 	if strings.HasPrefix(keyFile, conf.Pkcs11URIPrefix) {
 		engine, err := openssl.EngineById(engineId)
@@ -469,6 +594,11 @@ func loadClientTrust(ctx *openssl.Ctx, conf *conf.HttpConfig) (*openssl.Ctx, err
 	if err != nil {
 		return ctx, err
 	}
+	if fipsMode(conf.Security) {
+		if err = checkFIPSPublicKey(key); err != nil {
+			return ctx, errors.Wrap(err, "client certificate")
+		}
+	}
 
 	err = ctx.UsePrivateKey(key)
 	if err != nil {
@@ -483,42 +613,97 @@ func establishSSLConnection(
 	proxyURL *url.URL,
 	ctx *openssl.Ctx,
 	flags openssl.DialFlags,
+	dialer *netDialer,
+	nextProtos []string,
 ) (*openssl.Conn, error) {
 	if proxyURL != nil {
-		proxyConn, err := dialProxy("tcp", addr, proxyURL)
+		var proxyConn net.Conn
+		var err error
+		if isSocksProxy(proxyURL) {
+			proxyConn, err = dialSocksProxy("tcp", addr, proxyURL, dialer)
+		} else {
+			proxyConn, err = dialProxy("tcp", addr, proxyURL, dialer)
+		}
 		if err != nil {
 			return nil, errors.Wrap(err, "Failed to connect to proxy")
 		}
-		return openssl.DialUpgrade(addr, proxyConn, ctx, flags)
+		return openssl.DialUpgradeNextProtos(addr, proxyConn, ctx, flags, nextProtos)
+	}
+	tcpConn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
 	}
-	return openssl.Dial("tcp", addr, ctx, flags)
+	return openssl.DialUpgradeNextProtos(addr, tcpConn, ctx, flags, nextProtos)
 }
 
+// dialOpenSSL connects to addr through OpenSSL, and verifies the server. If
+// nextProtos is given, the protocols are offered to the server with ALPN.
 func dialOpenSSL(
 	ctx *openssl.Ctx,
 	conf *conf.HttpConfig,
 	_, addr string,
+	nextProtos []string,
 ) (net.Conn, error) {
-	proxyURL, err := ProxyURLFromHostPortGetter(addr)
+	proxyURL, err := proxyURLForAddr(conf, addr)
 	if err != nil {
 		return nil, errors.Wrapf(err,
 			"Failed to get http-proxy configurations during dial")
 	}
 	flags := openssl.DialFlags(0)
 
-	if conf.NoVerify {
+	psk := pskConfigured(conf.Security)
+	if conf.NoVerify || psk {
+		// Verified below if the server doesn't use the pre-shared key.
 		flags = openssl.InsecureSkipHostVerification
 	}
 
-	conn, err := establishSSLConnection(addr, proxyURL, ctx, flags)
+	conn, err := establishSSLConnection(addr, proxyURL, ctx, flags,
+		newNetDialer(conf.Connectivity), nextProtos)
 	if err != nil {
 		return nil, err
 	}
 
+	if psk {
+		if _, err = conn.PeerCertificate(); err != nil {
+			// No certificate, the server authenticated with the key.
+			return conn, nil
+		}
+		if !conf.NoVerify {
+			host, _, _ := net.SplitHostPort(addr)
+			if err = conn.VerifyHostname(host); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if fipsMode(conf.Security) {
+		if err = checkFIPSConnection(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if conf.Security != nil && len(conf.Security.ServerPublicKeyPins) > 0 {
+		if err = verifyPublicKeyPins(conn, conf.Security.ServerPublicKeyPins); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	if conf.NoVerify {
 		return conn, nil
 	}
 	v := conn.VerifyResult()
+	if v == openssl.CertHasExpired || v == openssl.CertNotYetValid {
+		err = checkCertificateTime(conn, conf, addr)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "server certificate rejected "+
+				"(openssl verify rc: %d server cert file: %s)", v, conf.ServerCert)
+		}
+		v = openssl.Ok
+	}
 	if v != openssl.Ok {
 		if v == openssl.CertHasExpired {
 			return nil, errors.Errorf("certificate has expired, "+
@@ -539,13 +724,124 @@ func dialOpenSSL(
 		return nil, errors.Errorf("not a valid certificate, "+
 			"openssl verify rc: %d server cert file: %s", v, conf.ServerCert)
 	}
+
+	if conf.Security != nil &&
+		conf.Security.OCSPMode != "" && conf.Security.OCSPMode != OCSPModeOff {
+		if err = checkOCSP(conn, conf); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if conf.Security != nil && conf.Security.ServerCRL != "" {
+		if err = checkCRL(conn, conf); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 	return conn, err
 }
 
-func newOpenSSLCtx(conf conf.HttpConfig) (*openssl.Ctx, error) {
-	ctx, err := openssl.NewCtx()
+// publicKeyPin returns the base64 encoded SHA-256 hash of the
+// SubjectPublicKeyInfo of the certificate.
+func publicKeyPin(cert *openssl.Certificate) (string, error) {
+	key, err := cert.PublicKey()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	der, err := key.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// verifyPublicKeyPins checks that the public key of at least one of the
+// certificates presented by the server matches one of the pins.
+func verifyPublicKeyPins(conn *openssl.Conn, pins []string) error {
+	chain, err := conn.PeerCertificateChain()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the server certificate chain")
+	}
+	for _, cert := range chain {
+		pin, err := publicKeyPin(cert)
+		if err != nil {
+			log.Debugf("Failed to compute the public key pin of a server certificate: %s",
+				err.Error())
+			continue
+		}
+		for _, expected := range pins {
+			if pin == strings.TrimSpace(expected) {
+				return nil
+			}
+		}
+	}
+	return errors.New("the server certificate chain does not match any of the " +
+		"configured public key pins")
+}
+
+// OpenSSL option values not exported by the bindings. These are the same
+// in OpenSSL 1.1 and 3.x.
+const (
+	openSSLNoTLSv1_1 openssl.Options = 0x10000000
+	openSSLNoTLSv1_2 openssl.Options = 0x08000000
+)
+
+// applyTLSSettings restricts the protocol versions and cipher suites the
+// context will negotiate. Unlike the trust settings, a bad value here is an
+// error, since silently falling back to the defaults would weaken the
+// connection.
+func applyTLSSettings(ctx *openssl.Ctx, security *conf.Security) error {
+	minVersion, cipherSuites := security.MinTLSVersion, security.TLSCipherSuites
+	if security.FIPSMode {
+		switch minVersion {
+		case "":
+			minVersion = "1.2"
+		case "1.0", "1.1":
+			return errors.Wrapf(utils.ErrNotFIPSApproved, "TLS %s", minVersion)
+		}
+		if len(cipherSuites) == 0 {
+			cipherSuites = conf.FIPSCipherSuites
+		}
+		for _, suite := range cipherSuites {
+			if !conf.IsFIPSCipherSuite(suite) {
+				return errors.Wrapf(utils.ErrNotFIPSApproved,
+					"TLS cipher suite %s", suite)
+			}
+		}
+	}
+
+	switch minVersion {
+	case "", "1.0":
+	case "1.1":
+		ctx.SetOptions(openssl.NoTLSv1)
+	case "1.2":
+		ctx.SetOptions(openssl.NoTLSv1 | openSSLNoTLSv1_1)
+	case "1.3":
+		ctx.SetOptions(openssl.NoTLSv1 | openSSLNoTLSv1_1 | openSSLNoTLSv1_2)
+	default:
+		return errors.Errorf("unsupported MinTLSVersion %q, "+
+			"must be one of \"1.0\", \"1.1\", \"1.2\" or \"1.3\"",
+			minVersion)
+	}
+
+	if len(cipherSuites) > 0 {
+		err := ctx.SetCipherList(strings.Join(cipherSuites, ":"))
+		if err != nil {
+			return errors.Wrap(err, "failed to set the TLS cipher suites")
+		}
+	}
+	return nil
+}
+
+// newOpenSSLCtx sets up a new OpenSSL context from the configuration. A
+// failure to load the client certificate doesn't fail the context, but is
+// returned separately as clientErr so that callers can decide what to do
+// with it.
+func newOpenSSLCtx(conf conf.HttpConfig) (ctx *openssl.Ctx, clientErr error, err error) {
+	ctx, err = openssl.NewCtx()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	ctx, err = loadServerTrust(ctx, &conf)
@@ -554,9 +850,22 @@ func newOpenSSLCtx(conf conf.HttpConfig) (*openssl.Ctx, error) {
 	}
 
 	if conf.HttpsClient != nil {
-		ctx, err = loadClientTrust(ctx, &conf)
-		if err != nil {
-			log.Warn(errors.Wrap(err, "Failed to load the client TLS certificate settings"))
+		ctx, clientErr = loadClientTrust(ctx, &conf)
+		if errors.Cause(clientErr) == utils.ErrNotFIPSApproved {
+			// Unlike a missing certificate, a key which is not allowed is fatal.
+			return nil, nil, clientErr
+		}
+	}
+
+	if conf.Security != nil {
+		if err = applyTLSSettings(ctx, conf.Security); err != nil {
+			return nil, nil, err
+		}
+		if err = validateOCSPMode(conf.Security.OCSPMode); err != nil {
+			return nil, nil, err
+		}
+		if err = applyPSK(ctx, conf.Security); err != nil {
+			return nil, nil, err
 		}
 	}
 
@@ -564,31 +873,37 @@ func newOpenSSLCtx(conf conf.HttpConfig) (*openssl.Ctx, error) {
 		log.Warn("certificate verification skipped..")
 	}
 
-	return ctx, nil
+	return ctx, clientErr, nil
 }
 
 func newHttpsClient(conf conf.HttpConfig) (*http.Client, error) {
-	ctx, err := newOpenSSLCtx(conf)
+	ctx, err := newReloadingOpenSSLCtx(conf)
 	if err != nil {
 		return nil, err
 	}
 
 	disableKeepAlive := false
 	idleConnTimeoutSeconds := 0
+	maxIdleConns, maxIdleConnsPerHost := 0, 0
 	if conf.Connectivity != nil {
 		disableKeepAlive = conf.Connectivity.DisableKeepAlive
 		idleConnTimeoutSeconds = conf.Connectivity.IdleConnTimeoutSeconds
+		maxIdleConns = conf.Connectivity.MaxIdleConns
+		maxIdleConnsPerHost = conf.Connectivity.MaxIdleConnsPerHost
 	}
 	transport := http.Transport{
-		DisableKeepAlives: disableKeepAlive,
-		IdleConnTimeout:   time.Duration(idleConnTimeoutSeconds) * time.Second,
-		DialTLS: func(network string, addr string) (net.Conn, error) {
-			return dialOpenSSL(ctx, &conf, network, addr)
-		},
+		Proxy:               newProxyFunc(&conf, true),
+		DisableKeepAlives:   disableKeepAlive,
+		IdleConnTimeout:     time.Duration(idleConnTimeoutSeconds) * time.Second,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
 	}
 
 	client := newHttpClient()
-	client.Transport = &transport
+	client.Transport = newOpenSSLTransport(&transport,
+		func(_ context.Context, network, addr string, nextProtos []string) (net.Conn, error) {
+			return dialOpenSSL(ctx.get(), &conf, network, addr, nextProtos)
+		})
 	return client, nil
 }
 
@@ -604,17 +919,62 @@ func buildApiURL(server, url string) string {
 }
 
 // Normally one minute, but used in tests to lower the interval to avoid
-// waiting.
+// waiting. Can be changed with the RetryPollBaseIntervalSeconds setting.
 var ExponentialBackoffSmallestUnit time.Duration = time.Minute
 
+// Maximum random deviation of each backoff interval, in percent of the
+// interval. Spreads out the retries of devices which failed at the same time,
+// for instance after a fleet-wide power outage. Zero disables jitter.
+var ExponentialBackoffJitterPercent int = 0
+
+// Seeded explicitly, so that devices don't all draw the same jitter.
+var backoffJitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
 var MaxRetriesExceededError = errors.New("Tried maximum amount of times")
 
+// ConfigureExponentialBackoff sets the base interval and jitter used by
+// GetExponentialBackoffTime. A zero baseInterval keeps the current base
+// interval.
+func ConfigureExponentialBackoff(baseInterval time.Duration, jitterPercent int) {
+	if baseInterval > 0 {
+		ExponentialBackoffSmallestUnit = baseInterval
+	}
+	if jitterPercent < 0 || jitterPercent > 100 {
+		log.Warnf("Invalid backoff jitter of %d%%, must be between 0 and 100. "+
+			"Disabling jitter", jitterPercent)
+		jitterPercent = 0
+	}
+	ExponentialBackoffJitterPercent = jitterPercent
+}
+
 // Simple algorithm: Start with one minute, and try three times, then double
 // interval (maxInterval is maximum) and try again. Repeat until we tried
-// three times with maxInterval.
+// three times with maxInterval. If jitter is configured, the returned interval
+// is randomly adjusted up or down by at most the given percentage.
 func GetExponentialBackoffTime(tried int,
 	maxInterval time.Duration,
 	maxAttempts int) (time.Duration, error) {
+
+	interval, err := getExponentialBackoffTime(tried, maxInterval, maxAttempts)
+	if err != nil || ExponentialBackoffJitterPercent <= 0 {
+		return interval, err
+	}
+	maxJitter := int64(interval) * int64(ExponentialBackoffJitterPercent) / 100
+	if maxJitter <= 0 {
+		return interval, nil
+	}
+	backoffJitterRand.Lock()
+	jitter := backoffJitterRand.Int63n(2*maxJitter+1) - maxJitter
+	backoffJitterRand.Unlock()
+	return interval + time.Duration(jitter), nil
+}
+
+func getExponentialBackoffTime(tried int,
+	maxInterval time.Duration,
+	maxAttempts int) (time.Duration, error) {
 	const perIntervalAttempts = 3
 
 	interval := 1 * ExponentialBackoffSmallestUnit
@@ -663,14 +1023,14 @@ func unmarshalErrorMessage(r io.Reader) string {
 }
 
 func newWebsocketDialerTLS(conf conf.HttpConfig) (*websocket.Dialer, error) {
-	ctx, err := newOpenSSLCtx(conf)
+	ctx, err := newReloadingOpenSSLCtx(conf)
 	if err != nil {
 		return nil, err
 	}
 
 	dialer := websocket.Dialer{
 		NetDialTLSContext: func(_ context.Context, network string, addr string) (net.Conn, error) {
-			return dialOpenSSL(ctx, &conf, network, addr)
+			return dialOpenSSL(ctx.get(), &conf, network, addr, nil)
 		},
 	}
 
@@ -687,6 +1047,93 @@ var ProxyURLFromHostPortGetter = func(addr string) (*url.URL, error) {
 	return http.ProxyFromEnvironment(&http.Request{URL: u})
 }
 
+// newProxyFunc returns the proxy selection function for the HTTP transport. An
+// explicitly configured proxy takes precedence over the environment. If
+// tunnelsTLS is set, HTTPS requests are tunneled through the proxy by
+// dialOpenSSL instead, so the transport must not proxy those itself.
+func newProxyFunc(
+	config *conf.HttpConfig,
+	tunnelsTLS bool,
+) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if tunnelsTLS && req.URL.Scheme == "https" {
+			return nil, nil
+		}
+		if config.Connectivity == nil || config.Connectivity.Proxy == "" {
+			return http.ProxyFromEnvironment(req)
+		}
+		return proxyURLForAddr(config, getHostPort(req.URL))
+	}
+}
+
+// proxyURLForAddr returns the proxy to use when connecting to addr, or nil if
+// the connection should be made directly.
+func proxyURLForAddr(config *conf.HttpConfig, addr string) (*url.URL, error) {
+	if config.Connectivity == nil || config.Connectivity.Proxy == "" {
+		return ProxyURLFromHostPortGetter(addr)
+	}
+	if excludedFromProxy(addr) {
+		return nil, nil
+	}
+	return parseProxyURL(config.Connectivity.Proxy)
+}
+
+// parseProxyURL parses a proxy URL the same way the standard library parses
+// the HTTP_PROXY variable: a missing scheme defaults to "http". Besides HTTP
+// proxies, SOCKS5 proxies are supported through the "socks5" and "socks5h"
+// schemes.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		if u, err := url.Parse("http://" + proxy); err == nil {
+			proxyURL = u
+		}
+	}
+	if proxyURL == nil {
+		return nil, errors.Wrapf(err, "invalid proxy URL %q", proxy)
+	}
+	if proxyURL.Scheme != "http" && !isSocksProxy(proxyURL) {
+		return nil, errors.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	return proxyURL, nil
+}
+
+func isSocksProxy(proxyURL *url.URL) bool {
+	return proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h"
+}
+
+// excludedFromProxy reports whether addr matches an entry of the NO_PROXY
+// environment variable. Entries are either "*", a host name, which also
+// matches its subdomains, or an IP address. Ports in entries are ignored.
+func excludedFromProxy(addr string) bool {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
 func getHostPort(u *url.URL) (hostPort string) {
 	hostPort = u.Host
 	if i := strings.LastIndex(u.Host, ":"); i > strings.LastIndex(u.Host, "]") {
@@ -702,13 +1149,18 @@ func getHostPort(u *url.URL) (hostPort string) {
 	return hostPort
 }
 
-func dialProxy(network string, addr string, proxyURL *url.URL) (net.Conn, error) {
+func dialProxy(
+	network string,
+	addr string,
+	proxyURL *url.URL,
+	dialer *netDialer,
+) (net.Conn, error) {
 	var (
 		resp *http.Response
 		err  error
 	)
 	hostPort := getHostPort(proxyURL)
-	conn, err := net.Dial(network, hostPort)
+	conn, err := dialer.Dial(network, hostPort)
 	if err != nil {
 		return nil, err
 	}
@@ -762,6 +1214,21 @@ func dialProxy(network string, addr string, proxyURL *url.URL) (net.Conn, error)
 	return conn, nil
 }
 
+// dialSocksProxy connects to addr through the SOCKS5 proxy given by proxyURL,
+// authenticating with the username and password from the URL if present.
+func dialSocksProxy(
+	network string,
+	addr string,
+	proxyURL *url.URL,
+	dialer *netDialer,
+) (net.Conn, error) {
+	socksDialer, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return socksDialer.Dial(network, addr)
+}
+
 func NewWebsocketDialer(config conf.HttpConfig) (*websocket.Dialer, error) {
 	var dialer *websocket.Dialer
 	var err error
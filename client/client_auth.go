@@ -141,5 +141,5 @@ func makeAuthRequest(server string, dataSrc AuthDataMessenger) (*http.Request, e
 	hreq.Header.Add("Content-Type", "application/json")
 	hreq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", req.Token))
 	hreq.Header.Add("X-MEN-Signature", base64.StdEncoding.EncodeToString(req.Signature))
-	return hreq, nil
+	return withRequestKind(hreq, requestKindAuth), nil
 }
@@ -0,0 +1,114 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+
+	"github.com/mendersoftware/openssl"
+)
+
+// reloadingOpenSSLCtx hands out the OpenSSL context used for new
+// connections, and sets up a fresh one when the client certificate or key
+// file has been replaced on disk, so that a renewed device certificate is
+// presented without restarting the client. Connections that are already
+// established keep using the context they were made with.
+type reloadingOpenSSLCtx struct {
+	conf conf.HttpConfig
+
+	lock        sync.Mutex
+	ctx         *openssl.Ctx
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// How often the client certificate files are checked for changes.
+var clientCertCheckInterval = 10 * time.Second
+
+func newReloadingOpenSSLCtx(conf conf.HttpConfig) (*reloadingOpenSSLCtx, error) {
+	r := &reloadingOpenSSLCtx{conf: conf}
+	r.certModTime, r.keyModTime = r.clientCertModTimes()
+
+	ctx, clientErr, err := newOpenSSLCtx(conf)
+	if err != nil {
+		return nil, err
+	}
+	if clientErr != nil {
+		log.Warn(errors.Wrap(clientErr, "Failed to load the client TLS certificate settings"))
+	}
+	r.ctx = ctx
+	r.lastCheck = time.Now()
+	return r, nil
+}
+
+// clientCertModTimes returns the modification times of the client
+// certificate and key files. Keys which live in a hardware module have no
+// file, and give a zero time.
+func (r *reloadingOpenSSLCtx) clientCertModTimes() (cert, key time.Time) {
+	if r.conf.HttpsClient == nil {
+		return
+	}
+	if info, err := os.Stat(r.conf.HttpsClient.Certificate); err == nil {
+		cert = info.ModTime()
+	}
+	if !strings.HasPrefix(r.conf.HttpsClient.Key, conf.Pkcs11URIPrefix) {
+		if info, err := os.Stat(r.conf.HttpsClient.Key); err == nil {
+			key = info.ModTime()
+		}
+	}
+	return
+}
+
+// get returns the context to use for a new connection.
+func (r *reloadingOpenSSLCtx) get() *openssl.Ctx {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.conf.HttpsClient == nil || time.Since(r.lastCheck) < clientCertCheckInterval {
+		return r.ctx
+	}
+	r.lastCheck = time.Now()
+
+	certModTime, keyModTime := r.clientCertModTimes()
+	if certModTime.Equal(r.certModTime) && keyModTime.Equal(r.keyModTime) {
+		return r.ctx
+	}
+
+	ctx, clientErr, err := newOpenSSLCtx(r.conf)
+	if err == nil {
+		err = clientErr
+	}
+	if err != nil {
+		// The files may be in the middle of being replaced. Keep the
+		// old context, and try again at the next check.
+		log.Warnf("Failed to reload the renewed client TLS certificate, "+
+			"retrying later: %s", err.Error())
+		return r.ctx
+	}
+
+	log.Info("The client TLS certificate has changed on disk, and has been reloaded.")
+	r.ctx = ctx
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	return r.ctx
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func generateClientCert(t *testing.T, commonName string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestClientCertificateReload(t *testing.T) {
+	prevInterval := clientCertCheckInterval
+	clientCertCheckInterval = 0
+	defer func() {
+		clientCertCheckInterval = prevInterval
+	}()
+
+	var presented string
+	serverCert, err := tls.X509KeyPair(localhostCert, localhostKey)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			presented = ""
+			if len(r.TLS.PeerCertificates) > 0 {
+				presented = r.TLS.PeerCertificates[0].Subject.CommonName
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		NextProtos:   []string{"http/1.1"},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tdir, err := ioutil.TempDir("", "TestClientCertificateReload")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	certFile := path.Join(tdir, "client.crt")
+	keyFile := path.Join(tdir, "client.key")
+
+	certPEM, keyPEM := generateClientCert(t, "first")
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	cl, err := NewApiClient(conf.HttpConfig{
+		ServerCert: "testdata/server.crt",
+		HttpsClient: &conf.HttpsClient{
+			Certificate: certFile,
+			Key:         keyFile,
+		},
+		Connectivity: &conf.Connectivity{DisableKeepAlive: true},
+	})
+	require.NoError(t, err)
+
+	doRequest := func() {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(req)
+		require.NoError(t, err)
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+
+	doRequest()
+	assert.Equal(t, "first", presented)
+
+	// A half written certificate keeps the old one in use.
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	doRequest()
+	assert.Equal(t, "first", presented)
+
+	// Renewed certificate and key.
+	certPEM, keyPEM = generateClientCert(t, "second")
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	modTime = modTime.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	doRequest()
+	assert.Equal(t, "second", presented)
+}
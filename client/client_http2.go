@@ -0,0 +1,116 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+// The protocols offered with ALPN on connections which may use HTTP/2.
+var http2NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+
+var errHTTP2NotSelected = errors.New("the server did not select HTTP/2")
+
+// openSSLTransport sends HTTPS requests over connections made through
+// OpenSSL. HTTP/2 is offered with ALPN, and servers selecting it get all
+// requests multiplexed over one connection. Servers which don't are
+// remembered, and get their requests over HTTP/1.1 connections.
+// net/http can't do this by itself, since it only speaks HTTP/2 over
+// connections from crypto/tls.
+type openSSLTransport struct {
+	http1 *http.Transport
+	http2 *http2.Transport
+
+	lock sync.Mutex
+	// Addresses of the servers which didn't select HTTP/2.
+	http1Only map[string]bool
+}
+
+func newOpenSSLTransport(
+	http1 *http.Transport,
+	dial func(ctx context.Context, network, addr string, nextProtos []string) (net.Conn, error),
+) *openSSLTransport {
+	t := &openSSLTransport{
+		http1:     http1,
+		http1Only: map[string]bool{},
+	}
+	t.http1.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr, nil)
+	}
+	t.http2 = &http2.Transport{
+		DialTLSContext: func(
+			ctx context.Context,
+			network, addr string,
+			_ *tls.Config,
+		) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr, http2NextProtos)
+			if err != nil {
+				return nil, err
+			}
+			if negotiatedProtocol(conn) == http2.NextProtoTLS {
+				return conn, nil
+			}
+			// The connection can't be handed over to the HTTP/1.1
+			// transport, which makes its own. That only costs one
+			// extra handshake per server.
+			conn.Close()
+			t.lock.Lock()
+			t.http1Only[addr] = true
+			t.lock.Unlock()
+			log.Debugf("%s doesn't support HTTP/2, using HTTP/1.1", addr)
+			return nil, errHTTP2NotSelected
+		},
+	}
+	return t
+}
+
+func negotiatedProtocol(conn net.Conn) string {
+	if c, ok := conn.(interface{ NegotiatedProtocol() string }); ok {
+		return c.NegotiatedProtocol()
+	}
+	return ""
+}
+
+func (t *openSSLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || t.http1.DisableKeepAlives {
+		return t.http1.RoundTrip(req)
+	}
+	addr := getHostPort(req.URL)
+	t.lock.Lock()
+	http1Only := t.http1Only[addr]
+	t.lock.Unlock()
+	if http1Only {
+		return t.http1.RoundTrip(req)
+	}
+
+	rsp, err := t.http2.RoundTrip(req)
+	if errors.Is(err, errHTTP2NotSelected) {
+		// Nothing has been sent yet.
+		return t.http1.RoundTrip(req)
+	}
+	return rsp, err
+}
+
+func (t *openSSLTransport) CloseIdleConnections() {
+	t.http1.CloseIdleConnections()
+	t.http2.CloseIdleConnections()
+}
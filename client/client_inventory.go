@@ -15,11 +15,19 @@ package client
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/store"
 )
 
 type InventorySubmitter interface {
@@ -27,6 +35,30 @@ type InventorySubmitter interface {
 }
 
 type InventoryClient struct {
+	// Request bodies of at least this many bytes are gzip compressed. Zero
+	// disables compression.
+	GzipThresholdBytes int
+	// If non-zero, a submission identical to the last successful one is
+	// skipped, unless the last one is older than this. In delta mode this
+	// is also how often the full inventory is submitted.
+	MaxAge time.Duration
+	// Submit only the attributes which changed since the last successful
+	// submission, using PATCH.
+	Delta bool
+	// If non-zero, attributes are left out of submissions larger than
+	// this, see InventoryData.Truncate.
+	MaxSizeBytes int
+	// Where the last submission is stored, so that it is remembered
+	// across restarts. May be nil.
+	Cache store.Store
+
+	lock          sync.Mutex
+	lastDigest    [sha256.Size]byte
+	lastSubmitted time.Time
+	lastURL       string
+	lastData      InventoryData
+	lastFull      time.Time
+	cacheLoaded   bool
 }
 
 func NewInventory() InventorySubmitter {
@@ -35,24 +67,141 @@ func NewInventory() InventorySubmitter {
 
 // Submit reports status information to the backend
 func (i *InventoryClient) Submit(api ApiRequester, url string, data interface{}) error {
-	// PATCH used to be the only method available in Mender Product 2.5, so
-	// fall back to that if PUT fails.
-	r, err := doSubmitInventory(api, http.MethodPut, url, data)
-	if err == nil {
-		defer r.Body.Close()
-	} else if r != nil && r.StatusCode == http.StatusMethodNotAllowed {
-		r, err = doSubmitInventory(api, http.MethodPatch, url, data)
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.loadCache()
+
+	if idata, ok := data.(InventoryData); ok && i.MaxSizeBytes > 0 {
+		var omitted []string
+		if idata, omitted = idata.Truncate(i.MaxSizeBytes); len(omitted) > 0 {
+			log.Warnf("The inventory is larger than %d bytes, leaving out the "+
+				"attributes: %s", i.MaxSizeBytes, strings.Join(omitted, ", "))
+			data = idata
+		}
+	}
+
+	var digest [sha256.Size]byte
+	if i.MaxAge > 0 {
+		var err error
+		digest, err = inventoryDigest(url, data)
+		if err != nil {
+			return errors.Wrap(err, "failed to compute inventory digest")
+		}
+		if digest == i.lastDigest && time.Since(i.lastSubmitted) < i.MaxAge {
+			log.Debug("Inventory unchanged since the last submission, not submitting")
+			return nil
+		}
+	}
+
+	idata, _ := data.(InventoryData)
+	full := true
+	var err error
+	if delta, ok := i.delta(url, idata); ok && len(delta) == 0 {
+		log.Debug("No inventory attributes changed since the last submission")
+		return nil
+	} else if ok {
+		log.Debugf("Submitting %d changed inventory attributes out of %d",
+			len(delta), len(idata))
+		var r *http.Response
+		r, err = i.submitWithMethod(api, http.MethodPatch, url, delta)
 		if err == nil {
-			defer r.Body.Close()
+			full = false
+		} else if r != nil {
+			// The server responded, but didn't accept it.
+			log.Warnf("Partial inventory submission failed, submitting all of it: %s",
+				err.Error())
+			err = nil
 		}
 	}
+	if full && err == nil {
+		err = i.submitFull(api, url, data)
+	}
+
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	now := time.Now()
+	if i.MaxAge > 0 {
+		i.lastDigest = digest
+		i.lastSubmitted = now
+	}
+	if i.Delta && idata != nil {
+		i.lastURL = url
+		i.lastData = append(InventoryData(nil), idata...)
+		if full {
+			i.lastFull = now
+		}
+	}
+	if i.MaxAge > 0 || i.Delta {
+		i.storeCache()
+	}
+	return nil
+}
+
+// delta returns the attributes which changed since the last successful
+// submission, if a partial submission is possible.
+func (i *InventoryClient) delta(url string, data InventoryData) (InventoryData, bool) {
+	if !i.Delta || data == nil || i.lastData == nil || url != i.lastURL {
+		return nil, false
+	}
+	if i.MaxAge > 0 && time.Since(i.lastFull) >= i.MaxAge {
+		return nil, false
+	}
+	return data.ChangedSince(i.lastData)
+}
+
+func (i *InventoryClient) submitFull(api ApiRequester, url string, data interface{}) error {
+	// PATCH used to be the only method available in Mender Product 2.5, so
+	// fall back to that if PUT fails.
+	r, err := i.submitWithMethod(api, http.MethodPut, url, data)
+	if err != nil && r != nil && r.StatusCode == http.StatusMethodNotAllowed {
+		_, err = i.submitWithMethod(api, http.MethodPatch, url, data)
+	}
+	return err
+}
+
+func (i *InventoryClient) submitWithMethod(
+	api ApiRequester,
+	method, url string,
+	data interface{},
+) (*http.Response, error) {
+	r, err := doSubmitInventory(api, method, url, data, i.GzipThresholdBytes)
+	if err != nil && r != nil && r.StatusCode == http.StatusUnsupportedMediaType &&
+		i.GzipThresholdBytes > 0 {
+		// The server doesn't accept compressed bodies.
+		log.Debug("Inventory submission: compression not supported by the server")
+		r, err = doSubmitInventory(api, method, url, data, 0)
+	}
 
 	log.Debugf("Inventory update sent, response %v", r)
 
+	return r, err
+}
+
+// inventoryDigest hashes the server URL and the inventory data. The
+// attributes are sorted first, since their order is not significant.
+func inventoryDigest(url string, data interface{}) ([sha256.Size]byte, error) {
+	if idata, ok := data.(InventoryData); ok {
+		sorted := make(InventoryData, len(idata))
+		copy(sorted, idata)
+		sort.SliceStable(sorted, func(a, b int) bool {
+			return sorted[a].Name < sorted[b].Name
+		})
+		data = sorted
+	}
+	encoded, err := json.Marshal(data)
 	if err != nil {
-		log.Error(err.Error())
+		return [sha256.Size]byte{}, err
 	}
+	return sha256.Sum256(append([]byte(url+"\n"), encoded...)), nil
+}
 
+// UpdateInventoryAttributes sets the given attributes, leaving the others the
+// server has for the device as they are.
+func UpdateInventoryAttributes(api ApiRequester, url string, data InventoryData) error {
+	_, err := doSubmitInventory(api, http.MethodPatch, url, data, 0)
 	return err
 }
 
@@ -60,8 +209,9 @@ func doSubmitInventory(
 	api ApiRequester,
 	method, url string,
 	data interface{},
+	gzipThreshold int,
 ) (*http.Response, error) {
-	req, err := makeInventorySubmitRequest(method, url, data)
+	req, err := makeInventorySubmitRequest(method, url, data, gzipThreshold)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to prepare inventory submit request")
 	}
@@ -86,7 +236,14 @@ func doSubmitInventory(
 	return r, nil
 }
 
-func makeInventorySubmitRequest(method, server string, data interface{}) (*http.Request, error) {
+// makeInventorySubmitRequest prepares the request, gzip compressing the body
+// if it is at least gzipThreshold bytes. A zero gzipThreshold never
+// compresses.
+func makeInventorySubmitRequest(
+	method, server string,
+	data interface{},
+	gzipThreshold int,
+) (*http.Request, error) {
 	url := buildApiURL(server, "/v1/inventory/device/attributes")
 
 	out := &bytes.Buffer{}
@@ -96,11 +253,29 @@ func makeInventorySubmitRequest(method, server string, data interface{}) (*http.
 		return nil, errors.Wrapf(err, "failed to encode inventory request data")
 	}
 
+	compress := gzipThreshold > 0 && out.Len() >= gzipThreshold
+	if compress {
+		compressed := &bytes.Buffer{}
+		zw := gzip.NewWriter(compressed)
+		if _, err = zw.Write(out.Bytes()); err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compress inventory request data")
+		}
+		log.Debugf("Inventory data compressed from %d to %d bytes",
+			out.Len(), compressed.Len())
+		out = compressed
+	}
+
 	hreq, err := http.NewRequest(method, url, out)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create inventory HTTP request")
 	}
 
 	hreq.Header.Add("Content-Type", "application/json")
-	return hreq, nil
+	if compress {
+		hreq.Header.Add("Content-Encoding", "gzip")
+	}
+	return withRequestKind(hreq, requestKindInventory), nil
 }
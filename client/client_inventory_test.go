@@ -14,14 +14,20 @@
 package client
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
 )
 
 func TestInventoryClient(t *testing.T) {
@@ -107,3 +113,256 @@ func TestInventoryFallbackToPatch(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestInventoryGzip(t *testing.T) {
+	var encoding string
+	var body []byte
+	acceptGzip := true
+	ts := startTestHTTPS(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding = r.Header.Get("Content-Encoding")
+			if encoding == "gzip" {
+				if !acceptGzip {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				zr, err := gzip.NewReader(r.Body)
+				require.NoError(t, err)
+				body, _ = ioutil.ReadAll(zr)
+			} else {
+				body, _ = ioutil.ReadAll(r.Body)
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		localhostCert,
+		localhostKey)
+	defer ts.Close()
+
+	ac, err := NewApiClient(
+		conf.HttpConfig{ServerCert: "testdata/server.crt"},
+	)
+	require.NoError(t, err)
+
+	data := InventoryData{
+		{"foo", "bar"},
+		{"packages", strings.Repeat("package ", 100)},
+	}
+	expected, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	// Below the threshold.
+	client := &InventoryClient{GzipThresholdBytes: 10000}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, "", encoding)
+	assert.JSONEq(t, string(expected), string(body))
+
+	// Above the threshold.
+	client = &InventoryClient{GzipThresholdBytes: 100}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, "gzip", encoding)
+	assert.JSONEq(t, string(expected), string(body))
+
+	// Server which doesn't support compressed bodies.
+	acceptGzip = false
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, "", encoding)
+	assert.JSONEq(t, string(expected), string(body))
+}
+
+func TestInventoryUnchanged(t *testing.T) {
+	submissions := 0
+	ts := startTestHTTPS(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			submissions++
+			w.WriteHeader(http.StatusOK)
+		}),
+		localhostCert,
+		localhostKey)
+	defer ts.Close()
+
+	ac, err := NewApiClient(
+		conf.HttpConfig{ServerCert: "testdata/server.crt"},
+	)
+	require.NoError(t, err)
+
+	client := &InventoryClient{MaxAge: time.Hour}
+	data := InventoryData{{"foo", "bar"}, {"bar", "baz"}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 1, submissions)
+
+	// Same attributes, in a different order.
+	require.NoError(t, client.Submit(ac, ts.URL, InventoryData{{"bar", "baz"}, {"foo", "bar"}}))
+	assert.Equal(t, 1, submissions)
+
+	// Changed attributes.
+	data = InventoryData{{"foo", "bar"}, {"bar", "qux"}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 2, submissions)
+
+	// Unchanged, but too old.
+	client.lastSubmitted = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 3, submissions)
+
+	// Without a max age everything is submitted.
+	client = &InventoryClient{}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 5, submissions)
+}
+
+func TestInventoryDelta(t *testing.T) {
+	var method string
+	var body InventoryData
+	patchStatus := http.StatusOK
+	ts := startTestHTTPS(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			body = nil
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if r.Method == http.MethodPatch {
+				w.WriteHeader(patchStatus)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		localhostCert,
+		localhostKey)
+	defer ts.Close()
+
+	ac, err := NewApiClient(
+		conf.HttpConfig{ServerCert: "testdata/server.crt"},
+	)
+	require.NoError(t, err)
+
+	client := &InventoryClient{Delta: true, MaxAge: time.Hour}
+
+	// The first submission is always complete.
+	data := InventoryData{{"foo", "bar"}, {"bar", "baz"}, {"list", []string{"a", "b"}}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Len(t, body, 3)
+
+	// One changed, one added.
+	data = InventoryData{{"foo", "bar"}, {"bar", "qux"}, {"list", []string{"a", "b"}},
+		{"new", "value"}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, http.MethodPatch, method)
+	assert.ElementsMatch(t, InventoryData{{"bar", "qux"}, {"new", "value"}}, body)
+
+	// Removed attributes need a complete submission.
+	data = InventoryData{{"foo", "bar"}, {"bar", "qux"}, {"list", []string{"a", "c"}}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Len(t, body, 3)
+
+	// A refused partial submission is followed by a complete one.
+	patchStatus = http.StatusBadRequest
+	data = InventoryData{{"foo", "baz"}, {"bar", "qux"}, {"list", []string{"a", "c"}}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Len(t, body, 3)
+
+	// After the max age, everything is submitted again.
+	patchStatus = http.StatusOK
+	client.lastFull = time.Now().Add(-2 * time.Hour)
+	data = InventoryData{{"foo", "bar"}, {"bar", "qux"}, {"list", []string{"a", "c"}}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Len(t, body, 3)
+}
+
+func TestInventoryTruncate(t *testing.T) {
+	data := InventoryData{
+		{"device_type", strings.Repeat("d", 50)},
+		{"packages", []string{strings.Repeat("p", 100), strings.Repeat("q", 100)}},
+		{"aa", "abc"},
+		{"zz", "xyz"},
+		{"artifact_name", "release-1"},
+	}
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	kept, omitted := data.Truncate(len(encoded))
+	assert.Equal(t, data, kept)
+	assert.Empty(t, omitted)
+
+	// Without the largest.
+	kept, omitted = data.Truncate(len(encoded) - 1)
+	assert.Equal(t, []string{"packages"}, omitted)
+	assert.Equal(t, InventoryData{data[0], data[2], data[3], data[4]}, kept)
+	encoded, err = json.Marshal(kept)
+	require.NoError(t, err)
+
+	// Of the same size, the greatest name first.
+	kept, omitted = kept.Truncate(len(encoded) - 1)
+	assert.Equal(t, []string{"zz"}, omitted)
+	assert.Equal(t, InventoryData{data[0], data[2], data[4]}, kept)
+
+	// The essential attributes are kept however large they are.
+	kept, omitted = data.Truncate(1)
+	assert.Equal(t, []string{"packages", "zz", "aa"}, omitted)
+	assert.Equal(t, InventoryData{data[0], data[4]}, kept)
+
+	ts := startTestHTTPS(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body InventoryData
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Len(t, body, 2)
+			w.WriteHeader(http.StatusOK)
+		}),
+		localhostCert,
+		localhostKey)
+	defer ts.Close()
+
+	ac, err := NewApiClient(
+		conf.HttpConfig{ServerCert: "testdata/server.crt"},
+	)
+	require.NoError(t, err)
+	client := &InventoryClient{MaxSizeBytes: 1}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+}
+
+func TestInventoryCache(t *testing.T) {
+	var method string
+	submissions := 0
+	ts := startTestHTTPS(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			submissions++
+			w.WriteHeader(http.StatusOK)
+		}),
+		localhostCert,
+		localhostKey)
+	defer ts.Close()
+
+	ac, err := NewApiClient(
+		conf.HttpConfig{ServerCert: "testdata/server.crt"},
+	)
+	require.NoError(t, err)
+
+	cache := store.NewMemStore()
+	data := InventoryData{{"foo", "bar"}, {"list", []string{"a", "b"}}}
+	client := &InventoryClient{MaxAge: time.Hour, Delta: true, Cache: cache}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 1, submissions)
+	assert.Equal(t, http.MethodPut, method)
+
+	// After a restart, unchanged inventory isn't submitted again.
+	client = &InventoryClient{MaxAge: time.Hour, Delta: true, Cache: cache}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 1, submissions)
+
+	// And changes are submitted partially.
+	client = &InventoryClient{MaxAge: time.Hour, Delta: true, Cache: cache}
+	data = InventoryData{{"foo", "baz"}, {"list", []string{"a", "b"}}}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 2, submissions)
+	assert.Equal(t, http.MethodPatch, method)
+
+	// Without a cache, everything is submitted after a restart.
+	client = &InventoryClient{MaxAge: time.Hour, Delta: true}
+	require.NoError(t, client.Submit(ac, ts.URL, data))
+	assert.Equal(t, 3, submissions)
+	assert.Equal(t, http.MethodPut, method)
+}
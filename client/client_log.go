@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -32,6 +33,12 @@ type LogData struct {
 }
 
 type LogUploadClient struct {
+	// If non-zero, the logs are gzip compressed and uploaded in chunks of
+	// this many bytes, resuming after a chunk fails. Servers which don't
+	// support it get the logs in one uncompressed request.
+	ChunkSize int
+	// Longest wait before resuming a chunked upload.
+	MaxWait time.Duration
 }
 
 func NewLog() LogUploader {
@@ -40,6 +47,14 @@ func NewLog() LogUploader {
 
 // Report status information to the backend
 func (u *LogUploadClient) Upload(api ApiRequester, url string, logs LogData) error {
+	if u.ChunkSize > 0 {
+		err := u.uploadChunked(api, url, &logs)
+		if err != errChunkedLogUploadUnsupported {
+			return err
+		}
+		log.Info("The server doesn't support chunked log upload, uploading the logs at once")
+	}
+
 	req, err := makeLogUploadRequest(url, &logs)
 	if err != nil {
 		return errors.Wrapf(err, "failed to prepare log upload request")
@@ -74,5 +89,5 @@ func makeLogUploadRequest(server string, logs *LogData) (*http.Request, error) {
 	}
 
 	hreq.Header.Add("Content-Type", "application/json")
-	return hreq, nil
+	return withRequestKind(hreq, requestKindLogUpload), nil
 }
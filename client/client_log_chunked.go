@@ -0,0 +1,148 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status returned by the server for a chunk which isn't the last, and when
+// asked how much of the upload it has, as with Google's resumable uploads.
+const statusResumeIncomplete = 308
+
+const maxLogChunkAttempts = 5
+
+var errChunkedLogUploadUnsupported = errors.New("chunked log upload not supported")
+
+// uploadChunked sends the gzip compressed logs in consecutive PUT requests,
+// each with a Content-Range header giving its position in the compressed
+// body. When a chunk fails, the server is asked which bytes it has, with an
+// empty PUT with "Content-Range: bytes */<total>", and the upload resumes from
+// the end of the range in its Range response header.
+func (u *LogUploadClient) uploadChunked(api ApiRequester, server string, logs *LogData) error {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(logs.Messages); err != nil {
+		return errors.Wrap(err, "failed to compress the logs")
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "failed to compress the logs")
+	}
+	body := compressed.Bytes()
+	total := len(body)
+
+	var offset, attempts int
+	for offset < total {
+		end := offset + u.ChunkSize
+		if end > total {
+			end = total
+		}
+		r, err := u.sendLogChunk(api, server, logs, body[offset:end],
+			fmt.Sprintf("bytes %d-%d/%d", offset, end-1, total))
+		if err == nil {
+			r.Body.Close()
+			switch {
+			case r.StatusCode == http.StatusNoContent ||
+				(r.StatusCode == statusResumeIncomplete && end < total):
+				offset = end
+				attempts = 0
+				continue
+			case offset == 0 && r.StatusCode >= 400 && r.StatusCode < 500 &&
+				r.StatusCode != http.StatusUnauthorized:
+				return errChunkedLogUploadUnsupported
+			case r.StatusCode < 500:
+				return NewAPIError(errors.Errorf(
+					"uploading logs failed, bad status %v", r.StatusCode), r)
+			}
+			err = errors.Errorf("bad status %v", r.StatusCode)
+		}
+
+		log.Warnf("Uploading bytes %d-%d of the logs failed: %s", offset, end-1, err.Error())
+		wait, backoffErr := GetExponentialBackoffTime(attempts, u.MaxWait, maxLogChunkAttempts)
+		if backoffErr != nil {
+			return errors.Wrap(err, "uploading logs failed")
+		}
+		attempts++
+		time.Sleep(wait)
+		if offset, err = u.logUploadOffset(api, server, logs, total); err != nil {
+			log.Warnf("Failed to get the log upload status, starting over: %s", err.Error())
+			offset = 0
+		}
+	}
+	log.Debug("Logs uploaded")
+	return nil
+}
+
+func (u *LogUploadClient) sendLogChunk(
+	api ApiRequester,
+	server string,
+	logs *LogData,
+	chunk []byte,
+	contentRange string,
+) (*http.Response, error) {
+	req, err := makeLogUploadRequest(server, &LogData{
+		DeploymentID: logs.DeploymentID,
+		Messages:     chunk,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare log upload request")
+	}
+	if len(chunk) > 0 {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("Content-Range", contentRange)
+	return api.Do(req)
+}
+
+// logUploadOffset asks the server how much of the compressed logs it has.
+func (u *LogUploadClient) logUploadOffset(
+	api ApiRequester,
+	server string,
+	logs *LogData,
+	total int,
+) (int, error) {
+	r, err := u.sendLogChunk(api, server, logs, nil, fmt.Sprintf("bytes */%d", total))
+	if err != nil {
+		return 0, err
+	}
+	r.Body.Close()
+	switch r.StatusCode {
+	case http.StatusNoContent:
+		return total, nil
+	case statusResumeIncomplete:
+		have := r.Header.Get("Range")
+		if have == "" {
+			return 0, nil
+		}
+		if !strings.HasPrefix(have, "bytes=0-") {
+			return 0, errors.Errorf("unexpected Range %q", have)
+		}
+		last, err := strconv.Atoi(strings.TrimPrefix(have, "bytes=0-"))
+		if err != nil || last >= total {
+			return 0, errors.Errorf("unexpected Range %q", have)
+		}
+		return last + 1, nil
+	default:
+		return 0, errors.Errorf("bad status %v", r.StatusCode)
+	}
+}
@@ -14,12 +14,20 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mendersoftware/mender/conf"
 )
@@ -92,3 +100,79 @@ func TestLogUploadClient(t *testing.T) {
 	})
 	assert.Error(t, err)
 }
+
+func TestChunkedLogUpload(t *testing.T) {
+	ConfigureExponentialBackoff(time.Millisecond, 0)
+	defer ConfigureExponentialBackoff(time.Minute, 0)
+
+	messages := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(messages)
+
+	var lock sync.Mutex
+	var received []byte
+	// Fail the chunk starting at this offset once, after storing half of it.
+	failAt := -1
+	supported := true
+	var uploaded []byte
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			contentRange := r.Header.Get("Content-Range")
+			if contentRange == "" || !supported {
+				if contentRange != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				uploaded, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			var start, end, total int
+			if _, err := fmt.Sscanf(contentRange, "bytes */%d", &total); err == nil {
+				if len(received) > 0 {
+					w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(received)-1))
+				}
+				w.WriteHeader(statusResumeIncomplete)
+				return
+			}
+			_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
+			require.NoError(t, err)
+			assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+			body, _ := ioutil.ReadAll(r.Body)
+			require.Equal(t, len(received), start)
+			require.Equal(t, end-start+1, len(body))
+			if start == failAt {
+				failAt = -1
+				received = append(received, body[:len(body)/2]...)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			received = append(received, body...)
+			if len(received) == total {
+				gz, err := gzip.NewReader(bytes.NewReader(received))
+				require.NoError(t, err)
+				uploaded, err = ioutil.ReadAll(gz)
+				require.NoError(t, err)
+				w.WriteHeader(http.StatusNoContent)
+			} else {
+				w.WriteHeader(statusResumeIncomplete)
+			}
+		}))
+	defer ts.Close()
+
+	api, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	client := &LogUploadClient{ChunkSize: 8192, MaxWait: time.Second}
+	logs := LogData{DeploymentID: "deployment-1", Messages: messages}
+
+	failAt = 2 * 8192
+	require.NoError(t, client.Upload(api, ts.URL, logs))
+	assert.Equal(t, messages, uploaded)
+	assert.Equal(t, -1, failAt)
+
+	// Servers without chunked upload get everything at once.
+	received, uploaded, supported = nil, nil, false
+	require.NoError(t, client.Upload(api, ts.URL, logs))
+	assert.Equal(t, messages, uploaded)
+}
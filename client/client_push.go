@@ -0,0 +1,143 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	pushNotificationsURL = "/v1/deployments/device/notifications"
+
+	// The event sent by the server when a new deployment is available.
+	PushEventDeploymentAvailable = "deployment_available"
+
+	// The connection is considered dead when nothing, not even a pong, has
+	// been received for this long.
+	pushReadTimeout = 3 * time.Minute
+	pushPingPeriod  = time.Minute
+)
+
+var ErrPushUnauthorized = errors.New("push notification connection unauthorized")
+
+// PushNotification is an event sent by the server over the push
+// notification connection.
+type PushNotification struct {
+	Event        string `json:"event"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+}
+
+// PushClient connects to the push notification channel of the server.
+type PushClient struct {
+	dialer       *websocket.Dialer
+	connectivity *conf.Connectivity
+}
+
+// PushConnection is an open push notification channel.
+type PushConnection struct {
+	conn *websocket.Conn
+	stop chan struct{}
+}
+
+func NewPushClient(config conf.HttpConfig) (*PushClient, error) {
+	dialer, err := NewWebsocketDialer(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the push notification dialer")
+	}
+	return &PushClient{dialer: dialer, connectivity: config.Connectivity}, nil
+}
+
+func pushURL(server string) string {
+	url := buildApiURL(server, pushNotificationsURL)
+	if strings.HasPrefix(url, "http://") {
+		return "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	return "wss://" + strings.TrimPrefix(url, "https://")
+}
+
+// Connect opens the push notification channel. ErrPushUnauthorized is
+// returned if the server rejects the token.
+func (p *PushClient) Connect(server string, token AuthToken) (*PushConnection, error) {
+	header := http.Header{}
+	if token != MTLSAuthToken {
+		header.Set("Authorization", "Bearer "+string(token))
+	}
+	header.Set("User-Agent", getUserAgent())
+	setStaticHeaders(header, p.connectivity)
+	conn, rsp, err := p.dialer.Dial(pushURL(server), header)
+	if err != nil {
+		if rsp != nil {
+			defer rsp.Body.Close()
+			if rsp.StatusCode == http.StatusUnauthorized {
+				return nil, NewAPIError(ErrPushUnauthorized, rsp)
+			}
+			return nil, NewAPIError(errors.Wrapf(err,
+				"push notification connection failed with status %d", rsp.StatusCode), rsp)
+		}
+		return nil, errors.Wrap(err, "push notification connection failed")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+	})
+	c := &PushConnection{conn: conn, stop: make(chan struct{})}
+	go c.ping()
+	return c, nil
+}
+
+func (c *PushConnection) ping() {
+	ticker := time.NewTicker(pushPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil,
+				time.Now().Add(pushPingPeriod))
+			if err != nil {
+				return
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Next blocks until the server sends a notification, or the connection fails.
+func (c *PushConnection) Next() (*PushNotification, error) {
+	var notification PushNotification
+	if err := c.conn.ReadJSON(&notification); err != nil {
+		return nil, errors.Wrap(err, "push notification connection lost")
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+	return &notification, nil
+}
+
+// Close closes the connection, which makes a blocked Next return.
+func (c *PushConnection) Close() error {
+	select {
+	case <-c.stop:
+		return nil
+	default:
+		close(c.stop)
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestPushNotifications(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/devices/v1/deployments/device/notifications", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.WriteJSON(PushNotification{
+			Event:        PushEventDeploymentAvailable,
+			DeploymentID: "foo",
+		}))
+		// Wait for the client to hang up.
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	assert.Equal(t, "wss://server/api/devices/v1/deployments/device/notifications",
+		pushURL("server"))
+
+	pc, err := NewPushClient(conf.HttpConfig{})
+	require.NoError(t, err)
+
+	_, err = pc.Connect(ts.URL, "wrong")
+	assert.Equal(t, ErrPushUnauthorized, errors.Cause(err))
+
+	conn, err := pc.Connect(ts.URL, "token")
+	require.NoError(t, err)
+	notification, err := conn.Next()
+	require.NoError(t, err)
+	assert.Equal(t, PushEventDeploymentAvailable, notification.Event)
+	assert.Equal(t, "foo", notification.DeploymentID)
+
+	require.NoError(t, conn.Close())
+	_, err = conn.Next()
+	assert.Error(t, err)
+	assert.NoError(t, conn.Close())
+}
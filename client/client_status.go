@@ -43,6 +43,17 @@ type StatusReport struct {
 	DeploymentID string `json:"-"`
 	Status       string `json:"status"`
 	SubState     string `json:"substate,omitempty"`
+	// Progress is the percentage of the status done, such as how much of
+	// the artifact has been downloaded. Not reported if nil.
+	Progress *int `json:"progress,omitempty"`
+	// Payloads is the progress of each payload of the artifact.
+	Payloads []PayloadProgress `json:"payloads,omitempty"`
+}
+
+// PayloadProgress is the percentage of the status done for one payload.
+type PayloadProgress struct {
+	Type     string `json:"type"`
+	Progress int    `json:"progress"`
 }
 
 // StatusReportWrapper holds the data that is passed to the
@@ -109,5 +120,5 @@ func makeStatusReportRequest(server string, report StatusReport) (*http.Request,
 	}
 
 	hreq.Header.Add("Content-Type", "application/json")
-	return hreq, nil
+	return withRequestKind(hreq, requestKindStatus), nil
 }
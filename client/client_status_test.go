@@ -78,6 +78,19 @@ func TestStatusClient(t *testing.T) {
 		responder.path,
 	)
 
+	progress := 40
+	err = client.Report(ac, ts.URL, StatusReport{
+		DeploymentID: "deployment1",
+		Status:       StatusDownloading,
+		SubState:     "Download",
+		Progress:     &progress,
+		Payloads:     []PayloadProgress{{Type: "rootfs-image", Progress: 40}},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"status": "downloading", "substate": "Download", "progress": 40,
+		"payloads": [{"type": "rootfs-image", "progress": 40}]}`,
+		string(responder.recdata))
+
 	responder.httpStatus = http.StatusUnauthorized
 	err = client.Report(ac, ts.URL, StatusReport{
 		DeploymentID: "deployment1",
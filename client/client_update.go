@@ -15,6 +15,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,6 +29,7 @@ import (
 
 	"github.com/mendersoftware/mender/app/updatecontrolmap"
 	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
 )
 
 const (
@@ -38,7 +40,27 @@ type RequestProcessingFunc func(response *http.Response) (interface{}, error)
 
 type Updater interface {
 	GetScheduledUpdate(api ApiRequester, server string, current *CurrentUpdate) (interface{}, error)
-	FetchUpdate(api ApiRequester, url string, maxWait time.Duration) (io.ReadCloser, int64, error)
+	FetchUpdate(
+		ctx context.Context,
+		api ApiRequester,
+		url string,
+		maxWait time.Duration,
+	) (io.ReadCloser, int64, error)
+	ResumeUpdate(
+		ctx context.Context,
+		api ApiRequester,
+		url string,
+		offset, size int64,
+		validator string,
+		maxWait time.Duration,
+	) (io.ReadCloser, error)
+}
+
+// RangeValidator is implemented by the streams returned by FetchUpdate. It
+// returns the strong ETag, or the Last-Modified date, of the artifact being
+// downloaded, which ResumeUpdate needs. Empty if the server sent neither.
+type RangeValidator interface {
+	RangeValidator() string
 }
 
 var (
@@ -49,6 +71,15 @@ var (
 
 type UpdateClient struct {
 	minImageSize int64
+	// Number of connections artifacts are downloaded over, if the server
+	// supports range requests. Less than two means a single connection.
+	DownloadConnections int
+	// Size of the ranges downloaded over each connection. Defaults to
+	// 4 MiB.
+	DownloadChunkSize int64
+	// Where the ETag of the last update check response is kept, may be
+	// nil.
+	ResponseCache store.Store
 }
 
 func NewUpdate() *UpdateClient {
@@ -98,10 +129,18 @@ func (u *UpdateClient) getUpdateInfo(api ApiRequester, process RequestProcessing
 		return nil, errors.Wrapf(err, "failed to create update check request")
 	}
 
-	r, err := findFirstWorkingEndpoint(api, reqs)
+	u.setIfNoneMatch(reqs)
+	r, req, err := findFirstWorkingEndpoint(api, reqs)
 	if err != nil {
 		return nil, err
 	}
+	u.cacheUpdateCheckResponse(req, r)
+	if r.StatusCode == http.StatusNotModified {
+		// Still no deployment, same as the cached response.
+		log.Debug("Update check response not modified")
+		r.StatusCode = http.StatusNoContent
+		r.Status = "204 No Content"
+	}
 
 	respdata, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -118,7 +157,12 @@ func (u *UpdateClient) getUpdateInfo(api ApiRequester, process RequestProcessing
 	return data, err
 }
 
-func findFirstWorkingEndpoint(api ApiRequester, reqs []*http.Request) (*http.Response, error) {
+// findFirstWorkingEndpoint returns the first successful response, and the
+// request it answered.
+func findFirstWorkingEndpoint(
+	api ApiRequester,
+	reqs []*http.Request,
+) (*http.Response, *http.Request, error) {
 	var r *http.Response
 	var err error
 	for _, req := range reqs {
@@ -126,7 +170,7 @@ func findFirstWorkingEndpoint(api ApiRequester, reqs []*http.Request) (*http.Res
 		if err != nil {
 			log.Debugf("Failed sending update check request to the backend: (%s %s): Error: %s",
 				req.Method, req.URL.String(), err.Error())
-			return nil, errors.Wrapf(err, "update check request failed")
+			return nil, nil, errors.Wrapf(err, "update check request failed")
 		}
 
 		authStatus := "authorized"
@@ -134,12 +178,12 @@ func findFirstWorkingEndpoint(api ApiRequester, reqs []*http.Request) (*http.Res
 		case http.StatusUnauthorized:
 			authStatus = "unauthorized"
 			fallthrough
-		case http.StatusOK, http.StatusNoContent:
+		case http.StatusOK, http.StatusNoContent, http.StatusNotModified:
 			log.Debugf("Successful (%s) request: (%s %s): Response code: %d",
 				authStatus, req.Method, req.URL.String(), r.StatusCode)
 			// Unauthorized is also ok, since there is nothing wrong
 			// with the request itself.
-			return r, nil
+			return r, req, nil
 
 		default:
 			r.Body.Close()
@@ -153,25 +197,28 @@ func findFirstWorkingEndpoint(api ApiRequester, reqs []*http.Request) (*http.Res
 			} else if r.StatusCode >= 400 && r.StatusCode < 600 {
 				log.Debugf("request not accepted by the server: (%s %s): Response code: %d",
 					req.Method, req.URL.String(), r.StatusCode)
-				return nil, fmt.Errorf("failed to check update info on the server. Response: %v", r)
+				return nil, nil, fmt.Errorf("failed to check update info on the server. Response: %v", r)
 			} else {
-				return nil,
+				return nil, nil,
 					fmt.Errorf("received unexpected HTTP status code: %d. Response: %v",
 						r.StatusCode, r)
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("failed to check update info on the server. Response: %v", r)
+	return nil, nil, fmt.Errorf("failed to check update info on the server. Response: %v", r)
 }
 
 // FetchUpdate returns a byte stream which is a download of the given link.
+// Cancelling the context tears down the download, including any attempts to
+// resume it, and reading from the stream fails from then on.
 func (u *UpdateClient) FetchUpdate(
+	ctx context.Context,
 	api ApiRequester,
 	url string,
 	maxWait time.Duration,
 ) (io.ReadCloser, int64, error) {
-	req, err := makeUpdateFetchRequest(url)
+	req, err := makeUpdateFetchRequest(ctx, url)
 	if err != nil {
 		return nil, -1, errors.Wrapf(err, "failed to create update fetch request")
 	}
@@ -204,7 +251,51 @@ func (u *UpdateClient) FetchUpdate(
 		return nil, -1, errors.New("Image size is smaller than expected. Aborting.")
 	}
 
-	return NewUpdateResumer(r.Body, r.ContentLength, maxWait, api, req), r.ContentLength, nil
+	if d := newParallelDownload(ctx, api, url, r, u.DownloadConnections,
+		u.DownloadChunkSize, maxWait); d != nil {
+		return d, r.ContentLength, nil
+	}
+
+	resumer := NewUpdateResumer(r.Body, r.ContentLength, maxWait, api, req)
+	resumer.SetRangeValidator(r.Header)
+	return resumer, r.ContentLength, nil
+}
+
+// ResumeUpdate continues a download of the given link, of size bytes in
+// total, from offset, the same way the stream returned by FetchUpdate does
+// when the connection breaks. The validator makes sure that the artifact
+// hasn't been replaced on the server since the download started.
+func (u *UpdateClient) ResumeUpdate(
+	ctx context.Context,
+	api ApiRequester,
+	url string,
+	offset, size int64,
+	validator string,
+	maxWait time.Duration,
+) (io.ReadCloser, error) {
+	req, err := makeUpdateFetchRequest(ctx, url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create update fetch request")
+	}
+	resumer := NewUpdateResumer(nil, size, maxWait, api, req)
+	resumer.offset = offset
+	resumer.validator = validator
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	r, err := api.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "update resume request failed")
+	}
+	stream, err := resumer.getStreamFromPartialContent(r)
+	if err != nil {
+		r.Body.Close()
+		return nil, err
+	}
+	resumer.stream = stream
+	return resumer, nil
 }
 
 type UpdateResponse struct {
@@ -314,7 +405,7 @@ func makeUpdateCheckRequest(server string, current *CurrentUpdate) ([]*http.Requ
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	reqs = append(reqs, req)
+	reqs = append(reqs, withRequestKind(req, requestKindUpdateCheck))
 
 	// POST v1 -------------------------------------------------------------
 	body, err = json.Marshal(v1Body)
@@ -329,7 +420,7 @@ func makeUpdateCheckRequest(server string, current *CurrentUpdate) ([]*http.Requ
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	reqs = append(reqs, req)
+	reqs = append(reqs, withRequestKind(req, requestKindUpdateCheck))
 
 	// GET v1 --------------------------------------------------------------
 	if len(vals) != 0 {
@@ -340,17 +431,17 @@ func makeUpdateCheckRequest(server string, current *CurrentUpdate) ([]*http.Requ
 	if err != nil {
 		return nil, err
 	}
-	reqs = append(reqs, req)
+	reqs = append(reqs, withRequestKind(req, requestKindUpdateCheck))
 
 	return reqs, nil
 }
 
-func makeUpdateFetchRequest(url string) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func makeUpdateFetchRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	return req, nil
+	return withRequestKind(req, requestKindDownload), nil
 }
 
 // GetUpdateControlMap - requests an udpate control map refresh from the server
@@ -14,6 +14,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,6 +31,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
 )
 
 const correctUpdateResponse = `{
@@ -409,7 +411,7 @@ func Test_FetchUpdate_noContent_UpdateFailing(t *testing.T) {
 	client := NewUpdate()
 	assert.NotNil(t, client)
 
-	_, _, err = client.FetchUpdate(ac, ts.URL, 1*time.Minute)
+	_, _, err = client.FetchUpdate(context.Background(), ac, ts.URL, 1*time.Minute)
 	assert.Error(t, err)
 }
 
@@ -435,7 +437,7 @@ func Test_FetchUpdate_invalidRequest_UpdateFailing(t *testing.T) {
 	client := NewUpdate()
 	assert.NotNil(t, client)
 
-	_, _, err = client.FetchUpdate(ac, "broken-request", 1*time.Minute)
+	_, _, err = client.FetchUpdate(context.Background(), ac, "broken-request", 1*time.Minute)
 	assert.Error(t, err)
 }
 
@@ -462,10 +464,44 @@ func Test_FetchUpdate_correctContent_UpdateFetched(t *testing.T) {
 	assert.NotNil(t, client)
 	client.minImageSize = 1
 
-	_, _, err = client.FetchUpdate(ac, ts.URL, 1*time.Minute)
+	_, _, err = client.FetchUpdate(context.Background(), ac, ts.URL, 1*time.Minute)
 	assert.NoError(t, err)
 }
 
+func TestResumeUpdate(t *testing.T) {
+	content := "some content to be fetched"
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"content"`)
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}))
+	defer ts.Close()
+
+	ac, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	client := NewUpdate()
+	client.minImageSize = 1
+
+	img, size, err := client.FetchUpdate(context.Background(), ac, ts.URL, time.Minute)
+	require.NoError(t, err)
+	img.Close()
+	validator := img.(RangeValidator).RangeValidator()
+	assert.Equal(t, `"content"`, validator)
+
+	img, err = client.ResumeUpdate(context.Background(), ac, ts.URL,
+		5, size, validator, time.Minute)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(img)
+	require.NoError(t, err)
+	assert.Equal(t, content[5:], string(data))
+	assert.Equal(t, validator, img.(RangeValidator).RangeValidator())
+
+	// The artifact was replaced.
+	_, err = client.ResumeUpdate(context.Background(), ac, ts.URL,
+		5, size, `"other"`, time.Minute)
+	assert.Equal(t, ErrArtifactChanged, err)
+}
+
 func Test_UpdateApiClientError(t *testing.T) {
 	client := NewUpdate()
 
@@ -473,7 +509,7 @@ func Test_UpdateApiClientError(t *testing.T) {
 		"http://foo.bar", &CurrentUpdate{})
 	assert.Error(t, err)
 
-	_, _, err = client.FetchUpdate(NewMockApiClient(nil, errors.New("foo")),
+	_, _, err = client.FetchUpdate(context.Background(), NewMockApiClient(nil, errors.New("foo")),
 		"http://foo.bar", 1*time.Minute)
 	assert.Error(t, err)
 }
@@ -777,3 +813,54 @@ func TestGetUpdateControlMap(t *testing.T) {
 		ts.Close()
 	}
 }
+
+func TestUpdateCheckETag(t *testing.T) {
+	var ifNoneMatch string
+	status := http.StatusNoContent
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ifNoneMatch = r.Header.Get("If-None-Match")
+			w.Header().Set("ETag", `"none"`)
+			if ifNoneMatch == `"none"` && status == http.StatusNoContent {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				fmt.Fprint(w, correctUpdateResponse)
+			}
+		}))
+	defer ts.Close()
+
+	ac, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	client := NewUpdate()
+	client.ResponseCache = store.NewMemStore()
+	current := &CurrentUpdate{Artifact: "release-1", DeviceType: "qemux86-64"}
+
+	_, err = client.GetScheduledUpdate(ac, ts.URL, current)
+	require.IsType(t, &APIError{}, err)
+	assert.Equal(t, ErrNoDeploymentAvailable, err.(*APIError).Cause())
+	assert.Empty(t, ifNoneMatch)
+
+	// Not modified still means no deployment.
+	_, err = client.GetScheduledUpdate(ac, ts.URL, current)
+	require.IsType(t, &APIError{}, err)
+	assert.Equal(t, ErrNoDeploymentAvailable, err.(*APIError).Cause())
+	assert.Equal(t, `"none"`, ifNoneMatch)
+
+	// A different request isn't conditional.
+	current = &CurrentUpdate{Artifact: "release-2", DeviceType: "qemux86-64"}
+	_, err = client.GetScheduledUpdate(ac, ts.URL, current)
+	require.IsType(t, &APIError{}, err)
+	assert.Equal(t, ErrNoDeploymentAvailable, err.(*APIError).Cause())
+	assert.Empty(t, ifNoneMatch)
+
+	// A deployment clears the cache.
+	status = http.StatusOK
+	_, err = client.GetScheduledUpdate(ac, ts.URL, current)
+	assert.NoError(t, err)
+	assert.Equal(t, `"none"`, ifNoneMatch)
+	_, err = client.GetScheduledUpdate(ac, ts.URL, current)
+	assert.Empty(t, ifNoneMatch)
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// ErrClockSkew is the cause of the errors returned when the server
+// certificate is rejected as expired, or not yet valid, because the system
+// clock is wrong. Typical for devices without a battery backed real time
+// clock, after a power loss.
+var ErrClockSkew = errors.New("the system clock is wrong")
+
+const (
+	// How long to wait for the server's Date header, when estimating the
+	// clock skew.
+	serverDateTimeout = 10 * time.Second
+	// If the server's clock and ours differ by less, the certificate is
+	// taken to really be expired, or not yet valid.
+	maxServerClockDifference = 5 * time.Minute
+)
+
+// certificateTimeSkew returns how far the clock needs to be moved for all the
+// certificates in the chain to be valid: forward if positive, backward if
+// negative. The second return value is false if they are never all valid at
+// the same time.
+func certificateTimeSkew(certs []*x509.Certificate, now time.Time) (time.Duration, bool) {
+	var notBefore, notAfter time.Time
+	for i, cert := range certs {
+		if i == 0 || cert.NotBefore.After(notBefore) {
+			notBefore = cert.NotBefore
+		}
+		if i == 0 || cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	switch {
+	case notAfter.Before(notBefore):
+		return 0, false
+	case now.Before(notBefore):
+		return notBefore.Sub(now), true
+	case now.After(notAfter):
+		return notAfter.Sub(now), true
+	default:
+		return 0, true
+	}
+}
+
+// serverDate asks the server for its time, with a HEAD request over the
+// connection, whose certificate hasn't been accepted. So the answer is only
+// good for telling the clock skew.
+func serverDate(conn net.Conn, addr string) (time.Time, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if err = conn.SetDeadline(time.Now().Add(serverDateTimeout)); err != nil {
+		return time.Time{}, err
+	}
+	_, err = fmt.Fprintf(conn, "HEAD / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rsp.Body.Close()
+	return http.ParseTime(rsp.Header.Get("Date"))
+}
+
+// verifyChainAt verifies the chain sent by the server as of the given time,
+// against the system's trusted certificates and the configured server
+// certificate.
+func verifyChainAt(certs []*x509.Certificate, serverCert string, at time.Time) error {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if serverCert != "" {
+		for _, c := range loadServerCertificates(serverCert) {
+			roots.AddCert(c)
+		}
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+	})
+	return err
+}
+
+// checkCertificateTime is called when OpenSSL has rejected the server
+// certificate as expired, or not yet valid. Within the configured clock skew
+// tolerance the certificate is accepted if it is valid otherwise. Beyond it,
+// the server's Date header tells whether our clock is wrong, in which case
+// the error has the cause ErrClockSkew.
+func checkCertificateTime(conn *openssl.Conn, config *conf.HttpConfig, addr string) error {
+	certs, err := peerCertificates(conn)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	skew, ok := certificateTimeSkew(certs, now)
+	if !ok {
+		return errors.New("the server certificate chain is never valid")
+	}
+
+	if config.Security != nil && config.Security.ClockSkewToleranceSeconds > 0 {
+		tolerance := time.Duration(config.Security.ClockSkewToleranceSeconds) * time.Second
+		if skew <= tolerance && skew >= -tolerance {
+			if err = verifyChainAt(certs, config.ServerCert, now.Add(skew)); err != nil {
+				return errors.Wrap(err, "server certificate verification failed")
+			}
+			log.Warnf("Accepting the server certificate, which is only valid with the "+
+				"clock moved by %s, within the clock skew tolerance",
+				skew.Round(time.Second))
+			return nil
+		}
+	}
+
+	date, err := serverDate(conn, addr)
+	if err != nil {
+		return errors.Wrapf(ErrClockSkew, "the server certificate is only valid with "+
+			"the clock moved by %s, and the server's time couldn't be read (%s)",
+			skew.Round(time.Second), err.Error())
+	}
+	difference := date.Sub(now)
+	if difference < maxServerClockDifference && difference > -maxServerClockDifference {
+		if skew > 0 {
+			return errors.Errorf("certificate is not yet valid, until %s",
+				now.Add(skew).UTC().Format(time.RFC3339))
+		}
+		return errors.Errorf("certificate has expired, at %s",
+			now.Add(skew).UTC().Format(time.RFC3339))
+	}
+	return errors.Wrapf(ErrClockSkew, "the system time is %s, but the server's is %s",
+		now.UTC().Format(time.RFC3339), date.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestCertificateTimeSkew(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	cert := func(notBefore, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter}
+	}
+	tests := map[string]struct {
+		certs []*x509.Certificate
+		skew  time.Duration
+		ok    bool
+	}{
+		"valid": {
+			certs: []*x509.Certificate{cert(now.Add(-time.Hour), now.Add(time.Hour))},
+			ok:    true,
+		},
+		"not yet valid": {
+			certs: []*x509.Certificate{
+				cert(now.Add(2*time.Hour), now.Add(48*time.Hour)),
+				cert(now.Add(-time.Hour), now.Add(72*time.Hour)),
+			},
+			skew: 2 * time.Hour,
+			ok:   true,
+		},
+		"expired intermediate": {
+			certs: []*x509.Certificate{
+				cert(now.Add(-48*time.Hour), now.Add(time.Hour)),
+				cert(now.Add(-72*time.Hour), now.Add(-24*time.Hour)),
+			},
+			skew: -24 * time.Hour,
+			ok:   true,
+		},
+		"never valid together": {
+			certs: []*x509.Certificate{
+				cert(now.Add(time.Hour), now.Add(2*time.Hour)),
+				cert(now.Add(-2*time.Hour), now.Add(-time.Hour)),
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			skew, ok := certificateTimeSkew(test.certs, now)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.skew, skew)
+		})
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate,
+		&caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	// Valid in an hour, as if our clock was an hour behind.
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(time.Hour),
+		NotAfter:     time.Now().Add(3 * time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &leafTemplate, ca,
+		&leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	var serverTime time.Time
+	ts := httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", serverTime.UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusOK)
+		}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  leafKey,
+		}},
+		NextProtos: []string{"http/1.1"},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tdir, err := ioutil.TempDir("", "TestClockSkew")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	caFile := path.Join(tdir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+
+	send := func(tolerance int) error {
+		cl, err := NewApiClient(conf.HttpConfig{
+			ServerCert: caFile,
+			Security:   &conf.Security{ClockSkewToleranceSeconds: tolerance},
+		})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(req)
+		if err == nil {
+			rsp.Body.Close()
+		}
+		return err
+	}
+
+	// The server's clock agrees with ours, the certificate is the problem.
+	serverTime = time.Now()
+	err = send(0)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrClockSkew), err.Error())
+	assert.Contains(t, err.Error(), "not yet valid")
+
+	// The server's clock is ahead.
+	serverTime = time.Now().Add(2 * time.Hour)
+	err = send(0)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrClockSkew), err.Error())
+	err = send(1800)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrClockSkew), err.Error())
+
+	// Within the tolerance.
+	assert.NoError(t, send(2*3600))
+
+	// Also with a directory of server certificates.
+	caDir := path.Join(tdir, "ca.d")
+	require.NoError(t, os.Mkdir(caDir, 0700))
+	require.NoError(t, os.Rename(caFile, path.Join(caDir, "ca.crt")))
+	caFile = caDir
+	err = send(0)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrClockSkew), err.Error())
+	assert.NoError(t, send(2*3600))
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+
+	"github.com/mendersoftware/openssl"
+)
+
+var (
+	// How often a CRL given as a URL is downloaded again, unless
+	// configured otherwise.
+	defaultCRLRefreshInterval = 24 * time.Hour
+	// How long to wait before trying again when downloading a CRL fails.
+	crlRetryInterval = 5 * time.Minute
+	// How long to wait for the CRL server.
+	crlRequestTimeout = 30 * time.Second
+
+	crlCache = struct {
+		sync.Mutex
+		entries map[string]*crlCacheEntry
+	}{entries: map[string]*crlCacheEntry{}}
+)
+
+type crlCacheEntry struct {
+	crl *pkix.CertificateList
+	// Modification time of the file the CRL was read from.
+	modTime time.Time
+	// When the CRL was last downloaded, or attempted to.
+	lastAttempt time.Time
+	lastSuccess time.Time
+}
+
+func isCRLURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// loadCRL returns the configured CRL, reading or downloading it again if it
+// has changed or is due for a refresh.
+func loadCRL(config *conf.HttpConfig) (*pkix.CertificateList, error) {
+	source := config.Security.ServerCRL
+
+	crlCache.Lock()
+	defer crlCache.Unlock()
+	entry := crlCache.entries[source]
+
+	if !isCRLURL(source) {
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the CRL file")
+		}
+		if entry != nil && entry.modTime.Equal(info.ModTime()) {
+			return entry.crl, nil
+		}
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the CRL file")
+		}
+		crl, err := x509.ParseCRL(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the CRL file %s", source)
+		}
+		crlCache.entries[source] = &crlCacheEntry{crl: crl, modTime: info.ModTime()}
+		return crl, nil
+	}
+
+	refresh := defaultCRLRefreshInterval
+	if config.Security.ServerCRLRefreshSeconds > 0 {
+		refresh = time.Duration(config.Security.ServerCRLRefreshSeconds) * time.Second
+	}
+	if entry == nil {
+		entry = &crlCacheEntry{}
+		crlCache.entries[source] = entry
+	}
+	now := time.Now()
+	if entry.crl != nil && now.Sub(entry.lastSuccess) < refresh {
+		return entry.crl, nil
+	}
+	if now.Sub(entry.lastAttempt) < crlRetryInterval {
+		// The last download failed, don't try again just yet.
+		if entry.crl != nil {
+			return entry.crl, nil
+		}
+		return nil, errors.Errorf("the CRL could not be downloaded from %s", source)
+	}
+
+	entry.lastAttempt = now
+	crl, err := downloadCRL(config, source)
+	if err != nil {
+		if entry.crl != nil {
+			log.Warnf("Failed to download the CRL, using the previously downloaded one: %s",
+				err.Error())
+			return entry.crl, nil
+		}
+		return nil, err
+	}
+	entry.crl = crl
+	entry.lastSuccess = now
+	return crl, nil
+}
+
+func downloadCRL(config *conf.HttpConfig, url string) (*pkix.CertificateList, error) {
+	client := http.Client{
+		Timeout: crlRequestTimeout,
+		Transport: &http.Transport{
+			Proxy: newProxyFunc(config, false),
+		},
+	}
+	rsp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download the CRL")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to download the CRL from %s: %s",
+			url, rsp.Status)
+	}
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download the CRL")
+	}
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the CRL from %s", url)
+	}
+	return crl, nil
+}
+
+// checkCRL rejects the connection if any certificate in the server's chain
+// is listed in the configured CRL. The CRL only applies to certificates
+// issued by the CA that signed it. The connection is rejected as well if the
+// CRL doesn't apply to any certificate in the chain, or if its signature
+// can't be verified, since the revocation status is unknown then.
+func checkCRL(conn *openssl.Conn, config *conf.HttpConfig) error {
+	crl, err := loadCRL(config)
+	if err != nil {
+		return err
+	}
+	certs, err := peerCertificates(conn)
+	if err != nil {
+		return err
+	}
+
+	var crlIssuer pkix.Name
+	crlIssuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	checked := false
+	for i, cert := range certs {
+		if cert.Issuer.String() != crlIssuer.String() {
+			continue
+		}
+		issuer, err := findIssuer(cert, certs[i+1:], config.ServerCert)
+		if err != nil {
+			return errors.Wrap(err, "the CRL can not be verified")
+		}
+		if err := issuer.CheckCRLSignature(crl); err != nil {
+			return errors.Wrapf(err, "the CRL from %s is not signed by %q",
+				config.Security.ServerCRL, issuer.Subject)
+		}
+		checked = true
+		if crl.HasExpired(time.Now()) {
+			log.Warnf("The CRL from %s is past its next update time, "+
+				"revocations done since then are not known", config.Security.ServerCRL)
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return ErrCertificateRevoked
+			}
+		}
+	}
+	if !checked {
+		return errors.Errorf("the CRL from %s was issued by %q, which didn't issue "+
+			"any certificate of the server", config.Security.ServerCRL, crlIssuer)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestCRLCheck(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign |
+			x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate,
+		&caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &leafTemplate, ca,
+		&leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  leafKey,
+		}},
+		NextProtos: []string{"http/1.1"},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	newCRL := func(serials ...int64) []byte {
+		var revoked []pkix.RevokedCertificate
+		for _, serial := range serials {
+			revoked = append(revoked, pkix.RevokedCertificate{
+				SerialNumber:   big.NewInt(serial),
+				RevocationTime: time.Now().Add(-time.Minute),
+			})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:              big.NewInt(1),
+			ThisUpdate:          time.Now().Add(-time.Minute),
+			NextUpdate:          time.Now().Add(time.Hour),
+			RevokedCertificates: revoked,
+		}, ca, caKey)
+		require.NoError(t, err)
+		return der
+	}
+
+	tdir, err := ioutil.TempDir("", "TestCRLCheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	caFile := path.Join(tdir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	goodCRL := path.Join(tdir, "good.crl")
+	require.NoError(t, ioutil.WriteFile(goodCRL, newCRL(7), 0600))
+	revokedCRL := path.Join(tdir, "revoked.crl")
+	require.NoError(t, ioutil.WriteFile(revokedCRL,
+		pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: newCRL(7, 42)}), 0600))
+
+	crlAvailable := true
+	crlServer := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !crlAvailable {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(newCRL(42))
+		}))
+	defer crlServer.Close()
+
+	doRequest := func(crl string) error {
+		cl, err := NewApiClient(conf.HttpConfig{
+			ServerCert: caFile,
+			Security:   &conf.Security{ServerCRL: crl},
+		})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(req)
+		if err == nil {
+			rsp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, doRequest(goodCRL))
+
+	err = doRequest(revokedCRL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "revoked")
+
+	err = doRequest(path.Join(tdir, "missing.crl"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read the CRL file")
+
+	// A CRL which can't be verified, or which doesn't apply to the server's
+	// chain, fails the connection.
+	newOtherCRL := func(name string) []byte {
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		otherTemplate := caTemplate
+		otherTemplate.Subject = pkix.Name{CommonName: name}
+		otherDER, err := x509.CreateCertificate(rand.Reader, &otherTemplate,
+			&otherTemplate, &otherKey.PublicKey, otherKey)
+		require.NoError(t, err)
+		other, err := x509.ParseCertificate(otherDER)
+		require.NoError(t, err)
+		der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: time.Now().Add(-time.Minute),
+			NextUpdate: time.Now().Add(time.Hour),
+		}, other, otherKey)
+		require.NoError(t, err)
+		return der
+	}
+	forgedCRL := path.Join(tdir, "forged.crl")
+	require.NoError(t, ioutil.WriteFile(forgedCRL, newOtherCRL("Test CA"), 0600))
+	err = doRequest(forgedCRL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not signed by")
+	otherCRL := path.Join(tdir, "other.crl")
+	require.NoError(t, ioutil.WriteFile(otherCRL, newOtherCRL("Other CA"), 0600))
+	err = doRequest(otherCRL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "didn't issue any certificate")
+
+	// A replaced file is read again.
+	require.NoError(t, ioutil.WriteFile(goodCRL, newCRL(42), 0600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(goodCRL, modTime, modTime))
+	err = doRequest(goodCRL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "revoked")
+
+	// A downloaded CRL keeps being used when the server goes away.
+	prevRefresh := defaultCRLRefreshInterval
+	prevRetry := crlRetryInterval
+	defaultCRLRefreshInterval = 0
+	crlRetryInterval = 0
+	defer func() {
+		defaultCRLRefreshInterval = prevRefresh
+		crlRetryInterval = prevRetry
+	}()
+	err = doRequest(crlServer.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "revoked")
+	crlAvailable = false
+	err = doRequest(crlServer.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "revoked")
+
+	// No CRL at all fails the connection.
+	err = doRequest(crlServer.URL + "/other")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
@@ -0,0 +1,263 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// IP family preferences, as given in the IPPreference setting.
+const (
+	IPPreferV4 = "prefer-v4"
+	IPPreferV6 = "prefer-v6"
+	IPOnlyV4   = "v4-only"
+	IPOnlyV6   = "v6-only"
+)
+
+func validateIPPreference(preference string) error {
+	switch preference {
+	case "", IPPreferV4, IPPreferV6, IPOnlyV4, IPOnlyV6:
+		return nil
+	default:
+		return errors.Errorf("unsupported IPPreference %q, must be one of %q, %q, %q or %q",
+			preference, IPPreferV4, IPPreferV6, IPOnlyV4, IPOnlyV6)
+	}
+}
+
+// orderByIPPreference sorts the addresses by the preferred family, keeping
+// the order within each family, and drops the other family for the "-only"
+// preferences.
+func orderByIPPreference(addrs []string, preference string) []string {
+	if preference == "" {
+		return addrs
+	}
+	var v4, v6 []string
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	switch preference {
+	case IPPreferV4:
+		return append(v4, v6...)
+	case IPPreferV6:
+		return append(v6, v4...)
+	case IPOnlyV4:
+		return v4
+	case IPOnlyV6:
+		return v6
+	}
+	return addrs
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// dnsResolver resolves host names with the configured DNS servers, if any,
+// or else the system resolver, and caches the results.
+type dnsResolver struct {
+	// One per configured server, in order.
+	resolvers   []*net.Resolver
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+
+	lock  sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsResolverKey struct {
+	servers     string
+	positiveTTL int
+	negativeTTL int
+}
+
+// All clients with the same settings share a cache.
+var dnsResolvers = struct {
+	sync.Mutex
+	resolvers map[dnsResolverKey]*dnsResolver
+}{resolvers: map[dnsResolverKey]*dnsResolver{}}
+
+// dnsServerAddr adds the default port to a DNS server address without one.
+func dnsServerAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+// getDNSResolver returns the resolver for the given settings, or nil if
+// the system resolver should be used as is.
+func getDNSResolver(connectivity *conf.Connectivity) *dnsResolver {
+	if connectivity == nil || len(connectivity.DNSServers) == 0 &&
+		connectivity.DNSCacheSeconds <= 0 && connectivity.DNSNegativeCacheSeconds <= 0 {
+		return nil
+	}
+	key := dnsResolverKey{
+		servers:     strings.Join(connectivity.DNSServers, ","),
+		positiveTTL: connectivity.DNSCacheSeconds,
+		negativeTTL: connectivity.DNSNegativeCacheSeconds,
+	}
+
+	dnsResolvers.Lock()
+	defer dnsResolvers.Unlock()
+	if r, ok := dnsResolvers.resolvers[key]; ok {
+		return r
+	}
+
+	r := &dnsResolver{
+		resolvers:   []*net.Resolver{net.DefaultResolver},
+		positiveTTL: time.Duration(key.positiveTTL) * time.Second,
+		negativeTTL: time.Duration(key.negativeTTL) * time.Second,
+		cache:       map[string]dnsCacheEntry{},
+	}
+	if len(connectivity.DNSServers) > 0 {
+		r.resolvers = nil
+		for _, server := range connectivity.DNSServers {
+			server := dnsServerAddr(strings.TrimSpace(server))
+			r.resolvers = append(r.resolvers, &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, network, server)
+				},
+			})
+		}
+	}
+	dnsResolvers.resolvers[key] = r
+	return r
+}
+
+// lookup returns the addresses of the host, from the cache if possible.
+func (r *dnsResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.lock.Lock()
+	entry, ok := r.cache[host]
+	r.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, entry.err
+	}
+
+	// Move on to the next server only if one can't answer at all.
+	var addrs []string
+	var err error
+	for _, resolver := range r.resolvers {
+		addrs, err = resolver.LookupHost(ctx, host)
+		if dnsErr, ok := err.(*net.DNSError); !ok || dnsErr.IsNotFound {
+			break
+		}
+		log.Debugf("DNS lookup of %s failed, trying the next server: %s", host, err.Error())
+	}
+	if err == nil && len(addrs) == 0 {
+		err = errors.Errorf("no addresses found for %s", host)
+	}
+	if ctx.Err() != nil {
+		// A cancelled lookup says nothing about the host.
+		return nil, err
+	}
+
+	ttl := r.positiveTTL
+	if err != nil {
+		ttl = r.negativeTTL
+	}
+	if ttl > 0 {
+		r.lock.Lock()
+		r.cache[host] = dnsCacheEntry{addrs: addrs, err: err, expires: time.Now().Add(ttl)}
+		r.lock.Unlock()
+	}
+	return addrs, err
+}
+
+// netDialer makes the connections to the server and to proxies, resolving
+// names with the configured resolver, and trying the addresses one at a
+// time in the order of the IP family preference.
+type netDialer struct {
+	net.Dialer
+	resolver     *dnsResolver
+	ipPreference string
+}
+
+func newNetDialer(connectivity *conf.Connectivity) *netDialer {
+	d := &netDialer{
+		Dialer: net.Dialer{
+			Timeout:   1 * time.Minute,
+			KeepAlive: connectionKeepaliveTime,
+		},
+		resolver: getDNSResolver(connectivity),
+	}
+	if connectivity != nil {
+		d.ipPreference = connectivity.IPPreference
+		if connectivity.TCPKeepAliveSeconds != 0 {
+			// A negative KeepAlive disables the probes.
+			d.KeepAlive = time.Duration(connectivity.TCPKeepAliveSeconds) * time.Second
+		}
+	}
+	return d
+}
+
+func (d *netDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.resolver == nil && d.ipPreference == "" {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	switch {
+	case d.resolver != nil:
+		addrs, err = d.resolver.lookup(ctx, host)
+	case net.ParseIP(host) != nil:
+		addrs = []string{host}
+	default:
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	addrs = orderByIPPreference(addrs, d.ipPreference)
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("no addresses for %s allowed by the IP preference %s",
+			host, d.ipPreference)
+	}
+	for _, ip := range addrs {
+		var conn net.Conn
+		conn, err = d.Dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		log.Debugf("Failed to connect to %s at %s: %s", host, ip, err.Error())
+	}
+	return nil, err
+}
+
+func (d *netDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
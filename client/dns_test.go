@@ -0,0 +1,217 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// dnsTestServer answers A queries for names starting with "mender.test"
+// with 127.0.0.1, and everything else with NXDOMAIN.
+type dnsTestServer struct {
+	conn    *net.UDPConn
+	lock    sync.Mutex
+	queries map[string]int
+}
+
+func startDNSTestServer(t *testing.T) *dnsTestServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	s := &dnsTestServer{conn: conn, queries: map[string]int{}}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if rsp := s.answer(buf[:n]); rsp != nil {
+				_, _ = conn.WriteToUDP(rsp, addr)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *dnsTestServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// Read the name of the only question.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		if i+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	question := query[12 : i+5]
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	name := strings.Join(labels, ".")
+
+	s.lock.Lock()
+	if qtype == 1 {
+		s.queries[name]++
+	}
+	s.lock.Unlock()
+
+	rsp := make([]byte, 12, 64)
+	copy(rsp, query[:2])
+	// Response, recursion desired and available.
+	rsp[2] = 0x81
+	rsp[3] = 0x80
+	binary.BigEndian.PutUint16(rsp[4:], 1)
+	rsp = append(rsp, question...)
+	if !strings.HasPrefix(name, "mender.test") {
+		// NXDOMAIN
+		rsp[3] |= 3
+		return rsp
+	}
+	if qtype == 1 {
+		binary.BigEndian.PutUint16(rsp[6:], 1)
+		rsp = append(rsp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+	}
+	return rsp
+}
+
+func (s *dnsTestServer) count(prefix string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	total := 0
+	for name, n := range s.queries {
+		if strings.HasPrefix(name, prefix) {
+			total += n
+		}
+	}
+	return total
+}
+
+func TestDNSResolver(t *testing.T) {
+	dns := startDNSTestServer(t)
+	defer dns.conn.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(t, err)
+
+	connectivity := &conf.Connectivity{
+		// The first server can't be reached.
+		DNSServers:              []string{"127.0.0.1:1", dns.conn.LocalAddr().String()},
+		DNSCacheSeconds:         60,
+		DNSNegativeCacheSeconds: 60,
+	}
+	ac, err := NewApiClient(conf.HttpConfig{Connectivity: connectivity})
+	require.NoError(t, err)
+
+	get := func(host string) error {
+		req, err := http.NewRequest(http.MethodGet, "http://"+host+":"+port+"/", nil)
+		require.NoError(t, err)
+		// Don't reuse connections, so that every request resolves.
+		req.Close = true
+		rsp, err := ac.Do(req)
+		if err == nil {
+			rsp.Body.Close()
+		}
+		return err
+	}
+
+	require.NoError(t, get("mender.test"))
+	require.NoError(t, get("mender.test"))
+	assert.Equal(t, 1, dns.count("mender.test"))
+
+	assert.Error(t, get("missing.test"))
+	queries := dns.count("missing.test")
+	assert.NotZero(t, queries)
+	assert.Error(t, get("missing.test"))
+	assert.Equal(t, queries, dns.count("missing.test"))
+
+	// Expired entries are resolved again.
+	resolver := getDNSResolver(connectivity)
+	resolver.lock.Lock()
+	for host, entry := range resolver.cache {
+		entry.expires = time.Now().Add(-time.Second)
+		resolver.cache[host] = entry
+	}
+	resolver.lock.Unlock()
+	require.NoError(t, get("mender.test"))
+	assert.Equal(t, 2, dns.count("mender.test"))
+
+	assert.Equal(t, "8.8.8.8:53", dnsServerAddr("8.8.8.8"))
+	assert.Equal(t, "[2001:db8::1]:53", dnsServerAddr("[2001:db8::1]"))
+	assert.Equal(t, "[2001:db8::1]:5353", dnsServerAddr("[2001:db8::1]:5353"))
+	assert.Nil(t, getDNSResolver(&conf.Connectivity{}))
+}
+
+func TestIPPreference(t *testing.T) {
+	addrs := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+	tests := map[string][]string{
+		"":         addrs,
+		IPPreferV4: {"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"},
+		IPPreferV6: {"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"},
+		IPOnlyV4:   {"192.0.2.1", "192.0.2.2"},
+		IPOnlyV6:   {"2001:db8::1", "2001:db8::2"},
+	}
+	for preference, expected := range tests {
+		assert.Equal(t, expected, orderByIPPreference(addrs, preference), preference)
+		assert.NoError(t, validateIPPreference(preference))
+	}
+	assert.Error(t, validateIPPreference("v5-only"))
+
+	_, err := NewApiClient(conf.HttpConfig{
+		Connectivity: &conf.Connectivity{IPPreference: "v5-only"},
+	})
+	assert.Error(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	for preference, success := range map[string]bool{IPOnlyV4: true, IPOnlyV6: false} {
+		ac, err := NewApiClient(conf.HttpConfig{
+			Connectivity: &conf.Connectivity{IPPreference: preference},
+		})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := ac.Do(req)
+		if success {
+			require.NoError(t, err)
+			rsp.Body.Close()
+		} else {
+			assert.Error(t, err, preference)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Certificates are enrolled with EST, RFC 7030, with the simple enrollment
+// and re-enrollment requests. The CSR is sent, and the certificate returned,
+// base64 encoded, the certificate in a PKCS#7 certs-only structure.
+
+var ErrESTEnrollmentPending = errors.New("the EST server has not issued the certificate yet")
+
+var oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// ESTEnroll sends the DER encoded certificate request to the EST server at
+// serverURL, as a simple enrollment, or, with reenroll, as a re-enrollment,
+// which the server authenticates with the client certificate of the
+// connection. The credentials are sent with HTTP basic authentication, if
+// given. The certificate issued is returned PEM encoded.
+func ESTEnroll(
+	api ApiRequester,
+	serverURL string,
+	csr []byte,
+	reenroll bool,
+	username, password string,
+) ([]byte, error) {
+	operation := "simpleenroll"
+	if reenroll {
+		operation = "simplereenroll"
+	}
+	body := base64.StdEncoding.EncodeToString(csr)
+	req, err := http.NewRequest(http.MethodPost,
+		strings.TrimSuffix(serverURL, "/")+"/"+operation, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build the EST request")
+	}
+	req.Header.Set("Content-Type", "application/pkcs10")
+	req.Header.Set("Content-Transfer-Encoding", "base64")
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	log.Debugf("Sending a certificate request to %s", req.URL)
+	rsp, err := api.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "EST request failed")
+	}
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		return nil, NewAPIError(ErrESTEnrollmentPending, rsp)
+	default:
+		return nil, NewAPIError(errors.Errorf(
+			"unexpected response to the EST request: %d", rsp.StatusCode), rsp)
+	}
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to receive the EST response")
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the EST response")
+	}
+	certs, err := parsePKCS7Certificates(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the EST response")
+	}
+	var out bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return out.Bytes(), nil
+}
+
+// parsePKCS7Certificates returns the certificates of a PKCS#7 signed data
+// structure, in the order they are in it.
+func parsePKCS7Certificates(der []byte) ([]*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, errors.Errorf("unexpected PKCS#7 content type %s", info.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate in the PKCS#7 structure")
+	}
+	return certs, nil
+}
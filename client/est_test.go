@@ -0,0 +1,87 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestESTEnroll(t *testing.T) {
+	response, err := ioutil.ReadFile("testdata/est-response.p7.b64")
+	require.NoError(t, err)
+	clientCert, err := ioutil.ReadFile("testdata/client.crt")
+	require.NoError(t, err)
+
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/pkcs10", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		csr, err := base64.StdEncoding.DecodeString(string(body))
+		assert.NoError(t, err)
+		assert.Equal(t, "csr", string(csr))
+
+		user, password, ok := r.BasicAuth()
+		switch r.URL.Path {
+		case "/.well-known/est/simpleenroll":
+			assert.True(t, ok)
+			assert.Equal(t, "user", user)
+			assert.Equal(t, "secret", password)
+		case "/.well-known/est/simplereenroll":
+			assert.False(t, ok)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write(response)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	url := ts.URL + "/.well-known/est/"
+
+	certs, err := ESTEnroll(api, url, []byte("csr"), false, "user", "secret")
+	require.NoError(t, err)
+	block, rest := pem.Decode(certs)
+	require.NotNil(t, block)
+	first, _ := pem.Decode(clientCert)
+	assert.Equal(t, first.Bytes, block.Bytes)
+	// The chain follows the certificate.
+	assert.Contains(t, string(rest), "BEGIN CERTIFICATE")
+
+	_, err = ESTEnroll(api, url, []byte("csr"), true, "", "")
+	assert.NoError(t, err)
+
+	status = http.StatusAccepted
+	_, err = ESTEnroll(api, url, []byte("csr"), true, "", "")
+	assert.Contains(t, err.Error(), ErrESTEnrollmentPending.Error())
+
+	_, err = ESTEnroll(api, ts.URL+"/other", []byte("csr"), false, "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/utils"
+)
+
+func fipsMode(security *conf.Security) bool {
+	return security != nil && security.FIPSMode
+}
+
+func checkFIPSPublicKey(key openssl.PublicKey) error {
+	der, err := key.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the public key")
+	}
+	return utils.CheckFIPSPublicKey(der)
+}
+
+// checkFIPSConnection makes sure that the server didn't get the connection
+// to use anything not approved by FIPS 140. The cipher suites of TLS 1.3
+// can't be restricted through the bindings, so they are checked here,
+// together with the key of the server certificate.
+func checkFIPSConnection(conn *openssl.Conn) error {
+	cipher, err := conn.CurrentCipher()
+	if err != nil {
+		return err
+	}
+	if !conf.IsFIPSCipherSuite(cipher) {
+		err = errors.Wrapf(utils.ErrNotFIPSApproved,
+			"the server negotiated the TLS cipher suite %s", cipher)
+		log.Error(err.Error())
+		return err
+	}
+	cert, err := conn.PeerCertificate()
+	if err != nil {
+		// Authenticated with a pre-shared key.
+		return nil
+	}
+	key, err := cert.PublicKey()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the key of the server certificate")
+	}
+	if err = checkFIPSPublicKey(key); err != nil {
+		err = errors.Wrap(err, "server certificate")
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
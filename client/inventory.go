@@ -13,6 +13,19 @@
 //	limitations under the License.
 package client
 
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// Attributes which Truncate never leaves out, since the server needs them.
+var essentialInventoryAttributes = map[string]bool{
+	"device_type":           true,
+	"artifact_name":         true,
+	"mender_client_version": true,
+}
+
 type InventoryAttribute struct {
 	Name  string      `json:"name"`
 	Value interface{} `json:"value"`
@@ -41,3 +54,81 @@ func (id *InventoryData) ReplaceAttributes(attr []InventoryAttribute) error {
 	}
 	return nil
 }
+
+// ChangedSince returns the attributes which are new or have a different value
+// than in old. It returns false if any attribute in old has been removed,
+// since a removal can't be expressed as a partial update.
+func (id InventoryData) ChangedSince(old InventoryData) (InventoryData, bool) {
+	current := make(map[string]interface{}, len(id))
+	for _, ia := range id {
+		current[ia.Name] = ia.Value
+	}
+	previous := make(map[string]interface{}, len(old))
+	for _, ia := range old {
+		if _, ok := current[ia.Name]; !ok {
+			return nil, false
+		}
+		previous[ia.Name] = ia.Value
+	}
+
+	changed := InventoryData{}
+	for _, ia := range id {
+		value, ok := previous[ia.Name]
+		if !ok || !reflect.DeepEqual(value, ia.Value) {
+			changed = append(changed, ia)
+		}
+	}
+	return changed, true
+}
+
+// Truncate leaves out attributes until the JSON encoding of the inventory is
+// at most maxBytes long, and returns the rest, in the same order, and the
+// names of those left out. The largest attributes are left out first, and
+// among attributes of the same size, those with the greatest names.
+// device_type, artifact_name and mender_client_version are never left out,
+// even if they alone are too large.
+func (id InventoryData) Truncate(maxBytes int) (InventoryData, []string) {
+	sizes := make(map[string]int, len(id))
+	// The brackets, and the commas between the attributes.
+	total := 2 + len(id) - 1
+	var candidates []string
+	for _, ia := range id {
+		encoded, err := json.Marshal(ia)
+		if err != nil {
+			continue
+		}
+		sizes[ia.Name] = len(encoded)
+		total += len(encoded)
+		if !essentialInventoryAttributes[ia.Name] {
+			candidates = append(candidates, ia.Name)
+		}
+	}
+	if total <= maxBytes {
+		return id, nil
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		if sizes[candidates[a]] != sizes[candidates[b]] {
+			return sizes[candidates[a]] > sizes[candidates[b]]
+		}
+		return candidates[a] > candidates[b]
+	})
+	omitted := map[string]bool{}
+	var omittedNames []string
+	for _, name := range candidates {
+		if total <= maxBytes {
+			break
+		}
+		total -= sizes[name] + 1
+		omitted[name] = true
+		omittedNames = append(omittedNames, name)
+	}
+
+	kept := make(InventoryData, 0, len(id)-len(omittedNames))
+	for _, ia := range id {
+		if !omitted[ia.Name] {
+			kept = append(kept, ia)
+		}
+	}
+	return kept, omittedNames
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/datastore"
+)
+
+// inventoryCache is what the InventoryClient remembers of the last
+// successful submission, stored so that it survives restarts.
+type inventoryCache struct {
+	Digest    []byte        `json:"digest,omitempty"`
+	Submitted time.Time     `json:"submitted,omitempty"`
+	URL       string        `json:"url,omitempty"`
+	Data      InventoryData `json:"data,omitempty"`
+	Full      time.Time     `json:"full,omitempty"`
+}
+
+// loadCache picks up the last submission from before a restart, once.
+func (i *InventoryClient) loadCache() {
+	if i.Cache == nil || i.cacheLoaded {
+		return
+	}
+	i.cacheLoaded = true
+	data, err := i.Cache.ReadAll(datastore.InventoryCacheKey)
+	if err != nil {
+		return
+	}
+	var cache inventoryCache
+	if err = json.Unmarshal(data, &cache); err != nil {
+		log.Warnf("Ignoring the stored inventory submission: %s", err.Error())
+		return
+	}
+	copy(i.lastDigest[:], cache.Digest)
+	i.lastSubmitted = cache.Submitted
+	i.lastURL = cache.URL
+	i.lastFull = cache.Full
+	if cache.Data != nil {
+		// Lists decode as []interface{}, but are compared with the []string
+		// of the inventory.
+		i.lastData = make(InventoryData, len(cache.Data))
+		for n, ia := range cache.Data {
+			if list, ok := ia.Value.([]interface{}); ok {
+				values := make([]string, 0, len(list))
+				for _, v := range list {
+					if s, ok := v.(string); ok {
+						values = append(values, s)
+					}
+				}
+				ia.Value = values
+			}
+			i.lastData[n] = ia
+		}
+	}
+}
+
+// storeCache stores the last submission.
+func (i *InventoryClient) storeCache() {
+	if i.Cache == nil {
+		return
+	}
+	cache := inventoryCache{
+		Submitted: i.lastSubmitted,
+		URL:       i.lastURL,
+		Data:      i.lastData,
+		Full:      i.lastFull,
+	}
+	if i.lastDigest != ([sha256.Size]byte{}) {
+		cache.Digest = i.lastDigest[:]
+	}
+	data, err := json.Marshal(cache)
+	if err == nil {
+		err = i.Cache.WriteAll(datastore.InventoryCacheKey, data)
+	}
+	if err != nil {
+		log.Warnf("Failed to store the inventory submission: %s", err.Error())
+	}
+}
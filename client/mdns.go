@@ -0,0 +1,404 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// An mDNS (RFC 6762) browser and responder, for DNS-SD (RFC 6763) services,
+// with which devices find each other on the local network. Only what is
+// needed for that is implemented: PTR questions, and PTR and SRV answers.
+
+const (
+	mdnsPort = 5353
+
+	dnsTypePTR = 12
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+	dnsClassIN = 1
+
+	// Top bit of the class of a question, asking for a unicast response.
+	mdnsUnicastResponse = 0x8000
+	// Top bit of the class of a record, telling that it replaces any
+	// cached one.
+	mdnsCacheFlush = 0x8000
+
+	dnsFlagResponse      = 0x8000
+	dnsFlagAuthoritative = 0x0400
+
+	mdnsTTL            = 120
+	mdnsMaxMessageSize = 9000
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// MDNSPeer is a device on the local network which offers a service.
+type MDNSPeer struct {
+	// Name of the instance of the service, unique on the network.
+	Instance string
+	// Address of the service, as "host:port".
+	Addr string
+}
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	// Offset of the data in the message, since names in it may point to
+	// earlier parts of the message.
+	dataOffset int
+	data       []byte
+}
+
+type dnsMessage struct {
+	id        uint16
+	flags     uint16
+	questions []dnsQuestion
+	records   []dnsRecord
+}
+
+// dnsName turns the name into the form it is compared in.
+func dnsName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// readDNSName reads the name at the offset in the message, following the
+// pointers of compressed names, and returns it, with the offset after it.
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("truncated name")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("too many compression pointers in name")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+		case length&0xc0 != 0:
+			return "", 0, errors.New("invalid label in name")
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("truncated name")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("truncated DNS message")
+	}
+	m := &dnsMessage{
+		id:    binary.BigEndian.Uint16(msg[0:]),
+		flags: binary.BigEndian.Uint16(msg[2:]),
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	// Answers, authority and additional records are all the same here.
+	records := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errors.New("truncated DNS question")
+		}
+		m.questions = append(m.questions, dnsQuestion{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(msg[next:]),
+			class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		offset = next + 4
+	}
+	for i := 0; i < records; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errors.New("truncated DNS record")
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		if next+10+length > len(msg) {
+			return nil, errors.New("truncated DNS record")
+		}
+		m.records = append(m.records, dnsRecord{
+			name:       name,
+			rtype:      binary.BigEndian.Uint16(msg[next:]),
+			class:      binary.BigEndian.Uint16(msg[next+2:]),
+			ttl:        binary.BigEndian.Uint32(msg[next+4:]),
+			dataOffset: next + 10,
+			data:       msg[next+10 : next+10+length],
+		})
+		offset = next + 10 + length
+	}
+	return m, nil
+}
+
+func (m *dnsMessage) marshal() []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	binary.BigEndian.PutUint16(b[2:], m.flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.records)))
+	for _, q := range m.questions {
+		b = appendDNSName(b, q.name)
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-4:], q.qtype)
+		binary.BigEndian.PutUint16(b[len(b)-2:], q.class)
+	}
+	for _, r := range m.records {
+		b = appendDNSName(b, r.name)
+		b = append(b, make([]byte, 10)...)
+		binary.BigEndian.PutUint16(b[len(b)-10:], r.rtype)
+		binary.BigEndian.PutUint16(b[len(b)-8:], r.class)
+		binary.BigEndian.PutUint32(b[len(b)-6:], r.ttl)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(r.data)))
+		b = append(b, r.data...)
+	}
+	return b
+}
+
+func mdnsQuery(service string) []byte {
+	m := dnsMessage{questions: []dnsQuestion{{
+		name:  service,
+		qtype: dnsTypePTR,
+		class: dnsClassIN | mdnsUnicastResponse,
+	}}}
+	return m.marshal()
+}
+
+// mdnsPeers returns the instances of the service in the response, at the
+// address it came from.
+func mdnsPeers(msg []byte, service string, from net.IP) []MDNSPeer {
+	m, err := parseDNSMessage(msg)
+	if err != nil {
+		log.Debugf("Ignoring invalid mDNS message from %s: %s", from, err.Error())
+		return nil
+	}
+	if m.flags&dnsFlagResponse == 0 {
+		return nil
+	}
+	suffix := "." + dnsName(service)
+	var peers []MDNSPeer
+	for _, r := range m.records {
+		name := dnsName(r.name)
+		if r.rtype != dnsTypeSRV || !strings.HasSuffix(name, suffix) || len(r.data) < 7 {
+			continue
+		}
+		port := binary.BigEndian.Uint16(r.data[4:])
+		peers = append(peers, MDNSPeer{
+			Instance: strings.TrimSuffix(name, suffix),
+			Addr:     net.JoinHostPort(from.String(), strconv.Itoa(int(port))),
+		})
+	}
+	return peers
+}
+
+// MDNSBrowse asks the local network for the instances of the service, such
+// as "_http._tcp.local", and returns those which answer within the wait.
+func MDNSBrowse(ctx context.Context, service string, wait time.Duration) ([]MDNSPeer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the mDNS socket")
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err = conn.WriteToUDP(mdnsQuery(service), mdnsGroup); err != nil {
+		return nil, errors.Wrap(err, "failed to send the mDNS query")
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, err
+	}
+
+	var peers []MDNSPeer
+	seen := make(map[string]bool)
+	buf := make([]byte, mdnsMaxMessageSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The wait is over.
+			break
+		}
+		for _, peer := range mdnsPeers(buf[:n], service, from.IP) {
+			if !seen[peer.Instance] {
+				seen[peer.Instance] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+	return peers, ctx.Err()
+}
+
+// MDNSResponder answers the mDNS queries for a service on the local network
+// with the instance of it on this device.
+type MDNSResponder struct {
+	service  string
+	instance string
+	port     int
+	conn     *net.UDPConn
+}
+
+// NewMDNSResponder joins the mDNS group, to answer for the instance of the
+// service, on the port.
+func NewMDNSResponder(service, instance string, port int) (*MDNSResponder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to join the mDNS group")
+	}
+	return &MDNSResponder{
+		service: service,
+		// The instance is one label.
+		instance: strings.ReplaceAll(instance, ".", "-"),
+		port:     port,
+		conn:     conn,
+	}, nil
+}
+
+// Serve answers queries until the responder is closed.
+func (r *MDNSResponder) Serve() {
+	buf := make([]byte, mdnsMaxMessageSize)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query, err := parseDNSMessage(buf[:n])
+		if err != nil || query.flags&dnsFlagResponse != 0 {
+			continue
+		}
+		// Queries not from the mDNS port are answered like unicast DNS,
+		// as RFC 6762 section 6.7 says.
+		unicast := from.Port != mdnsPort
+		response := r.answer(query, unicast)
+		if response == nil {
+			continue
+		}
+		to := mdnsGroup
+		for _, q := range query.questions {
+			if unicast || q.class&mdnsUnicastResponse != 0 {
+				to = from
+			}
+		}
+		if _, err = r.conn.WriteToUDP(response, to); err != nil {
+			log.Debugf("Failed to answer the mDNS query from %s: %s", from, err.Error())
+		}
+	}
+}
+
+// answer returns the response to the query, nil if it isn't for the
+// service.
+func (r *MDNSResponder) answer(query *dnsMessage, unicast bool) []byte {
+	service := dnsName(r.service)
+	instance := r.instance + "." + service
+	response := dnsMessage{flags: dnsFlagResponse | dnsFlagAuthoritative}
+	srv := dnsRecord{
+		name:  instance,
+		rtype: dnsTypeSRV,
+		class: dnsClassIN | mdnsCacheFlush,
+		ttl:   mdnsTTL,
+	}
+	srv.data = make([]byte, 6)
+	binary.BigEndian.PutUint16(srv.data[4:], uint16(r.port))
+	srv.data = appendDNSName(srv.data, r.instance+".local")
+
+	for _, q := range query.questions {
+		name := dnsName(q.name)
+		switch {
+		case name == service && (q.qtype == dnsTypePTR || q.qtype == dnsTypeANY):
+			response.records = append(response.records, dnsRecord{
+				name:  service,
+				rtype: dnsTypePTR,
+				class: dnsClassIN,
+				ttl:   mdnsTTL,
+				data:  appendDNSName(nil, instance),
+			}, srv)
+		case name == instance && (q.qtype == dnsTypeSRV || q.qtype == dnsTypeANY):
+			response.records = append(response.records, srv)
+		}
+	}
+	if len(response.records) == 0 {
+		return nil
+	}
+	if unicast {
+		response.id = query.id
+		response.questions = query.questions
+	}
+	return response.marshal()
+}
+
+// Close stops answering queries.
+func (r *MDNSResponder) Close() error {
+	return r.conn.Close()
+}
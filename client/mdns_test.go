@@ -0,0 +1,99 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDNSAnswer(t *testing.T) {
+	r := &MDNSResponder{
+		service:  "_mender-artifact._tcp.local",
+		instance: "device-1",
+		port:     8585,
+	}
+	query, err := parseDNSMessage(mdnsQuery("_Mender-Artifact._tcp.local."))
+	require.NoError(t, err)
+	require.Len(t, query.questions, 1)
+	assert.Equal(t, uint16(dnsClassIN|mdnsUnicastResponse), query.questions[0].class)
+
+	response := r.answer(query, false)
+	require.NotNil(t, response)
+	peers := mdnsPeers(response, "_mender-artifact._tcp.local", net.IPv4(10, 0, 0, 2))
+	assert.Equal(t, []MDNSPeer{{Instance: "device-1", Addr: "10.0.0.2:8585"}}, peers)
+
+	// Legacy unicast queries get their ID and questions back.
+	query.id = 1234
+	m, err := parseDNSMessage(r.answer(query, true))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(1234), m.id)
+	assert.Len(t, m.questions, 1)
+	assert.Len(t, m.records, 2)
+
+	// Other services aren't answered.
+	query, err = parseDNSMessage(mdnsQuery("_http._tcp.local"))
+	require.NoError(t, err)
+	assert.Nil(t, r.answer(query, false))
+
+	// Nor are queries taken for answers.
+	assert.Nil(t, mdnsPeers(mdnsQuery("_mender-artifact._tcp.local"),
+		"_mender-artifact._tcp.local", net.IPv4(10, 0, 0, 2)))
+}
+
+func TestReadDNSName(t *testing.T) {
+	msg := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		// "_tcp.local" at 12
+		4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		// "dev._tcp.local", compressed, at 24
+		3, 'd', 'e', 'v', 0xc0, 12,
+		// A pointer to itself
+		0xc0, 30,
+	}
+	name, next, err := readDNSName(msg, 24)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev._tcp.local", name)
+	assert.Equal(t, 30, next)
+
+	_, _, err = readDNSName(msg, 30)
+	assert.EqualError(t, err, "too many compression pointers in name")
+
+	_, _, err = readDNSName(msg[:20], 12)
+	assert.EqualError(t, err, "truncated name")
+}
+
+func TestMDNSBrowse(t *testing.T) {
+	r, err := NewMDNSResponder("_mender-test._tcp.local", "device.1", 8585)
+	if err != nil {
+		t.Skipf("No multicast here: %s", err.Error())
+	}
+	defer r.Close()
+	go r.Serve()
+
+	peers, err := MDNSBrowse(context.Background(), "_mender-test._tcp.local",
+		500*time.Millisecond)
+	if err != nil || len(peers) == 0 {
+		t.Skipf("No multicast here: %v", err)
+	}
+	require.Len(t, peers, 1)
+	assert.Equal(t, "device-1", peers[0].Instance)
+	_, port, _ := net.SplitHostPort(peers[0].Addr)
+	assert.Equal(t, "8585", port)
+}
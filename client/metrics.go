@@ -0,0 +1,195 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	metricsRetryReauthorization = "reauthorization"
+	metricsRetryDownloadResume  = "download_resume"
+)
+
+// Upper bounds, in seconds, of the request duration histogram buckets.
+var metricsDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type metricsRequestKey struct {
+	kind string
+	code string
+}
+
+type metricsHistogram struct {
+	// Cumulative counts, one per bucket.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// metrics counts what all API clients send to and receive from the server,
+// and is exposed in the Prometheus text format by MetricsHandler.
+var metrics = struct {
+	sync.Mutex
+	requests        map[metricsRequestKey]uint64
+	durations       map[string]*metricsHistogram
+	retries         map[string]uint64
+	downloadedBytes uint64
+}{
+	requests:  map[metricsRequestKey]uint64{},
+	durations: map[string]*metricsHistogram{},
+	retries:   map[string]uint64{},
+}
+
+func (k requestKind) String() string {
+	switch k {
+	case requestKindAuth:
+		return "auth"
+	case requestKindInventory:
+		return "inventory"
+	case requestKindStatus:
+		return "status"
+	case requestKindDownload:
+		return "download"
+	case requestKindUpdateCheck:
+		return "update_check"
+	case requestKindLogUpload:
+		return "log_upload"
+	default:
+		return "other"
+	}
+}
+
+// recordRequest counts a request, and the time until its response headers
+// were received. A request which failed without a response has the code
+// "error".
+func recordRequest(kind requestKind, rsp *http.Response, duration time.Duration) {
+	code := "error"
+	if rsp != nil {
+		code = strconv.Itoa(rsp.StatusCode)
+	}
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.requests[metricsRequestKey{kind: kind.String(), code: code}]++
+	histogram := metrics.durations[kind.String()]
+	if histogram == nil {
+		histogram = &metricsHistogram{buckets: make([]uint64, len(metricsDurationBuckets))}
+		metrics.durations[kind.String()] = histogram
+	}
+	seconds := duration.Seconds()
+	for i, bound := range metricsDurationBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+		}
+	}
+	histogram.sum += seconds
+	histogram.count++
+}
+
+func recordRetry(reason string) {
+	metrics.Lock()
+	metrics.retries[reason]++
+	metrics.Unlock()
+}
+
+// downloadCounter counts the bytes read from an artifact download.
+type downloadCounter struct {
+	io.ReadCloser
+}
+
+func (c *downloadCounter) Read(buf []byte) (int, error) {
+	n, err := c.ReadCloser.Read(buf)
+	if n > 0 {
+		metrics.Lock()
+		metrics.downloadedBytes += uint64(n)
+		metrics.Unlock()
+	}
+	return n, err
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+}
+
+func writeMetrics(w io.Writer) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	fmt.Fprintln(w, "# HELP mender_http_requests_total "+
+		"Requests sent to the server, by kind and status code.")
+	fmt.Fprintln(w, "# TYPE mender_http_requests_total counter")
+	requestKeys := make([]metricsRequestKey, 0, len(metrics.requests))
+	for key := range metrics.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i].kind != requestKeys[j].kind {
+			return requestKeys[i].kind < requestKeys[j].kind
+		}
+		return requestKeys[i].code < requestKeys[j].code
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(w, "mender_http_requests_total{kind=%q,code=%q} %d\n",
+			key.kind, key.code, metrics.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP mender_http_request_duration_seconds "+
+		"Time until the response headers were received, by kind.")
+	fmt.Fprintln(w, "# TYPE mender_http_request_duration_seconds histogram")
+	kinds := make([]string, 0, len(metrics.durations))
+	for kind := range metrics.durations {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		histogram := metrics.durations[kind]
+		for i, bound := range metricsDurationBuckets {
+			fmt.Fprintf(w, "mender_http_request_duration_seconds_bucket{kind=%q,le=%q} %d\n",
+				kind, strconv.FormatFloat(bound, 'g', -1, 64), histogram.buckets[i])
+		}
+		fmt.Fprintf(w, "mender_http_request_duration_seconds_bucket{kind=%q,le=\"+Inf\"} %d\n",
+			kind, histogram.count)
+		fmt.Fprintf(w, "mender_http_request_duration_seconds_sum{kind=%q} %g\n",
+			kind, histogram.sum)
+		fmt.Fprintf(w, "mender_http_request_duration_seconds_count{kind=%q} %d\n",
+			kind, histogram.count)
+	}
+
+	fmt.Fprintln(w, "# HELP mender_http_retries_total "+
+		"Requests sent again, after reauthorizing or to resume a download.")
+	fmt.Fprintln(w, "# TYPE mender_http_retries_total counter")
+	reasons := make([]string, 0, len(metrics.retries))
+	for reason := range metrics.retries {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "mender_http_retries_total{reason=%q} %d\n",
+			reason, metrics.retries[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP mender_http_downloaded_bytes_total "+
+		"Artifact bytes downloaded from the server.")
+	fmt.Fprintln(w, "# TYPE mender_http_downloaded_bytes_total counter")
+	fmt.Fprintf(w, "mender_http_downloaded_bytes_total %d\n", metrics.downloadedBytes)
+}
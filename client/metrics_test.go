@@ -0,0 +1,77 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics.Lock()
+	metrics.requests = map[metricsRequestKey]uint64{}
+	metrics.durations = map[string]*metricsHistogram{}
+	metrics.retries = map[string]uint64{}
+	metrics.downloadedBytes = 0
+	metrics.Unlock()
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("0123456789"))
+		}))
+	defer ts.Close()
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+
+	send := func(path string, kind requestKind) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(withRequestKind(req, kind))
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		rsp.Body.Close()
+	}
+	send("/", requestKindDownload)
+	send("/", requestKindDownload)
+	send("/missing", requestKindStatus)
+	recordRetry(metricsRetryDownloadResume)
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`mender_http_requests_total{kind="download",code="200"} 2`,
+		`mender_http_requests_total{kind="status",code="404"} 1`,
+		`mender_http_request_duration_seconds_bucket{kind="download",le="+Inf"} 2`,
+		`mender_http_request_duration_seconds_count{kind="status"} 1`,
+		`mender_http_retries_total{reason="download_resume"} 1`,
+		`mender_http_downloaded_bytes_total 20`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("# HELP")))
+}
@@ -0,0 +1,419 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// A minimal MQTT 3.1.1 client, supporting what is needed to tunnel requests
+// through a broker: a clean session, QoS 1 publishing and one subscription.
+
+const (
+	mqttPacketConnect    = 1
+	mqttPacketConnack    = 2
+	mqttPacketPublish    = 3
+	mqttPacketPuback     = 4
+	mqttPacketSubscribe  = 8
+	mqttPacketSuback     = 9
+	mqttPacketPingreq    = 12
+	mqttPacketPingresp   = 13
+	mqttPacketDisconnect = 14
+
+	mqttDefaultKeepAlive = 60 * time.Second
+	mqttConnectTimeout   = 30 * time.Second
+	// Largest packet the MQTT protocol can express.
+	mqttMaxRemainingLength = 268435455
+)
+
+var errMQTTClosed = errors.New("MQTT connection closed")
+
+type mqttPacket struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// mqttConn is a connection to an MQTT broker.
+type mqttConn struct {
+	conn      net.Conn
+	onMessage func(topic string, payload []byte)
+
+	writeLock sync.Mutex
+
+	lock   sync.Mutex
+	nextID uint16
+	acks   map[uint16]chan struct{}
+
+	done chan struct{}
+	err  error
+}
+
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("malformed MQTT string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("malformed MQTT string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func writeMQTTPacket(w io.Writer, packet mqttPacket) error {
+	if len(packet.body) > mqttMaxRemainingLength {
+		return errors.New("MQTT packet too large")
+	}
+	header := []byte{packet.kind<<4 | packet.flags}
+	length := len(packet.body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		header = append(header, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(header, packet.body...))
+	return err
+}
+
+func readMQTTPacket(r *bufio.Reader) (mqttPacket, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return mqttPacket{}, err
+	}
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return mqttPacket{}, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return mqttPacket{}, errors.New("malformed MQTT packet length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return mqttPacket{}, err
+	}
+	return mqttPacket{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+func mqttKeepAlive(config *conf.MQTT) time.Duration {
+	if config.KeepAliveSeconds > 0 {
+		return time.Duration(config.KeepAliveSeconds) * time.Second
+	}
+	return mqttDefaultKeepAlive
+}
+
+// dialMQTTBroker opens the network connection to the broker, using TLS with
+// the server settings for the "mqtts" scheme.
+func dialMQTTBroker(httpConfig conf.HttpConfig) (net.Conn, error) {
+	broker, err := url.Parse(httpConfig.MQTT.BrokerURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid MQTT broker URL")
+	}
+	host := broker.Host
+	switch broker.Scheme {
+	case "mqtts", "ssl", "tls":
+		if broker.Port() == "" {
+			host = net.JoinHostPort(broker.Hostname(), "8883")
+		}
+		ctx, _, err := newOpenSSLCtx(httpConfig)
+		if err != nil {
+			return nil, err
+		}
+		return dialOpenSSL(ctx, &httpConfig, "tcp", host, nil)
+	case "mqtt", "tcp":
+		if !httpConfig.MQTT.AllowInsecure {
+			return nil, errors.Errorf("refusing to connect to the MQTT broker %s "+
+				"unencrypted, AllowInsecure is not set", broker.Host)
+		}
+		if broker.Port() == "" {
+			host = net.JoinHostPort(broker.Hostname(), "1883")
+		}
+		log.Warnf("Connecting to the MQTT broker %s unencrypted, the password and the "+
+			"authorization token can be read by anyone on the network", host)
+		return newNetDialer(httpConfig.Connectivity).Dial("tcp", host)
+	default:
+		return nil, errors.Errorf("unsupported MQTT broker URL scheme %q", broker.Scheme)
+	}
+}
+
+// dialMQTT connects to the broker and subscribes to the given topic.
+// onMessage is called, from a separate go routine, for each message
+// received.
+func dialMQTT(
+	httpConfig conf.HttpConfig,
+	clientID, subscribeTopic string,
+	onMessage func(topic string, payload []byte),
+) (*mqttConn, error) {
+	config := httpConfig.MQTT
+	netConn, err := dialMQTTBroker(httpConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to the MQTT broker")
+	}
+
+	c := &mqttConn{
+		conn:      netConn,
+		onMessage: onMessage,
+		acks:      map[uint16]chan struct{}{},
+		done:      make(chan struct{}),
+	}
+	reader := bufio.NewReader(netConn)
+	if err = c.handshake(reader, config, clientID); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	go c.readLoop(reader)
+	go c.keepAlive(mqttKeepAlive(config))
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqttConnectTimeout)
+	defer cancel()
+	if err = c.subscribe(ctx, subscribeTopic); err != nil {
+		c.Close()
+		return nil, err
+	}
+	log.Infof("Connected to the MQTT broker %s", config.BrokerURL)
+	return c, nil
+}
+
+func (c *mqttConn) handshake(reader *bufio.Reader, config *conf.MQTT, clientID string) error {
+	// Clean session.
+	var flags byte = 0x02
+	payload := mqttString(clientID)
+	if config.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(config.Username)...)
+		if config.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(config.Password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags, 0, 0)
+	binary.BigEndian.PutUint16(body[len(body)-2:],
+		uint16(mqttKeepAlive(config)/time.Second))
+	body = append(body, payload...)
+
+	_ = c.conn.SetDeadline(time.Now().Add(mqttConnectTimeout))
+	defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
+	if err := writeMQTTPacket(c.conn, mqttPacket{kind: mqttPacketConnect, body: body}); err != nil {
+		return errors.Wrap(err, "failed to send MQTT connect")
+	}
+	packet, err := readMQTTPacket(reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to read MQTT connect acknowledgement")
+	}
+	if packet.kind != mqttPacketConnack || len(packet.body) != 2 {
+		return errors.New("unexpected response from the MQTT broker")
+	}
+	if packet.body[1] != 0 {
+		return errors.Errorf("the MQTT broker refused the connection with code %d",
+			packet.body[1])
+	}
+	return nil
+}
+
+func (c *mqttConn) write(packet mqttPacket) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return writeMQTTPacket(c.conn, packet)
+}
+
+func (c *mqttConn) readLoop(reader *bufio.Reader) {
+	var err error
+	for err == nil {
+		var packet mqttPacket
+		packet, err = readMQTTPacket(reader)
+		if err != nil {
+			break
+		}
+		switch packet.kind {
+		case mqttPacketPublish:
+			err = c.handlePublish(packet)
+		case mqttPacketPuback, mqttPacketSuback:
+			if len(packet.body) < 2 {
+				err = errors.New("malformed MQTT acknowledgement")
+				break
+			}
+			c.acknowledge(binary.BigEndian.Uint16(packet.body))
+		case mqttPacketPingresp:
+		default:
+			log.Debugf("Ignoring MQTT packet of type %d", packet.kind)
+		}
+	}
+	c.closeWithError(err)
+}
+
+func (c *mqttConn) handlePublish(packet mqttPacket) error {
+	topic, rest, err := readMQTTString(packet.body)
+	if err != nil {
+		return err
+	}
+	qos := (packet.flags >> 1) & 0x03
+	if qos > 0 {
+		if len(rest) < 2 {
+			return errors.New("malformed MQTT publish")
+		}
+		id := rest[:2]
+		rest = rest[2:]
+		if err = c.write(mqttPacket{kind: mqttPacketPuback, body: id}); err != nil {
+			return err
+		}
+	}
+	c.onMessage(topic, rest)
+	return nil
+}
+
+func (c *mqttConn) acknowledge(id uint16) {
+	c.lock.Lock()
+	ack, ok := c.acks[id]
+	delete(c.acks, id)
+	c.lock.Unlock()
+	if ok {
+		close(ack)
+	}
+}
+
+func (c *mqttConn) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write(mqttPacket{kind: mqttPacketPingreq}); err != nil {
+				c.closeWithError(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// sendAcknowledged sends a packet with a new packet identifier, built by
+// build, and waits for the broker to acknowledge it.
+func (c *mqttConn) sendAcknowledged(
+	ctx context.Context,
+	kind, flags byte,
+	build func(id []byte) []byte,
+) error {
+	ack := make(chan struct{})
+	c.lock.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	c.acks[id] = ack
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.acks, id)
+		c.lock.Unlock()
+	}()
+
+	idBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(idBytes, id)
+	if err := c.write(mqttPacket{kind: kind, flags: flags, body: build(idBytes)}); err != nil {
+		c.closeWithError(err)
+		return err
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *mqttConn) subscribe(ctx context.Context, topic string) error {
+	err := c.sendAcknowledged(ctx, mqttPacketSubscribe, 0x02, func(id []byte) []byte {
+		// QoS 1.
+		return append(append(id, mqttString(topic)...), 1)
+	})
+	return errors.Wrapf(err, "failed to subscribe to MQTT topic %s", topic)
+}
+
+// publish sends the message with QoS 1, and waits for the broker to
+// acknowledge it.
+func (c *mqttConn) publish(ctx context.Context, topic string, payload []byte) error {
+	err := c.sendAcknowledged(ctx, mqttPacketPublish, 0x02, func(id []byte) []byte {
+		body := append(mqttString(topic), id...)
+		return append(body, payload...)
+	})
+	return errors.Wrapf(err, "failed to publish to MQTT topic %s", topic)
+}
+
+func (c *mqttConn) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *mqttConn) closeWithError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed() {
+		return
+	}
+	if err == nil || err == io.EOF {
+		err = errMQTTClosed
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// Close disconnects from the broker.
+func (c *mqttConn) Close() error {
+	if !c.closed() {
+		_ = c.write(mqttPacket{kind: mqttPacketDisconnect})
+	}
+	c.closeWithError(errMQTTClosed)
+	return nil
+}
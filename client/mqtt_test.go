@@ -0,0 +1,205 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// mqttTestBridge is a broker and bridge in one, answering every request with
+// status 200.
+type mqttTestBridge struct {
+	listener net.Listener
+	lock     sync.Mutex
+	connect  []byte
+	topics   []string
+	requests []mqttRequest
+}
+
+func startMQTTTestBridge(t *testing.T) *mqttTestBridge {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &mqttTestBridge{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, conn)
+		}
+	}()
+	return b
+}
+
+func (b *mqttTestBridge) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var responseTopic string
+	for {
+		packet, err := readMQTTPacket(reader)
+		if err != nil {
+			return
+		}
+		switch packet.kind {
+		case mqttPacketConnect:
+			b.lock.Lock()
+			b.connect = packet.body
+			b.lock.Unlock()
+			_ = writeMQTTPacket(conn, mqttPacket{kind: mqttPacketConnack, body: []byte{0, 0}})
+		case mqttPacketSubscribe:
+			responseTopic, _, err = readMQTTString(packet.body[2:])
+			require.NoError(t, err)
+			_ = writeMQTTPacket(conn, mqttPacket{
+				kind: mqttPacketSuback,
+				body: append(packet.body[:2:2], 1),
+			})
+		case mqttPacketPublish:
+			topic, rest, err := readMQTTString(packet.body)
+			require.NoError(t, err)
+			_ = writeMQTTPacket(conn, mqttPacket{kind: mqttPacketPuback, body: rest[:2]})
+			var req mqttRequest
+			require.NoError(t, json.Unmarshal(rest[2:], &req))
+			b.lock.Lock()
+			b.topics = append(b.topics, topic)
+			b.requests = append(b.requests, req)
+			b.lock.Unlock()
+
+			payload, _ := json.Marshal(mqttResponse{
+				ID:     req.ID,
+				Status: http.StatusOK,
+				Body:   []byte("response"),
+			})
+			body := append(mqttString(responseTopic), 0, 1)
+			_ = writeMQTTPacket(conn, mqttPacket{
+				kind:  mqttPacketPublish,
+				flags: 0x02,
+				body:  append(body, payload...),
+			})
+		case mqttPacketPingreq:
+			_ = writeMQTTPacket(conn, mqttPacket{kind: mqttPacketPingresp})
+		}
+	}
+}
+
+func TestMQTTRefusesPlaintextBroker(t *testing.T) {
+	bridge := startMQTTTestBridge(t)
+	defer bridge.listener.Close()
+
+	for _, scheme := range []string{"mqtt", "tcp"} {
+		_, err := dialMQTTBroker(conf.HttpConfig{
+			MQTT: &conf.MQTT{BrokerURL: scheme + "://" + bridge.listener.Addr().String()},
+		})
+		assert.Error(t, err, scheme)
+	}
+
+	conn, err := dialMQTTBroker(conf.HttpConfig{
+		MQTT: &conf.MQTT{
+			BrokerURL:     "mqtt://" + bridge.listener.Addr().String(),
+			AllowInsecure: true,
+		},
+	})
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestMQTTTransport(t *testing.T) {
+	bridge := startMQTTTestBridge(t)
+	defer bridge.listener.Close()
+
+	directRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		directRequests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	ac, err := NewApiClient(conf.HttpConfig{
+		MQTT: &conf.MQTT{
+			BrokerURL:     "mqtt://" + bridge.listener.Addr().String(),
+			AllowInsecure: true,
+			ClientID:      "device",
+			Username:      "user",
+			Password:      "secret",
+		},
+	})
+	require.NoError(t, err)
+
+	// Inventory goes through the broker.
+	err = NewInventory().Submit(ac, "https://server.example.com",
+		InventoryData{{"foo", "bar"}})
+	require.NoError(t, err)
+
+	// Status reports too.
+	req, err := makeStatusReportRequest("https://server.example.com",
+		StatusReport{DeploymentID: "deployment", Status: StatusSuccess})
+	require.NoError(t, err)
+	rsp, err := ac.Do(req)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "response", string(body))
+
+	// Other requests don't.
+	req, err = http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	rsp, err = ac.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, 1, directRequests)
+
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	require.Len(t, bridge.requests, 2)
+	assert.Equal(t, []string{"mender/device/request", "mender/device/request"}, bridge.topics)
+	assert.Equal(t, http.MethodPut, bridge.requests[0].Method)
+	assert.Equal(t, "https://server.example.com/api/devices/v1/inventory/device/attributes",
+		bridge.requests[0].URL)
+	assert.JSONEq(t, `[{"name":"foo","value":"bar"}]`, string(bridge.requests[0].Body))
+	assert.Equal(t, http.MethodPut, bridge.requests[1].Method)
+	assert.NotEqual(t, bridge.requests[0].ID, bridge.requests[1].ID)
+
+	// Protocol name, level, flags (user name, password, clean session).
+	require.True(t, len(bridge.connect) > 8)
+	assert.Equal(t, []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2}, bridge.connect[:8])
+	assert.Equal(t, uint16(60), binary.BigEndian.Uint16(bridge.connect[8:10]))
+}
+
+func TestMQTTPacketLength(t *testing.T) {
+	for _, length := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		var buf bytes.Buffer
+		packet := mqttPacket{kind: mqttPacketPublish, flags: 0x02, body: make([]byte, length)}
+		require.NoError(t, writeMQTTPacket(&buf, packet))
+		read, err := readMQTTPacket(bufio.NewReader(&buf))
+		require.NoError(t, err)
+		assert.Equal(t, packet.kind, read.kind)
+		assert.Equal(t, packet.flags, read.flags)
+		assert.Len(t, read.body, length)
+	}
+}
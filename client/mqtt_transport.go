@@ -0,0 +1,208 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const mqttDefaultTopicPrefix = "mender"
+
+// mqttRequest is an HTTP request, as published to the broker.
+type mqttRequest struct {
+	ID     string      `json:"id"`
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// mqttResponse is the response to an mqttRequest, published back by the
+// bridge.
+type mqttResponse struct {
+	ID     string      `json:"id"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// mqttTransport sends requests through the MQTT broker. The connection is
+// opened on the first request, and again after it has been lost.
+type mqttTransport struct {
+	httpConfig conf.HttpConfig
+	clientID   string
+
+	lock    sync.Mutex
+	conn    *mqttConn
+	counter uint64
+	pending map[string]chan *mqttResponse
+}
+
+// All clients share one connection to each broker.
+var mqttTransports = struct {
+	sync.Mutex
+	transports map[conf.MQTT]*mqttTransport
+}{transports: map[conf.MQTT]*mqttTransport{}}
+
+func getMQTTTransport(httpConfig conf.HttpConfig) *mqttTransport {
+	mqttTransports.Lock()
+	defer mqttTransports.Unlock()
+	key := *httpConfig.MQTT
+	t, ok := mqttTransports.transports[key]
+	if !ok {
+		clientID := key.ClientID
+		if clientID == "" {
+			id := make([]byte, 8)
+			_, _ = rand.Read(id)
+			clientID = "mender-" + hex.EncodeToString(id)
+		}
+		t = &mqttTransport{
+			httpConfig: httpConfig,
+			clientID:   clientID,
+			pending:    map[string]chan *mqttResponse{},
+		}
+		mqttTransports.transports[key] = t
+	}
+	return t
+}
+
+// carries returns whether requests of the given kind go through the broker.
+func (t *mqttTransport) carries(kind requestKind) bool {
+	switch kind {
+	case requestKindAuth, requestKindInventory, requestKindStatus:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *mqttTransport) topic(name string) string {
+	prefix := t.httpConfig.MQTT.TopicPrefix
+	if prefix == "" {
+		prefix = mqttDefaultTopicPrefix
+	}
+	return prefix + "/" + t.clientID + "/" + name
+}
+
+func (t *mqttTransport) connection() (*mqttConn, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn != nil && !t.conn.closed() {
+		return t.conn, nil
+	}
+	conn, err := dialMQTT(t.httpConfig, t.clientID, t.topic("response"), t.handleResponse)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+func (t *mqttTransport) handleResponse(_ string, payload []byte) {
+	var rsp mqttResponse
+	if err := json.Unmarshal(payload, &rsp); err != nil {
+		log.Warnf("Ignoring malformed MQTT response: %s", err.Error())
+		return
+	}
+	t.lock.Lock()
+	waiting, ok := t.pending[rsp.ID]
+	delete(t.pending, rsp.ID)
+	t.lock.Unlock()
+	if !ok {
+		log.Debugf("Ignoring MQTT response to unknown request %q", rsp.ID)
+		return
+	}
+	waiting <- &rsp
+}
+
+// Do publishes the request, and waits for the response, or for the context
+// of the request to end.
+func (t *mqttTransport) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the request body")
+		}
+	}
+
+	conn, err := t.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	waiting := make(chan *mqttResponse, 1)
+	t.lock.Lock()
+	t.counter++
+	id := t.clientID + "-" + strconv.FormatUint(t.counter, 10)
+	t.pending[id] = waiting
+	t.lock.Unlock()
+	defer func() {
+		t.lock.Lock()
+		delete(t.pending, id)
+		t.lock.Unlock()
+	}()
+
+	payload, err := json.Marshal(mqttRequest{
+		ID:     id,
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the MQTT request")
+	}
+	if err = conn.publish(req.Context(), t.topic("request"), payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case rsp := <-waiting:
+		header := rsp.Header
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", rsp.Status, http.StatusText(rsp.Status)),
+			StatusCode:    rsp.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(rsp.Body)),
+			ContentLength: int64(len(rsp.Body)),
+			Request:       req,
+		}, nil
+	case <-conn.done:
+		return nil, errors.Wrap(conn.err, "MQTT connection lost while waiting for a response")
+	case <-req.Context().Done():
+		return nil, errors.Wrap(req.Context().Err(), "no response through MQTT")
+	}
+}
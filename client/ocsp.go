@@ -0,0 +1,480 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+
+	"github.com/mendersoftware/openssl"
+)
+
+// OCSP modes for the server certificate, as given in the OCSPMode setting.
+const (
+	OCSPModeOff      = "off"
+	OCSPModeSoftFail = "soft-fail"
+	OCSPModeHardFail = "hard-fail"
+)
+
+var (
+	// ErrCertificateRevoked is returned when the OCSP responder reports the
+	// server certificate as revoked.
+	ErrCertificateRevoked = errors.New("the server certificate has been revoked")
+
+	// How long to wait for the OCSP responder.
+	ocspRequestTimeout = 10 * time.Second
+	// How long a response is cached if it doesn't say when the next
+	// update is due.
+	ocspDefaultCacheTime = time.Hour
+	// Allowed clock difference between the device and the responder.
+	ocspClockSkew = 5 * time.Minute
+
+	ocspCache = struct {
+		sync.Mutex
+		entries map[string]ocspCacheEntry
+	}{entries: map[string]ocspCacheEntry{}}
+)
+
+type ocspCacheEntry struct {
+	revoked bool
+	expires time.Time
+}
+
+func validateOCSPMode(mode string) error {
+	switch mode {
+	case "", OCSPModeOff, OCSPModeSoftFail, OCSPModeHardFail:
+		return nil
+	default:
+		return errors.Errorf("unsupported OCSPMode %q, must be one of %q, %q or %q",
+			mode, OCSPModeOff, OCSPModeSoftFail, OCSPModeHardFail)
+	}
+}
+
+// ASN.1 structures from RFC 6960.
+
+var (
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+var ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+func newOCSPCertID(cert, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, errors.Wrap(err, "failed to parse the issuer public key")
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSHA1,
+			Parameters: asn1.RawValue{Tag: asn1.TagNull},
+		},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+func (id ocspCertID) matches(other ocspCertID) bool {
+	return id.HashAlgorithm.Algorithm.Equal(other.HashAlgorithm.Algorithm) &&
+		bytes.Equal(id.NameHash, other.NameHash) &&
+		bytes.Equal(id.IssuerKeyHash, other.IssuerKeyHash) &&
+		id.SerialNumber.Cmp(other.SerialNumber) == 0
+}
+
+// parseOCSPResponse checks the response from the responder, and returns
+// whether the certificate is revoked, and until when the answer is valid.
+func parseOCSPResponse(
+	der []byte,
+	id ocspCertID,
+	issuer *x509.Certificate,
+) (revoked bool, expires time.Time, err error) {
+	var rsp ocspResponse
+	if _, err = asn1.Unmarshal(der, &rsp); err != nil {
+		return false, expires, errors.Wrap(err, "malformed OCSP response")
+	}
+	if rsp.Status != 0 {
+		return false, expires, errors.Errorf("OCSP responder returned status %d",
+			rsp.Status)
+	}
+	if !rsp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return false, expires, errors.New("unsupported OCSP response type")
+	}
+	var basic ocspBasicResponse
+	if _, err = asn1.Unmarshal(rsp.Response.Response, &basic); err != nil {
+		return false, expires, errors.Wrap(err, "malformed OCSP response")
+	}
+
+	if err = checkOCSPSignature(&basic, issuer); err != nil {
+		return false, expires, err
+	}
+
+	now := time.Now()
+	for _, single := range basic.TBSResponseData.Responses {
+		if !id.matches(single.CertID) {
+			continue
+		}
+		if single.ThisUpdate.After(now.Add(ocspClockSkew)) {
+			return false, expires, errors.New("OCSP response is not yet valid")
+		}
+		expires = now.Add(ocspDefaultCacheTime)
+		if !single.NextUpdate.IsZero() {
+			if single.NextUpdate.Before(now.Add(-ocspClockSkew)) {
+				return false, expires, errors.New("OCSP response has expired")
+			}
+			expires = single.NextUpdate
+		}
+		switch {
+		case bool(single.Good):
+			return false, expires, nil
+		case bool(single.Unknown):
+			return false, expires, errors.New(
+				"OCSP responder does not know the certificate")
+		default:
+			return true, expires, nil
+		}
+	}
+	return false, expires, errors.New("OCSP response does not cover the certificate")
+}
+
+// checkOCSPSignature verifies that the response is signed either by the
+// issuer itself, or by a responder certificate the issuer has delegated
+// OCSP signing to.
+func checkOCSPSignature(basic *ocspBasicResponse, issuer *x509.Certificate) error {
+	algorithm, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return errors.Errorf("unsupported OCSP signature algorithm %s",
+			basic.SignatureAlgorithm.Algorithm)
+	}
+	tbs := basic.TBSResponseData.Raw
+	signature := basic.Signature.RightAlign()
+
+	if issuer.CheckSignature(algorithm, tbs, signature) == nil {
+		return nil
+	}
+	for _, raw := range basic.Certificates {
+		responder, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			continue
+		}
+		if responder.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		delegated := false
+		for _, usage := range responder.ExtKeyUsage {
+			if usage == x509.ExtKeyUsageOCSPSigning {
+				delegated = true
+			}
+		}
+		if delegated && responder.CheckSignature(algorithm, tbs, signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("OCSP response is not signed by the certificate issuer")
+}
+
+// queryOCSP asks the responders given in the certificate about its status.
+func queryOCSP(
+	config *conf.HttpConfig,
+	cert, issuer *x509.Certificate,
+) (revoked bool, expires time.Time, err error) {
+	if len(cert.OCSPServer) == 0 {
+		return false, expires, errors.New(
+			"the server certificate does not name an OCSP responder")
+	}
+	id, err := newOCSPCertID(cert, issuer)
+	if err != nil {
+		return false, expires, err
+	}
+	body, err := asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspSingleRequest{{Cert: id}},
+		},
+	})
+	if err != nil {
+		return false, expires, err
+	}
+
+	client := http.Client{
+		Timeout: ocspRequestTimeout,
+		Transport: &http.Transport{
+			Proxy: newProxyFunc(config, false),
+		},
+	}
+	for _, responder := range cert.OCSPServer {
+		var rsp *http.Response
+		rsp, err = client.Post(responder, "application/ocsp-request",
+			bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		var der []byte
+		der, err = ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			continue
+		}
+		if rsp.StatusCode != http.StatusOK {
+			err = errors.Errorf("OCSP responder %s returned %s", responder, rsp.Status)
+			continue
+		}
+		revoked, expires, err = parseOCSPResponse(der, id, issuer)
+		if err == nil {
+			return revoked, expires, nil
+		}
+	}
+	return false, expires, errors.Wrap(err, "OCSP check failed")
+}
+
+func toX509Certificate(cert *openssl.Certificate) (*x509.Certificate, error) {
+	pemBytes, err := cert.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("failed to decode certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// peerCertificates returns the certificate chain sent by the server, starting
+// with the server's own certificate.
+func peerCertificates(conn *openssl.Conn) ([]*x509.Certificate, error) {
+	chain, err := conn.PeerCertificateChain()
+	if err == nil && len(chain) == 0 {
+		err = errors.New("no server certificate")
+	}
+	var certs []*x509.Certificate
+	for _, c := range chain {
+		if err != nil {
+			break
+		}
+		var cert *x509.Certificate
+		cert, err = toX509Certificate(c)
+		certs = append(certs, cert)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the server certificate")
+	}
+	return certs, nil
+}
+
+// findIssuer looks for the certificate which signed cert, first in the
+// chain sent by the server, then in the configured server certificates.
+func findIssuer(
+	cert *x509.Certificate,
+	chain []*x509.Certificate,
+	serverCert string,
+) (*x509.Certificate, error) {
+	candidates := chain
+	if serverCert != "" {
+		candidates = append(candidates, loadServerCertificates(serverCert)...)
+	}
+	for _, candidate := range candidates {
+		if cert.CheckSignatureFrom(candidate) == nil {
+			return candidate, nil
+		}
+	}
+	return nil, errors.Errorf("the issuer of the server certificate %q was not found",
+		cert.Subject)
+}
+
+// loadServerCertificates parses the certificates in certPath, which is either
+// a PEM file or a directory of such files, the same as for the trusted
+// certificates of the TLS connection in loadVerifyLocations. Files which
+// can't be read are skipped, as they are there.
+func loadServerCertificates(certPath string) []*x509.Certificate {
+	files := []string{certPath}
+	if info, err := os.Stat(certPath); err == nil && info.IsDir() {
+		entries, err := ioutil.ReadDir(certPath)
+		if err != nil {
+			log.Debugf("Failed to read the server certificate directory %s: %s",
+				certPath, err.Error())
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			filePath := path.Join(certPath, entry.Name())
+			// Need to re-stat here because ReadDir does not resolve
+			// symlinks.
+			if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+				files = append(files, filePath)
+			}
+		}
+	}
+
+	var certs []*x509.Certificate
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Debugf("Failed to read the server certificate %s: %s", file, err.Error())
+			continue
+		}
+		for _, pemData := range openssl.SplitPEM(data) {
+			block, _ := pem.Decode(pemData)
+			if block == nil {
+				continue
+			}
+			if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, c)
+			}
+		}
+	}
+	return certs
+}
+
+// checkOCSP checks the revocation status of the server certificate. A
+// revoked certificate is always an error. Any other failure to get a
+// definite answer is only an error in hard-fail mode.
+func checkOCSP(conn *openssl.Conn, config *conf.HttpConfig) error {
+	mode := config.Security.OCSPMode
+
+	certs, err := peerCertificates(conn)
+	if err != nil {
+		return ocspFailure(mode, err)
+	}
+	leaf := certs[0]
+	// Without the issuer the certificate can't be identified to the
+	// responder, and the chain doesn't match the configured certificates,
+	// so this is an error in any mode.
+	issuer, err := findIssuer(leaf, certs[1:], config.ServerCert)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	h.Write(issuer.Raw)
+	h.Write(leaf.SerialNumber.Bytes())
+	key := string(h.Sum(nil))
+
+	ocspCache.Lock()
+	entry, found := ocspCache.entries[key]
+	ocspCache.Unlock()
+	if !found || time.Now().After(entry.expires) {
+		revoked, expires, err := queryOCSP(config, leaf, issuer)
+		if err != nil {
+			return ocspFailure(mode, err)
+		}
+		entry = ocspCacheEntry{revoked: revoked, expires: expires}
+		ocspCache.Lock()
+		ocspCache.entries[key] = entry
+		ocspCache.Unlock()
+	}
+	if entry.revoked {
+		return ErrCertificateRevoked
+	}
+	return nil
+}
+
+func ocspFailure(mode string, err error) error {
+	if mode == OCSPModeHardFail {
+		return err
+	}
+	log.Warnf("Could not check the revocation status of the server certificate, "+
+		"continuing: %s", err.Error())
+	return nil
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	ocspTestGood = iota
+	ocspTestRevoked
+	ocspTestUnknown
+	ocspTestUnavailable
+)
+
+// ocspTestResponse builds a response signed by the CA for the certificate
+// in the request.
+func ocspTestResponse(
+	t *testing.T,
+	request []byte,
+	status int,
+	ca *x509.Certificate,
+	caKey *ecdsa.PrivateKey,
+) []byte {
+	var req ocspRequest
+	_, err := asn1.Unmarshal(request, &req)
+	require.NoError(t, err)
+	require.Len(t, req.TBSRequest.RequestList, 1)
+
+	now := time.Now()
+	single := ocspSingleResponse{
+		CertID:     req.TBSRequest.RequestList[0].Cert,
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(time.Hour),
+	}
+	switch status {
+	case ocspTestGood:
+		single.Good = true
+	case ocspTestRevoked:
+		single.Revoked = ocspRevokedInfo{RevocationTime: now.Add(-time.Hour)}
+	case ocspTestUnknown:
+		single.Unknown = true
+	}
+
+	keyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+	responderID, err := asn1.Marshal(keyHash[:])
+	require.NoError(t, err)
+	tbs, err := asn1.Marshal(ocspResponseData{
+		RawResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        2,
+			IsCompound: true,
+			Bytes:      responderID,
+		},
+		ProducedAt: now,
+		Responses:  []ocspSingleResponse{single},
+	})
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(tbs)
+	signature, err := ecdsa.SignASN1(rand.Reader, caKey, digest[:])
+	require.NoError(t, err)
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData: ocspResponseData{Raw: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2},
+		},
+		Signature: asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	require.NoError(t, err)
+
+	rsp, err := asn1.Marshal(ocspResponse{
+		Response: ocspResponseBytes{
+			ResponseType: oidOCSPBasicResponse,
+			Response:     basic,
+		},
+	})
+	require.NoError(t, err)
+	return rsp
+}
+
+func TestOCSPCheck(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate,
+		&caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	var status int
+	responder := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status == ocspTestUnavailable {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.Write(ocspTestResponse(t, body, status, ca, caKey))
+		}))
+	defer responder.Close()
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{responder.URL},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &leafTemplate, ca,
+		&leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  leafKey,
+		}},
+		NextProtos: []string{"http/1.1"},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tdir, err := ioutil.TempDir("", "TestOCSPCheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	caFile := path.Join(tdir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+
+	tests := map[string]struct {
+		mode    string
+		status  int
+		success bool
+		err     string
+	}{
+		"off, revoked": {
+			mode:    OCSPModeOff,
+			status:  ocspTestRevoked,
+			success: true,
+		},
+		"soft-fail, good": {
+			mode:    OCSPModeSoftFail,
+			status:  ocspTestGood,
+			success: true,
+		},
+		"soft-fail, revoked": {
+			mode:   OCSPModeSoftFail,
+			status: ocspTestRevoked,
+			err:    "revoked",
+		},
+		"soft-fail, unknown": {
+			mode:    OCSPModeSoftFail,
+			status:  ocspTestUnknown,
+			success: true,
+		},
+		"soft-fail, unavailable": {
+			mode:    OCSPModeSoftFail,
+			status:  ocspTestUnavailable,
+			success: true,
+		},
+		"hard-fail, good": {
+			mode:    OCSPModeHardFail,
+			status:  ocspTestGood,
+			success: true,
+		},
+		"hard-fail, revoked": {
+			mode:   OCSPModeHardFail,
+			status: ocspTestRevoked,
+			err:    "revoked",
+		},
+		"hard-fail, unknown": {
+			mode:   OCSPModeHardFail,
+			status: ocspTestUnknown,
+			err:    "does not know the certificate",
+		},
+		"hard-fail, unavailable": {
+			mode:   OCSPModeHardFail,
+			status: ocspTestUnavailable,
+			err:    "503",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ocspCache.Lock()
+			ocspCache.entries = map[string]ocspCacheEntry{}
+			ocspCache.Unlock()
+			status = test.status
+
+			cl, err := NewApiClient(conf.HttpConfig{
+				ServerCert: caFile,
+				Security:   &conf.Security{OCSPMode: test.mode},
+			})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			rsp, err := cl.Do(req)
+			if test.success {
+				require.NoError(t, err)
+				rsp.Body.Close()
+				assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+
+	// The issuer is found in a directory of server certificates too, when
+	// the server doesn't send it.
+	leafOnly := httptest.NewUnstartedServer(ts.Config.Handler)
+	leafOnly.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafDER},
+			PrivateKey:  leafKey,
+		}},
+		NextProtos: []string{"http/1.1"},
+	}
+	leafOnly.StartTLS()
+	defer leafOnly.Close()
+	caDir := path.Join(tdir, "ca.d")
+	require.NoError(t, os.Mkdir(caDir, 0700))
+	require.NoError(t, os.Rename(caFile, path.Join(caDir, "ca.crt")))
+	for _, status = range []int{ocspTestGood, ocspTestRevoked} {
+		ocspCache.Lock()
+		ocspCache.entries = map[string]ocspCacheEntry{}
+		ocspCache.Unlock()
+
+		cl, err := NewApiClient(conf.HttpConfig{
+			ServerCert: caDir,
+			Security:   &conf.Security{OCSPMode: OCSPModeHardFail},
+		})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, leafOnly.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(req)
+		if status == ocspTestGood {
+			require.NoError(t, err)
+			rsp.Body.Close()
+		} else {
+			assert.ErrorIs(t, err, ErrCertificateRevoked)
+		}
+	}
+
+	_, err = NewApiClient(conf.HttpConfig{
+		ServerCert: caDir,
+		Security:   &conf.Security{OCSPMode: "sometimes"},
+	})
+	assert.Error(t, err)
+}
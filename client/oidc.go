@@ -0,0 +1,203 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Errors of the token endpoint, during the device authorization grant, RFC
+// 8628, section 3.5.
+var (
+	ErrOIDCAuthorizationPending = errors.New("the device is not approved yet")
+	ErrOIDCSlowDown             = errors.New("polling the provider too often")
+	ErrOIDCAccessDenied         = errors.New("the device was not approved")
+	ErrOIDCExpiredToken         = errors.New("the device code has expired")
+	ErrOIDCInvalidGrant         = errors.New("the refresh token is not valid anymore")
+)
+
+// OIDCProvider is an OpenID Connect provider, which supports the device
+// authorization grant.
+type OIDCProvider struct {
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+}
+
+// OIDCDeviceAuthorization is what the provider responds when the device
+// authorization is started. The user code needs to be entered at the
+// verification URI to approve the device.
+type OIDCDeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// OIDCTokens are the tokens issued by the provider.
+type OIDCTokens struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type oidcError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// DiscoverOIDCProvider reads the endpoints of the provider from its
+// configuration document.
+func DiscoverOIDCProvider(api ApiRequester, issuer string) (*OIDCProvider, error) {
+	req, err := http.NewRequest(http.MethodGet,
+		strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OIDC discovery request")
+	}
+	rsp, err := api.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "OIDC discovery request failed")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, NewAPIError(errors.Errorf("OIDC discovery failed with status %d",
+			rsp.StatusCode), rsp)
+	}
+	var provider OIDCProvider
+	if err = json.NewDecoder(rsp.Body).Decode(&provider); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the OIDC provider configuration")
+	}
+	if provider.DeviceAuthorizationEndpoint == "" || provider.TokenEndpoint == "" {
+		return nil, errors.New("the OIDC provider doesn't support the device authorization grant")
+	}
+	return &provider, nil
+}
+
+// postForm posts the form, and decodes the JSON response into result. Error
+// responses of the token endpoint, RFC 6749 section 5.2, are returned as
+// the matching error.
+func (p *OIDCProvider) postForm(
+	api ApiRequester,
+	endpoint string,
+	form url.Values,
+	result interface{},
+) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to create OIDC request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	rsp, err := api.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "OIDC request failed")
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read OIDC response")
+	}
+
+	if rsp.StatusCode == http.StatusOK {
+		if err = json.Unmarshal(body, result); err != nil {
+			return errors.Wrap(err, "failed to parse OIDC response")
+		}
+		return nil
+	}
+
+	var oidcErr oidcError
+	if json.Unmarshal(body, &oidcErr) != nil || oidcErr.Error == "" {
+		return NewAPIError(errors.Errorf("OIDC request failed with status %d",
+			rsp.StatusCode), rsp)
+	}
+	switch oidcErr.Error {
+	case "authorization_pending":
+		return ErrOIDCAuthorizationPending
+	case "slow_down":
+		return ErrOIDCSlowDown
+	case "access_denied":
+		return ErrOIDCAccessDenied
+	case "expired_token":
+		return ErrOIDCExpiredToken
+	case "invalid_grant":
+		return ErrOIDCInvalidGrant
+	}
+	if oidcErr.Description != "" {
+		return errors.Errorf("OIDC request failed: %s: %s", oidcErr.Error,
+			oidcErr.Description)
+	}
+	return errors.Errorf("OIDC request failed: %s", oidcErr.Error)
+}
+
+// StartDeviceAuthorization starts the device authorization grant.
+func (p *OIDCProvider) StartDeviceAuthorization(
+	api ApiRequester,
+	clientID, scope string,
+) (*OIDCDeviceAuthorization, error) {
+	var auth OIDCDeviceAuthorization
+	err := p.postForm(api, p.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {clientID},
+		"scope":     {scope},
+	}, &auth)
+	if err != nil {
+		return nil, err
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" {
+		return nil, errors.New("the OIDC provider returned no device code")
+	}
+	return &auth, nil
+}
+
+// PollDeviceToken asks for the tokens once, which fails with
+// ErrOIDCAuthorizationPending until the device has been approved.
+func (p *OIDCProvider) PollDeviceToken(
+	api ApiRequester,
+	clientID, deviceCode string,
+) (*OIDCTokens, error) {
+	var tokens OIDCTokens
+	err := p.postForm(api, p.TokenEndpoint, url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"client_id":   {clientID},
+		"device_code": {deviceCode},
+	}, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+// RefreshTokens gets new tokens with the refresh token.
+func (p *OIDCProvider) RefreshTokens(
+	api ApiRequester,
+	clientID, refreshToken string,
+) (*OIDCTokens, error) {
+	var tokens OIDCTokens
+	err := p.postForm(api, p.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {clientID},
+		"refresh_token": {refreshToken},
+	}, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultDownloadChunkSize int64 = 4 * 1024 * 1024
+
+// downloadChunk is a range of the artifact, fetched over its own connection.
+type downloadChunk struct {
+	start int64
+	data  []byte
+	err   error
+	done  chan struct{}
+}
+
+// ParallelDownload fetches an artifact as consecutive ranges over several
+// connections at once, and returns the ranges in order, so that the artifact
+// reader sees, and verifies the checksums of, the same stream as with a
+// single connection. At most one chunk per connection is held in memory.
+type ParallelDownload struct {
+	api         ApiRequester
+	url         string
+	validator   string
+	size        int64
+	chunkSize   int64
+	connections int
+	maxWait     time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	first   io.ReadCloser
+	pending []*downloadChunk
+	next    int64
+	current []byte
+}
+
+// newParallelDownload returns nil if the response to the initial request
+// doesn't allow a parallel download, in which case its body should be read
+// as usual. The body is used for the first chunk.
+func newParallelDownload(
+	ctx context.Context,
+	api ApiRequester,
+	url string,
+	rsp *http.Response,
+	connections int,
+	chunkSize int64,
+	maxWait time.Duration,
+) *ParallelDownload {
+	if chunkSize <= 0 {
+		chunkSize = defaultDownloadChunkSize
+	}
+	if connections < 2 || rsp.Header.Get("Accept-Ranges") != "bytes" ||
+		rsp.ContentLength < 2*chunkSize {
+		return nil
+	}
+	// Without a validator a replaced artifact would go unnoticed, and be
+	// spliced together with the old one.
+	validator := rsp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = rsp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		return nil
+	}
+
+	log.Infof("Downloading the artifact over %d connections", connections)
+	ctx, cancel := context.WithCancel(ctx)
+	d := &ParallelDownload{
+		api:         api,
+		url:         url,
+		validator:   validator,
+		size:        rsp.ContentLength,
+		chunkSize:   chunkSize,
+		connections: connections,
+		maxWait:     maxWait,
+		ctx:         ctx,
+		cancel:      cancel,
+		first:       rsp.Body,
+	}
+	d.schedule()
+	return d
+}
+
+// schedule starts fetching chunks until there is one per connection.
+func (d *ParallelDownload) schedule() {
+	for len(d.pending) < d.connections && d.next < d.size {
+		size := d.chunkSize
+		if d.next+size > d.size {
+			size = d.size - d.next
+		}
+		c := &downloadChunk{
+			start: d.next,
+			data:  make([]byte, size),
+			done:  make(chan struct{}),
+		}
+		var body io.ReadCloser
+		if c.start == 0 {
+			body = d.first
+		}
+		go d.fetch(c, body)
+		d.pending = append(d.pending, c)
+		d.next += size
+	}
+}
+
+// fetch fills the chunk, starting with body if it's not nil, and resuming
+// with range requests when the connection breaks.
+func (d *ParallelDownload) fetch(c *downloadChunk, body io.ReadCloser) {
+	defer close(c.done)
+	var got int
+	for attempt := 0; ; attempt++ {
+		var err error
+		if body == nil {
+			body, err = d.request(c.start+int64(got), c.start+int64(len(c.data))-1)
+		}
+		if err == nil {
+			var n int
+			n, err = io.ReadFull(body, c.data[got:])
+			got += n
+			body.Close()
+			body = nil
+			if err == nil {
+				return
+			}
+		}
+		if err == ErrArtifactChanged || d.ctx.Err() != nil {
+			c.err = err
+			return
+		}
+
+		log.Errorf("Download of bytes %d-%d failed: %s",
+			c.start, c.start+int64(len(c.data))-1, err.Error())
+		wait, backoffErr := GetExponentialBackoffTime(attempt, d.maxWait, 0)
+		if backoffErr != nil {
+			c.err = errors.Wrap(err, "Cannot resume download")
+			return
+		}
+		recordRetry(metricsRetryDownloadResume)
+		select {
+		case <-time.After(wait):
+		case <-d.ctx.Done():
+			c.err = d.ctx.Err()
+			return
+		}
+	}
+}
+
+// request fetches the bytes from start to end, inclusive.
+func (d *ParallelDownload) request(start, end int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = withRequestKind(req, requestKindDownload)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Set("If-Range", d.validator)
+	rsp, err := d.api.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode == http.StatusOK {
+		// The server ignored the range because the If-Range
+		// condition did not hold.
+		rsp.Body.Close()
+		return nil, ErrArtifactChanged
+	} else if rsp.StatusCode != http.StatusPartialContent {
+		rsp.Body.Close()
+		return nil, errors.Errorf("Could not download bytes %d-%d. HTTP status code: %s",
+			start, end, rsp.Status)
+	}
+	contentRange := fmt.Sprintf("bytes %d-%d/", start, end)
+	if got := rsp.Header.Get("Content-Range"); got != contentRange+strconv.FormatInt(d.size, 10) &&
+		got != contentRange+"*" {
+		rsp.Body.Close()
+		return nil, errors.Errorf("HTTP server did not return the expected range. "+
+			"Expected '%s%d', got '%s'", contentRange, d.size, got)
+	}
+	return rsp.Body, nil
+}
+
+func (d *ParallelDownload) Read(buf []byte) (int, error) {
+	for len(d.current) == 0 {
+		if len(d.pending) == 0 {
+			return 0, io.EOF
+		}
+		c := d.pending[0]
+		<-c.done
+		if c.err != nil {
+			return 0, c.err
+		}
+		d.pending = d.pending[1:]
+		d.current = c.data
+		d.schedule()
+	}
+	n := copy(buf, d.current)
+	d.current = d.current[n:]
+	return n, nil
+}
+
+// Close stops the chunks still being fetched.
+func (d *ParallelDownload) Close() error {
+	d.cancel()
+	// Not bound to the context, and may still be read by the first chunk.
+	d.first.Close()
+	for _, c := range d.pending {
+		<-c.done
+	}
+	d.pending = nil
+	return nil
+}
+
+func (d *ParallelDownload) RangeValidator() string {
+	return d.validator
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestParallelDownload(t *testing.T) {
+	ConfigureExponentialBackoff(time.Millisecond, 0)
+	defer ConfigureExponentialBackoff(time.Minute, 0)
+
+	artifact := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(artifact)
+
+	var lock sync.Mutex
+	var ranges, failures int
+	etag := `"v1"`
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			if r.Header.Get("Range") != "" {
+				ranges++
+				if failures > 0 {
+					failures--
+					lock.Unlock()
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+			}
+			w.Header().Set("ETag", etag)
+			lock.Unlock()
+			http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(artifact))
+		}))
+	defer ts.Close()
+
+	api, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	updater := NewUpdate()
+	updater.DownloadConnections = 4
+	updater.DownloadChunkSize = 8192
+
+	t.Run("in order", func(t *testing.T) {
+		ranges, failures = 0, 2
+		stream, size, err := updater.FetchUpdate(context.Background(), api, ts.URL, time.Second)
+		require.NoError(t, err)
+		assert.IsType(t, &ParallelDownload{}, stream)
+		assert.Equal(t, int64(len(artifact)), size)
+		data, err := ioutil.ReadAll(stream)
+		require.NoError(t, err)
+		assert.NoError(t, stream.Close())
+		assert.Equal(t, artifact, data)
+		// Every chunk but the first, plus the failed attempts.
+		assert.Equal(t, 12+2, ranges)
+	})
+
+	t.Run("artifact changed", func(t *testing.T) {
+		stream, _, err := updater.FetchUpdate(context.Background(), api, ts.URL, time.Second)
+		require.NoError(t, err)
+		lock.Lock()
+		etag = `"v2"`
+		lock.Unlock()
+		_, err = ioutil.ReadAll(stream)
+		assert.Equal(t, ErrArtifactChanged, err)
+		assert.NoError(t, stream.Close())
+	})
+
+	t.Run("single connection", func(t *testing.T) {
+		updater := NewUpdate()
+		stream, _, err := updater.FetchUpdate(context.Background(), api, ts.URL, time.Second)
+		require.NoError(t, err)
+		assert.IsType(t, &UpdateResumer{}, stream)
+		stream.Close()
+	})
+}
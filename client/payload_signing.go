@@ -0,0 +1,56 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Header with the base64 encoded signature of the body, by the device key,
+// of inventory submissions and deployment status reports.
+const PayloadSignatureHeader = "X-Mender-Payload-Signature"
+
+// PayloadSigner signs a payload with the device key, the same way as auth
+// requests are signed.
+type PayloadSigner func(payload []byte) ([]byte, error)
+
+// SetPayloadSigner has inventory submissions and deployment status reports
+// signed, so that the server, or something along the way, can verify that
+// they come from the device even where TLS is terminated by a proxy which
+// isn't trusted. Nil stops the signing.
+func (c *ApiClient) SetPayloadSigner(signer PayloadSigner) {
+	c.payloadSigner = signer
+}
+
+// signPayload adds the signature of the body, as it is sent, to the request.
+func (c *ApiClient) signPayload(req *http.Request) error {
+	switch getRequestKind(req) {
+	case requestKindInventory, requestKindStatus:
+	default:
+		return nil
+	}
+	body, err := readRequestBody(req)
+	if err != nil {
+		return err
+	}
+	sig, err := c.payloadSigner(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign the request body")
+	}
+	req.Header.Set(PayloadSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
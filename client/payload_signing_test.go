@@ -0,0 +1,91 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestPayloadSigning(t *testing.T) {
+	var body []byte
+	var signature string
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			signature = r.Header.Get(PayloadSignatureHeader)
+			switch r.Method {
+			case http.MethodPut:
+				if r.URL.Path == "/api/devices/v1/inventory/device/attributes" {
+					w.WriteHeader(http.StatusOK)
+				} else {
+					w.WriteHeader(http.StatusNoContent)
+				}
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+	defer ts.Close()
+
+	// Stands in for the device key.
+	sign := func(payload []byte) ([]byte, error) {
+		sum := sha256.Sum256(payload)
+		return sum[:], nil
+	}
+	verify := func() {
+		sum := sha256.Sum256(body)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), signature)
+	}
+
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	cl.SetPayloadSigner(sign)
+
+	err = NewInventory().Submit(cl, ts.URL, InventoryData{{Name: "foo", Value: "bar"}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, body)
+	verify()
+
+	err = NewStatus().Report(cl, ts.URL, StatusReport{
+		DeploymentID: "deployment",
+		Status:       StatusSuccess,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, string(body), StatusSuccess)
+	verify()
+
+	// Other requests aren't signed.
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	rsp, err := cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Empty(t, signature)
+
+	cl.SetPayloadSigner(nil)
+	err = NewInventory().Submit(cl, ts.URL, InventoryData{{Name: "foo", Value: "baz"}})
+	require.NoError(t, err)
+	assert.Empty(t, signature)
+}
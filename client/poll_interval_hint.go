@@ -0,0 +1,92 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PollIntervalHintHeader is sent by the server on update check and inventory
+// responses, with the number of seconds the device should wait before the
+// next poll of the same kind. Lets the server slow down, or speed up, the
+// polling of the whole fleet.
+const PollIntervalHintHeader = "X-Mender-Poll-Interval"
+
+// pollIntervalHints holds the latest hint for each kind of poll. Shared by
+// all clients.
+var pollIntervalHints = struct {
+	sync.Mutex
+	hints map[requestKind]time.Duration
+}{hints: map[requestKind]time.Duration{}}
+
+// recordPollIntervalHint remembers the poll interval hint in an update check
+// or inventory response. A successful response without a hint drops the
+// last hint, so that the device returns to its own interval.
+func recordPollIntervalHint(kind requestKind, rsp *http.Response) {
+	if kind != requestKindUpdateCheck && kind != requestKindInventory {
+		return
+	}
+	var hint time.Duration
+	if value := strings.TrimSpace(rsp.Header.Get(PollIntervalHintHeader)); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			log.Warnf("Ignoring invalid %s header: %q", PollIntervalHintHeader, value)
+			return
+		}
+		hint = time.Duration(seconds) * time.Second
+	} else if rsp.StatusCode >= 300 && rsp.StatusCode != http.StatusNotModified {
+		return
+	}
+
+	poll := "update check"
+	if kind == requestKindInventory {
+		poll = "inventory"
+	}
+	pollIntervalHints.Lock()
+	defer pollIntervalHints.Unlock()
+	if pollIntervalHints.hints[kind] == hint {
+		return
+	}
+	if hint > 0 {
+		log.Infof("The server asks for an %s interval of %s", poll, hint)
+		pollIntervalHints.hints[kind] = hint
+	} else {
+		log.Infof("The server no longer asks for an %s interval", poll)
+		delete(pollIntervalHints.hints, kind)
+	}
+}
+
+func pollIntervalHint(kind requestKind) time.Duration {
+	pollIntervalHints.Lock()
+	defer pollIntervalHints.Unlock()
+	return pollIntervalHints.hints[kind]
+}
+
+// UpdatePollIntervalHint returns the update check interval last asked for by
+// the server, or zero if none.
+func UpdatePollIntervalHint() time.Duration {
+	return pollIntervalHint(requestKindUpdateCheck)
+}
+
+// InventoryPollIntervalHint returns the inventory submission interval last
+// asked for by the server, or zero if none.
+func InventoryPollIntervalHint() time.Duration {
+	return pollIntervalHint(requestKindInventory)
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestPollIntervalHint(t *testing.T) {
+	var status int
+	var hint string
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hint != "" {
+				w.Header().Set(PollIntervalHintHeader, hint)
+			}
+			w.WriteHeader(status)
+		}))
+	defer ts.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	send := func(kind requestKind, rspStatus int, rspHint string) {
+		status = rspStatus
+		hint = rspHint
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(withRequestKind(req, kind))
+		require.NoError(t, err)
+		rsp.Body.Close()
+	}
+
+	send(requestKindUpdateCheck, http.StatusNoContent, "3600")
+	assert.Equal(t, time.Hour, UpdatePollIntervalHint())
+	assert.Zero(t, InventoryPollIntervalHint())
+
+	send(requestKindInventory, http.StatusOK, "600")
+	assert.Equal(t, 10*time.Minute, InventoryPollIntervalHint())
+	assert.Equal(t, time.Hour, UpdatePollIntervalHint())
+
+	// Other kinds of requests don't carry hints.
+	send(requestKindStatus, http.StatusNoContent, "60")
+	assert.Equal(t, time.Hour, UpdatePollIntervalHint())
+
+	// Invalid hints, and failures without a hint, keep the last hint.
+	send(requestKindUpdateCheck, http.StatusNoContent, "soon")
+	assert.Equal(t, time.Hour, UpdatePollIntervalHint())
+	send(requestKindUpdateCheck, http.StatusNoContent, "-60")
+	assert.Equal(t, time.Hour, UpdatePollIntervalHint())
+	send(requestKindUpdateCheck, http.StatusServiceUnavailable, "")
+	assert.Equal(t, time.Hour, UpdatePollIntervalHint())
+
+	// A failure may still carry a hint, e.g. during maintenance.
+	send(requestKindUpdateCheck, http.StatusServiceUnavailable, "7200")
+	assert.Equal(t, 2*time.Hour, UpdatePollIntervalHint())
+
+	// A successful response without a hint drops it.
+	send(requestKindUpdateCheck, http.StatusNotModified, "")
+	assert.Zero(t, UpdatePollIntervalHint())
+	send(requestKindInventory, http.StatusOK, "")
+	assert.Zero(t, InventoryPollIntervalHint())
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// RateLimitedReader caps the rate at which data can be read from the
+// underlying stream, so that an artifact download does not use up all the
+// bandwidth available to the device.
+type RateLimitedReader struct {
+	stream         io.ReadCloser
+	bytesPerSecond int64
+	start          time.Time
+	bytesRead      int64
+}
+
+func NewRateLimitedReader(stream io.ReadCloser, bytesPerSecond int64) *RateLimitedReader {
+	return &RateLimitedReader{
+		stream:         stream,
+		bytesPerSecond: bytesPerSecond,
+	}
+}
+
+func (r *RateLimitedReader) Read(buf []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	// Never read more than a second worth of data at a time, to keep the
+	// rate smooth.
+	if int64(len(buf)) > r.bytesPerSecond {
+		buf = buf[:r.bytesPerSecond]
+	}
+	n, err := r.stream.Read(buf)
+	r.bytesRead += int64(n)
+
+	expected := time.Duration(r.bytesRead) * time.Second / time.Duration(r.bytesPerSecond)
+	if wait := expected - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+func (r *RateLimitedReader) Close() error {
+	return r.stream.Close()
+}
+
+// apiRateLimiter is a token bucket, which limits the rate of the requests
+// made to the server, so that a misconfigured fleet can't overload it.
+type apiRateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+type apiRateLimiterKey struct {
+	rate  float64
+	burst int
+}
+
+// Shared by all clients with the same limits, so that together they stay
+// within them.
+var apiRateLimiters = struct {
+	sync.Mutex
+	limiters map[apiRateLimiterKey]*apiRateLimiter
+}{limiters: map[apiRateLimiterKey]*apiRateLimiter{}}
+
+// getAPIRateLimiter returns nil if no limit is configured.
+func getAPIRateLimiter(connectivity *conf.Connectivity) *apiRateLimiter {
+	if connectivity == nil || connectivity.APIRequestsPerSecond <= 0 {
+		return nil
+	}
+	key := apiRateLimiterKey{
+		rate:  connectivity.APIRequestsPerSecond,
+		burst: connectivity.APIRequestBurst,
+	}
+	if key.burst <= 0 {
+		key.burst = int(math.Ceil(key.rate))
+	}
+
+	apiRateLimiters.Lock()
+	defer apiRateLimiters.Unlock()
+	if l, ok := apiRateLimiters.limiters[key]; ok {
+		return l
+	}
+	l := &apiRateLimiter{
+		rate:   key.rate,
+		burst:  float64(key.burst),
+		tokens: float64(key.burst),
+		last:   time.Now(),
+	}
+	apiRateLimiters.limiters[key] = l
+	return l
+}
+
+// wait takes a token from the bucket, waiting for one if it's empty, or
+// until the context is done.
+func (l *apiRateLimiter) wait(ctx context.Context) error {
+	for {
+		l.lock.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.lock.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.lock.Unlock()
+
+		log.Debugf("API request rate limit reached, waiting %s", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
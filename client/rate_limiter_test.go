@@ -0,0 +1,93 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestRateLimitedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("mender"), 2048)
+	reader := NewRateLimitedReader(ioutil.NopCloser(bytes.NewReader(data)), 16*1024)
+
+	start := time.Now()
+	actual, err := ioutil.ReadAll(reader)
+	elapsed := time.Since(start)
+
+	require.NoError(t, err)
+	assert.Equal(t, data, actual)
+	// 12 KiB at 16 KiB/s.
+	assert.GreaterOrEqual(t, elapsed, 700*time.Millisecond)
+	assert.Less(t, elapsed, 3*time.Second)
+	assert.NoError(t, reader.Close())
+}
+
+func TestAPIRateLimiter(t *testing.T) {
+	apiRateLimiters.Lock()
+	apiRateLimiters.limiters = map[apiRateLimiterKey]*apiRateLimiter{}
+	apiRateLimiters.Unlock()
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer ts.Close()
+
+	connectivity := &conf.Connectivity{APIRequestsPerSecond: 20, APIRequestBurst: 2}
+	// Clients with the same limits share them.
+	statusClient, err := NewApiClient(conf.HttpConfig{Connectivity: connectivity})
+	require.NoError(t, err)
+	authClient, err := NewApiClient(conf.HttpConfig{Connectivity: connectivity})
+	require.NoError(t, err)
+	assert.Same(t, statusClient.limiter, authClient.limiter)
+
+	send := func(cl *ApiClient, kind requestKind) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(withRequestKind(req, kind))
+		require.NoError(t, err)
+		rsp.Body.Close()
+	}
+
+	start := time.Now()
+	send(statusClient, requestKindStatus)
+	send(authClient, requestKindAuth)
+	assert.Less(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	// Downloads aren't limited.
+	send(statusClient, requestKindDownload)
+	assert.Less(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	for i := 0; i < 4; i++ {
+		send(authClient, requestKindInventory)
+	}
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(190*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, statusClient.limiter.wait(ctx))
+
+	cl, err := NewApiClient(conf.HttpConfig{Connectivity: &conf.Connectivity{}})
+	require.NoError(t, err)
+	assert.Nil(t, cl.limiter)
+}
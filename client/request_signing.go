@@ -0,0 +1,122 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	// Header with the algorithm and the base64 encoded signature, e.g.
+	// "hmac-sha256 ZXhhbXBsZQ==".
+	RequestSignatureHeader = "X-Mender-Signature"
+	// Header with the time of signing, in seconds since the epoch.
+	RequestSignatureTimestampHeader = "X-Mender-Signature-Timestamp"
+
+	requestSigningDefaultAlgorithm = "hmac-sha256"
+)
+
+var requestSigningAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha384": sha512.New384,
+	"hmac-sha512": sha512.New,
+}
+
+// requestSigner signs requests with the device's HMAC key, so that a reverse
+// proxy in front of the server can authenticate them. The signature covers
+// the method, the request URI, the timestamp and the body, each followed by
+// a newline except the body.
+type requestSigner struct {
+	algorithm string
+	hash      func() hash.Hash
+	key       []byte
+}
+
+func newRequestSigner(security *conf.Security) (*requestSigner, error) {
+	if security == nil || security.RequestSigningKey == "" {
+		return nil, nil
+	}
+	algorithm := security.RequestSigningAlgorithm
+	if algorithm == "" {
+		algorithm = requestSigningDefaultAlgorithm
+	}
+	hashFunc, ok := requestSigningAlgorithms[algorithm]
+	if !ok {
+		return nil, errors.Errorf("unsupported RequestSigningAlgorithm %q", algorithm)
+	}
+	key, err := ioutil.ReadFile(security.RequestSigningKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the request signing key")
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, errors.Errorf("the request signing key %s is empty",
+			security.RequestSigningKey)
+	}
+	return &requestSigner{algorithm: algorithm, hash: hashFunc, key: key}, nil
+}
+
+func (s *requestSigner) signature(method, uri, timestamp string, body []byte) string {
+	mac := hmac.New(s.hash, s.key)
+	io.WriteString(mac, strings.Join([]string{method, uri, timestamp, ""}, "\n"))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readRequestBody reads the body of the request for signing, and replaces
+// it with a copy.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the request body for signing")
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// sign adds the signature headers to the request. The body is read, and
+// replaced with a copy.
+func (s *requestSigner) sign(req *http.Request) error {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(RequestSignatureTimestampHeader, timestamp)
+	req.Header.Set(RequestSignatureHeader, s.algorithm+" "+
+		s.signature(req.Method, req.URL.RequestURI(), timestamp, body))
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestRequestSigning(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "TestRequestSigning")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	keyFile := path.Join(tdir, "signing.key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("secret\n"), 0600))
+
+	var header, timestamp string
+	var body []byte
+	busy := false
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Get(RequestSignatureHeader)
+			timestamp = r.Header.Get(RequestSignatureTimestampHeader)
+			body, _ = ioutil.ReadAll(r.Body)
+			if busy {
+				busy = false
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer ts.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{
+		Security: &conf.Security{
+			RequestSigningKey:       keyFile,
+			RequestSigningAlgorithm: "hmac-sha512",
+		},
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/some/path?a=b",
+		bytes.NewBufferString(`{"status":"success"}`))
+	require.NoError(t, err)
+	rsp, err := cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+
+	assert.Equal(t, `{"status":"success"}`, string(body))
+	require.NotEmpty(t, timestamp)
+	mac := hmac.New(sha512.New, []byte("secret"))
+	mac.Write([]byte("PUT\n/some/path?a=b\n" + timestamp + "\n"))
+	mac.Write(body)
+	assert.Equal(t, "hmac-sha512 "+base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		header)
+
+	// The signature is made after waiting for Retry-After, so that its
+	// timestamp is current.
+	busy = true
+	retryAt := time.Now().Add(time.Second).Unix()
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/busy", nil)
+	require.NoError(t, err)
+	rsp, err = cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/busy", nil)
+	require.NoError(t, err)
+	rsp, err = cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, signedAt, retryAt)
+
+	// Without a key nothing is signed.
+	cl, err = NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	rsp, err = cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Empty(t, header)
+
+	_, err = NewApiClient(conf.HttpConfig{
+		Security: &conf.Security{
+			RequestSigningKey:       keyFile,
+			RequestSigningAlgorithm: "md5",
+		},
+	})
+	assert.Error(t, err)
+	_, err = NewApiClient(conf.HttpConfig{
+		Security: &conf.Security{RequestSigningKey: path.Join(tdir, "missing")},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// requestKind classifies the requests made to the server, so that each kind
+// can be given its own timeout.
+type requestKind int
+
+const (
+	requestKindOther requestKind = iota
+	requestKindAuth
+	requestKindInventory
+	requestKindStatus
+	requestKindDownload
+	requestKindUpdateCheck
+	requestKindLogUpload
+)
+
+type requestKindKey struct{}
+
+// withRequestKind tags the request with the given kind.
+func withRequestKind(req *http.Request, kind requestKind) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestKindKey{}, kind))
+}
+
+func getRequestKind(req *http.Request) requestKind {
+	kind, _ := req.Context().Value(requestKindKey{}).(requestKind)
+	return kind
+}
+
+// requestTimeout returns the configured timeout for the kind of the given
+// request, or zero if none is configured.
+func requestTimeout(connectivity *conf.Connectivity, req *http.Request) time.Duration {
+	if connectivity == nil {
+		return 0
+	}
+	var seconds int
+	switch getRequestKind(req) {
+	case requestKindAuth:
+		seconds = connectivity.AuthTimeoutSeconds
+	case requestKindInventory:
+		seconds = connectivity.InventoryTimeoutSeconds
+	case requestKindStatus:
+		seconds = connectivity.StatusTimeoutSeconds
+	case requestKindDownload:
+		seconds = connectivity.DownloadTimeoutSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// cancelOnClose releases the context of a request once its response body has
+// been consumed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// Do sends the request, limited by the timeout configured for its kind, if
+// any. The timeout covers the whole exchange, including reading the body. If
+// the endpoint answered an earlier request with a Retry-After header, the
+// request waits until then. API responses are requested gzip compressed, and
+// decompressed transparently.
+func (c *ApiClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", getUserAgent())
+	}
+	setStaticHeaders(req.Header, c.connectivity)
+	kind := getRequestKind(req)
+	acceptGzip(req, kind)
+	if err := waitRetryAfter(req.Context(), req); err != nil {
+		return nil, err
+	}
+	if c.limiter != nil && kind != requestKindDownload {
+		if err := c.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	timeout := requestTimeout(c.connectivity, req)
+	do := c.Client.Do
+	if c.mqtt != nil && c.mqtt.carries(kind) {
+		do = c.mqtt.Do
+		if timeout <= 0 {
+			// http.Client enforces its timeout itself, but isn't
+			// used here.
+			timeout = c.Client.Timeout
+		}
+	}
+	// Sign last, so that the signature's timestamp isn't stale after
+	// waiting above.
+	if c.payloadSigner != nil {
+		if err := c.signPayload(req); err != nil {
+			return nil, err
+		}
+	}
+	if c.signer != nil {
+		if err := c.signer.sign(req); err != nil {
+			return nil, err
+		}
+	}
+	trace := startSpan(req, kind)
+	start := time.Now()
+	var rsp *http.Response
+	var err error
+	if timeout <= 0 {
+		rsp, err = do(req)
+	} else {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		rsp, err = do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+		} else {
+			rsp.Body = &cancelOnClose{ReadCloser: rsp.Body, cancel: cancel}
+		}
+	}
+	recordRequest(kind, rsp, time.Since(start))
+	if err != nil {
+		trace.finish(nil, err)
+		return nil, err
+	}
+	decodeGzip(rsp, kind)
+	recordRetryAfter(req, rsp)
+	recordPollIntervalHint(kind, rsp)
+	if kind == requestKindDownload {
+		rsp.Body = &downloadCounter{ReadCloser: rsp.Body}
+	}
+	return trace.finish(rsp, nil), nil
+}
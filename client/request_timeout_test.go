@@ -0,0 +1,59 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestPerRequestKindTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{
+		Connectivity: &conf.Connectivity{
+			StatusTimeoutSeconds:   1,
+			DownloadTimeoutSeconds: 5 * 60 * 60,
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Hour, cl.Timeout)
+
+	req, err := makeStatusReportRequest(ts.URL, StatusReport{
+		DeploymentID: "foo",
+		Status:       StatusInstalling,
+	})
+	require.NoError(t, err)
+	_, err = cl.Do(req)
+	assert.Error(t, err)
+
+	// Inventory submissions have no timeout of their own.
+	req, err = makeInventorySubmitRequest(http.MethodPut, ts.URL, nil, 0)
+	require.NoError(t, err)
+	rsp, err := cl.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.NoError(t, rsp.Body.Close())
+}
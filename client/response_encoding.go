@@ -0,0 +1,74 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptGzip asks for a gzip compressed response, unless the request already
+// says what it accepts. Artifact downloads are left alone, since artifacts
+// are compressed already, and are resumed with Range requests.
+func acceptGzip(req *http.Request, kind requestKind) {
+	if kind == requestKindDownload || req.Method == http.MethodHead ||
+		req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+}
+
+// decodeGzip replaces a gzip compressed response body with the decompressed
+// data, so that callers don't need to care. The transport only does this by
+// itself when it added the Accept-Encoding header, and the MQTT tunnel doesn't
+// do it at all.
+func decodeGzip(rsp *http.Response, kind requestKind) {
+	if kind == requestKindDownload || rsp.Uncompressed ||
+		!strings.EqualFold(rsp.Header.Get("Content-Encoding"), "gzip") {
+		return
+	}
+	rsp.Body = &gzipBody{body: rsp.Body}
+	rsp.Header.Del("Content-Encoding")
+	rsp.Header.Del("Content-Length")
+	rsp.ContentLength = -1
+	rsp.Uncompressed = true
+}
+
+// gzipBody decompresses the body as it is read. The gzip header is only read
+// on the first Read, so that an empty body, as in a 204 or 304 response, is
+// not an error unless it is read.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (g *gzipBody) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	if g.zr == nil {
+		g.zr, g.err = gzip.NewReader(g.body)
+		if g.err != nil {
+			return 0, g.err
+		}
+	}
+	return g.zr.Read(p)
+}
+
+func (g *gzipBody) Close() error {
+	return g.body.Close()
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestGzipResponses(t *testing.T) {
+	const body = `{"id":"deployment","artifact":{"artifact_name":"release-1"}}`
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	var acceptEncoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		if r.URL.Path == "/empty" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if acceptEncoding == "gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes())
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+
+	get := func(req *http.Request) (*http.Response, string) {
+		rsp, err := cl.Do(req)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		data, err := ioutil.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		return rsp, string(data)
+	}
+
+	// API requests ask for, and get, a decompressed body.
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	rsp, data := get(withRequestKind(req, requestKindUpdateCheck))
+	assert.Equal(t, "gzip", acceptEncoding)
+	assert.Equal(t, body, data)
+	assert.Empty(t, rsp.Header.Get("Content-Encoding"))
+	assert.True(t, rsp.Uncompressed)
+
+	// A request which is sent again is still decompressed.
+	req, err = http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req = withRequestKind(req, requestKindStatus)
+	_, data = get(req)
+	assert.Equal(t, body, data)
+	_, data = get(req)
+	assert.Equal(t, body, data)
+
+	// An empty body isn't an error.
+	req, err = http.NewRequest(http.MethodGet, srv.URL+"/empty", nil)
+	require.NoError(t, err)
+	rsp, data = get(req)
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.Empty(t, data)
+
+	// Downloads, and requests which say what they accept, are left alone.
+	req, err = http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=0-")
+	_, data = get(withRequestKind(req, requestKindDownload))
+	assert.Empty(t, acceptEncoding)
+	assert.Equal(t, body, data)
+
+	req, err = http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "identity")
+	_, data = get(req)
+	assert.Equal(t, "identity", acceptEncoding)
+	assert.Equal(t, body, data)
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Longer Retry-After delays are cut short, in case the server is
+// misconfigured.
+const maxRetryAfter = time.Hour
+
+// retryAfter holds, for each endpoint which answered 429 Too Many Requests or
+// 503 Service Unavailable with a Retry-After header, the time before which it
+// shouldn't be sent another request. Shared by all clients.
+var retryAfter = struct {
+	sync.Mutex
+	notBefore map[string]time.Time
+}{notBefore: map[string]time.Time{}}
+
+// retryAfterEndpoint identifies the endpoint by host and path, ignoring
+// the query.
+func retryAfterEndpoint(req *http.Request) string {
+	return req.URL.Host + "/" + strings.TrimLeft(req.URL.Path, "/")
+}
+
+// parseRetryAfter accepts both a number of seconds and an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// recordRetryAfter remembers the delay the server asked for in a 429 or 503
+// response.
+func recordRetryAfter(req *http.Request, rsp *http.Response) {
+	if rsp.StatusCode != http.StatusTooManyRequests &&
+		rsp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	now := time.Now()
+	delay, ok := parseRetryAfter(rsp.Header.Get("Retry-After"), now)
+	if !ok || delay == 0 {
+		return
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	endpoint := retryAfterEndpoint(req)
+	log.Warnf("The server asked not to retry %s for %s", endpoint, delay)
+	retryAfter.Lock()
+	retryAfter.notBefore[endpoint] = now.Add(delay)
+	retryAfter.Unlock()
+}
+
+// waitRetryAfter waits until the endpoint of the request may be sent a
+// request again, or the context is done.
+func waitRetryAfter(ctx context.Context, req *http.Request) error {
+	endpoint := retryAfterEndpoint(req)
+	retryAfter.Lock()
+	notBefore, ok := retryAfter.notBefore[endpoint]
+	if ok && !time.Now().Before(notBefore) {
+		delete(retryAfter.notBefore, endpoint)
+		ok = false
+	}
+	retryAfter.Unlock()
+	if !ok {
+		return nil
+	}
+
+	delay := time.Until(notBefore)
+	log.Infof("Waiting %s before sending to %s, as the server asked", delay, endpoint)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
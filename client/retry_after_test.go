@@ -0,0 +1,95 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		"seconds":     {value: "120", delay: 2 * time.Minute, ok: true},
+		"date":        {value: "Mon, 01 May 2023 12:00:30 GMT", delay: 30 * time.Second, ok: true},
+		"past date":   {value: "Mon, 01 May 2023 11:00:00 GMT", ok: true},
+		"empty":       {value: ""},
+		"negative":    {value: "-5"},
+		"not a value": {value: "soon"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(test.value, now)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.delay, delay)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	requests := map[string]int{}
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests[r.URL.Path]++
+			if r.URL.Path == "/busy" && requests[r.URL.Path] == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer ts.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	send := func(path string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(req)
+		require.NoError(t, err)
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, send("/busy"))
+
+	// Other endpoints aren't affected.
+	start := time.Now()
+	assert.Equal(t, http.StatusOK, send("/other"))
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+
+	// A cancelled request doesn't wait.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/busy", nil)
+	require.NoError(t, err)
+	_, err = cl.Do(req)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, http.StatusOK, send("/busy"))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(900*time.Millisecond))
+	assert.Equal(t, 2, requests["/busy"])
+}
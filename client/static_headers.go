@@ -0,0 +1,50 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func validateStaticHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r)
+		}) >= 0 {
+			return errors.Errorf("invalid header name %q in HTTPHeaders", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf("invalid value for header %q in HTTPHeaders", name)
+		}
+	}
+	return nil
+}
+
+// setStaticHeaders adds the headers from the configuration. Headers which
+// are already set, such as the authorization token, are left alone.
+func setStaticHeaders(header http.Header, connectivity *conf.Connectivity) {
+	if connectivity == nil {
+		return
+	}
+	for name, value := range connectivity.HTTPHeaders {
+		if header.Get(name) == "" {
+			header.Set(name, value)
+		}
+	}
+}
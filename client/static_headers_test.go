@@ -0,0 +1,66 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestStaticHeaders(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer ts.Close()
+
+	cl, err := NewApiClient(conf.HttpConfig{
+		Connectivity: &conf.Connectivity{
+			HTTPHeaders: map[string]string{
+				"X-Site-ID":     "site-1",
+				"Authorization": "gateway",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer token")
+	rsp, err := cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, "site-1", header.Get("X-Site-ID"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	for _, headers := range []map[string]string{
+		{"X Site": "1"},
+		{"X-Site:": "1"},
+		{"": "1"},
+		{"X-Site": "1\r\nX-Other: 2"},
+	} {
+		_, err = NewApiClient(conf.HttpConfig{
+			Connectivity: &conf.Connectivity{HTTPHeaders: headers},
+		})
+		assert.Error(t, err, headers)
+	}
+}
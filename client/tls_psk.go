@@ -0,0 +1,71 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+
+	"github.com/mendersoftware/openssl"
+)
+
+type pskCredentials struct {
+	identity string
+	key      []byte
+}
+
+func pskConfigured(security *conf.Security) bool {
+	return security != nil && security.PSKKeyFile != ""
+}
+
+func loadPSKCredentials(security *conf.Security) (*pskCredentials, error) {
+	if security.PSKIdentity == "" {
+		return nil, errors.New("PSKIdentity must be set together with PSKKeyFile")
+	}
+	data, err := ioutil.ReadFile(security.PSKKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the pre-shared TLS key")
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "the pre-shared TLS key in %s is not hex encoded",
+			security.PSKKeyFile)
+	}
+	if len(key) == 0 {
+		return nil, errors.Errorf("the pre-shared TLS key %s is empty", security.PSKKeyFile)
+	}
+	return &pskCredentials{identity: security.PSKIdentity, key: key}, nil
+}
+
+// applyPSK makes the context offer the configured pre-shared key to the
+// server.
+func applyPSK(ctx *openssl.Ctx, security *conf.Security) error {
+	if !pskConfigured(security) {
+		return nil
+	}
+	creds, err := loadPSKCredentials(security)
+	if err != nil {
+		return err
+	}
+	// The identity hint from the server is not used.
+	ctx.SetPSKClientCallback(func(string) (string, []byte, bool) {
+		return creds.identity, creds.key, true
+	})
+	return nil
+}
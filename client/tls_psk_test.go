@@ -0,0 +1,146 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const pskTestKey = "00112233445566778899aabbccddeeff"
+
+func writePSKKey(t *testing.T, dir, name, content string) string {
+	file := path.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestLoadPSKCredentials(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "TestLoadPSKCredentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	good := writePSKKey(t, tdir, "good", pskTestKey+"\n")
+	creds, err := loadPSKCredentials(&conf.Security{
+		PSKIdentity: "device",
+		PSKKeyFile:  good,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "device", creds.identity)
+	assert.Equal(t, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, creds.key)
+
+	_, err = loadPSKCredentials(&conf.Security{PSKKeyFile: good})
+	assert.Error(t, err)
+
+	for _, content := range []string{"", "\n", "not hex"} {
+		_, err = loadPSKCredentials(&conf.Security{
+			PSKIdentity: "device",
+			PSKKeyFile:  writePSKKey(t, tdir, "bad", content),
+		})
+		assert.Error(t, err, content)
+	}
+
+	_, err = loadPSKCredentials(&conf.Security{
+		PSKIdentity: "device",
+		PSKKeyFile:  path.Join(tdir, "missing"),
+	})
+	assert.Error(t, err)
+
+	assert.False(t, pskConfigured(nil))
+	assert.False(t, pskConfigured(&conf.Security{PSKIdentity: "device"}))
+}
+
+// startPSKServer runs "openssl s_server" without a certificate, so that the
+// connection can only be authenticated with the pre-shared key.
+func startPSKServer(t *testing.T, version string) string {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl command not available")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command("openssl", "s_server", "-quiet", "-www", "-nocert",
+		"-"+version, "-psk", pskTestKey, "-psk_identity", "device",
+		"-accept", fmt.Sprint(port))
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		require.Less(t, i, 50, "openssl s_server didn't start")
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "https://" + addr
+}
+
+func TestPSKConnection(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "TestPSKConnection")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	good := writePSKKey(t, tdir, "good", pskTestKey)
+	wrong := writePSKKey(t, tdir, "wrong", "ffeeddccbbaa99887766554433221100")
+
+	for _, version := range []string{"tls1_2", "tls1_3"} {
+		t.Run(version, func(t *testing.T) {
+			url := startPSKServer(t, version)
+
+			for name, test := range map[string]struct {
+				security *conf.Security
+				success  bool
+			}{
+				"no key":    {security: nil},
+				"right key": {security: &conf.Security{PSKIdentity: "device", PSKKeyFile: good}, success: true},
+				"wrong key": {security: &conf.Security{PSKIdentity: "device", PSKKeyFile: wrong}},
+			} {
+				t.Run(name, func(t *testing.T) {
+					cl, err := NewApiClient(conf.HttpConfig{Security: test.security})
+					require.NoError(t, err)
+					req, err := http.NewRequest(http.MethodGet, url, nil)
+					require.NoError(t, err)
+					rsp, err := cl.Do(req)
+					if test.success {
+						require.NoError(t, err)
+						rsp.Body.Close()
+						assert.Equal(t, http.StatusOK, rsp.StatusCode)
+					} else {
+						assert.Error(t, err)
+					}
+				})
+			}
+		})
+	}
+}
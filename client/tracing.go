@@ -0,0 +1,320 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	otlpTracesPath = "/v1/traces"
+
+	// Spans are sent when this many have ended, or after
+	// tracingExportInterval, whichever comes first.
+	tracingBatchSize      = 64
+	tracingExportInterval = 5 * time.Second
+	// Spans which end while this many are waiting to be sent are dropped.
+	tracingQueueSize = 512
+
+	otlpSpanKindClient  = 3
+	otlpStatusCodeOK    = 1
+	otlpStatusCodeError = 2
+)
+
+// tracer holds the exporter the spans of all API clients are sent to, nil
+// when tracing is disabled.
+var tracer struct {
+	sync.Mutex
+	exporter *otlpExporter
+}
+
+// ConfigureTracing has a span recorded for every authorization request,
+// update check, artifact download, status report and log upload, and sent
+// to the OpenTelemetry collector at collectorURL, using OTLP over HTTP, e.g.
+// "http://127.0.0.1:4318". An empty collectorURL disables tracing.
+func ConfigureTracing(collectorURL string) error {
+	var exporter *otlpExporter
+	if collectorURL != "" {
+		parsed, err := url.Parse(collectorURL)
+		if err != nil {
+			return errors.Wrap(err, "invalid TracingCollectorURL")
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return errors.Errorf("TracingCollectorURL %q must be an http or https URL",
+				collectorURL)
+		}
+		exporter = newOTLPExporter(strings.TrimSuffix(collectorURL, "/") + otlpTracesPath)
+	}
+
+	tracer.Lock()
+	defer tracer.Unlock()
+	if tracer.exporter != nil {
+		tracer.exporter.stop()
+	}
+	tracer.exporter = exporter
+	return nil
+}
+
+type span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+
+	exporter *otlpExporter
+	once     sync.Once
+}
+
+// startSpan returns nil if tracing is disabled, or the kind of request isn't
+// traced. The span is propagated to the server in the traceparent header.
+func startSpan(req *http.Request, kind requestKind) *span {
+	if kind == requestKindOther || kind == requestKindInventory {
+		return nil
+	}
+	tracer.Lock()
+	exporter := tracer.exporter
+	tracer.Unlock()
+	if exporter == nil {
+		return nil
+	}
+
+	s := &span{
+		name:  "mender." + kind.String(),
+		start: time.Now(),
+		attributes: map[string]interface{}{
+			"http.method": req.Method,
+			"http.url":    req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+		},
+		exporter: exporter,
+	}
+	_, _ = rand.Read(s.traceID[:])
+	_, _ = rand.Read(s.spanID[:])
+	req.Header.Set("traceparent", "00-"+hex.EncodeToString(s.traceID[:])+"-"+
+		hex.EncodeToString(s.spanID[:])+"-01")
+	return s
+}
+
+// finish ends the span once the response, if any, has been read. It's safe
+// to call on a nil span.
+func (s *span) finish(rsp *http.Response, err error) *http.Response {
+	if s == nil {
+		return rsp
+	}
+	if err != nil {
+		s.endWith(err, -1)
+		return rsp
+	}
+	s.attributes["http.status_code"] = rsp.StatusCode
+	if rsp.StatusCode >= 400 {
+		s.err = errors.New(http.StatusText(rsp.StatusCode))
+	}
+	rsp.Body = &spanBody{ReadCloser: rsp.Body, span: s}
+	return rsp
+}
+
+// endWith ends the span, the first time it's called. responseBytes is the
+// size of the response body, or -1 if it wasn't read.
+func (s *span) endWith(err error, responseBytes int64) {
+	s.once.Do(func() {
+		if err != nil {
+			s.err = err
+		}
+		if responseBytes >= 0 {
+			s.attributes["http.response_content_length"] = responseBytes
+		}
+		s.end = time.Now()
+		s.exporter.add(s)
+	})
+}
+
+// spanBody ends the span when the response body has been read to the end, or
+// closed.
+type spanBody struct {
+	io.ReadCloser
+	span  *span
+	bytes int64
+}
+
+func (b *spanBody) Read(buf []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buf)
+	b.bytes += int64(n)
+	if err == io.EOF {
+		b.end(nil)
+	} else if err != nil {
+		b.end(err)
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end(nil)
+	return err
+}
+
+func (b *spanBody) end(err error) {
+	b.span.endWith(err, b.bytes)
+}
+
+// otlpExporter sends ended spans to the collector in batches, in the OTLP
+// JSON encoding.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+	spans  chan *span
+	quit   chan struct{}
+}
+
+func newOTLPExporter(url string) *otlpExporter {
+	e := &otlpExporter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		spans:  make(chan *span, tracingQueueSize),
+		quit:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) add(s *span) {
+	select {
+	case e.spans <- s:
+	default:
+		log.Debugf("Dropping trace span %s, too many waiting to be sent", s.name)
+	}
+}
+
+func (e *otlpExporter) stop() {
+	close(e.quit)
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(tracingExportInterval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) < tracingBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.quit:
+			e.send(batch)
+			return
+		}
+		e.send(batch)
+		batch = nil
+	}
+}
+
+func (e *otlpExporter) send(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpTraces(batch))
+	if err != nil {
+		log.Debugf("Failed to encode trace spans: %s", err.Error())
+		return
+	}
+	rsp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Debugf("Failed to send trace spans: %s", err.Error())
+		return
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		log.Debugf("The trace collector refused %d spans, status %d",
+			len(batch), rsp.StatusCode)
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		kv := otlpKeyValue{Key: key}
+		switch v := value.(type) {
+		case int:
+			kv.Value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			kv.Value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			kv.Value = map[string]interface{}{"stringValue": v}
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+func otlpTraces(batch []*span) interface{} {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		status := map[string]interface{}{"code": otlpStatusCodeOK}
+		if s.err != nil {
+			status = map[string]interface{}{
+				"code":    otlpStatusCodeError,
+				"message": s.err.Error(),
+			}
+		}
+		spans = append(spans, map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              otlpSpanKindClient,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+			"status":            status,
+		})
+	}
+	resource := map[string]interface{}{
+		"attributes": otlpAttributes(map[string]interface{}{
+			"service.name":    "mender-client",
+			"service.version": conf.VersionString(),
+		}),
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": resource,
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/mendersoftware/mender/client"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
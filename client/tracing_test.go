@@ -0,0 +1,116 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestTracing(t *testing.T) {
+	exported := make(chan map[string]interface{}, 10)
+	collector := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, otlpTracesPath, r.URL.Path)
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			exported <- body
+		}))
+	defer collector.Close()
+
+	var traceparent string
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			w.Write([]byte("token"))
+		}))
+	defer ts.Close()
+
+	require.NoError(t, ConfigureTracing(collector.URL))
+	defer ConfigureTracing("")
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/auth", nil)
+	require.NoError(t, err)
+	rsp, err := cl.Do(withRequestKind(req, requestKindAuth))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	sentTraceparent := traceparent
+
+	// Not traced.
+	req, err = http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	rsp, err = cl.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Empty(t, traceparent)
+
+	// Stopping the exporter sends what's waiting.
+	require.NoError(t, ConfigureTracing(""))
+	var body map[string]interface{}
+	select {
+	case body = <-exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans exported")
+	}
+	encoded, err := json.Marshal(body)
+	require.NoError(t, err)
+	var traces struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string `json:"traceId"`
+					SpanID     string `json:"spanId"`
+					Name       string `json:"name"`
+					Attributes []otlpKeyValue
+					Status     struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(encoded, &traces))
+	require.Len(t, traces.ResourceSpans, 1)
+	require.Len(t, traces.ResourceSpans[0].ScopeSpans, 1)
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+	assert.Equal(t, "mender.auth", spans[0].Name)
+	assert.Equal(t, otlpStatusCodeOK, spans[0].Status.Code)
+	assert.Len(t, spans[0].TraceID, 32)
+	assert.Len(t, spans[0].SpanID, 16)
+	assert.Equal(t, "00-"+spans[0].TraceID+"-"+spans[0].SpanID+"-01", sentTraceparent)
+	attributes := map[string]interface{}{}
+	for _, kv := range spans[0].Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	assert.Equal(t, map[string]interface{}{"intValue": "200"},
+		attributes["http.status_code"])
+	assert.Equal(t, map[string]interface{}{"intValue": "5"},
+		attributes["http.response_content_length"])
+
+	assert.Error(t, ConfigureTracing("ftp://collector"))
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/datastore"
+)
+
+// updateCheckCache remembers the ETag of the last update check response
+// without a deployment. As long as the device sends the same request, a 304
+// Not Modified answer means there is still no deployment.
+type updateCheckCache struct {
+	ETag          string `json:"etag"`
+	RequestDigest string `json:"request_digest"`
+}
+
+// updateCheckDigest covers the method, URL and body of the request, which
+// holds the device type, artifact name and provides.
+func updateCheckDigest(req *http.Request) string {
+	h := sha256.New()
+	io.WriteString(h, req.Method+" "+req.URL.String()+"\n")
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			_, _ = io.Copy(h, body)
+			body.Close()
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// setIfNoneMatch adds the cached ETag to the requests identical to the one
+// which the cached response answered.
+func (u *UpdateClient) setIfNoneMatch(reqs []*http.Request) {
+	if u.ResponseCache == nil {
+		return
+	}
+	data, err := u.ResponseCache.ReadAll(datastore.UpdateCheckETagKey)
+	if err != nil {
+		return
+	}
+	var cache updateCheckCache
+	if err = json.Unmarshal(data, &cache); err != nil {
+		return
+	}
+	for _, req := range reqs {
+		if updateCheckDigest(req) == cache.RequestDigest {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+	}
+}
+
+// cacheUpdateCheckResponse stores the ETag of a response without a
+// deployment, and forgets it after any other response.
+func (u *UpdateClient) cacheUpdateCheckResponse(req *http.Request, rsp *http.Response) {
+	if u.ResponseCache == nil || rsp.StatusCode == http.StatusNotModified {
+		return
+	}
+	etag := rsp.Header.Get("ETag")
+	if rsp.StatusCode != http.StatusNoContent || etag == "" {
+		_ = u.ResponseCache.Remove(datastore.UpdateCheckETagKey)
+		return
+	}
+	data, err := json.Marshal(updateCheckCache{
+		ETag:          etag,
+		RequestDigest: updateCheckDigest(req),
+	})
+	if err == nil {
+		err = u.ResponseCache.WriteAll(datastore.UpdateCheckETagKey, data)
+	}
+	if err != nil {
+		log.Warnf("Failed to store the update check ETag: %s", err.Error())
+	}
+}
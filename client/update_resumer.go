@@ -28,6 +28,8 @@ import (
 	"github.com/pkg/errors"
 )
 
+var ErrArtifactChanged = errors.New("artifact changed on the server during download")
+
 type UpdateResumer struct {
 	stream        io.ReadCloser
 	apiReq        ApiRequester
@@ -36,6 +38,9 @@ type UpdateResumer struct {
 	contentLength int64
 	retryAttempts int
 	maxWait       time.Duration
+	// Value of the If-Range header sent when resuming, which makes sure the
+	// remaining bytes come from the same artifact as the partial data.
+	validator string
 }
 
 // Note: It is important that nothing has been read from the stream yet.
@@ -54,6 +59,23 @@ func NewUpdateResumer(stream io.ReadCloser,
 	}
 }
 
+// SetRangeValidator records the strong ETag, or failing that the Last-Modified
+// date, from the headers of the initial download response. Subsequent resume
+// requests are made conditional on it, so that the download fails instead of
+// splicing together two different artifacts if the artifact is replaced on
+// the server in the meantime.
+func (h *UpdateResumer) SetRangeValidator(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.validator = etag
+	} else {
+		h.validator = header.Get("Last-Modified")
+	}
+}
+
+func (h *UpdateResumer) RangeValidator() string {
+	return h.validator
+}
+
 func (h *UpdateResumer) Read(buf []byte) (int, error) {
 	origOffset := h.offset
 	for {
@@ -73,11 +95,21 @@ func (h *UpdateResumer) Read(buf []byte) (int, error) {
 		// a sign that we should try to resume from the same position.
 
 		h.req.Header.Set("Range", fmt.Sprintf("bytes=%d-", h.offset))
+		if h.validator != "" {
+			h.req.Header.Set("If-Range", h.validator)
+		}
 
 		var res *http.Response
 		for {
 			log.Errorf("Download connection broken: %s", err.Error())
 
+			// The download was cancelled, don't try to resume it.
+			ctx := h.req.Context()
+			if ctx.Err() != nil {
+				return int(h.offset - origOffset),
+					errors.Wrap(ctx.Err(), "Download cancelled")
+			}
+
 			waitTime, err := GetExponentialBackoffTime(h.retryAttempts, h.maxWait, 0)
 			if err != nil {
 				return int(h.offset - origOffset),
@@ -86,8 +118,14 @@ func (h *UpdateResumer) Read(buf []byte) (int, error) {
 
 			log.Infof("Resuming download in %s", waitTime.String())
 			h.retryAttempts += 1
+			recordRetry(metricsRetryDownloadResume)
 
-			time.Sleep(waitTime)
+			select {
+			case <-time.After(waitTime):
+			case <-ctx.Done():
+				return int(h.offset - origOffset),
+					errors.Wrap(ctx.Err(), "Download cancelled")
+			}
 
 			log.Infof("Attempting to resume artifact download from offset %d", h.offset)
 
@@ -98,7 +136,10 @@ func (h *UpdateResumer) Read(buf []byte) (int, error) {
 			}
 
 			stream, err := h.getStreamFromPartialContent(res)
-			if err != nil {
+			if err == ErrArtifactChanged {
+				res.Body.Close()
+				return int(h.offset - origOffset), err
+			} else if err != nil {
 				continue
 			}
 
@@ -113,7 +154,11 @@ func (h *UpdateResumer) Read(buf []byte) (int, error) {
 func (h *UpdateResumer) getStreamFromPartialContent(res *http.Response) (io.ReadCloser, error) {
 	var err error
 
-	if h.offset > 0 && res.StatusCode != http.StatusPartialContent {
+	if h.offset > 0 && res.StatusCode == http.StatusOK && h.validator != "" {
+		// The server ignored the range because the If-Range
+		// condition did not hold.
+		return nil, ErrArtifactChanged
+	} else if h.offset > 0 && res.StatusCode != http.StatusPartialContent {
 		return nil, fmt.Errorf("Could not resume download from offset %d. HTTP status code: %s",
 			h.offset, res.Status)
 	}
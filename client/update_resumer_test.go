@@ -43,6 +43,8 @@ type testHandler struct {
 	breakAfterShortRange    bool
 	serverDownAfter         time.Duration
 	serverUpAgainAfter      time.Duration
+	etag                    string
+	etagChanged             bool
 
 	success bool
 }
@@ -61,7 +63,17 @@ func (h *testHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	assert.NoError(t, err)
 	size := stat.Size()
 
-	if len(hRangeStr) > 0 && !h.noPartialContentSupport {
+	ifRange := req.Header.Get("If-Range")
+	if h.etag != "" {
+		res.Header().Set("ETag", h.etag)
+		if len(hRangeStr) > 0 {
+			assert.Equal(t, h.etag, ifRange)
+		}
+	}
+	// An If-Range which doesn't match means the full content is sent.
+	rangeValid := ifRange == "" || (ifRange == h.etag && !h.etagChanged)
+
+	if len(hRangeStr) > 0 && !h.noPartialContentSupport && rangeValid {
 		code = http.StatusPartialContent
 		assert.True(t, strings.HasPrefix(hRangeStr, "bytes="))
 		hRange := strings.Split(hRangeStr[len("bytes="):], "-")
@@ -170,6 +182,7 @@ func testBrokenReadAndPartialDownload_oneCase(t *testing.T, h *testHandler) {
 	assert.NoError(t, err)
 
 	updateResumer := NewUpdateResumer(res.Body, contentLength, 3*time.Second, &client, req)
+	updateResumer.SetRangeValidator(res.Header)
 	defer updateResumer.Close()
 
 	if h.serverDownAfter > 0 {
@@ -358,6 +371,27 @@ func testBrokenReadAndPartialDownload_group(t *testing.T) {
 			testBrokenReadAndPartialDownload_oneCase(t, &h)
 		})
 	}
+
+	{
+		h := base
+		h.addr = ":9769"
+		h.success = true
+		h.etag = `"artifact-v1"`
+		t.Run("matchingETag", func(t *testing.T) {
+			testBrokenReadAndPartialDownload_oneCase(t, &h)
+		})
+	}
+
+	{
+		h := base
+		h.addr = ":9770"
+		h.success = false
+		h.etag = `"artifact-v1"`
+		h.etagChanged = true
+		t.Run("changedETag", func(t *testing.T) {
+			testBrokenReadAndPartialDownload_oneCase(t, &h)
+		})
+	}
 }
 
 func TestBrokenReadAndPartialDownload(t *testing.T) {
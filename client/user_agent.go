@@ -0,0 +1,107 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const kernelReleaseFile = "/proc/sys/kernel/osrelease"
+
+// userAgent is sent with every request which doesn't set one itself, so that
+// proxies in front of the server can tell devices apart.
+var userAgent = struct {
+	sync.RWMutex
+	metadata     bool
+	deviceType   string
+	artifactName string
+	kernel       string
+	value        string
+}{metadata: true}
+
+// ConfigureUserAgent sets whether the User-Agent includes the device type,
+// the kernel release and the artifact name, or only the client version.
+func ConfigureUserAgent(metadata bool) {
+	userAgent.Lock()
+	defer userAgent.Unlock()
+	userAgent.metadata = metadata
+	if metadata && userAgent.kernel == "" {
+		release, err := ioutil.ReadFile(kernelReleaseFile)
+		if err == nil {
+			userAgent.kernel = strings.TrimSpace(string(release))
+		}
+	}
+	userAgent.value = buildUserAgent()
+}
+
+// SetUserAgentMetadata updates the device type and artifact name in the
+// User-Agent, after they may have changed.
+func SetUserAgentMetadata(deviceType, artifactName string) {
+	userAgent.Lock()
+	defer userAgent.Unlock()
+	userAgent.deviceType = deviceType
+	userAgent.artifactName = artifactName
+	userAgent.value = buildUserAgent()
+}
+
+func getUserAgent() string {
+	userAgent.RLock()
+	value := userAgent.value
+	userAgent.RUnlock()
+	if value == "" {
+		return buildUserAgent()
+	}
+	return value
+}
+
+// buildUserAgent returns e.g. "Mender/3.5.0 (device_type=raspberrypi4;
+// kernel=5.15.61; artifact_name=release-1)". Must be called with the lock
+// held, or before the User-Agent has been configured.
+func buildUserAgent() string {
+	product := "Mender/" + userAgentValue(conf.VersionString())
+	if !userAgent.metadata {
+		return product
+	}
+	var details []string
+	for _, field := range []struct{ name, value string }{
+		{"device_type", userAgent.deviceType},
+		{"kernel", userAgent.kernel},
+		{"artifact_name", userAgent.artifactName},
+	} {
+		if field.value != "" {
+			details = append(details,
+				fmt.Sprintf("%s=%s", field.name, userAgentValue(field.value)))
+		}
+	}
+	if len(details) == 0 {
+		return product
+	}
+	return product + " (" + strings.Join(details, "; ") + ")"
+}
+
+// userAgentValue replaces the characters which would break up the
+// User-Agent, or aren't allowed in a header.
+func userAgentValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("();/", r) {
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestUserAgent(t *testing.T) {
+	defer func() {
+		ConfigureUserAgent(true)
+		SetUserAgentMetadata("", "")
+	}()
+
+	var agent string
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			agent = r.Header.Get("User-Agent")
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer ts.Close()
+	cl, err := NewApiClient(conf.HttpConfig{})
+	require.NoError(t, err)
+	send := func() string {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		rsp, err := cl.Do(req)
+		require.NoError(t, err)
+		rsp.Body.Close()
+		return agent
+	}
+
+	ConfigureUserAgent(true)
+	userAgent.Lock()
+	userAgent.kernel = "5.15.61-v8+"
+	userAgent.Unlock()
+	SetUserAgentMetadata("raspberrypi4", "release 1 (test)")
+	assert.Equal(t, "Mender/"+conf.VersionString()+
+		" (device_type=raspberrypi4; kernel=5.15.61-v8+; artifact_name=release_1__test_)",
+		send())
+
+	ConfigureUserAgent(false)
+	assert.Equal(t, "Mender/"+conf.VersionString(), send())
+}
@@ -14,6 +14,12 @@
 package conf
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"io"
 	"io/ioutil"
 	"os"
@@ -307,3 +313,326 @@ func TestDBusUpdateControlMapExpirationTimeSecondsConfig(t *testing.T) {
 	assert.Equal(t, 10, config.GetUpdateControlMapExpirationTimeSeconds())
 	assert.Equal(t, 15, config.GetUpdateControlMapBootExpirationTimeSeconds())
 }
+
+func TestAuthModeValidate(t *testing.T) {
+	validate := func(mode string, httpsClient HttpsClient, oidc OIDC) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.AuthMode = mode
+		config.HttpsClient = httpsClient
+		config.OIDC = oidc
+		return config.Validate()
+	}
+	cert := HttpsClient{Certificate: "/data/mender/client.crt", Key: "/data/mender/client.key"}
+	assert.NoError(t, validate("", HttpsClient{}, OIDC{}))
+	assert.NoError(t, validate(AuthModeJWT, HttpsClient{}, OIDC{}))
+	assert.NoError(t, validate(AuthModeMTLS, cert, OIDC{}))
+	assert.Error(t, validate(AuthModeMTLS, HttpsClient{}, OIDC{}))
+	assert.Error(t, validate(AuthModeMTLS, cert,
+		OIDC{Issuer: "https://idp.example.com", ClientID: "mender"}))
+	assert.Error(t, validate("psk", HttpsClient{}, OIDC{}))
+}
+
+func TestFIPSModeValidate(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "TestFIPSModeValidate")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	writeKey := func(name string, key interface{}) string {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		require.NoError(t, err)
+		keyPath := path.Join(tdir, name)
+		require.NoError(t, ioutil.WriteFile(keyPath,
+			pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+		return keyPath
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecKeyPath := writeKey("ec.pem", &ecKey.PublicKey)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edKeyPath := writeKey("ed25519.pem", edKey)
+
+	validate := func(security Security, verifyKeys ...string) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.Security = security
+		config.Security.FIPSMode = true
+		config.ArtifactVerifyKeys = verifyKeys
+		return config.Validate()
+	}
+	assert.NoError(t, validate(Security{}))
+	assert.NoError(t, validate(Security{
+		KeyType:         "ecdsa",
+		MinTLSVersion:   "1.3",
+		TLSCipherSuites: []string{"ECDHE-ECDSA-AES128-GCM-SHA256"},
+	}, ecKeyPath))
+	assert.Error(t, validate(Security{KeyType: "ed25519"}))
+	assert.Error(t, validate(Security{KeyLength: 1024}))
+	assert.Error(t, validate(Security{MinTLSVersion: "1.1"}))
+	assert.Error(t, validate(Security{
+		TLSCipherSuites: []string{"ECDHE-RSA-CHACHA20-POLY1305"},
+	}))
+	assert.Error(t, validate(Security{}, ecKeyPath, edKeyPath))
+
+	config := NewMenderConfig()
+	config.ServerURL = "https://mender.io"
+	config.Security.KeyType = "ed25519"
+	config.ArtifactVerifyKeys = []string{edKeyPath}
+	assert.NoError(t, config.Validate())
+}
+
+func TestOIDCConfigValidate(t *testing.T) {
+	validate := func(oidc OIDC) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.OIDC = oidc
+		return config.Validate()
+	}
+	assert.Error(t, validate(OIDC{Issuer: "https://idp.example.com"}))
+	assert.NoError(t, validate(OIDC{Issuer: "https://idp.example.com", ClientID: "mender"}))
+	assert.NoError(t, validate(OIDC{
+		Issuer:    "https://idp.example.com",
+		ClientID:  "mender",
+		TokenType: "id_token",
+	}))
+	assert.Error(t, validate(OIDC{
+		Issuer:    "https://idp.example.com",
+		ClientID:  "mender",
+		TokenType: "refresh_token",
+	}))
+}
+
+func TestUpdateSecurityConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mender-conf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "mender.conf")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{
+  "ServerURL": "https://mender.io",
+  "Security": {"KeyType": "ecdsa", "SSLEngine": "foo"}
+}`), 0640))
+
+	require.NoError(t, UpdateSecurityConfig(file, map[string]interface{}{
+		"AuthPrivateKey": "pkcs11:token=t;object=k;type=private",
+		"SSLEngine":      "pkcs11",
+	}))
+
+	config, err := LoadConfig(file, "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://mender.io", config.ServerURL)
+	assert.Equal(t, "ecdsa", config.Security.KeyType)
+	assert.Equal(t, "pkcs11:token=t;object=k;type=private", config.Security.AuthPrivateKey)
+	assert.Equal(t, "pkcs11", config.Security.SSLEngine)
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	_, err = os.Stat(file + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	bad := path.Join(dir, "bad.conf")
+	require.NoError(t, ioutil.WriteFile(bad, []byte("{"), 0600))
+	assert.Error(t, UpdateSecurityConfig(bad, map[string]interface{}{"SSLEngine": "pkcs11"}))
+}
+
+func TestAttestationConfigValidate(t *testing.T) {
+	validate := func(attestation Attestation) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.Attestation = attestation
+		return config.Validate()
+	}
+	assert.NoError(t, validate(Attestation{PCRBank: "md5"}))
+	assert.NoError(t, validate(Attestation{KeyHandle: "0x81010002"}))
+	assert.NoError(t, validate(Attestation{
+		KeyHandle: "0x81010002",
+		PCRs:      []int{0, 7, 23},
+		PCRBank:   "sha384",
+	}))
+	assert.Error(t, validate(Attestation{KeyHandle: "0x81010002", PCRBank: "md5"}))
+	assert.Error(t, validate(Attestation{KeyHandle: "0x81010002", PCRs: []int{24}}))
+}
+
+func TestESTConfigValidate(t *testing.T) {
+	validate := func(est EST, httpsClient HttpsClient) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.EST = est
+		config.HttpsClient = httpsClient
+		return config.Validate()
+	}
+	httpsClient := HttpsClient{Certificate: "/data/cert.pem", Key: "/data/key.pem"}
+	assert.NoError(t, validate(EST{}, HttpsClient{}))
+	assert.NoError(t, validate(EST{ServerURL: "https://est.example.com/.well-known/est"},
+		httpsClient))
+	assert.Error(t, validate(EST{ServerURL: "http://est.example.com/.well-known/est"},
+		httpsClient))
+	assert.Error(t, validate(EST{ServerURL: "https://est.example.com/.well-known/est"},
+		HttpsClient{Key: "/data/key.pem"}))
+}
+
+func TestMQTTConfigValidate(t *testing.T) {
+	validate := func(brokerURL string, allowInsecure bool) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.MQTT.BrokerURL = brokerURL
+		config.MQTT.AllowInsecure = allowInsecure
+		return config.Validate()
+	}
+	assert.NoError(t, validate("", false))
+	assert.NoError(t, validate("mqtts://broker.local:8883", false))
+	assert.NoError(t, validate("ssl://broker.local", false))
+	assert.Error(t, validate("mqtt://broker.local:1883", false))
+	assert.Error(t, validate("tcp://broker.local", false))
+	assert.NoError(t, validate("mqtt://broker.local:1883", true))
+	assert.NoError(t, validate("tcp://broker.local", true))
+	assert.Error(t, validate("https://broker.local", true))
+	assert.Error(t, validate("mqtts://broker.local:port", false))
+}
+
+func TestPeerSharingConfigValidate(t *testing.T) {
+	validate := func(peerSharing PeerSharing, keys []string) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.PeerSharing = peerSharing
+		config.ArtifactVerifyKeys = keys
+		return config.Validate()
+	}
+	keys := []string{"/etc/mender/artifact-verify-key.pem"}
+	assert.NoError(t, validate(PeerSharing{}, nil))
+	assert.NoError(t, validate(PeerSharing{Enabled: true, Port: 8080}, keys))
+	assert.Error(t, validate(PeerSharing{Enabled: true}, nil))
+	assert.Error(t, validate(PeerSharing{Enabled: true, Port: 65536}, keys))
+}
+
+func TestArtifactCacheConfigValidate(t *testing.T) {
+	validate := func(cache ArtifactCache, cacheURL string, keys []string) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.ArtifactCache = cache
+		config.ArtifactCacheURL = cacheURL
+		config.ArtifactVerifyKeys = keys
+		return config.Validate()
+	}
+	keys := []string{"/etc/mender/artifact-verify-key.pem"}
+	assert.NoError(t, validate(ArtifactCache{}, "", nil))
+	assert.NoError(t, validate(ArtifactCache{ListenAddress: ":8586"}, "", keys))
+	assert.NoError(t, validate(ArtifactCache{}, "http://gateway.local:8586", nil))
+	assert.Error(t, validate(ArtifactCache{ListenAddress: ":8586"}, "", nil))
+	assert.Error(t, validate(ArtifactCache{ListenAddress: ":8586", MaxSizeMiB: -1}, "", keys))
+	assert.Error(t, validate(ArtifactCache{}, "gateway.local:8586", nil))
+}
+
+func TestDownloadWindowsConfigValidate(t *testing.T) {
+	validate := func(windows []string) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.DownloadWindows = windows
+		return config.Validate()
+	}
+	assert.NoError(t, validate(nil))
+	assert.NoError(t, validate([]string{"* 1-4 * * *", "* * * * 0,6"}))
+	assert.Error(t, validate([]string{"* 1-4 * * *", "* 25 * * *"}))
+
+	config := NewMenderConfig()
+	config.ServerURL = "https://mender.io"
+	config.RebootWindows = []string{"0-30 3 * * *"}
+	config.CommitWindows = []string{"* 3-5 * * *"}
+	assert.NoError(t, config.Validate())
+	config.CommitWindows = []string{"3-5 * * *"}
+	assert.Error(t, config.Validate())
+}
+
+func TestInventoryProvidersConfigValidate(t *testing.T) {
+	validate := func(providers []string, location Location) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.InventoryProviders = providers
+		config.Location = location
+		return config.Validate()
+	}
+	assert.NoError(t, validate([]string{InventoryProviderCPU, InventoryProviderDisk,
+		InventoryProviderPower}, Location{}))
+	assert.Error(t, validate([]string{InventoryProviderCPU, "gpu"}, Location{}))
+
+	location := []string{InventoryProviderLocation}
+	assert.Error(t, validate(location, Location{}))
+	assert.NoError(t, validate(location,
+		Location{Source: LocationSourceStatic, Latitude: 59.91, Longitude: 10.75}))
+	assert.Error(t, validate(location,
+		Location{Source: LocationSourceStatic, Latitude: 91, Longitude: 10.75}))
+	assert.Error(t, validate(location,
+		Location{Source: LocationSourceGPSD, PrecisionDecimals: 7}))
+	assert.NoError(t, validate(location,
+		Location{Source: LocationSourceGPSD, PrecisionDecimals: 3}))
+
+	validateContainers := func(containers Containers) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.InventoryProviders = []string{InventoryProviderContainers}
+		config.Containers = containers
+		return config.Validate()
+	}
+	assert.NoError(t, validateContainers(Containers{}))
+	assert.NoError(t, validateContainers(Containers{Runtime: ContainerRuntimePodman}))
+	assert.NoError(t, validateContainers(Containers{
+		Runtime:   ContainerRuntimeContainerd,
+		Namespace: "k8s.io",
+	}))
+	assert.Error(t, validateContainers(Containers{Runtime: "lxc"}))
+	assert.Error(t, validateContainers(Containers{
+		Runtime:   ContainerRuntimeDocker,
+		Namespace: "k8s.io",
+	}))
+
+	validateIntervals := func(intervals map[string]int) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.InventoryIntervalSeconds = intervals
+		return config.Validate()
+	}
+	assert.NoError(t, validateIntervals(map[string]int{"mender-inventory-packages": 3600}))
+	assert.Error(t, validateIntervals(map[string]int{InventoryProviderCPU: -1}))
+
+	validateBlacklist := func(blacklist []string) error {
+		config := NewMenderConfig()
+		config.ServerURL = "https://mender.io"
+		config.InventoryAttributeBlacklist = blacklist
+		return config.Validate()
+	}
+	assert.NoError(t, validateBlacklist([]string{"hostname", "customer_*"}))
+	assert.Error(t, validateBlacklist([]string{"customer_["}))
+
+	config := NewMenderConfig()
+	config.ServerURL = "https://mender.io"
+	config.InventoryScriptTimeoutSeconds = -1
+	assert.Error(t, config.Validate())
+	config = NewMenderConfig()
+	config.ServerURL = "https://mender.io"
+	config.InventoryScriptConcurrency = -1
+	assert.Error(t, config.Validate())
+}
+
+func TestGetVerificationKeysDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mender-verify-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyDir := path.Join(dir, "keys")
+	require.NoError(t, os.Mkdir(keyDir, 0700))
+	require.NoError(t, os.Mkdir(path.Join(keyDir, "subdir"), 0700))
+	require.NoError(t, ioutil.WriteFile(path.Join(keyDir, "2-new.pem"), []byte("new"), 0600))
+	require.NoError(t, ioutil.WriteFile(path.Join(keyDir, "1-old.pem"), []byte("old"), 0600))
+	single := path.Join(dir, "single.pem")
+	require.NoError(t, ioutil.WriteFile(single, []byte("single"), 0600))
+
+	config := NewMenderConfig()
+	config.ArtifactVerifyKeys = []string{single, keyDir, path.Join(dir, "missing.pem")}
+	keys := config.GetVerificationKeys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, single, keys[0].Path)
+	assert.Equal(t, path.Join(keyDir, "1-old.pem"), keys[1].Path)
+	assert.Equal(t, []byte("old"), keys[1].Data)
+	assert.Equal(t, path.Join(keyDir, "2-new.pem"), keys[2].Path)
+	assert.Equal(t, []byte("new"), keys[2].Data)
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package conf
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/utils"
+)
+
+// FIPSCipherSuites are the TLS cipher suites, in OpenSSL naming, which are
+// allowed in FIPS mode. They are also the default list in that mode.
+var FIPSCipherSuites = []string{
+	"ECDHE-ECDSA-AES256-GCM-SHA384",
+	"ECDHE-RSA-AES256-GCM-SHA384",
+	"ECDHE-ECDSA-AES128-GCM-SHA256",
+	"ECDHE-RSA-AES128-GCM-SHA256",
+	"ECDHE-ECDSA-AES256-SHA384",
+	"ECDHE-RSA-AES256-SHA384",
+	"ECDHE-ECDSA-AES128-SHA256",
+	"ECDHE-RSA-AES128-SHA256",
+	"DHE-RSA-AES256-GCM-SHA384",
+	"DHE-RSA-AES128-GCM-SHA256",
+}
+
+// FIPSTLS13CipherSuites are the TLS 1.3 suites allowed in FIPS mode.
+var FIPSTLS13CipherSuites = []string{
+	"TLS_AES_256_GCM_SHA384",
+	"TLS_AES_128_GCM_SHA256",
+}
+
+// IsFIPSCipherSuite tells whether the suite, in OpenSSL naming, is allowed in
+// FIPS mode.
+func IsFIPSCipherSuite(name string) bool {
+	for _, list := range [][]string{FIPSCipherSuites, FIPSTLS13CipherSuites} {
+		for _, suite := range list {
+			if suite == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateFIPSMode rejects settings which would use algorithms not approved
+// by FIPS 140. The device key itself is checked when it is loaded.
+func (c *MenderConfig) validateFIPSMode() error {
+	security := &c.Security
+	if security.KeyType == "ed25519" {
+		return errors.New("KeyType \"ed25519\" is not allowed")
+	}
+	if security.KeyLength != 0 && security.KeyLength < utils.FIPSMinRSAKeyLength {
+		return errors.Errorf("KeyLength %d is too short", security.KeyLength)
+	}
+	switch security.MinTLSVersion {
+	case "", "1.2", "1.3":
+	default:
+		return errors.Errorf("MinTLSVersion %q is not allowed, "+
+			"it must be \"1.2\" or \"1.3\"", security.MinTLSVersion)
+	}
+	for _, suite := range security.TLSCipherSuites {
+		if !IsFIPSCipherSuite(suite) {
+			return errors.Errorf("TLS cipher suite %q is not allowed", suite)
+		}
+	}
+
+	for _, keyPath := range c.verificationKeyPaths() {
+		data, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			// Reported when the keys are read for verification.
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.Errorf("artifact verify key %s is not PEM encoded", keyPath)
+		}
+		if err = utils.CheckFIPSPublicKey(block.Bytes); err != nil {
+			return errors.Wrapf(err, "artifact verify key %s", keyPath)
+		}
+	}
+	return nil
+}
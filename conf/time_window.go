@@ -0,0 +1,153 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package conf
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TimeWindow is a set of minutes, given in the syntax of the time fields of
+// crontab(5): minute, hour, day of month, month and day of week, such as
+// "* 1-4 * * 1-5" for 01:00 to 04:59 on weekdays. The fields are lists of
+// numbers, ranges and steps, such as "0-5,22-23" or "*/2", and, as with
+// cron, if both days of the month and of the week are restricted, a day
+// matching either is in the window.
+type TimeWindow struct {
+	fields [5]uint64
+	// Whether the days of the month and of the week are restricted.
+	monthDays, weekDays bool
+}
+
+var timeWindowFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	// 0 and 7 are both Sunday.
+	{"day of week", 0, 7},
+}
+
+// ParseTimeWindow parses the time fields of a crontab(5) line.
+func ParseTimeWindow(spec string) (*TimeWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(timeWindowFields) {
+		return nil, errors.Errorf("%q has %d fields instead of %d", spec,
+			len(fields), len(timeWindowFields))
+	}
+	w := &TimeWindow{}
+	for i, field := range fields {
+		bits, err := parseTimeWindowField(field, timeWindowFields[i].min,
+			timeWindowFields[i].max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s in %q", timeWindowFields[i].name, spec)
+		}
+		w.fields[i] = bits
+	}
+	if w.fields[4]&(1<<7) != 0 {
+		w.fields[4] |= 1
+	}
+	w.monthDays = !strings.HasPrefix(fields[2], "*")
+	w.weekDays = !strings.HasPrefix(fields[4], "*")
+	return w, nil
+}
+
+func parseTimeWindowField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, element := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(element, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(element[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q", element[i+1:])
+			}
+			element = element[:i]
+		}
+		first, last := min, max
+		if element != "*" {
+			i := strings.Index(element, "-")
+			var err error
+			if i < 0 {
+				first, err = strconv.Atoi(element)
+				// "5/10" is from 5 to the end.
+				if step == 1 {
+					last = first
+				}
+			} else {
+				first, err = strconv.Atoi(element[:i])
+				if err == nil {
+					last, err = strconv.Atoi(element[i+1:])
+				}
+			}
+			if err != nil {
+				return 0, errors.Errorf("invalid range %q", element)
+			}
+		}
+		if first < min || last > max || first > last {
+			return 0, errors.Errorf("%q is out of %d-%d", element, min, max)
+		}
+		for n := first; n <= last; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// Contains tells whether the minute of the time, in its location, is in the
+// window.
+func (w *TimeWindow) Contains(t time.Time) bool {
+	return w.has(0, t.Minute()) && w.has(1, t.Hour()) && w.has(3, int(t.Month())) &&
+		w.containsDay(t)
+}
+
+// Next returns the start of the first minute from t which is in the window,
+// and false if there is none within a year.
+func (w *TimeWindow) Next(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	end := t.AddDate(1, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !w.has(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !w.containsDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !w.has(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !w.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w *TimeWindow) has(field, n int) bool {
+	return w.fields[field]&(1<<uint(n)) != 0
+}
+
+func (w *TimeWindow) containsDay(t time.Time) bool {
+	monthDay, weekDay := w.has(2, t.Day()), w.has(4, int(t.Weekday()))
+	if w.monthDays && w.weekDays {
+		return monthDay || weekDay
+	}
+	return monthDay && weekDay
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package conf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWindow(t *testing.T) {
+	// May 17 2023 is a Wednesday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2023, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	w, err := ParseTimeWindow("* 1-4 * * *")
+	require.NoError(t, err)
+	assert.False(t, w.Contains(at(17, 0, 59)))
+	assert.True(t, w.Contains(at(17, 1, 0)))
+	assert.True(t, w.Contains(at(17, 4, 59)))
+	assert.False(t, w.Contains(at(17, 5, 0)))
+
+	w, err = ParseTimeWindow("*/15 22-23,0-5 * * 6,7")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(at(20, 23, 45)))
+	assert.False(t, w.Contains(at(20, 23, 46)))
+	assert.True(t, w.Contains(at(21, 0, 0)))
+	assert.False(t, w.Contains(at(17, 0, 0)))
+
+	w, err = ParseTimeWindow("0-29 12 1 1-12/2 *")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(time.Date(2023, 3, 1, 12, 29, 0, 0, time.UTC)))
+	assert.False(t, w.Contains(time.Date(2023, 4, 1, 12, 29, 0, 0, time.UTC)))
+	assert.False(t, w.Contains(time.Date(2023, 3, 2, 12, 29, 0, 0, time.UTC)))
+
+	// Either day matches if both are restricted.
+	w, err = ParseTimeWindow("* * 1 * 3")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(at(17, 12, 0)))
+	assert.True(t, w.Contains(at(1, 12, 0)))
+	assert.False(t, w.Contains(at(18, 12, 0)))
+
+	// From 30 to the end of the hour, every 10 minutes.
+	w, err = ParseTimeWindow("30/10 * * * *")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(at(17, 12, 50)))
+	assert.False(t, w.Contains(at(17, 12, 45)))
+	assert.False(t, w.Contains(at(17, 12, 20)))
+
+	// The next minute in the window
+	w, err = ParseTimeWindow("0 3 * * *")
+	require.NoError(t, err)
+	next, ok := w.Next(at(17, 12, 30))
+	assert.True(t, ok)
+	assert.Equal(t, at(18, 3, 0), next)
+	next, ok = w.Next(at(18, 3, 0))
+	assert.True(t, ok)
+	assert.Equal(t, at(18, 3, 0), next)
+
+	w, err = ParseTimeWindow("*/30 22-23 * 6 1")
+	require.NoError(t, err)
+	next, ok = w.Next(at(17, 22, 31))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2023, 6, 5, 22, 0, 0, 0, time.UTC), next)
+
+	w, err = ParseTimeWindow("* * 30 2 *")
+	require.NoError(t, err)
+	_, ok = w.Next(at(17, 12, 30))
+	assert.False(t, ok)
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 5-1 * * *",
+		"* * 0 * *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"a * * * *",
+		"1- * * * *",
+	} {
+		_, err = ParseTimeWindow(spec)
+		assert.Error(t, err, spec)
+	}
+}
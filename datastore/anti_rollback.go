@@ -0,0 +1,98 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package datastore
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/store"
+)
+
+// CompareVersions compares two versions made of dot separated parts, such as
+// "2.4.10" or "17". Numeric parts are compared as numbers, others as
+// strings, and a version is older than the versions it is a prefix of. The
+// result is negative, zero or positive as a is older than, the same as or
+// newer than b.
+func CompareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.ParseUint(aParts[i], 10, 64)
+		bNum, bErr := strconv.ParseUint(bParts[i], 10, 64)
+		if aErr == nil && bErr == nil {
+			if aNum < bNum {
+				return -1
+			} else if aNum > bNum {
+				return 1
+			}
+		} else if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+			return c
+		}
+	}
+	return len(aParts) - len(bParts)
+}
+
+// CheckAntiRollbackFloor returns an error if the artifact with the given
+// provides is older than the anti-rollback floor, in the version it provides
+// as provide. Once there is a floor, artifacts without the provide are
+// refused too, since they could be old artifacts from before it was
+// introduced.
+func CheckAntiRollbackFloor(dbStore store.Store, provide string,
+	provides map[string]string) error {
+
+	if provide == "" {
+		return nil
+	}
+	floor, err := dbStore.ReadAll(AntiRollbackFloorKey)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, errMsgReadingFromStoreF, "AntiRollbackFloor")
+	}
+	version, ok := provides[provide]
+	if !ok {
+		return errors.Errorf("anti-rollback: the artifact doesn't provide %q, "+
+			"which must be at least %s", provide, floor)
+	}
+	if CompareVersions(version, string(floor)) < 0 {
+		return errors.Errorf("anti-rollback: the artifact provides %s %s, "+
+			"which is older than the minimum %s", provide, version, floor)
+	}
+	return nil
+}
+
+// RaiseAntiRollbackFloor raises the anti-rollback floor to the version of the
+// committed artifact, if it is newer.
+func RaiseAntiRollbackFloor(txn store.Transaction, provide string,
+	provides map[string]string) error {
+
+	version, ok := provides[provide]
+	if provide == "" || !ok {
+		return nil
+	}
+	floor, err := txn.ReadAll(AntiRollbackFloorKey)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, errMsgReadingFromStoreF, "AntiRollbackFloor")
+	}
+	if err == nil && CompareVersions(version, string(floor)) <= 0 {
+		return nil
+	}
+	log.Infof("Raising the anti-rollback minimum of %s to %s", provide, version)
+	return txn.WriteAll(AntiRollbackFloorKey, []byte(version))
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, CompareVersions("1.2.3", "1.2.3"))
+	assert.True(t, CompareVersions("1.2.10", "1.2.9") > 0)
+	assert.True(t, CompareVersions("9", "10") < 0)
+	assert.True(t, CompareVersions("1.2", "1.2.1") < 0)
+	assert.True(t, CompareVersions("2.0", "1.9.9") > 0)
+	assert.True(t, CompareVersions("1.b", "1.a") > 0)
+}
+
+func TestAntiRollbackFloor(t *testing.T) {
+	ms := store.NewMemStore()
+	const provide = "rootfs-image.version"
+	raise := func(version string) {
+		assert.NoError(t, ms.WriteTransaction(func(txn store.Transaction) error {
+			return RaiseAntiRollbackFloor(txn, provide, map[string]string{
+				provide: version,
+			})
+		}))
+	}
+
+	// Anything goes until there is a floor.
+	assert.NoError(t, CheckAntiRollbackFloor(ms, provide, map[string]string{provide: "1"}))
+	assert.NoError(t, CheckAntiRollbackFloor(ms, provide, nil))
+
+	raise("1.5")
+	assert.Error(t, CheckAntiRollbackFloor(ms, provide, map[string]string{provide: "1.4"}))
+	assert.NoError(t, CheckAntiRollbackFloor(ms, provide, map[string]string{provide: "1.5"}))
+	assert.NoError(t, CheckAntiRollbackFloor(ms, provide, map[string]string{provide: "1.10"}))
+	assert.Error(t, CheckAntiRollbackFloor(ms, provide, map[string]string{"other": "2"}))
+
+	// The floor is only ever raised.
+	raise("1.2")
+	floor, err := ms.ReadAll(AntiRollbackFloorKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.5", string(floor))
+	raise("2.0")
+	assert.Error(t, CheckAntiRollbackFloor(ms, provide, map[string]string{provide: "1.5"}))
+
+	// Without a provide to check, there is no check.
+	assert.NoError(t, CheckAntiRollbackFloor(ms, "", map[string]string{provide: "1"}))
+}
@@ -59,6 +59,73 @@ const (
 	// in memory.
 	UpdateControlMaps = "update-control-maps"
 
+	// The URL of the server the client last authorized with. Used to stay
+	// with the same server across restarts during a deployment, when
+	// several weighted Servers are configured.
+	AuthServerURLKey = "auth-server-url"
+
+	// Status reports, deployment logs and inventory data which could not
+	// be delivered because the server could not be reached, waiting to be
+	// sent. A JSON list of entries.
+	OfflineQueueKey = "offline-queue"
+
+	// How far the artifact download has got, its checksum so far, and where
+	// it is kept, so that it can continue without downloading that part
+	// again. As JSON.
+	DownloadResumeKey = "download-resume"
+
+	// The ETag of the last update check response, which said there was no
+	// deployment, and a digest of the request it answered. Sent in
+	// If-None-Match with the next identical update check.
+	UpdateCheckETagKey = "update-check-etag"
+
+	// A tenant token set at runtime, and the configured tenant token it
+	// replaced, as JSON. Ignored once the configured token changes.
+	TenantTokenKey = "tenant-token"
+
+	// The refresh token issued by the OIDC provider, so that the device
+	// doesn't need to be approved again after a restart.
+	OIDCRefreshTokenKey = "oidc-refresh-token"
+
+	// SHA-256 hash of the bootstrap token of the configuration, once the
+	// device has been authorized with it, so that it is only used once.
+	BootstrapTokenUsedKey = "bootstrap-token-used"
+
+	// The number of consecutive times the server rejected the device, and
+	// until when no authorization is attempted, as JSON.
+	AuthRejectionsKey = "auth-rejections"
+
+	// The lowest version of the AntiRollbackProvide of the configuration
+	// which may be installed, the highest committed so far. Only ever
+	// raised.
+	AntiRollbackFloorKey = "anti-rollback-floor"
+
+	// Inventory attributes set by local applications over D-Bus, submitted
+	// with those of the inventory scripts. A JSON object of lists of
+	// values.
+	RuntimeInventoryKey = "runtime-inventory"
+
+	// The last inventory submitted, and when, so that unchanged inventory
+	// isn't submitted again after a restart, and partial submissions can
+	// continue. As JSON.
+	InventoryCacheKey = "inventory-cache"
+
+	// The ID of the deployment paused locally, which stays paused until
+	// it is resumed, also after a restart.
+	DeploymentPausedKey = "deployment-paused"
+
+	// How much of the update has been written to the inactive partition,
+	// so that the write can continue after a power loss. As JSON.
+	RootfsWriteProgressKey = "rootfs-write-progress"
+
+	// The artifact shared with other devices on the local network, with
+	// its size and checksum. As JSON.
+	PeerSharedArtifactKey = "peer-shared-artifact"
+
+	// The artifacts kept by the artifact cache of the site, with their
+	// files and when they were last used. As JSON.
+	ArtifactCacheIndexKey = "artifact-cache-index"
+
 	// ---------------------- NOT IN USE ANYMORE --------------------------
 
 	// Key used to store the auth token.
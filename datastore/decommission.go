@@ -0,0 +1,94 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/store"
+)
+
+// DecommissionKeys are the keys removed when the device is decommissioned:
+// everything tying the device to a server, a tenant or a deployment. The
+// keys describing the installed software, and the anti-rollback floor, are
+// kept, since they stay true of the device.
+var DecommissionKeys = []string{
+	StandaloneStateKey,
+	StateDataKey,
+	StateDataKeyUncommitted,
+	UpdateControlMaps,
+	AuthServerURLKey,
+	OfflineQueueKey,
+	DownloadResumeKey,
+	UpdateCheckETagKey,
+	TenantTokenKey,
+	OIDCRefreshTokenKey,
+	BootstrapTokenUsedKey,
+	AuthRejectionsKey,
+	RuntimeInventoryKey,
+	InventoryCacheKey,
+	DeploymentPausedKey,
+	RootfsWriteProgressKey,
+	PeerSharedArtifactKey,
+	ArtifactCacheIndexKey,
+	AuthTokenName,
+	AuthTokenCacheInvalidatorName,
+}
+
+// WipeDecommissioned removes the DecommissionKeys from the store, in one
+// transaction.
+func WipeDecommissioned(dbStore store.Store) error {
+	err := dbStore.WriteTransaction(func(txn store.Transaction) error {
+		for _, key := range DecommissionKeys {
+			if err := txn.Remove(key); err != nil {
+				return errors.Wrapf(err, "failed to remove %s", key)
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "failed to wipe the local store")
+}
+
+// WipeDecommissionedFiles removes the files, and the contents of the
+// directories, in paths. Those which don't exist are skipped.
+func WipeDecommissionedFiles(paths []string) error {
+	for _, p := range paths {
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to remove %s", p)
+		}
+		if !info.IsDir() {
+			if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "failed to remove %s", p)
+			}
+			continue
+		}
+		entries, err := ioutil.ReadDir(p)
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove the contents of %s", p)
+		}
+		for _, entry := range entries {
+			if err = os.RemoveAll(filepath.Join(p, entry.Name())); err != nil {
+				return errors.Wrapf(err, "failed to remove the contents of %s", p)
+			}
+		}
+	}
+	return nil
+}
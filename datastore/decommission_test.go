@@ -0,0 +1,125 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package datastore
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestWipeDecommissioned(t *testing.T) {
+	ms := store.NewMemStore()
+	for _, key := range DecommissionKeys {
+		require.NoError(t, ms.WriteAll(key, []byte("data")))
+	}
+	require.NoError(t, ms.WriteAll(ArtifactNameKey, []byte("release-1")))
+	require.NoError(t, ms.WriteAll(AntiRollbackFloorKey, []byte("3")))
+
+	require.NoError(t, WipeDecommissioned(ms))
+	for _, key := range DecommissionKeys {
+		_, err := ms.ReadAll(key)
+		assert.Equal(t, os.ErrNotExist, err, key)
+	}
+	data, err := ms.ReadAll(ArtifactNameKey)
+	require.NoError(t, err)
+	assert.Equal(t, "release-1", string(data))
+	_, err = ms.ReadAll(AntiRollbackFloorKey)
+	assert.NoError(t, err)
+
+	// Wiping again is fine.
+	assert.NoError(t, WipeDecommissioned(ms))
+}
+
+func TestWipeDecommissionedFiles(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "TestWipeDecommissionedFiles")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	logFile := filepath.Join(tdir, "deployments.0001.1234.log")
+	keptFile := filepath.Join(tdir, "mender-agent.pem")
+	cacheDir := filepath.Join(tdir, "artifact-cache")
+	for _, file := range []string{
+		logFile,
+		keptFile,
+		filepath.Join(cacheDir, "1.mender"),
+		filepath.Join(cacheDir, "partial", "2.mender"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0700))
+		require.NoError(t, ioutil.WriteFile(file, []byte("data"), 0600))
+	}
+	paths := []string{logFile, cacheDir, filepath.Join(tdir, "missing")}
+
+	require.NoError(t, WipeDecommissionedFiles(paths))
+	_, err = os.Stat(logFile)
+	assert.True(t, os.IsNotExist(err))
+	// Directories are emptied, but kept.
+	entries, err := ioutil.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = os.Stat(keptFile)
+	assert.NoError(t, err)
+
+	// Wiping again is fine.
+	assert.NoError(t, WipeDecommissionedFiles(paths))
+}
+
+// Every key in dbkeys.go must either be wiped on decommissioning, or be
+// listed here as describing the device itself.
+func TestDecommissionKeysComplete(t *testing.T) {
+	kept := map[string]bool{
+		ArtifactNameKey:             true,
+		ArtifactGroupKey:            true,
+		ArtifactTypeInfoProvidesKey: true,
+		AntiRollbackFloorKey:        true,
+	}
+	wiped := map[string]bool{}
+	for _, key := range DecommissionKeys {
+		wiped[key] = true
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "dbkeys.go", nil, 0)
+	require.NoError(t, err)
+	found := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				lit, ok := value.Values[i].(*ast.BasicLit)
+				require.True(t, ok, name.Name)
+				key, err := strconv.Unquote(lit.Value)
+				require.NoError(t, err)
+				found++
+				assert.True(t, wiped[key] != kept[key],
+					"%s must be in exactly one of DecommissionKeys and the kept keys",
+					name.Name)
+			}
+		}
+	}
+	assert.Equal(t, len(wiped)+len(kept), found)
+}
@@ -22,4 +22,5 @@ type StandaloneStateData struct {
 	ArtifactTypeInfoProvides map[string]string
 	ArtifactClearsProvides   []string `json:",omitempty"`
 	PayloadTypes             []string
+	InstallOrder             []int `json:",omitempty"`
 }
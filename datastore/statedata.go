@@ -118,6 +118,10 @@ const (
 	MenderStateFetchUpdateControl
 	// retry the above state upon request errors
 	MenderStateFetchRetryUpdateControl
+	// wait for the reboot or commit window to open
+	MenderStateMaintenanceWindowWait
+	// wait for the deployment paused locally to be resumed
+	MenderStateDeploymentPaused
 
 	// No longer used
 	MenderStateReportStatusError
@@ -163,6 +167,8 @@ var (
 		MenderStateUpdateControlPause:               "mender-update-control-pause",
 		MenderStateFetchUpdateControl:               "mender-update-control-refresh-maps",
 		MenderStateFetchRetryUpdateControl:          "mender-update-control-retry-refresh-maps",
+		MenderStateMaintenanceWindowWait:            "maintenance-window-wait",
+		MenderStateDeploymentPaused:                 "deployment-paused",
 
 		// No longer used. Since this used to be at the very end of an
 		// update, if we encounter it in the database during startup, we
@@ -287,6 +293,15 @@ type UpdateInfo struct {
 	// all of them.
 	SupportsRollback SupportsRollbackType
 
+	// The order the payloads are installed in, as indices of PayloadTypes.
+	// Empty means the order of PayloadTypes.
+	InstallOrder []int `json:",omitempty"`
+
+	// How many payloads, in InstallOrder, installing has started for, so
+	// that only those are rolled back if installing one fails. Zero means
+	// all of them.
+	PayloadsInstalled int `json:",omitempty"`
+
 	// How many times this update's state has been stored. This is roughly,
 	// but not exactly, equivalent to the number of state transitions, and
 	// is used to break out of loops.
@@ -0,0 +1,157 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package device
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	defaultAttestationPCRBank  = "sha256"
+	defaultAttestationEventLog = "/sys/kernel/security/tpm0/binary_bios_measurements"
+	defaultTPM2QuoteCommand    = "tpm2_quote"
+)
+
+var defaultAttestationPCRs = []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+// Attestation collects evidence of the boot state of the device from its
+// TPM 2.0.
+type Attestation struct {
+	keyHandle string
+	pcrs      []int
+	pcrBank   string
+	eventLog  string
+	// The tool quoting the PCRs.
+	quoteCommand string
+}
+
+// NewAttestation returns a collector of evidence with the given
+// configuration, or nil if attestation is not enabled.
+func NewAttestation(config conf.Attestation) *Attestation {
+	if config.KeyHandle == "" {
+		return nil
+	}
+	a := &Attestation{
+		keyHandle: config.KeyHandle,
+		pcrs:      config.PCRs,
+		pcrBank:   config.PCRBank,
+		eventLog:  config.EventLog,
+
+		quoteCommand: defaultTPM2QuoteCommand,
+	}
+	if len(a.pcrs) == 0 {
+		a.pcrs = defaultAttestationPCRs
+	}
+	if a.pcrBank == "" {
+		a.pcrBank = defaultAttestationPCRBank
+	}
+	if a.eventLog == "" {
+		a.eventLog = defaultAttestationEventLog
+	}
+	return a
+}
+
+// pcrSelection returns the PCRs to quote in the format of tpm2-tools.
+func (a *Attestation) pcrSelection() string {
+	pcrs := make([]string, len(a.pcrs))
+	for i, pcr := range a.pcrs {
+		pcrs[i] = strconv.Itoa(pcr)
+	}
+	return a.pcrBank + ":" + strings.Join(pcrs, ",")
+}
+
+// Collect has the TPM quote the PCRs, with the nonce as qualifying data, and
+// reads the event log, if withEventLog is set and there is one.
+func (a *Attestation) Collect(
+	nonce []byte,
+	withEventLog bool,
+) (*client.AttestationEvidence, error) {
+	dir, err := ioutil.TempDir("", "mender-attestation")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	quoteFile := path.Join(dir, "quote")
+	sigFile := path.Join(dir, "signature")
+	pcrsFile := path.Join(dir, "pcrs")
+
+	selection := a.pcrSelection()
+	out, err := exec.Command(a.quoteCommand,
+		"--key-context", a.keyHandle,
+		"--pcr-list", selection,
+		"--qualification", hex.EncodeToString(nonce),
+		"--hash-algorithm", a.pcrBank,
+		"--message", quoteFile,
+		"--signature", sigFile,
+		"--pcr", pcrsFile).CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to quote the PCRs: %s",
+			strings.TrimSpace(string(out)))
+	}
+
+	evidence := &client.AttestationEvidence{
+		PCRSelection: selection,
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+	}
+	for file, field := range map[string]*string{
+		quoteFile: &evidence.Quote,
+		sigFile:   &evidence.Signature,
+		pcrsFile:  &evidence.PCRs,
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the quote")
+		}
+		*field = base64.StdEncoding.EncodeToString(data)
+	}
+
+	if withEventLog {
+		data, err := ioutil.ReadFile(a.eventLog)
+		if err == nil {
+			evidence.EventLog = base64.StdEncoding.EncodeToString(data)
+		} else if os.IsNotExist(err) {
+			log.Debugf("No measured boot event log at %s", a.eventLog)
+		} else {
+			return nil, errors.Wrap(err, "failed to read the measured boot event log")
+		}
+	}
+	return evidence, nil
+}
+
+// AttestationInventory returns the evidence as inventory attributes, leaving
+// out the event log.
+func AttestationInventory(
+	evidence *client.AttestationEvidence,
+) []client.InventoryAttribute {
+	return []client.InventoryAttribute{
+		{Name: "attestation_quote", Value: evidence.Quote},
+		{Name: "attestation_signature", Value: evidence.Signature},
+		{Name: "attestation_pcr_selection", Value: evidence.PCRSelection},
+		{Name: "attestation_pcrs", Value: evidence.PCRs},
+		{Name: "attestation_nonce", Value: evidence.Nonce},
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package device
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// Writes its arguments to the message, signature and PCR files, so that
+// the test can check them.
+const fakeTPM2Quote = `#!/bin/sh
+args="$*"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--message) echo "$args" > "$2" ;;
+	--signature) echo -n signature > "$2" ;;
+	--pcr) echo -n pcrs > "$2" ;;
+	esac
+	shift
+done
+`
+
+func TestAttestation(t *testing.T) {
+	assert.Nil(t, NewAttestation(conf.Attestation{}))
+
+	dir, err := ioutil.TempDir("", "mender-attestation-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	quote := path.Join(dir, "tpm2_quote")
+	require.NoError(t, ioutil.WriteFile(quote, []byte(fakeTPM2Quote), 0755))
+	eventLog := path.Join(dir, "event_log")
+
+	a := NewAttestation(conf.Attestation{
+		KeyHandle: "0x81010002",
+		EventLog:  eventLog,
+	})
+	require.NotNil(t, a)
+	a.quoteCommand = quote
+
+	// no event log
+	evidence, err := a.Collect([]byte{0xab, 0xcd}, true)
+	require.NoError(t, err)
+	data, err := base64.StdEncoding.DecodeString(evidence.Quote)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "--key-context 0x81010002")
+	assert.Contains(t, string(data), "--pcr-list sha256:0,1,2,3,4,5,6,7")
+	assert.Contains(t, string(data), "--qualification abcd")
+	assert.Equal(t, "sha256:0,1,2,3,4,5,6,7", evidence.PCRSelection)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("signature")), evidence.Signature)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("pcrs")), evidence.PCRs)
+	assert.Equal(t, "q80=", evidence.Nonce)
+	assert.Empty(t, evidence.EventLog)
+
+	require.NoError(t, ioutil.WriteFile(eventLog, []byte("log"), 0600))
+	evidence, err = a.Collect(nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("log")), evidence.EventLog)
+	evidence, err = a.Collect(nil, false)
+	require.NoError(t, err)
+	assert.Empty(t, evidence.EventLog)
+
+	attrs := AttestationInventory(evidence)
+	assert.Len(t, attrs, 5)
+	assert.Equal(t, "attestation_signature", attrs[1].Name)
+	assert.Equal(t, evidence.Signature, attrs[1].Value)
+
+	a = NewAttestation(conf.Attestation{
+		KeyHandle: "0x81010002",
+		PCRs:      []int{0, 7},
+		PCRBank:   "sha1",
+	})
+	assert.Equal(t, "sha1:0,7", a.pcrSelection())
+
+	a.quoteCommand = "false"
+	_, err = a.Collect(nil, false)
+	assert.Error(t, err)
+}
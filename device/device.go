@@ -148,6 +148,12 @@ func (d *DeviceManager) getValueFromDatabaseKey(key string) (string, error) {
 	return "", nil
 }
 
+// GetAntiRollbackProvide returns the name of the provide holding the version
+// checked against the anti-rollback minimum, empty if there is none.
+func (d *DeviceManager) GetAntiRollbackProvide() string {
+	return d.Config.AntiRollbackProvide
+}
+
 func (d *DeviceManager) GetDeviceType() (string, error) {
 	return GetDeviceType(d.DeviceTypeFile)
 }
@@ -14,7 +14,9 @@
 package device
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"path"
 
 	"github.com/pkg/errors"
@@ -49,8 +51,44 @@ func NewIdentityDataGetter() IdentityDataGetter {
 	}
 }
 
+// NewConfiguredIdentityDataGetter returns a getter using the identity helper
+// of the configuration, if one is given, instead of, or together with, the
+// default helper, adding the fixed identity attributes of the configuration,
+// and hashing or omitting the attributes the configuration says to.
+func NewConfiguredIdentityDataGetter(config *conf.MenderConfig) IdentityDataGetter {
+	runners := []*IdentityDataRunner{{IdentityDataHelper, &system.OsCalls{}}}
+	if config.IdentityHelper != "" {
+		helper := &IdentityDataRunner{config.IdentityHelper, &system.OsCalls{}}
+		if config.IdentityHelperMerge {
+			runners = append(runners, helper)
+		} else {
+			runners = []*IdentityDataRunner{helper}
+		}
+	}
+	filter := utils.NewAttributeFilter(config.HashedAttributes,
+		config.OmittedAttributes, config.AttributeHashKey)
+	if len(runners) == 1 && len(config.IdentityAttributes) == 0 && filter == nil {
+		return runners[0]
+	}
+	static := IdentityData{}
+	for k, v := range config.IdentityAttributes {
+		static[k] = v
+	}
+	return mergedIdentityData{runners: runners, static: static, filter: filter}
+}
+
 // Obtain identity data by calling a suitable helper tool
 func (id IdentityDataRunner) Get() (string, error) {
+	data, err := id.collect()
+	if err != nil {
+		return "", err
+	}
+	return data.encode()
+}
+
+// collect runs the helper, which prints either key=value lines, or a JSON
+// object with strings, or lists of strings, as values.
+func (id IdentityDataRunner) collect() (IdentityData, error) {
 	helper := IdentityDataHelper
 
 	if id.Helper != "" {
@@ -61,35 +99,116 @@ func (id IdentityDataRunner) Get() (string, error) {
 
 	out, err := cmd.StdoutPipe()
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to open pipe for reading")
+		return nil, errors.Wrapf(err, "failed to open pipe for reading")
 	}
 
 	if err := cmd.Start(); err != nil {
-		return "", errors.Wrapf(err, "failed to call %s", helper)
+		return nil, errors.Wrapf(err, "failed to call %s", helper)
 	}
 
-	p := utils.KeyValParser{}
-	parseErr := p.Parse(out)
+	output, err := ioutil.ReadAll(out)
+	var data IdentityData
+	var parseErr error
+	if err != nil {
+		parseErr = err
+	} else if bytes.HasPrefix(bytes.TrimSpace(output), []byte("{")) {
+		data, parseErr = parseJSONIdentityData(output)
+	} else {
+		p := utils.KeyValParser{}
+		if parseErr = p.Parse(bytes.NewReader(output)); parseErr == nil {
+			data = IdentityData{}
+			data.AppendFromRaw(p.Collect())
+		}
+	}
 
 	if err := cmd.Wait(); err != nil {
 		if parseErr != nil {
 			err = errors.Wrapf(parseErr, "failed to parse identity data")
 		}
-		return "", errors.Wrapf(err, "wait for helper failed")
+		return nil, errors.Wrapf(err, "wait for helper failed")
 	}
 
 	if parseErr != nil {
-		return "", errors.Wrapf(parseErr, "failed to parse identity data")
+		return nil, errors.Wrapf(parseErr, "failed to parse identity data")
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("no identity data colleted")
 	}
+	return data, nil
+}
 
-	collected := p.Collect()
-	if len(collected) == 0 {
-		return "", errors.New("no identity data colleted")
+func parseJSONIdentityData(output []byte) (IdentityData, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, err
 	}
 	data := IdentityData{}
-	data.AppendFromRaw(collected)
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			data[k] = v
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, e := range v {
+				s, ok := e.(string)
+				if !ok {
+					return nil, errors.Errorf(
+						"identity attribute %q is not a list of strings", k)
+				}
+				values = append(values, s)
+			}
+			data[k] = values
+		default:
+			return nil, errors.Errorf(
+				"identity attribute %q is neither a string nor a list of strings", k)
+		}
+	}
+	return data, nil
+}
+
+// mergedIdentityData combines the identity data of several helpers, and
+// fixed attributes, the attributes of later helpers replacing those of
+// earlier ones, and the fixed attributes replacing those of the helpers.
+// The filter is applied to the result.
+type mergedIdentityData struct {
+	runners []*IdentityDataRunner
+	static  IdentityData
+	filter  *utils.AttributeFilter
+}
 
-	encdata, err := json.Marshal(data)
+func (m mergedIdentityData) Get() (string, error) {
+	merged := IdentityData{}
+	for _, runner := range m.runners {
+		data, err := runner.collect()
+		if err != nil {
+			return "", err
+		}
+		for k, v := range data {
+			merged[k] = v
+		}
+	}
+	for k, v := range m.static {
+		merged[k] = v
+	}
+	for k, v := range merged {
+		if v, ok := m.filter.Filter(k, v); ok {
+			merged[k] = v
+		} else {
+			delete(merged, k)
+		}
+	}
+	if len(merged) == 0 {
+		return "", errors.New("no identity data left after omitting attributes")
+	}
+	return merged.encode()
+}
+
+// Try to keep things simple and reuse InventoryData as identity data structure
+type IdentityData map[string]interface{}
+
+func (id IdentityData) encode() (string, error) {
+	encdata, err := json.Marshal(id)
 	if err != nil {
 		return "", errors.Wrapf(err, "failed to encode identity data")
 	}
@@ -97,9 +216,6 @@ func (id IdentityDataRunner) Get() (string, error) {
 	return string(encdata), nil
 }
 
-// Try to keep things simple and reuse InventoryData as identity data structure
-type IdentityData map[string]interface{}
-
 func (id IdentityData) AppendFromRaw(raw map[string][]string) {
 	for k, v := range raw {
 		if len(v) == 1 {
@@ -17,7 +17,9 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/mendersoftware/mender/conf"
 	stest "github.com/mendersoftware/mender/system/testing"
+	"github.com/mendersoftware/mender/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -87,6 +89,33 @@ keyvalue
 			IdentityData{},
 			0,
 		},
+		{
+			`{"serial": "ABC123", "mac": ["de:ad:be:ef:00:01", "de:ad:be:ef:00:02"]}`,
+			false,
+			IdentityData{
+				"serial": "ABC123",
+				"mac":    []string{"de:ad:be:ef:00:01", "de:ad:be:ef:00:02"},
+			},
+			0,
+		},
+		{
+			`{"serial": 123}`,
+			true,
+			nil,
+			0,
+		},
+		{
+			`{"serial": "ABC123"`,
+			true,
+			nil,
+			0,
+		},
+		{
+			`{}`,
+			true,
+			nil,
+			0,
+		},
 	}
 
 	for id, tc := range td {
@@ -109,3 +138,75 @@ keyvalue
 		}
 	}
 }
+
+func TestMergedIdentityData(t *testing.T) {
+	m := mergedIdentityData{runners: []*IdentityDataRunner{
+		{Cmdr: stest.NewTestOSCalls("mac=de:ad:be:ef:00:01\nserial=unknown\n", 0)},
+		{Cmdr: stest.NewTestOSCalls(`{"serial": "ABC123"}`, 0)},
+	}}
+	id, err := m.Get()
+	assert.NoError(t, err)
+	refdata, _ := json.Marshal(IdentityData{
+		"mac":    "de:ad:be:ef:00:01",
+		"serial": "ABC123",
+	})
+	assert.Equal(t, string(refdata), id)
+
+	m.static = IdentityData{"plant": "berlin-03", "serial": "fixed"}
+	id, err = m.Get()
+	assert.NoError(t, err)
+	refdata, _ = json.Marshal(IdentityData{
+		"mac":    "de:ad:be:ef:00:01",
+		"plant":  "berlin-03",
+		"serial": "fixed",
+	})
+	assert.Equal(t, string(refdata), id)
+
+	m.filter = utils.NewAttributeFilter([]string{"mac"}, []string{"plant"}, "key")
+	id, err = m.Get()
+	assert.NoError(t, err)
+	mac, _ := m.filter.Filter("mac", "de:ad:be:ef:00:01")
+	refdata, _ = json.Marshal(IdentityData{
+		"mac":    mac,
+		"serial": "fixed",
+	})
+	assert.Equal(t, string(refdata), id)
+
+	m.filter = utils.NewAttributeFilter(nil, []string{"mac", "plant", "serial"}, "")
+	_, err = m.Get()
+	assert.Error(t, err)
+	m.filter = nil
+
+	m.runners[1].Cmdr = stest.NewTestOSCalls("", 1)
+	_, err = m.Get()
+	assert.Error(t, err)
+
+	config := &conf.MenderConfig{}
+	assert.Equal(t, NewIdentityDataGetter(), NewConfiguredIdentityDataGetter(config))
+	config.IdentityHelper = "/usr/bin/serial-identity"
+	runner, ok := NewConfiguredIdentityDataGetter(config).(*IdentityDataRunner)
+	assert.True(t, ok)
+	assert.Equal(t, "/usr/bin/serial-identity", runner.Helper)
+	config.IdentityHelperMerge = true
+	m, ok = NewConfiguredIdentityDataGetter(config).(mergedIdentityData)
+	assert.True(t, ok)
+	assert.Len(t, m.runners, 2)
+	assert.Equal(t, IdentityDataHelper, m.runners[0].Helper)
+	assert.Equal(t, "/usr/bin/serial-identity", m.runners[1].Helper)
+
+	config = &conf.MenderConfig{MenderConfigFromFile: conf.MenderConfigFromFile{
+		IdentityAttributes: map[string]string{"plant": "berlin-03"},
+	}}
+	m, ok = NewConfiguredIdentityDataGetter(config).(mergedIdentityData)
+	assert.True(t, ok)
+	assert.Len(t, m.runners, 1)
+	assert.Equal(t, IdentityDataHelper, m.runners[0].Helper)
+	assert.Equal(t, IdentityData{"plant": "berlin-03"}, m.static)
+
+	config = &conf.MenderConfig{MenderConfigFromFile: conf.MenderConfigFromFile{
+		HashedAttributes: []string{"mac"},
+	}}
+	m, ok = NewConfiguredIdentityDataGetter(config).(mergedIdentityData)
+	assert.True(t, ok)
+	assert.NotNil(t, m.filter)
+}
@@ -64,6 +64,19 @@ var blockdevice bdevice
 // Open tries to open the 'device' (/dev/<device> usually), and returns a
 // BlockDevice.
 func (bd bdevice) Open(device string, size int64) (*BlockDevice, error) {
+	return bd.openAt(device, size, 0, nil)
+}
+
+// openAt opens the device for writing the rest of an update, of which the
+// first 'offset' bytes are already on the device. 'synced', if not nil, is
+// called with every frame once it is on the device, except for the last one,
+// which only is when the device is closed. Not possible on UBI volumes, which
+// are always updated as a whole.
+func (bd bdevice) openAt(
+	device string,
+	size, offset int64,
+	synced func(frame []byte),
+) (*BlockDevice, error) {
 	log.Infof("Opening device %q for writing", device)
 
 	var out *os.File
@@ -94,6 +107,10 @@ func (bd bdevice) Open(device string, size int64) (*BlockDevice, error) {
 
 	log.Debugf("Device: %s is a ubi device: %t", device, typeUBI)
 
+	if typeUBI && offset != 0 {
+		return nil, errors.New("can't continue writing an update to a UBI volume")
+	}
+
 	var flag int
 
 	if typeUBI {
@@ -119,9 +136,9 @@ func (bd bdevice) Open(device string, size int64) (*BlockDevice, error) {
 			device, err)
 		return nil, err
 	} else if bsz < uint64(size) {
-		log.Errorf("Update (%v bytes) is larger than the size of device %s (%v bytes)",
+		return nil, errors.Wrapf(syscall.ENOSPC,
+			"the update (%v bytes) is larger than the size of device %s (%v bytes)",
 			size, device, bsz)
-		return nil, syscall.ENOSPC
 	}
 
 	nativeSsz, err := b.SectorSize()
@@ -157,6 +174,12 @@ func (bd bdevice) Open(device string, size int64) (*BlockDevice, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to open the device: %q", device)
 	}
+	if offset > 0 {
+		if _, err = out.Seek(offset, io.SeekStart); err != nil {
+			out.Close()
+			return nil, errors.Wrapf(err, "Failed to seek on the device: %q", device)
+		}
+	}
 
 	// From <mtd/ubi-user.h>
 	//
@@ -198,6 +221,7 @@ func (bd bdevice) Open(device string, size int64) (*BlockDevice, error) {
 		//
 		odw := &OptimizedBlockDeviceWriter{
 			blockDevice: fw,
+			synced:      synced,
 		}
 
 		//
@@ -221,7 +245,7 @@ func (bd bdevice) Open(device string, size int64) (*BlockDevice, error) {
 	//
 	b.w = &utils.LimitedWriteCloser{
 		W: bdw,
-		N: uint64(size),
+		N: uint64(size - offset),
 	}
 
 	return b, nil
@@ -349,6 +373,9 @@ type OptimizedBlockDeviceWriter struct {
 	blockDevice BlockDevicer
 	totalFrames int
 	dirtyFrames int
+	// Called with every frame once it is on the block-device. The frames
+	// are at least a sector, so the FlushingWriter syncs every one written.
+	synced func(frame []byte)
 }
 
 // Write only write 'dirty' frames.
@@ -379,11 +406,18 @@ func (bd *OptimizedBlockDeviceWriter) Write(b []byte) (n int, err error) {
 		}
 		bd.totalFrames += 1
 		bd.dirtyFrames += 1
-		return bd.blockDevice.Write(b)
+		n, err = bd.blockDevice.Write(b)
+		if err == nil && bd.synced != nil {
+			bd.synced(b)
+		}
+		return n, err
 	}
 
 	// No need to write a clean frame
 	bd.totalFrames += 1
+	if bd.synced != nil {
+		bd.synced(b)
+	}
 	return n, err
 }
 
@@ -0,0 +1,158 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package installer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+	"github.com/mendersoftware/mender/system"
+)
+
+// RootfsDeltaType is the payload type of delta updates of the root
+// filesystem. The payload is a VCDIFF delta, made with xdelta3, of the new
+// root filesystem against the one installed, which the new one is
+// reconstructed from in the inactive partition.
+const RootfsDeltaType = "rootfs-image-delta"
+
+type dualRootfsDeltaImpl struct {
+	*dualRootfsDeviceImpl
+	// The xdelta3 program applying the delta.
+	xdelta3 string
+	// The checksum and size of the new root filesystem, from the headers
+	// of the payload.
+	checksum string
+	size     int64
+}
+
+func (d *dualRootfsDeltaImpl) Initialize(artifactHeaders,
+	artifactAugmentedHeaders artifact.HeaderInfoer,
+	payloadHeaders handlers.ArtifactUpdateHeaders) error {
+
+	if payloadHeaders == nil {
+		return errors.New("delta update without payload headers")
+	}
+	provides, err := payloadHeaders.GetUpdateProvides()
+	if err != nil {
+		return err
+	}
+	// The checksum is what the new root filesystem is verified against,
+	// since what is written to the partition is not what was downloaded.
+	d.checksum = provides["rootfs-image.checksum"]
+	if d.checksum == "" {
+		return errors.New("delta update without the rootfs-image.checksum " +
+			"of the new root filesystem in its provides")
+	}
+	metaData, err := payloadHeaders.GetUpdateMetaData()
+	if err != nil {
+		return err
+	}
+	switch size := metaData["image_size"].(type) {
+	case nil:
+	case float64:
+		d.size = int64(size)
+	default:
+		return errors.Errorf("invalid type %T for image_size in the meta-data", size)
+	}
+	return nil
+}
+
+func (d *dualRootfsDeltaImpl) StoreUpdate(delta io.Reader, info os.FileInfo) error {
+	activePartition, err := d.GetActive()
+	if err != nil {
+		return err
+	}
+	inactivePartition, err := d.GetInactive()
+	if err != nil {
+		return err
+	}
+	if system.IsUbiBlockDevice(activePartition) {
+		activePartition = filepath.Join("/dev", activePartition)
+	}
+	// UBI volumes need the size of the image before it is written.
+	if d.size <= 0 && system.IsUbiBlockDevice(inactivePartition) {
+		return errors.New("delta update without the image_size of the new " +
+			"root filesystem in its meta-data, which UBI volumes need")
+	}
+
+	// Without the size, as much as the partition takes may be written.
+	size := d.size
+	if size <= 0 {
+		partitionSize, err := (&BlockDevice{Path: inactivePartition}).Size()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the size of %s", inactivePartition)
+		}
+		size = int64(partitionSize)
+	}
+
+	dev, err := blockdevice.Open(inactivePartition, size)
+	if err != nil {
+		errmsg := "Failed to write the update to the inactive partition: %q"
+		return errors.Wrapf(err, errmsg, inactivePartition)
+	}
+
+	hash := sha256.New()
+	written := &writeCounter{}
+	var stderr bytes.Buffer
+	cmd := exec.Command(d.xdelta3, "-d", "-c", "-s", activePartition)
+	cmd.Stdin = delta
+	cmd.Stdout = io.MultiWriter(dev, hash, written)
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		dev.Close()
+		return errors.Wrapf(err, "failed to apply the delta to %s: %s",
+			activePartition, strings.TrimSpace(stderr.String()))
+	}
+
+	if err = dev.Close(); err != nil {
+		log.Errorf("Failed to close the block-device. Error: %v", err)
+		return err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != d.checksum {
+		return errors.Errorf("the root filesystem reconstructed from the delta "+
+			"has checksum %s instead of %s; was the delta made against "+
+			"another root filesystem?", checksum, d.checksum)
+	}
+
+	log.Infof("Wrote %d bytes, reconstructed from a delta of %d bytes, "+
+		"to the inactive partition", written.n, info.Size())
+
+	return nil
+}
+
+func (d *dualRootfsDeltaImpl) GetType() string {
+	return RootfsDeltaType
+}
+
+type writeCounter struct {
+	n int64
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
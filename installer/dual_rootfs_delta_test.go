@@ -0,0 +1,128 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+// "Applies" the delta by appending it to the source.
+const fakeXdelta3 = `#!/bin/sh
+[ "$1 $2 $3" = "-d -c -s" ] || exit 2
+cat "$4" -
+`
+
+type fakeDeltaHeaders struct {
+	handlers.ArtifactUpdateHeaders
+	provides artifact.TypeInfoProvides
+	metaData map[string]interface{}
+}
+
+func (h *fakeDeltaHeaders) GetUpdateProvides() (artifact.TypeInfoProvides, error) {
+	return h.provides, nil
+}
+
+func (h *fakeDeltaHeaders) GetUpdateMetaData() (map[string]interface{}, error) {
+	return h.metaData, nil
+}
+
+func TestDualRootfsDelta(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mender-rootfs-delta")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	xdelta3 := path.Join(tmpDir, "xdelta3")
+	require.NoError(t, ioutil.WriteFile(xdelta3, []byte(fakeXdelta3), 0755))
+	active := path.Join(tmpDir, "active")
+	inactive := path.Join(tmpDir, "inactive")
+	require.NoError(t, ioutil.WriteFile(active, []byte("old rootfs,"), 0644))
+	require.NoError(t, ioutil.WriteFile(inactive, make([]byte, 1024), 0644))
+	newRootfs := "old rootfs, with changes"
+	sum := sha256.Sum256([]byte(newRootfs))
+	checksum := hex.EncodeToString(sum[:])
+
+	defer func(sizeOf func(*os.File) (uint64, error),
+		sectorSizeOf func(*os.File) (int, error)) {
+		BlockDeviceGetSizeOf = sizeOf
+		BlockDeviceGetSectorSizeOf = sectorSizeOf
+	}(BlockDeviceGetSizeOf, BlockDeviceGetSectorSizeOf)
+	BlockDeviceGetSizeOf = func(*os.File) (uint64, error) { return 1024, nil }
+	BlockDeviceGetSectorSizeOf = func(*os.File) (int, error) { return 512, nil }
+
+	device := &dualRootfsDeviceImpl{
+		partitions: &partitions{active: active, inactive: inactive},
+	}
+	updateType := RootfsDeltaType
+	storer, err := device.NewUpdateStorer(&updateType, 0)
+	require.NoError(t, err)
+	delta, ok := storer.(*dualRootfsDeltaImpl)
+	require.True(t, ok)
+	delta.xdelta3 = xdelta3
+	assert.Equal(t, RootfsDeltaType, delta.GetType())
+	updateType = "rootfs-image"
+	storer, err = device.NewUpdateStorer(&updateType, 0)
+	require.NoError(t, err)
+	assert.Equal(t, device, storer)
+
+	// The checksum of the new root filesystem is needed.
+	headers := &fakeDeltaHeaders{provides: artifact.TypeInfoProvides{}}
+	assert.Error(t, delta.Initialize(nil, nil, headers))
+	headers.provides["rootfs-image.checksum"] = checksum
+	headers.metaData = map[string]interface{}{"image_size": "24"}
+	assert.Error(t, delta.Initialize(nil, nil, headers))
+	headers.metaData["image_size"] = float64(len(newRootfs))
+	require.NoError(t, delta.Initialize(nil, nil, headers))
+	assert.Equal(t, int64(len(newRootfs)), delta.size)
+
+	err = delta.StoreUpdate(strings.NewReader(" with changes"),
+		&sizeOnlyFileInfo{int64(len(" with changes"))})
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(inactive)
+	require.NoError(t, err)
+	assert.Equal(t, newRootfs, string(data[:len(newRootfs)]))
+
+	// Without the size, the partition can take it all.
+	delta.size = 0
+	require.NoError(t, ioutil.WriteFile(inactive, make([]byte, 1024), 0644))
+	err = delta.StoreUpdate(strings.NewReader(" with changes"),
+		&sizeOnlyFileInfo{int64(len(" with changes"))})
+	require.NoError(t, err)
+	data, err = ioutil.ReadFile(inactive)
+	require.NoError(t, err)
+	assert.Equal(t, newRootfs, string(data[:len(newRootfs)]))
+
+	// A delta against another root filesystem doesn't give the checksum.
+	require.NoError(t, ioutil.WriteFile(active, []byte("other rootfs,"), 0644))
+	err = delta.StoreUpdate(strings.NewReader(" with changes"),
+		&sizeOnlyFileInfo{int64(len(" with changes"))})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+
+	delta.xdelta3 = "false"
+	err = delta.StoreUpdate(strings.NewReader(" with changes"),
+		&sizeOnlyFileInfo{int64(len(" with changes"))})
+	assert.Error(t, err)
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/mendersoftware/mender-artifact/artifact"
 	"github.com/mendersoftware/mender-artifact/handlers"
 	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
 	"github.com/mendersoftware/mender/system"
 )
 
@@ -43,6 +44,11 @@ type dualRootfsDeviceImpl struct {
 	system.Commander
 	*partitions
 	rebooter *system.SystemRebootCmd
+	// Where the progress of writing the update is kept, nil if it isn't,
+	// and how often it is stored.
+	store                 store.Store
+	writeProgressInterval int64
+	artifactName          string
 }
 
 // This interface is only here for tests.
@@ -74,11 +80,14 @@ func checkMounted(part string) string {
 	return ""
 }
 
-// Returns nil if config doesn't contain partition paths.
+// Returns nil if config doesn't contain partition paths. The progress of
+// writing updates is kept in the store, if not nil, so that a write which is
+// interrupted can continue.
 func NewDualRootfsDevice(
 	env BootEnvReadWriter,
 	sc system.StatCommander,
 	config conf.DualRootfsDeviceConfig,
+	store store.Store,
 ) DualRootfsDevice {
 	if config.RootfsPartA == "" || config.RootfsPartB == "" {
 		return nil
@@ -97,6 +106,9 @@ func NewDualRootfsDevice(
 		Commander:         sc,
 		partitions:        &partitions,
 		rebooter:          system.NewSystemRebootCmd(sc),
+		store:             store,
+
+		writeProgressInterval: defaultWriteProgressInterval,
 	}
 	return &dualRootfsDevice
 }
@@ -178,6 +190,9 @@ func (d *dualRootfsDeviceImpl) Initialize(artifactHeaders,
 	artifactAugmentedHeaders artifact.HeaderInfoer,
 	payloadHeaders handlers.ArtifactUpdateHeaders) error {
 
+	if artifactHeaders != nil {
+		d.artifactName = artifactHeaders.GetArtifactName()
+	}
 	return nil
 }
 
@@ -194,7 +209,18 @@ func (d *dualRootfsDeviceImpl) StoreUpdate(image io.Reader, info os.FileInfo) er
 
 	imageSize := info.Size()
 
-	dev, err := blockdevice.Open(inactivePartition, imageSize)
+	tracker, err := d.startWrite(inactivePartition, imageSize, image)
+	if err != nil {
+		return err
+	}
+	var dev *BlockDevice
+	var skipped int64
+	if tracker != nil {
+		skipped = tracker.progress.Offset
+		dev, err = blockdevice.openAt(inactivePartition, imageSize, skipped, tracker.synced)
+	} else {
+		dev, err = blockdevice.Open(inactivePartition, imageSize)
+	}
 	if err != nil {
 		errmsg := "Failed to write the update to the inactive partition: %q"
 		return errors.Wrapf(err, errmsg, inactivePartition)
@@ -210,8 +236,11 @@ func (d *dualRootfsDeviceImpl) StoreUpdate(image io.Reader, info os.FileInfo) er
 		log.Errorf("Failed to close the block-device. Error: %v", err)
 		return err
 	}
+	if tracker != nil {
+		removeWriteProgress(d.store)
+	}
 
-	log.Infof("Wrote %d/%d bytes to the inactive partition", n, imageSize)
+	log.Infof("Wrote %d/%d bytes to the inactive partition", skipped+n, imageSize)
 
 	return err
 }
@@ -332,7 +361,10 @@ func (d *dualRootfsDeviceImpl) Failure() error {
 }
 
 func (d *dualRootfsDeviceImpl) Cleanup() error {
-	// Nothing to do for rootfs updates.
+	// A write which was interrupted won't continue anymore.
+	if d.store != nil {
+		removeWriteProgress(d.store)
+	}
 	return nil
 }
 
@@ -344,6 +376,9 @@ func (d *dualRootfsDeviceImpl) NewUpdateStorer(
 	updateType *string,
 	payloadNum int,
 ) (handlers.UpdateStorer, error) {
+	if updateType != nil && *updateType == RootfsDeltaType {
+		return &dualRootfsDeltaImpl{dualRootfsDeviceImpl: d, xdelta3: "xdelta3"}, nil
+	}
 	// We don't maintain any particular state for each payload, just return
 	// the same object.
 	return d, nil
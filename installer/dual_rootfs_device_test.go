@@ -268,7 +268,8 @@ func TestDeviceVerifyReboot(t *testing.T) {
 	testDevice := NewDualRootfsDevice(
 		NewEnvironment(runner, "", ""),
 		nil,
-		config)
+		config,
+		nil)
 	err := testDevice.VerifyReboot()
 	assert.Contains(t, err.Error(), "failed to read environment variable:")
 	assert.Contains(t, err.Error(), ": exit status 255")
@@ -277,7 +278,8 @@ func TestDeviceVerifyReboot(t *testing.T) {
 	testDevice = NewDualRootfsDevice(
 		NewEnvironment(runner, "", ""),
 		nil,
-		config)
+		config,
+		nil)
 	err = testDevice.VerifyReboot()
 	assert.EqualError(t, err, verifyRebootError)
 
@@ -285,7 +287,8 @@ func TestDeviceVerifyReboot(t *testing.T) {
 	testDevice = NewDualRootfsDevice(
 		NewEnvironment(runner, "", ""),
 		nil,
-		config)
+		config,
+		nil)
 	err = testDevice.VerifyReboot()
 	assert.NoError(t, err)
 }
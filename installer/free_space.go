@@ -0,0 +1,43 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package installer
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// checkFreeSpace fails if the file system of the directory, as told by
+// statfs, doesn't have room for size more bytes, leaving the blocks reserved
+// for root alone.
+func checkFreeSpace(
+	statfs func(string, *syscall.Statfs_t) error,
+	dir string,
+	size int64,
+) error {
+	if size <= 0 {
+		return nil
+	}
+	var stat syscall.Statfs_t
+	if err := statfs(dir, &stat); err != nil {
+		return errors.Wrapf(err, "failed to read the free space in %s", dir)
+	}
+	available := int64(stat.Bavail) * int64(stat.Bsize)
+	if available < size {
+		return errors.Wrapf(syscall.ENOSPC, "%d bytes needed in %s, but only %d are available",
+			size, dir, available)
+	}
+	return nil
+}
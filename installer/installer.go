@@ -23,7 +23,6 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/mendersoftware/mender-artifact/areader"
-	"github.com/mendersoftware/mender-artifact/artifact"
 	"github.com/mendersoftware/mender-artifact/handlers"
 	"github.com/mendersoftware/mender/conf"
 	"github.com/mendersoftware/mender/statescript"
@@ -69,9 +68,14 @@ type DeviceInfoGetter interface {
 }
 
 type Installer struct {
-	ar *areader.Reader
+	ar    *areader.Reader
+	order []int
 }
 
+// InstallAfterKey is the key of the meta-data of a payload listing the
+// indices of the payloads of the artifact which are installed before it.
+const InstallAfterKey = "mender_install_after"
+
 type RebootAction int
 
 const (
@@ -137,38 +141,7 @@ func ReadHeaders(art io.ReadCloser, dt string, keys []*conf.VerificationKey, scr
 	// VerifySignatureCallback needs to be registered both for
 	// NewReader and NewReaderSigned to print a warning if artifact is signed
 	// but no verification key is provided.
-	ar.VerifySignatureCallback = func(message, sig []byte) error {
-		// MEN-1196 skip verification of the signature if there is no key
-		// provided. This means signed artifact will be installed on all
-		// devices having no key specified.
-		if len(keys) == 0 {
-			log.Warn("Installer: Installing signed artifact without verification " +
-				"as verification key is missing")
-			return nil
-		}
-
-		verified := false
-		for _, key := range keys {
-			// Do the verification only if the key is provided.
-			s, err := artifact.NewPKIVerifier(key.Data)
-			if err != nil {
-				log.Errorf("Installer: invalid PKI verification key %q: %v", key.Path, err)
-				continue
-			}
-			if err := s.Verify(message, sig); err != nil {
-				log.Errorf("Installer: verifying with key %q: %v", key.Path, err)
-				continue
-			}
-			// MEN-2152 Provide confirmation in log that digital signature was authenticated.
-			log.Info("Installer: authenticated digital signature of artifact")
-			verified = true
-			break
-		}
-		if !verified {
-			return errors.New("failed to verify message with any of the provided verification keys")
-		}
-		return nil
-	}
+	ar.VerifySignatureCallback = verifySignature(keys)
 
 	scr := statescript.NewStore(scrDir)
 	// we need to wipe out the scripts directory first
@@ -201,13 +174,18 @@ func ReadHeaders(art io.ReadCloser, dt string, keys []*conf.VerificationKey, scr
 		return nil, installers, err
 	}
 
-	// Remove this when adding support for more than one payload.
-	if len(updateStorers) > 1 {
+	// The dual rootfs device keeps the state of a single update.
+	if inst.DualRootfs != nil && rootfsPayloads(ar) > 1 {
 		return nil, installers, errors.New(
-			"Artifacts with more than one payload are not supported yet!",
+			"Artifacts with more than one root filesystem payload are not supported",
 		)
 	}
 
+	order, err := installOrder(ar)
+	if err != nil {
+		return nil, installers, err
+	}
+
 	installers, err = getInstallerList(updateStorers)
 	if err != nil {
 		return nil, installers, err
@@ -217,13 +195,174 @@ func ReadHeaders(art io.ReadCloser, dt string, keys []*conf.VerificationKey, scr
 		"Installer: Successfully read artifact [name: %v; version: %v; compatible devices: %v]",
 		ar.GetArtifactName(), ar.GetInfo().Version, ar.GetCompatibleDevices())
 
-	return &Installer{ar}, installers, nil
+	return &Installer{ar: ar, order: order}, installers, nil
+}
+
+// rootfsPayloads returns how many payloads the dual rootfs device installs.
+func rootfsPayloads(ar *areader.Reader) int {
+	count := 0
+	for _, payload := range ar.GetHandlers() {
+		updateType := payload.GetUpdateType()
+		if updateType != nil &&
+			(*updateType == "rootfs-image" || *updateType == RootfsDeltaType) {
+			count++
+		}
+	}
+	return count
+}
+
+// installOrder returns the indices of the payloads in the order they are
+// installed in, which is that of the artifact, except that each payload is
+// installed after the payloads listed under InstallAfterKey in its meta-data.
+// Returns nil if the order is that of the artifact.
+func installOrder(ar *areader.Reader) ([]int, error) {
+	payloads := ar.GetHandlers()
+	after := make([][]int, len(payloads))
+	for n := range after {
+		payload, ok := payloads[n]
+		if !ok {
+			continue
+		}
+		metaData, err := payload.GetUpdateMetaData()
+		if err != nil {
+			return nil, errors.Wrapf(err, "installer: invalid meta-data of payload %d", n)
+		}
+		if after[n], err = parseInstallAfter(metaData[InstallAfterKey], n,
+			len(payloads)); err != nil {
+			return nil, err
+		}
+	}
+	order, err := orderAfter(after)
+	if err != nil {
+		return nil, err
+	}
+	for n, index := range order {
+		if n != index {
+			return order, nil
+		}
+	}
+	return nil, nil
+}
+
+// orderAfter orders the indices so that each comes after those it lists, and
+// otherwise in ascending order.
+func orderAfter(after [][]int) ([]int, error) {
+	order := make([]int, 0, len(after))
+	ordered := make([]bool, len(after))
+	for len(order) < len(after) {
+		next := -1
+		for n := range after {
+			if !ordered[n] && allOrdered(after[n], ordered) {
+				next = n
+				break
+			}
+		}
+		if next < 0 {
+			return nil, errors.Errorf("installer: the payloads listed in %s "+
+				"are installed after each other in a loop", InstallAfterKey)
+		}
+		ordered[next] = true
+		order = append(order, next)
+	}
+	return order, nil
+}
+
+func parseInstallAfter(value interface{}, payload, payloads int) ([]int, error) {
+	if value == nil {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("installer: %s of payload %d must be a list "+
+			"of payload indices", InstallAfterKey, payload)
+	}
+	after := make([]int, len(list))
+	for n, item := range list {
+		index, ok := item.(float64)
+		if !ok || index != float64(int(index)) ||
+			index < 0 || int(index) >= payloads || int(index) == payload {
+			return nil, errors.Errorf("installer: invalid payload index %v in %s "+
+				"of payload %d", item, InstallAfterKey, payload)
+		}
+		after[n] = int(index)
+	}
+	return after, nil
+}
+
+func allOrdered(indices []int, ordered []bool) bool {
+	for _, n := range indices {
+		if !ordered[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// verifySignature returns the callback verifying the signature of an
+// artifact with any of the keys.
+func verifySignature(keys []*conf.VerificationKey) func(message, sig []byte) error {
+	return func(message, sig []byte) error {
+		// MEN-1196 skip verification of the signature if there is no key
+		// provided. This means signed artifact will be installed on all
+		// devices having no key specified.
+		if len(keys) == 0 {
+			log.Warn("Installer: Installing signed artifact without verification " +
+				"as verification key is missing")
+			return nil
+		}
+
+		verified := false
+		for _, key := range keys {
+			// Do the verification only if the key is provided.
+			s, err := newVerifier(key.Data)
+			if err != nil {
+				log.Errorf("Installer: invalid PKI verification key %q: %v", key.Path, err)
+				continue
+			}
+			if err := s.Verify(message, sig); err != nil {
+				log.Errorf("Installer: verifying with key %q: %v", key.Path, err)
+				continue
+			}
+			// MEN-2152 Provide confirmation in log that digital signature was authenticated.
+			log.Info("Installer: authenticated digital signature of artifact")
+			verified = true
+			break
+		}
+		if !verified {
+			return errors.New("failed to verify message with any of the provided verification keys")
+		}
+		return nil
+	}
+}
+
+// VerifyArtifact reads the whole artifact, verifying its signature with any
+// of the keys, if there are any, and the checksums of its payloads, which
+// are discarded. It returns the name of the artifact.
+func VerifyArtifact(art io.Reader, keys []*conf.VerificationKey) (string, error) {
+	var ar *areader.Reader
+	if len(keys) > 0 {
+		ar = areader.NewReaderSigned(art)
+	} else {
+		ar = areader.NewReader(art)
+	}
+	ar.VerifySignatureCallback = verifySignature(keys)
+	if err := ar.ReadArtifact(); err != nil {
+		return "", errors.Wrap(err, "installer: invalid artifact")
+	}
+	return ar.GetArtifactName(), nil
 }
 
 func (i *Installer) StorePayloads() error {
 	return i.ar.ReadArtifactData()
 }
 
+// InstallOrder returns the indices of the payloads in the order they are
+// installed in, or nil if that is the order of the artifact. If one fails to
+// install, those installed before it are rolled back in the reverse order.
+func (i *Installer) InstallOrder() []int {
+	return i.order
+}
+
 func (i *Installer) GetArtifactName() string {
 	return i.ar.GetArtifactName()
 }
@@ -259,6 +398,11 @@ func registerHandlers(ar *areader.Reader, inst *AllModules) error {
 		if err := ar.RegisterHandler(rootfs); err != nil {
 			return errors.Wrap(err, "failed to register rootfs install handler")
 		}
+		rootfsDelta := handlers.NewModuleImage(RootfsDeltaType)
+		rootfsDelta.SetUpdateStorerProducer(inst.DualRootfs)
+		if err := ar.RegisterHandler(rootfsDelta); err != nil {
+			return errors.Wrap(err, "failed to register rootfs delta install handler")
+		}
 	}
 
 	if inst.Modules == nil {
@@ -268,7 +412,10 @@ func registerHandlers(ar *areader.Reader, inst *AllModules) error {
 	// Update modules.
 	updateTypes := inst.Modules.GetModuleTypes()
 	for _, updateType := range updateTypes {
-		if updateType == "rootfs-image" {
+		// Delta updates of the root filesystem may be installed by a
+		// module on devices without the built-in dual rootfs support.
+		if updateType == "rootfs-image" ||
+			(updateType == RootfsDeltaType && inst.DualRootfs != nil) {
 			log.Errorf("Found update module called %s, which "+
 				"cannot be overridden. Ignoring.", updateType)
 			continue
@@ -307,7 +454,8 @@ func CreateInstallersFromList(inst *AllModules,
 
 	for n, desired := range desiredTypes {
 		var err error
-		if desired == "rootfs-image" {
+		if desired == "rootfs-image" ||
+			(desired == RootfsDeltaType && inst.DualRootfs != nil) {
 			if inst.DualRootfs != nil {
 				payloadStorers[n], err = inst.DualRootfs.NewUpdateStorer(&desired, n)
 				if err != nil {
@@ -16,6 +16,10 @@ package installer
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"io"
 	"io/ioutil"
 	"os"
@@ -84,6 +88,57 @@ func TestInstallSigned(t *testing.T) {
 
 }
 
+type ed25519Signer ed25519.PrivateKey
+
+func (s ed25519Signer) Sign(message []byte) ([]byte, error) {
+	sig := ed25519.Sign(ed25519.PrivateKey(s), message)
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+func TestInstallSignedEd25519(t *testing.T) {
+	updateProducers := AllModules{
+		DualRootfs: new(fDevice),
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	edKey := &conf.VerificationKey{
+		Data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		Path: "/path/to/public_ed25519_key",
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(otherPub)
+	require.NoError(t, err)
+	otherKey := &conf.VerificationKey{
+		Data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		Path: "/path/to/other_ed25519_key",
+	}
+
+	// verified with the Ed25519 key, also among keys of other types
+	art, err := makeRootfsImageArtifact(2, ed25519Signer(priv), false)
+	require.NoError(t, err)
+	_, err = Install(art, "vexpress-qemu", append(testVerificationKeys, edKey), "",
+		&updateProducers)
+	assert.NoError(t, err)
+
+	// not verified with another Ed25519 key
+	art, err = makeRootfsImageArtifact(2, ed25519Signer(priv), false)
+	require.NoError(t, err)
+	_, err = Install(art, "vexpress-qemu", []*conf.VerificationKey{otherKey}, "",
+		&updateProducers)
+	assert.Error(t, err)
+
+	// RSA signatures are still verified alongside Ed25519 keys
+	art, err = MakeRootfsImageArtifact(2, true, false)
+	require.NoError(t, err)
+	_, err = Install(art, "vexpress-qemu", []*conf.VerificationKey{otherKey,
+		testVerificationKeys[1]}, "", &updateProducers)
+	assert.NoError(t, err)
+}
+
 func TestInstallNoSignature(t *testing.T) {
 	updateProducers := AllModules{
 		DualRootfs: new(fDevice),
@@ -100,6 +155,30 @@ func TestInstallNoSignature(t *testing.T) {
 		"expecting signed artifact, but no signature file found")
 }
 
+func TestVerifyArtifact(t *testing.T) {
+	art, err := MakeRootfsImageArtifact(2, true, false)
+	require.NoError(t, err)
+	name, err := VerifyArtifact(art, testVerificationKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, "mender-1.1", name)
+
+	art, err = MakeRootfsImageArtifact(2, false, false)
+	require.NoError(t, err)
+	_, err = VerifyArtifact(art, testVerificationKeys)
+	assert.Error(t, err)
+
+	// Without keys, only the checksums are verified.
+	art, err = MakeRootfsImageArtifact(2, false, false)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(art)
+	require.NoError(t, err)
+	name, err = VerifyArtifact(bytes.NewReader(data), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "mender-1.1", name)
+	_, err = VerifyArtifact(bytes.NewReader(data[:len(data)/2]), nil)
+	assert.Error(t, err)
+}
+
 func TestInstallWithScripts(t *testing.T) {
 	updateProducers := AllModules{
 		DualRootfs: new(fDevice),
@@ -143,7 +222,43 @@ func TestMultiplePayloadsRejected(t *testing.T) {
 
 	_, err = Install(art, "vexpress-qemu", nil, "", &updateProducers)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "Artifacts with more than one payload are not supported yet")
+	assert.Contains(t, err.Error(),
+		"Artifacts with more than one root filesystem payload are not supported")
+}
+
+func TestInstallOrder(t *testing.T) {
+	order, err := orderAfter([][]int{nil, nil, nil})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, order)
+
+	order, err = orderAfter([][]int{{2}, nil, {1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 0}, order)
+
+	order, err = orderAfter([][]int{{3}, {0, 2}, nil, nil})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3, 0, 1}, order)
+
+	_, err = orderAfter([][]int{{2}, nil, {0}})
+	assert.Error(t, err)
+
+	after, err := parseInstallAfter([]interface{}{float64(2), float64(0)}, 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 0}, after)
+	after, err = parseInstallAfter(nil, 1, 3)
+	assert.NoError(t, err)
+	assert.Nil(t, after)
+	for _, invalid := range []interface{}{
+		float64(0),
+		[]interface{}{"0"},
+		[]interface{}{float64(1)},
+		[]interface{}{float64(3)},
+		[]interface{}{float64(-1)},
+		[]interface{}{float64(0.5)},
+	} {
+		_, err = parseInstallAfter(invalid, 1, 3)
+		assert.Error(t, err, invalid)
+	}
 }
 
 type fDevice struct{}
@@ -277,6 +392,18 @@ var (
 )
 
 func MakeRootfsImageArtifact(version int, signed bool,
+	hasScripts bool) (io.ReadCloser, error) {
+	if !signed {
+		return makeRootfsImageArtifact(version, nil, hasScripts)
+	}
+	s, err := artifact.NewPKISigner([]byte(PrivateRSAKey))
+	if err != nil {
+		return nil, err
+	}
+	return makeRootfsImageArtifact(version, s, hasScripts)
+}
+
+func makeRootfsImageArtifact(version int, signer artifact.Signer,
 	hasScripts bool) (io.ReadCloser, error) {
 	upd, err := MakeFakeUpdate("test update")
 	if err != nil {
@@ -287,14 +414,10 @@ func MakeRootfsImageArtifact(version int, signed bool,
 	art := bytes.NewBuffer(nil)
 	var aw *awriter.Writer
 	comp := artifact.NewCompressorGzip()
-	if !signed {
+	if signer == nil {
 		aw = awriter.NewWriter(art, comp)
 	} else {
-		s, err := artifact.NewPKISigner([]byte(PrivateRSAKey))
-		if err != nil {
-			return nil, err
-		}
-		aw = awriter.NewWriterSigned(art, comp, s)
+		aw = awriter.NewWriterSigned(art, comp, signer)
 	}
 	var u handlers.Composer
 	switch version {
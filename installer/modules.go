@@ -332,11 +332,14 @@ const (
 type namedReader struct {
 	r    io.Reader
 	name string
+	size int64
 }
 
 type moduleDownload struct {
 	payloadPath string
 	proc        *system.Cmd
+	// Tells the free space of a file system.
+	statfs func(string, *syscall.Statfs_t) error
 
 	// Channel for supplying new payload files while the download loop is
 	// running
@@ -376,6 +379,7 @@ func newModuleDownload(payloadPath string, proc *system.Cmd) *moduleDownload {
 	return &moduleDownload{
 		payloadPath:        payloadPath,
 		proc:               proc,
+		statfs:             syscall.Statfs,
 		nextArtifactStream: make(chan *namedReader),
 		status:             make(chan error),
 		finishChannel:      make(chan bool),
@@ -420,10 +424,7 @@ func (d *moduleDownload) handleCmdErr(err error) error {
 		if d.currentStream != nil {
 			// We may have gotten a stream already. Start
 			// downloading it straight into "files" directory.
-			filePath := path.Join(d.payloadPath, "files", d.currentStream.name)
-			d.stream = newStream(d.currentStream.r, filePath,
-				os.O_WRONLY|os.O_CREATE|os.O_EXCL)
-			d.stream.start()
+			return d.storeInFiles()
 		}
 
 	} else if d.downloaderType == moduleDownloader {
@@ -437,10 +438,7 @@ func (d *moduleDownload) handleCmdErr(err error) error {
 func (d *moduleDownload) handleNextArtifactStream() error {
 	if d.downloaderType == menderDownloader {
 		// Download new stream straight to "files".
-		filePath := path.Join(d.payloadPath, "files", d.currentStream.name)
-		d.stream = newStream(d.currentStream.r, filePath,
-			os.O_WRONLY|os.O_CREATE|os.O_EXCL)
-		d.stream.start()
+		return d.storeInFiles()
 	} else {
 		// Download new stream to update module using "stream-next" and
 		// "streams" directory.
@@ -455,6 +453,21 @@ func (d *moduleDownload) handleNextArtifactStream() error {
 	return nil
 }
 
+// storeInFiles downloads the current stream into the "files" directory, if
+// there is room for it there, so that the download fails before the disk
+// is full, rather than when it is.
+func (d *moduleDownload) storeInFiles() error {
+	filesPath := path.Join(d.payloadPath, "files")
+	if err := checkFreeSpace(d.statfs, filesPath, d.currentStream.size); err != nil {
+		return errors.Wrapf(err, "unable to store the payload file %s",
+			d.currentStream.name)
+	}
+	d.stream = newStream(d.currentStream.r, path.Join(filesPath, d.currentStream.name),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	d.stream.start()
+	return nil
+}
+
 func (d *moduleDownload) handleStreamNextChannel(err error) error {
 	d.streamNext = nil
 
@@ -633,8 +646,8 @@ func (d *moduleDownload) initializeMenderDownload() error {
 	return err
 }
 
-func (d *moduleDownload) downloadStream(r io.Reader, name string) error {
-	d.nextArtifactStream <- &namedReader{r, name}
+func (d *moduleDownload) downloadStream(r io.Reader, name string, size int64) error {
+	d.nextArtifactStream <- &namedReader{r, name, size}
 	err := <-d.status
 	return err
 }
@@ -707,7 +720,7 @@ func (mod *ModuleInstaller) StoreUpdate(r io.Reader, info os.FileInfo) error {
 		return errors.New("Internal error: StoreUpdate() called when download is inactive")
 	}
 
-	return mod.downloader.downloadStream(r, info.Name())
+	return mod.downloader.downloadStream(r, info.Name(), info.Size())
 }
 
 func (mod *ModuleInstaller) FinishStoreUpdate() error {
@@ -444,6 +444,29 @@ func assertIsError(t *testing.T, expected, actual error) {
 	}
 }
 
+func TestModulesDownloadNoSpace(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "TestModuleDownload")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	download, delayKiller := moduleDownloadSetup(t, tmpdir, "menderDownload")
+	download.statfs = func(_ string, stat *syscall.Statfs_t) error {
+		stat.Bavail = 10
+		stat.Bsize = 1
+		return nil
+	}
+	err = download.downloadStream(bytes.NewBufferString("Test content"), "test-name", 12)
+	require.Error(t, err)
+	assert.Equal(t, syscall.ENOSPC, errors.Cause(err))
+	assert.Contains(t, err.Error(), "test-name")
+	assert.Contains(t, err.Error(), "12 bytes needed")
+	download.finishDownloadProcess()
+	delayKiller.Stop()
+
+	_, err = os.Stat(path.Join(tmpdir, "files", "test-name"))
+	assert.True(t, os.IsNotExist(err))
+}
+
 func subTestModulesDownload(t *testing.T, c *modulesDownloadTestCase) {
 	tmpdir, err := ioutil.TempDir("", "TestModuleDownload")
 	require.NoError(t, err)
@@ -464,7 +487,7 @@ func subTestModulesDownload(t *testing.T, c *modulesDownloadTestCase) {
 
 	for n := range c.streamContents {
 		buf := bytes.NewBuffer([]byte(c.streamContents[n]))
-		err = download.downloadStream(buf, c.streamNames[n])
+		err = download.downloadStream(buf, c.streamNames[n], int64(buf.Len()))
 		if n < len(c.downloadErr) {
 			assertIsError(t, c.downloadErr[n], err)
 		} else {
@@ -0,0 +1,57 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package installer
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// ed25519Verifier verifies artifacts signed with Ed25519, which
+// mender-artifact can't verify. As with the other algorithms, the signature
+// is base64 encoded, and is made over the message itself, not a hash of it.
+type ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+func (v *ed25519Verifier) Verify(message, sig []byte) error {
+	dec := make([]byte, base64.StdEncoding.DecodedLen(len(sig)))
+	decLen, err := base64.StdEncoding.Decode(dec, sig)
+	if err != nil {
+		return errors.Wrap(err, "signer: error decoding signature")
+	}
+	if !ed25519.Verify(v.key, message, dec[:decLen]) {
+		return errors.New("signer: verification failed")
+	}
+	return nil
+}
+
+// newVerifier returns a verifier for the PEM encoded public key. The
+// algorithm is that of the key, so each verification key may use a
+// different one.
+func newVerifier(publicKey []byte) (artifact.Verifier, error) {
+	if block, _ := pem.Decode(publicKey); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if key, ok := pub.(ed25519.PublicKey); err == nil && ok {
+			return &ed25519Verifier{key: key}, nil
+		}
+	}
+	return artifact.NewPKIVerifier(publicKey)
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+	"github.com/mendersoftware/mender/system"
+)
+
+// The progress of writing the update is stored every time this many more
+// bytes are on the device, by default.
+const defaultWriteProgressInterval = 16 * 1024 * 1024
+
+// writeProgress is how much of an update has been written to the inactive
+// partition, and synced, as stored.
+type writeProgress struct {
+	Device       string `json:"device"`
+	ArtifactName string `json:"artifact_name"`
+	Size         int64  `json:"size"`
+	Offset       int64  `json:"offset"`
+	// SHA-256 of the first Offset bytes of the update, hex encoded.
+	Checksum string `json:"checksum"`
+}
+
+func loadWriteProgress(s store.Store) (*writeProgress, error) {
+	data, err := s.ReadAll(datastore.RootfsWriteProgressKey)
+	if err != nil {
+		return nil, err
+	}
+	progress := &writeProgress{}
+	if err = json.Unmarshal(data, progress); err != nil {
+		return nil, errors.Wrap(err, "invalid progress of writing the update")
+	}
+	return progress, nil
+}
+
+func removeWriteProgress(s store.Store) {
+	err := s.Remove(datastore.RootfsWriteProgressKey)
+	if err != nil && err != os.ErrNotExist {
+		log.Errorf("Failed to remove the progress of writing the update: %s", err.Error())
+	}
+}
+
+// WriteInterrupted returns whether writing the root filesystem of the
+// artifact to the inactive partition was interrupted, as by a power loss,
+// and may continue where it stopped if the update is stored again.
+func WriteInterrupted(s store.Store, artifactName string) bool {
+	progress, err := loadWriteProgress(s)
+	return err == nil && progress.ArtifactName == artifactName
+}
+
+// writeProgressTracker stores how much of the update is on the device now
+// and then, while it is written.
+type writeProgressTracker struct {
+	store    store.Store
+	hash     hash.Hash
+	progress writeProgress
+	// How many bytes are written between storing the progress.
+	interval int64
+	unstored int64
+}
+
+// synced is called with every frame of the update once it is on the device.
+func (t *writeProgressTracker) synced(frame []byte) {
+	t.hash.Write(frame)
+	t.progress.Offset += int64(len(frame))
+	t.unstored += int64(len(frame))
+	// The last frame is only synced when the device is closed, and then the
+	// progress is removed anyway.
+	if t.unstored < t.interval || t.progress.Offset >= t.progress.Size {
+		return
+	}
+	t.unstored = 0
+	t.progress.Checksum = hex.EncodeToString(t.hash.Sum(nil))
+	data, err := json.Marshal(t.progress)
+	if err == nil {
+		err = t.store.WriteAll(datastore.RootfsWriteProgressKey, data)
+	}
+	if err != nil {
+		log.Warnf("Failed to store the progress of writing the update: %s", err.Error())
+	}
+}
+
+// startWrite returns the tracker of writing the update to the device. If
+// writing the same update was interrupted, and what is on the device is
+// intact, the part already written is skipped in the image, and the write
+// continues after it. Otherwise it starts over.
+func (d *dualRootfsDeviceImpl) startWrite(
+	device string,
+	size int64,
+	image io.Reader,
+) (*writeProgressTracker, error) {
+	if d.store == nil || system.IsUbiBlockDevice(device) {
+		return nil, nil
+	}
+	tracker := &writeProgressTracker{
+		store: d.store,
+		hash:  sha256.New(),
+		progress: writeProgress{
+			Device:       device,
+			ArtifactName: d.artifactName,
+			Size:         size,
+		},
+		interval: d.writeProgressInterval,
+	}
+
+	progress, err := loadWriteProgress(d.store)
+	if err == os.ErrNotExist {
+		return tracker, nil
+	} else if err != nil {
+		log.Warnf("Ignoring the progress of writing the update: %s", err.Error())
+		return tracker, nil
+	}
+	if progress.Device != device || progress.ArtifactName != d.artifactName ||
+		progress.Size != size || progress.Offset <= 0 || progress.Offset > size {
+		return tracker, nil
+	}
+	if err = verifyWritten(progress); err != nil {
+		log.Warnf("Writing the update from the start, since what was written "+
+			"before the interruption can't be used: %s", err.Error())
+		return tracker, nil
+	}
+
+	if _, err = io.CopyN(tracker.hash, image, progress.Offset); err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(tracker.hash.Sum(nil)) != progress.Checksum {
+		// What was read of the image is gone, so it has to be stored again.
+		removeWriteProgress(d.store)
+		return nil, errors.New("the update differs from the one which was " +
+			"being written before the interruption")
+	}
+	log.Infof("Continuing to write the update to %s at %d of %d bytes",
+		device, progress.Offset, size)
+	tracker.progress.Offset = progress.Offset
+	tracker.progress.Checksum = progress.Checksum
+	return tracker, nil
+}
+
+// verifyWritten checks that what was written to the device, according to the
+// progress, is still there.
+func verifyWritten(progress *writeProgress) error {
+	dev, err := os.Open(progress.Device)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	hash := sha256.New()
+	if _, err = io.CopyN(hash, dev, progress.Offset); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != progress.Checksum {
+		return errors.New("the data on the device doesn't match")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package installer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"testing/iotest"
+
+	"github.com/pkg/errors"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestStoreUpdateResumesWrite(t *testing.T) {
+	td, err := ioutil.TempDir("", "mender-write-progress-")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	const mib = 1024 * 1024
+	image := make([]byte, 3*mib+mib/2)
+	rand.New(rand.NewSource(1)).Read(image)
+
+	part := path.Join(td, "inactive")
+
+	defer func(f BlockDeviceGetSizeFunc) { BlockDeviceGetSizeOf = f }(BlockDeviceGetSizeOf)
+	BlockDeviceGetSizeOf = func(*os.File) (uint64, error) { return uint64(len(image)), nil }
+	defer func(f BlockDeviceGetSectorSizeFunc) {
+		BlockDeviceGetSectorSizeOf = f
+	}(BlockDeviceGetSectorSizeOf)
+	BlockDeviceGetSectorSizeOf = func(*os.File) (int, error) { return 512, nil }
+
+	s := store.NewMemStore()
+	device := &dualRootfsDeviceImpl{
+		partitions:   &partitions{inactive: part},
+		store:        s,
+		artifactName: "release-2",
+
+		writeProgressInterval: mib,
+	}
+	info := &sizeOnlyFileInfo{int64(len(image))}
+
+	// Loses power after two and a half frames.
+	interrupt := func() {
+		require.NoError(t, ioutil.WriteFile(part, make([]byte, len(image)), 0600))
+		err := device.StoreUpdate(io.MultiReader(
+			bytes.NewReader(image[:2*mib+mib/2]),
+			iotest.ErrReader(errors.New("power loss")),
+		), info)
+		require.EqualError(t, err, "power loss")
+		require.True(t, WriteInterrupted(s, "release-2"))
+		assert.False(t, WriteInterrupted(s, "release-1"))
+		progress, err := loadWriteProgress(s)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2*mib), progress.Offset)
+	}
+
+	t.Run("resumes", func(t *testing.T) {
+		interrupt()
+		hook := logtest.NewGlobal()
+		defer hook.Reset()
+
+		require.NoError(t, device.StoreUpdate(bytes.NewReader(image), info))
+		assert.True(t, testLogContainsMessage(t, hook.AllEntries(),
+			"Continuing to write the update"))
+		written, err := ioutil.ReadFile(part)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(image, written))
+		assert.False(t, WriteInterrupted(s, "release-2"))
+	})
+
+	t.Run("starts over if the device was changed", func(t *testing.T) {
+		interrupt()
+		f, err := os.OpenFile(part, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteAt([]byte("garbage"), mib)
+		require.NoError(t, err)
+		f.Close()
+		hook := logtest.NewGlobal()
+		defer hook.Reset()
+
+		require.NoError(t, device.StoreUpdate(bytes.NewReader(image), info))
+		assert.True(t, testLogContainsMessage(t, hook.AllEntries(),
+			"Writing the update from the start"))
+		written, err := ioutil.ReadFile(part)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(image, written))
+		assert.False(t, WriteInterrupted(s, "release-2"))
+	})
+
+	t.Run("fails if the update is different", func(t *testing.T) {
+		interrupt()
+		other := make([]byte, len(image))
+		copy(other, image)
+		other[0]++
+
+		err := device.StoreUpdate(bytes.NewReader(other), info)
+		assert.EqualError(t, err, "the update differs from the one which was "+
+			"being written before the interruption")
+		assert.False(t, WriteInterrupted(s, "release-2"))
+	})
+
+	t.Run("cleanup forgets the interrupted write", func(t *testing.T) {
+		interrupt()
+		assert.NoError(t, device.Cleanup())
+		assert.False(t, WriteInterrupted(s, "release-2"))
+	})
+}
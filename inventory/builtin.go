@@ -0,0 +1,236 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// builtinSystem is where the built-in providers read the state of the device
+// from.
+type builtinSystem struct {
+	// Where procfs is mounted, and where sysfs has the power supplies.
+	procPath        string
+	powerSupplyPath string
+	// The network interfaces, their addresses and the routing table.
+	netInterfaces  func() ([]net.Interface, error)
+	interfaceAddrs func(*net.Interface) ([]net.Addr, error)
+	routeMessages  func() ([]syscall.NetlinkMessage, error)
+	// The ModemManager command line tool, and how long to wait for gpsd.
+	mmcliCommand string
+	gpsdTimeout  time.Duration
+	// Where the container runtimes are looked for, the containerd command
+	// line tool, and how long to wait for the runtimes.
+	containerSockets []containerSocket
+	ctrCommand       string
+	containerTimeout time.Duration
+}
+
+func newBuiltinSystem() *builtinSystem {
+	return &builtinSystem{
+		procPath:        "/proc",
+		powerSupplyPath: defaultPowerSupplyPath,
+		netInterfaces:   net.Interfaces,
+		interfaceAddrs:  (*net.Interface).Addrs,
+		routeMessages:   routeMessages,
+		mmcliCommand:    defaultMmcliCommand,
+		gpsdTimeout:     defaultGPSDTimeout,
+
+		containerSockets: defaultContainerSockets(),
+		ctrCommand:       defaultCtrCommand,
+		containerTimeout: defaultContainerTimeout,
+	}
+}
+
+// builtinProviders collect inventory attributes natively, so that they are
+// the same on every device, whatever tools its image has.
+var builtinProviders = map[string]func(
+	*builtinSystem,
+	*conf.MenderConfig,
+) (map[string][]string, error){
+	conf.InventoryProviderCPU:        (*builtinSystem).cpuInventory,
+	conf.InventoryProviderMemory:     (*builtinSystem).memoryInventory,
+	conf.InventoryProviderDisk:       (*builtinSystem).diskInventory,
+	conf.InventoryProviderUptime:     (*builtinSystem).uptimeInventory,
+	conf.InventoryProviderKernel:     (*builtinSystem).kernelInventory,
+	conf.InventoryProviderNetwork:    (*builtinSystem).networkInventory,
+	conf.InventoryProviderLocation:   (*builtinSystem).locationInventory,
+	conf.InventoryProviderContainers: (*builtinSystem).containersInventory,
+	conf.InventoryProviderPower:      (*builtinSystem).powerInventory,
+}
+
+// cpuInventory gives the model of the first CPU and the number of CPUs.
+func (sys *builtinSystem) cpuInventory(*conf.MenderConfig) (map[string][]string, error) {
+	f, err := os.Open(path.Join(sys.procPath, "cpuinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var model string
+	cores := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := cutField(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "processor":
+			cores++
+		case "model name", "Hardware", "cpu model":
+			// x86, some ARM kernels, and MIPS respectively.
+			if model == "" {
+				model = value
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cores == 0 {
+		cores = runtime.NumCPU()
+	}
+	attrs := map[string][]string{"cpu_cores": {strconv.Itoa(cores)}}
+	if model != "" {
+		attrs["cpu_model"] = []string{model}
+	}
+	return attrs, nil
+}
+
+func (sys *builtinSystem) memoryInventory(*conf.MenderConfig) (map[string][]string, error) {
+	f, err := os.Open(path.Join(sys.procPath, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	attrs := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := cutField(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "MemTotal":
+			attrs["mem_total_kB"] = []string{strings.TrimSuffix(value, " kB")}
+		case "MemAvailable":
+			attrs["mem_available_kB"] = []string{strings.TrimSuffix(value, " kB")}
+		}
+	}
+	return attrs, scanner.Err()
+}
+
+var mountNameReplacer = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// mountName turns the mount point into a part of an attribute name, "root"
+// for "/", and "var_lib_mender" for "/var/lib/mender".
+func mountName(mountPoint string) string {
+	name := strings.Trim(mountNameReplacer.ReplaceAllString(mountPoint, "_"), "_")
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+// Mount points have spaces, tabs, newlines and backslashes escaped in
+// /proc/mounts.
+var mountPointUnescaper = strings.NewReplacer(
+	`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// diskInventory gives the size and usage of the file system of every mounted
+// block device, except loop devices, which are images on other file
+// systems.
+func (sys *builtinSystem) diskInventory(*conf.MenderConfig) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path.Join(sys.procPath, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string][]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") ||
+			strings.HasPrefix(fields[0], "/dev/loop") {
+			continue
+		}
+		mountPoint := mountPointUnescaper.Replace(fields[1])
+		var stat syscall.Statfs_t
+		if err = syscall.Statfs(mountPoint, &stat); err != nil {
+			continue
+		}
+		name := "disk_" + mountName(mountPoint)
+		if _, ok := attrs[name+"_total_kB"]; ok {
+			// Mounted over, or mounted more than once.
+			continue
+		}
+		blockSize := uint64(stat.Bsize)
+		attrs[name+"_total_kB"] = []string{
+			strconv.FormatUint(stat.Blocks*blockSize/1024, 10)}
+		attrs[name+"_used_kB"] = []string{
+			strconv.FormatUint((stat.Blocks-stat.Bfree)*blockSize/1024, 10)}
+	}
+	return attrs, nil
+}
+
+func (sys *builtinSystem) uptimeInventory(*conf.MenderConfig) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path.Join(sys.procPath, "uptime"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil, errors.New("empty uptime")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid uptime")
+	}
+	return map[string][]string{
+		"uptime_seconds": {strconv.FormatInt(int64(seconds), 10)},
+	}, nil
+}
+
+func (sys *builtinSystem) kernelInventory(*conf.MenderConfig) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path.Join(sys.procPath, "sys/kernel/osrelease"))
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]string{
+		"kernel_version": {strings.TrimSpace(string(data))},
+	}, nil
+}
+
+// cutField splits a "name: value" line, trimming both.
+func cutField(line, sep string) (string, string, bool) {
+	i := strings.Index(line, sep)
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+len(sep):]), true
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestBuiltinProviders(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mender-inventory-builtin")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	require.NoError(t, os.MkdirAll(path.Join(tmpDir, "sys/kernel"), 0755))
+	mountPoint := path.Join(tmpDir, "data dir")
+	require.NoError(t, os.Mkdir(mountPoint, 0755))
+
+	files := map[string]string{
+		"cpuinfo": "processor\t: 0\nmodel name\t: ARMv7 Processor rev 4 (v7l)\n\n" +
+			"processor\t: 1\nmodel name\t: ARMv7 Processor rev 4 (v7l)\n",
+		"meminfo": "MemTotal:        1000184 kB\nMemFree:          500000 kB\n" +
+			"MemAvailable:     800000 kB\n",
+		"mounts": "/dev/root / ext4 rw 0 0\n" +
+			"/dev/mmcblk0p4 " + path.Join(tmpDir, `data\040dir`) + " ext4 rw 0 0\n" +
+			"/dev/loop0 /snap squashfs ro 0 0\n" +
+			"tmpfs /tmp tmpfs rw 0 0\n",
+		"uptime":               "12345.67 23456.78\n",
+		"sys/kernel/osrelease": "5.15.0-v7l\n",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, name), []byte(content), 0644))
+	}
+	config := conf.NewMenderConfig()
+	config.InventoryProviders = []string{
+		conf.InventoryProviderCPU,
+		conf.InventoryProviderMemory,
+		conf.InventoryProviderDisk,
+		conf.InventoryProviderUptime,
+		conf.InventoryProviderKernel,
+	}
+	inventory := NewInventoryDataRunner(path.Join(tmpDir, "no-scripts"))
+	inventory.system.procPath = tmpDir
+	inventory.SetBuiltinProviders(config)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+
+	attrs := map[string]interface{}{}
+	for _, attr := range data {
+		attrs[attr.Name] = attr.Value
+	}
+	assert.Equal(t, "ARMv7 Processor rev 4 (v7l)", attrs["cpu_model"])
+	assert.Equal(t, "2", attrs["cpu_cores"])
+	assert.Equal(t, "1000184", attrs["mem_total_kB"])
+	assert.Equal(t, "800000", attrs["mem_available_kB"])
+	assert.Equal(t, "12345", attrs["uptime_seconds"])
+	assert.Equal(t, "5.15.0-v7l", attrs["kernel_version"])
+	assert.Contains(t, attrs, "disk_root_total_kB")
+	assert.Contains(t, attrs, "disk_root_used_kB")
+	dataDir := "disk_" + mountName(mountPoint)
+	assert.Contains(t, attrs, dataDir+"_total_kB")
+	assert.Contains(t, attrs, dataDir+"_used_kB")
+	assert.NotContains(t, attrs, "disk_snap_total_kB")
+	assert.NotContains(t, attrs, "disk_tmp_total_kB")
+
+	assert.Equal(t, "root", mountName("/"))
+	assert.Equal(t, "var_lib_mender", mountName("/var/lib/mender"))
+
+	// A failing provider is left out.
+	require.NoError(t, os.Remove(path.Join(tmpDir, "uptime")))
+	config.InventoryProviders = []string{
+		conf.InventoryProviderUptime,
+		conf.InventoryProviderKernel,
+	}
+	data, err = inventory.Get()
+	require.NoError(t, err)
+	assert.Equal(t, client.InventoryData{
+		{Name: "kernel_version", Value: "5.15.0-v7l"},
+	}, data)
+}
@@ -0,0 +1,288 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	defaultContainerdNamespace = "default"
+	defaultCtrCommand          = "ctr"
+	defaultContainerTimeout    = 10 * time.Second
+)
+
+// containerSocket is where a container runtime serves its API.
+type containerSocket struct {
+	runtime string
+	socket  string
+}
+
+// defaultContainerSockets are the sockets of the runtimes, in the order they
+// are looked for when the configuration has none.
+func defaultContainerSockets() []containerSocket {
+	return []containerSocket{
+		{conf.ContainerRuntimeDocker, "/var/run/docker.sock"},
+		{conf.ContainerRuntimePodman, "/run/podman/podman.sock"},
+		{conf.ContainerRuntimeContainerd, "/run/containerd/containerd.sock"},
+	}
+}
+
+// container is what the inventory has of each container.
+type container struct {
+	name   string
+	image  string
+	digest string
+	state  string
+}
+
+// containersInventory lists the containers of the runtime of the
+// configuration, and the images it has.
+func (sys *builtinSystem) containersInventory(
+	config *conf.MenderConfig,
+) (map[string][]string, error) {
+	runtime := config.Containers.Runtime
+	socket := config.Containers.Socket
+	if runtime == "" && socket != "" {
+		runtime = conf.ContainerRuntimeDocker
+	}
+	for _, s := range sys.containerSockets {
+		if socket != "" {
+			break
+		}
+		if runtime == s.runtime {
+			socket = s.socket
+		} else if _, err := os.Stat(s.socket); runtime == "" && err == nil {
+			runtime, socket = s.runtime, s.socket
+		}
+	}
+	if runtime == "" {
+		return nil, errors.New("found no container runtime")
+	}
+
+	var containers []container
+	var images []string
+	var err error
+	switch runtime {
+	case conf.ContainerRuntimeDocker, conf.ContainerRuntimePodman:
+		// Podman serves the Docker API too.
+		containers, images, err = sys.dockerContainers(socket)
+	case conf.ContainerRuntimeContainerd:
+		namespace := config.Containers.Namespace
+		if namespace == "" {
+			namespace = defaultContainerdNamespace
+		}
+		containers, images, err = sys.containerdContainers(socket, namespace)
+	default:
+		return nil, errors.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the %s containers", runtime)
+	}
+
+	attrs := map[string][]string{"container_runtime": {runtime}}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].name < containers[j].name
+	})
+	for _, c := range containers {
+		attrs["containers"] = append(attrs["containers"], c.name)
+		attrs["container_"+c.name+"_image"] = []string{c.image}
+		if c.digest != "" {
+			attrs["container_"+c.name+"_image_digest"] = []string{c.digest}
+		}
+		attrs["container_"+c.name+"_state"] = []string{c.state}
+	}
+	if len(images) > 0 {
+		sort.Strings(images)
+		attrs["container_images"] = images
+	}
+	return attrs, nil
+}
+
+// dockerContainer and dockerImage are the parts of the containers and
+// images in the Docker API which the inventory is taken from.
+type dockerContainer struct {
+	Names   []string `json:"Names"`
+	Image   string   `json:"Image"`
+	ImageID string   `json:"ImageID"`
+	State   string   `json:"State"`
+}
+
+type dockerImage struct {
+	ID          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+// dockerContainers lists all the containers, running or not, and the tagged
+// images, through the Docker API on the socket.
+func (sys *builtinSystem) dockerContainers(socket string) ([]container, []string, error) {
+	client := &http.Client{
+		Timeout: sys.containerTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	get := func(path string, v interface{}) error {
+		// The host is ignored, the connection is to the socket.
+		rsp, err := client.Get("http://localhost" + path)
+		if err != nil {
+			return err
+		}
+		defer rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			return errors.Errorf("GET %s: %s", path, rsp.Status)
+		}
+		return json.NewDecoder(rsp.Body).Decode(v)
+	}
+
+	var dockerImages []dockerImage
+	if err := get("/images/json", &dockerImages); err != nil {
+		return nil, nil, err
+	}
+	var dockerContainers []dockerContainer
+	if err := get("/containers/json?all=1", &dockerContainers); err != nil {
+		return nil, nil, err
+	}
+
+	// The image ID is the digest of the image configuration, the digest of
+	// the manifest, which registries know the image by, is in the repo
+	// digests.
+	digests := make(map[string]string)
+	var images []string
+	for _, image := range dockerImages {
+		digest := image.ID
+		if len(image.RepoDigests) > 0 {
+			if i := strings.LastIndex(image.RepoDigests[0], "@"); i >= 0 {
+				digest = image.RepoDigests[0][i+1:]
+			}
+		}
+		digests[image.ID] = digest
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" {
+				images = append(images, tag)
+			}
+		}
+	}
+
+	containers := make([]container, 0, len(dockerContainers))
+	for _, c := range dockerContainers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		digest, ok := digests[c.ImageID]
+		if !ok {
+			digest = c.ImageID
+		}
+		containers = append(containers, container{
+			name:   strings.TrimPrefix(c.Names[0], "/"),
+			image:  c.Image,
+			digest: digest,
+			state:  c.State,
+		})
+	}
+	return containers, images, nil
+}
+
+// containerdContainers lists the containers of the namespace, and the
+// images, with ctr, as containerd only has a gRPC API.
+func (sys *builtinSystem) containerdContainers(
+	socket, namespace string,
+) ([]container, []string, error) {
+	ctr := func(args ...string) ([][]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), sys.containerTimeout)
+		defer cancel()
+		args = append([]string{"--address", socket, "--namespace", namespace}, args...)
+		out, err := exec.CommandContext(ctx, sys.ctrCommand, args...).Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s %s failed", sys.ctrCommand,
+				strings.Join(args[4:], " "))
+		}
+		// The first line has the headings of the columns.
+		var rows [][]string
+		lines := strings.Split(string(out), "\n")
+		for _, line := range lines[1:] {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				rows = append(rows, fields)
+			}
+		}
+		return rows, nil
+	}
+
+	// REF TYPE DIGEST SIZE PLATFORMS LABELS
+	imageRows, err := ctr("images", "list")
+	if err != nil {
+		return nil, nil, err
+	}
+	digests := make(map[string]string)
+	var images []string
+	for _, row := range imageRows {
+		if len(row) < 3 {
+			continue
+		}
+		digests[row[0]] = row[2]
+		images = append(images, row[0])
+	}
+
+	// TASK PID STATUS
+	taskRows, err := ctr("tasks", "list")
+	if err != nil {
+		return nil, nil, err
+	}
+	states := make(map[string]string)
+	for _, row := range taskRows {
+		if len(row) >= 3 {
+			states[row[0]] = strings.ToLower(row[2])
+		}
+	}
+
+	// CONTAINER IMAGE RUNTIME
+	containerRows, err := ctr("containers", "list")
+	if err != nil {
+		return nil, nil, err
+	}
+	containers := make([]container, 0, len(containerRows))
+	for _, row := range containerRows {
+		if len(row) < 2 {
+			continue
+		}
+		state, ok := states[row[0]]
+		if !ok {
+			// Containers without a task have never been started.
+			state = "created"
+		}
+		containers = append(containers, container{
+			name:   row[0],
+			image:  row[1],
+			digest: digests[row[1]],
+			state:  state,
+		})
+	}
+	return containers, images, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const fakeCtr = `#!/bin/sh
+case "$5 $6" in
+"images list") cat <<EOF
+REF                             TYPE                                       DIGEST                  SIZE     PLATFORMS   LABELS
+docker.io/library/nginx:1.25    application/vnd.oci.image.index.v1+json    sha256:1111             67.3 MiB linux/amd64 -
+docker.io/library/redis:7       application/vnd.oci.image.index.v1+json    sha256:2222             40.1 MiB linux/amd64 -
+EOF
+;;
+"tasks list") cat <<EOF
+TASK     PID     STATUS
+web      1234    RUNNING
+EOF
+;;
+"containers list") cat <<EOF
+CONTAINER    IMAGE                           RUNTIME
+web          docker.io/library/nginx:1.25    io.containerd.runc.v2
+cache        docker.io/library/redis:7       io.containerd.runc.v2
+EOF
+;;
+*) exit 1 ;;
+esac
+`
+
+func TestContainersInventory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mender-containers")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	sys := newBuiltinSystem()
+	for i := range sys.containerSockets {
+		sys.containerSockets[i].socket = path.Join(tmpDir,
+			sys.containerSockets[i].runtime+".sock")
+	}
+	config := conf.NewMenderConfig()
+	_, err = sys.containersInventory(config)
+	assert.Error(t, err)
+
+	// Docker
+	listener, err := net.Listen("unix", path.Join(tmpDir, "docker.sock"))
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/images/json":
+				w.Write([]byte(`[
+{"Id": "sha256:aaaa", "RepoTags": ["nginx:1.25"],
+ "RepoDigests": ["nginx@sha256:1111"]},
+{"Id": "sha256:bbbb", "RepoTags": ["<none>:<none>"], "RepoDigests": []}
+]`))
+			case "/containers/json":
+				assert.Equal(t, "1", r.URL.Query().Get("all"))
+				w.Write([]byte(`[
+{"Names": ["/web"], "Image": "nginx:1.25", "ImageID": "sha256:aaaa",
+ "State": "running"},
+{"Names": ["/old"], "Image": "sha256:bbbb", "ImageID": "sha256:bbbb",
+ "State": "exited"}
+]`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	attrs, err := sys.containersInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"container_runtime":          {"docker"},
+		"container_images":           {"nginx:1.25"},
+		"containers":                 {"old", "web"},
+		"container_web_image":        {"nginx:1.25"},
+		"container_web_image_digest": {"sha256:1111"},
+		"container_web_state":        {"running"},
+		"container_old_image":        {"sha256:bbbb"},
+		"container_old_image_digest": {"sha256:bbbb"},
+		"container_old_state":        {"exited"},
+	}, attrs)
+
+	// The socket of the runtime of the configuration is used, even if
+	// there is another.
+	config.Containers.Runtime = conf.ContainerRuntimePodman
+	_, err = sys.containersInventory(config)
+	assert.Error(t, err)
+	config.Containers.Socket = path.Join(tmpDir, "docker.sock")
+	attrs, err = sys.containersInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"podman"}, attrs["container_runtime"])
+	assert.Equal(t, []string{"old", "web"}, attrs["containers"])
+
+	// containerd
+	ctr := path.Join(tmpDir, "ctr")
+	require.NoError(t, ioutil.WriteFile(ctr, []byte(fakeCtr), 0755))
+	sys.ctrCommand = ctr
+
+	config.Containers = conf.Containers{Runtime: conf.ContainerRuntimeContainerd}
+	attrs, err = sys.containersInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"container_runtime": {"containerd"},
+		"container_images": {
+			"docker.io/library/nginx:1.25",
+			"docker.io/library/redis:7",
+		},
+		"containers":                   {"cache", "web"},
+		"container_web_image":          {"docker.io/library/nginx:1.25"},
+		"container_web_image_digest":   {"sha256:1111"},
+		"container_web_state":          {"running"},
+		"container_cache_image":        {"docker.io/library/redis:7"},
+		"container_cache_image_digest": {"sha256:2222"},
+		"container_cache_state":        {"created"},
+	}, attrs)
+
+	require.NoError(t, ioutil.WriteFile(ctr, []byte("#!/bin/sh\nexit 1\n"), 0755))
+	_, err = sys.containersInventory(config)
+	assert.Error(t, err)
+}
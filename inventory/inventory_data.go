@@ -17,31 +17,86 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+	"github.com/mendersoftware/mender/store"
 	"github.com/mendersoftware/mender/system"
-	"github.com/mendersoftware/mender/utils"
 )
 
 const (
 	inventoryToolPrefix = "mender-inventory-"
+
+	defaultInventoryScriptTimeout = 5 * time.Minute
 )
 
-func NewInventoryDataRunner(scriptsDir string) InventoryDataRunner {
+// NewInventoryDataRunner returns a runner of the inventory scripts in the
+// directories, in order.
+func NewInventoryDataRunner(scriptsDirs ...string) InventoryDataRunner {
 	return InventoryDataRunner{
-		scriptsDir,
-		&system.OsCalls{},
+		dirs:    scriptsDirs,
+		cmd:     &system.OsCalls{},
+		system:  newBuiltinSystem(),
+		lastRun: map[string]inventoryRun{},
+		now:     time.Now,
 	}
 }
 
 type InventoryDataRunner struct {
-	dir string
-	cmd system.Commander
+	dirs []string
+	cmd  system.Commander
+	// The configuration of the built-in providers to run before the
+	// scripts.
+	config *conf.MenderConfig
+	// What the built-in providers read.
+	system *builtinSystem
+	// Returns the provides of the installed artifacts, see SetProvides.
+	provides func() (map[string]string, error)
+	// Where the attributes set at runtime are stored, see
+	// SetRuntimeAttribute.
+	store store.Store
+	// The last successful run of each script and provider, see
+	// conf.MenderConfigFromFile.InventoryIntervalSeconds.
+	lastRun map[string]inventoryRun
+	// The clock the intervals are measured with.
+	now func() time.Time
+	// The intervals declared by the scripts, and those which keep running,
+	// streaming their attributes, see toolDocument.
+	declaredIntervals map[string]time.Duration
+	streams           map[string]*toolStream
+}
+
+type inventoryRun struct {
+	at    time.Time
+	attrs map[string][]string
+}
+
+// SetBuiltinProviders has the built-in providers of the configuration, see
+// conf.MenderConfigFromFile.InventoryProviders, collect attributes too.
+func (id *InventoryDataRunner) SetBuiltinProviders(config *conf.MenderConfig) {
+	id.config = config
+}
+
+// SetProvides has the provides of the installed artifacts, such as the
+// versions of the payloads installed with update modules, replace the
+// attributes of the same names collected by the scripts and providers.
+func (id *InventoryDataRunner) SetProvides(provides func() (map[string]string, error)) {
+	id.provides = provides
+}
+
+// SetRuntimeStore has the attributes set at runtime in the store, see
+// SetRuntimeAttribute, replace those collected by the scripts and
+// providers.
+func (id *InventoryDataRunner) SetRuntimeStore(dbStore store.Store) {
+	id.store = dbStore
 }
 
 func listRunnable(dpath string) ([]string, error) {
@@ -68,51 +123,250 @@ func listRunnable(dpath string) ([]string, error) {
 	return runnable, nil
 }
 
-func (id *InventoryDataRunner) Get() (client.InventoryData, error) {
-	tools, err := listRunnable(id.dir)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to list tools for inventory data")
+// lastAttributes returns the attributes of the script or provider with the
+// given name from its last run, if it has an interval which hasn't passed
+// yet. The last run is looked up by key, which tells apart scripts with the
+// same name in different directories.
+func (id *InventoryDataRunner) lastAttributes(
+	name, key string,
+	now time.Time,
+) (map[string][]string, bool) {
+	interval := id.interval(name, key)
+	if interval <= 0 {
+		return nil, false
+	}
+	last, ok := id.lastRun[key]
+	if !ok || now.Sub(last.at) >= interval {
+		return nil, false
+	}
+	log.Debugf("Using the inventory of %s from %s", name, last.at.Format(time.RFC3339))
+	return last.attrs, true
+}
+
+// recordRun remembers the attributes of a successful run.
+func (id *InventoryDataRunner) recordRun(
+	key string,
+	now time.Time,
+	attrs map[string][]string,
+) {
+	if id.lastRun == nil {
+		id.lastRun = map[string]inventoryRun{}
+	}
+	id.lastRun[key] = inventoryRun{at: now, attrs: attrs}
+}
+
+// interval returns how often the script or provider is run, as configured,
+// or else as declared by the script, see toolDocument.
+func (id *InventoryDataRunner) interval(name, key string) time.Duration {
+	if id.config != nil {
+		if seconds := id.config.InventoryIntervalSeconds[name]; seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
 	}
+	return id.declaredIntervals[key]
+}
 
+func (id *InventoryDataRunner) Get() (client.InventoryData, error) {
 	idec := NewInventoryDataDecoder()
-	for _, t := range tools {
-		cmd := id.cmd.Command(t)
-		out, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Errorf("Failed to open stdout for inventory tool %s: %v", t, err)
+	now := id.now()
+	var providers []string
+	if id.config != nil {
+		providers = id.config.InventoryProviders
+	}
+	for _, name := range providers {
+		provider, ok := builtinProviders[name]
+		if !ok {
+			log.Errorf("Unknown inventory provider %q", name)
 			continue
 		}
+		attrs, ok := id.lastAttributes(name, name, now)
+		if !ok {
+			var err error
+			if attrs, err = provider(id.system, id.config); err != nil {
+				log.Errorf("Inventory provider %s failed: %v", name, err)
+				continue
+			}
+			id.recordRun(name, now, attrs)
+		}
+		idec.appendFromSource(name, id.namespaced(name, attrs))
+	}
 
-		if err := cmd.Start(); err != nil {
-			log.Errorf("Inventory tool %s failed with status: %v", t, err)
+	var tools []string
+	var listErr error
+	listed := false
+	for _, dir := range id.dirs {
+		dirTools, err := listRunnable(dir)
+		if err != nil {
+			log.Warnf("Failed to list the inventory scripts in %s: %v", dir, err)
+			listErr = err
 			continue
 		}
+		listed = true
+		tools = append(tools, dirTools...)
+	}
+	if !listed && listErr != nil && len(providers) == 0 {
+		return nil, errors.Wrapf(listErr, "failed to list tools for inventory data")
+	}
 
-		p := utils.KeyValParser{}
-		var parseErr error
-		if parseErr = p.Parse(out); parseErr != nil {
-			log.Warnf("Inventory tool %s returned unparsable output: %v", t, parseErr)
+	timeout := defaultInventoryScriptTimeout
+	concurrency := 1
+	if id.config != nil {
+		if id.config.InventoryScriptTimeoutSeconds > 0 {
+			timeout = time.Duration(id.config.InventoryScriptTimeoutSeconds) * time.Second
 		}
-
-		if err := cmd.Wait(); err != nil {
-			log.Warnf("Inventory tool %s wait failed: %v", t, err)
+		if id.config.InventoryScriptConcurrency > 0 {
+			concurrency = id.config.InventoryScriptConcurrency
 		}
+	}
 
-		if parseErr == nil {
-			idec.AppendFromRaw(p.Collect())
+	// The tools may run concurrently, but their attributes are added in
+	// order, so that lists of values are always in the same order.
+	type toolRun struct {
+		result toolResult
+		err    error
+		cached bool
+	}
+	runs := make([]toolRun, len(tools))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range tools {
+		if stream := id.streams[t]; stream != nil {
+			if attrs, running := stream.attributes(); running {
+				runs[i] = toolRun{result: toolResult{attrs: attrs}, cached: true}
+				continue
+			}
+			log.Infof("Restarting the streaming inventory tool %s", t)
+			delete(id.streams, t)
+		}
+		if attrs, ok := id.lastAttributes(path.Base(t), t, now); ok {
+			runs[i] = toolRun{result: toolResult{attrs: attrs}, cached: true}
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, t string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			runs[i].result, runs[i].err = id.runTool(t, timeout)
+		}(i, t)
+	}
+	wg.Wait()
+	for i, t := range tools {
+		if runs[i].err != nil {
+			continue
+		}
+		result := runs[i].result
+		if !runs[i].cached {
+			if result.keepLast {
+				last, ok := id.lastRun[t]
+				if !ok {
+					continue
+				}
+				result.attrs = last.attrs
+			} else {
+				id.recordRun(t, now, result.attrs)
+			}
+			if result.interval > 0 {
+				if id.declaredIntervals == nil {
+					id.declaredIntervals = map[string]time.Duration{}
+				}
+				id.declaredIntervals[t] = result.interval
+			}
+			if result.stream != nil {
+				if id.streams == nil {
+					id.streams = map[string]*toolStream{}
+				}
+				id.streams[t] = result.stream
+			}
+		}
+		source := strings.TrimPrefix(path.Base(t), inventoryToolPrefix)
+		idec.appendFromSource(source, id.namespaced(source, result.attrs))
+	}
+	if id.provides != nil {
+		provides, err := id.provides()
+		if err != nil {
+			log.Errorf("Failed to read the provides of the installed artifacts: %v", err)
+		}
+		attrs := make(map[string][]string, len(provides))
+		for name, value := range provides {
+			attrs[name] = []string{value}
+		}
+		idec.replace(attrs)
+	}
+	if id.store != nil {
+		runtime, err := LoadRuntimeAttributes(id.store)
+		if err != nil {
+			log.Errorf("Failed to load the runtime inventory attributes: %v", err)
+		}
+		idec.replace(runtime)
+	}
+	if id.config != nil {
+		idec.removeMatching(id.config.InventoryAttributeBlacklist)
+		if id.config.InventorySchemaFile != "" {
+			schema, err := loadInventorySchema(id.config.InventorySchemaFile)
+			if err != nil {
+				log.Errorf("Not validating the inventory: %v", err)
+			} else {
+				idec.removeInvalid(schema)
+			}
 		}
 	}
 	return idec.GetInventoryData(), nil
 }
 
+// namespaced prefixes the names of the attributes with that of their source,
+// if configured, see conf.MenderConfigFromFile.InventoryAttributeNamespacing.
+func (id *InventoryDataRunner) namespaced(
+	source string,
+	attrs map[string][]string,
+) map[string][]string {
+	if id.config == nil || !id.config.InventoryAttributeNamespacing {
+		return attrs
+	}
+	prefix := source + "_"
+	prefixed := make(map[string][]string, len(attrs))
+	for name, values := range attrs {
+		if !strings.HasPrefix(name, prefix) {
+			name = prefix + name
+		}
+		prefixed[name] = append(prefixed[name], values...)
+	}
+	return prefixed
+}
+
 type InventoryDataDecoder struct {
 	data map[string]client.InventoryAttribute
+	// The script or provider which added each attribute first.
+	sources map[string]string
 }
 
 func NewInventoryDataDecoder() *InventoryDataDecoder {
 	return &InventoryDataDecoder{
-		make(map[string]client.InventoryAttribute),
+		data:    make(map[string]client.InventoryAttribute),
+		sources: make(map[string]string),
+	}
+}
+
+// appendFromSource adds the attributes of a script or provider. An attribute
+// which another one added already gets the values of both, in the order in
+// which they were added, and a warning is logged.
+func (id *InventoryDataDecoder) appendFromSource(source string, raw map[string][]string) {
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if first, ok := id.sources[name]; !ok {
+			id.sources[name] = source
+		} else if first != source {
+			log.Warnf("Inventory attribute %s is provided by both %s and %s, "+
+				"submitting the values of both", name, first, source)
+		}
 	}
+	id.AppendFromRaw(raw)
 }
 
 func (id *InventoryDataDecoder) GetInventoryData() client.InventoryData {
@@ -126,6 +380,28 @@ func (id *InventoryDataDecoder) GetInventoryData() client.InventoryData {
 	return idata
 }
 
+// removeMatching removes the attributes with names matching any of the
+// patterns, see path.Match.
+func (id *InventoryDataDecoder) removeMatching(patterns []string) {
+	for name := range id.data {
+		for _, pattern := range patterns {
+			if match, _ := path.Match(pattern, name); match {
+				log.Debugf("Leaving out the blacklisted inventory attribute %s", name)
+				delete(id.data, name)
+				break
+			}
+		}
+	}
+}
+
+// replace adds the attributes, replacing those of the same names.
+func (id *InventoryDataDecoder) replace(raw map[string][]string) {
+	for name := range raw {
+		delete(id.data, name)
+	}
+	id.AppendFromRaw(raw)
+}
+
 func (id *InventoryDataDecoder) AppendFromRaw(raw map[string][]string) {
 	for k, v := range raw {
 		if data, ok := id.data[k]; ok {
@@ -14,12 +14,15 @@
 package inventory
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -87,3 +90,261 @@ func TestInventoryDataParseError(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(data))
 }
+
+func TestInventoryDataJSON(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	fd, err := os.OpenFile(path.Join(tmpDir, "mender-inventory-test"),
+		os.O_CREATE|os.O_WRONLY, 0755)
+	require.NoError(t, err)
+	fd.Write([]byte(`#!/bin/sh
+cat <<EOF
+{
+  "hostname": "device 1",
+  "tags": ["a b", "c=d"],
+  "ready": true,
+  "cpus": 4,
+  "vendor": null,
+  "os": {"name": "Debian", "version": {"major": 12}},
+  "disks": [{"name": "sda", "size": 32}, {"name": "sdb", "size": 64}],
+  "levels": [[1, 2], 3]
+}
+EOF
+`))
+	fd.Close()
+
+	inventory := NewInventoryDataRunner(tmpDir)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.Len(t, data, 9)
+	for _, attr := range []client.InventoryAttribute{
+		{Name: "hostname", Value: "device 1"},
+		{Name: "tags", Value: []string{"a b", "c=d"}},
+		{Name: "ready", Value: "true"},
+		{Name: "cpus", Value: "4"},
+		{Name: "os_name", Value: "Debian"},
+		{Name: "os_version_major", Value: "12"},
+		{Name: "disks_name", Value: []string{"sda", "sdb"}},
+		{Name: "disks_size", Value: []string{"32", "64"}},
+		{Name: "levels", Value: []string{"1", "2", "3"}},
+	} {
+		assert.Contains(t, data, attr)
+	}
+}
+
+func TestParseJSONInventory(t *testing.T) {
+	attrs, err := parseJSONInventory([]byte(`[{"a": "1"}, {"a": "2", "b": 3.5}]`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"a": {"1", "2"},
+		"b": {"3.5"},
+	}, attrs)
+
+	for _, data := range []string{
+		`{"a": `,
+		`{"a": 1} {"b": 2}`,
+		`[{"a": 1}, "b"]`,
+	} {
+		_, err = parseJSONInventory([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestInventoryDataInterval(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// Each script counts its runs.
+	for _, name := range []string{"hourly", "always"} {
+		script := fmt.Sprintf("#!/bin/sh\necho x >> %s/%s.runs\necho %s=$(wc -l < %s/%s.runs)\n",
+			tmpDir, name, name, tmpDir, name)
+		require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+name),
+			[]byte(script), 0755))
+	}
+
+	now := time.Now()
+
+	config := conf.NewMenderConfig()
+	config.InventoryIntervalSeconds = map[string]int{inventoryToolPrefix + "hourly": 3600}
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.now = func() time.Time { return now }
+	inventory.SetBuiltinProviders(config)
+
+	get := func() client.InventoryData {
+		data, err := inventory.Get()
+		require.NoError(t, err)
+		return data
+	}
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "hourly", Value: "1"},
+		{Name: "always", Value: "1"},
+	}, get())
+
+	now = now.Add(59 * time.Minute)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "hourly", Value: "1"},
+		{Name: "always", Value: "2"},
+	}, get())
+
+	now = now.Add(time.Minute)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "hourly", Value: "2"},
+		{Name: "always", Value: "3"},
+	}, get())
+}
+
+func TestInventoryDataDirs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	vendorDir := path.Join(tmpDir, "vendor")
+	etcDir := path.Join(tmpDir, "inventory.d")
+	for dir, value := range map[string]string{vendorDir: "vendor", etcDir: "etc"} {
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, inventoryToolPrefix+"source"),
+			[]byte("#!/bin/sh\necho source="+value+"\n"), 0755))
+	}
+	require.NoError(t, ioutil.WriteFile(path.Join(etcDir, inventoryToolPrefix+"extra"),
+		[]byte("#!/bin/sh\necho extra=yes\n"), 0755))
+
+	inventory := NewInventoryDataRunner(vendorDir, path.Join(tmpDir, "missing"), etcDir)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "source", Value: []string{"vendor", "etc"}},
+		{Name: "extra", Value: "yes"},
+	}, data)
+
+	inventory = NewInventoryDataRunner(path.Join(tmpDir, "missing"))
+	_, err = inventory.Get()
+	assert.Error(t, err)
+}
+
+func TestInventoryDataBlacklist(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"test"),
+		[]byte("#!/bin/sh\necho hostname=device1\necho customer_id=42\n"+
+			"echo customer_name=ACME\necho os=Debian\n"), 0755))
+
+	config := conf.NewMenderConfig()
+	config.InventoryAttributeBlacklist = []string{"hostname", "customer_*"}
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetBuiltinProviders(config)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.Equal(t, client.InventoryData{{Name: "os", Value: "Debian"}}, data)
+}
+
+func TestInventoryDataTimeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// The hung script leaves a child behind, which must be killed too, or
+	// its output would never end.
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"hung"),
+		[]byte("#!/bin/sh\necho hung=yes\nsleep 60 &\nsleep 60\n"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"ok"),
+		[]byte("#!/bin/sh\necho ok=yes\n"), 0755))
+
+	config := conf.NewMenderConfig()
+	config.InventoryScriptTimeoutSeconds = 1
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetBuiltinProviders(config)
+	start := time.Now()
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(30*time.Second))
+	assert.Equal(t, client.InventoryData{{Name: "ok", Value: "yes"}}, data)
+}
+
+func TestInventoryDataConcurrency(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, ioutil.WriteFile(
+			path.Join(tmpDir, fmt.Sprintf("%s%d", inventoryToolPrefix, i)),
+			[]byte(fmt.Sprintf("#!/bin/sh\nsleep 1\necho value=%d\n", i)), 0755))
+	}
+
+	config := conf.NewMenderConfig()
+	config.InventoryScriptConcurrency = 4
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetBuiltinProviders(config)
+	start := time.Now()
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(3*time.Second))
+	// In the order of the scripts, whichever finished first.
+	assert.Equal(t, client.InventoryData{
+		{Name: "value", Value: []string{"0", "1", "2", "3"}},
+	}, data)
+}
+
+func TestInventoryDataNamespacing(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"app"),
+		[]byte("#!/bin/sh\necho version=1.2\necho app_name=demo\n"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"os"),
+		[]byte("#!/bin/sh\necho version=12\n"), 0755))
+
+	// Colliding attributes get the values of both, in order.
+	config := conf.NewMenderConfig()
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetBuiltinProviders(config)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "version", Value: []string{"1.2", "12"}},
+		{Name: "app_name", Value: "demo"},
+	}, data)
+
+	config.InventoryAttributeNamespacing = true
+	data, err = inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "app_version", Value: "1.2"},
+		{Name: "app_name", Value: "demo"},
+		{Name: "os_version", Value: "12"},
+	}, data)
+}
+
+func TestInventoryDataProvides(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// Like mender-inventory-provides, the provides replace those of the
+	// scripts rather than adding to them.
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"provides"),
+		[]byte("#!/bin/sh\necho rootfs-image.version=v1\necho os=Debian\n"), 0755))
+
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetProvides(func() (map[string]string, error) {
+		return map[string]string{
+			"artifact_name":              "release-2",
+			"rootfs-image.version":       "v2",
+			"data-partition.app.version": "1.4",
+		}, nil
+	})
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "artifact_name", Value: "release-2"},
+		{Name: "rootfs-image.version", Value: "v2"},
+		{Name: "data-partition.app.version", Value: "1.4"},
+		{Name: "os", Value: "Debian"},
+	}, data)
+}
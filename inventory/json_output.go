@@ -0,0 +1,91 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// parseJSONInventory parses the JSON output of an inventory tool, an object,
+// or an array of objects, whose members are flattened into attributes:
+//   - strings, numbers and booleans are values, and nulls are left out
+//   - arrays are lists of the values of their elements
+//   - members of nested objects are attributes named <object>_<member>,
+//     also when the objects are elements of an array, whose values are
+//     then collected in lists
+//
+// So {"os": {"name": "Debian", "version": 12}, "disks": [{"name": "sda"},
+// {"name": "sdb"}]} gives os_name=Debian, os_version=12 and
+// disks_name=[sda, sdb].
+func parseJSONInventory(data []byte) (map[string][]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid JSON: more than one value")
+	}
+
+	attrs := map[string][]string{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		flattenJSON("", v, attrs)
+	case []interface{}:
+		for _, elem := range v {
+			obj, ok := elem.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("a JSON array of inventory must only have objects")
+			}
+			flattenJSON("", obj, attrs)
+		}
+	}
+	return attrs, nil
+}
+
+func flattenJSON(name string, value interface{}, attrs map[string][]string) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		attrs[name] = append(attrs[name], v)
+	case json.Number:
+		attrs[name] = append(attrs[name], v.String())
+	case bool:
+		attrs[name] = append(attrs[name], strconv.FormatBool(v))
+	case []interface{}:
+		for _, elem := range v {
+			flattenJSON(name, elem, attrs)
+		}
+	case map[string]interface{}:
+		// In order, so that lists are in the same order every time.
+		members := make([]string, 0, len(v))
+		for member := range v {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		for _, member := range members {
+			memberName := member
+			if name != "" {
+				memberName = name + "_" + member
+			}
+			flattenJSON(memberName, v[member], attrs)
+		}
+	}
+}
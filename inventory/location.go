@@ -0,0 +1,162 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const (
+	defaultGPSDAddress       = "localhost:2947"
+	defaultLocationModem     = "any"
+	defaultLocationPrecision = 2
+)
+
+const (
+	defaultMmcliCommand = "mmcli"
+	defaultGPSDTimeout  = 5 * time.Second
+)
+
+// locationInventory gives the location of the device, from the source of
+// the configuration, rounded to its precision.
+func (sys *builtinSystem) locationInventory(
+	config *conf.MenderConfig,
+) (map[string][]string, error) {
+	location := config.Location
+	var lat, lon float64
+	var err error
+	switch location.Source {
+	case conf.LocationSourceGPSD:
+		address := location.GPSDAddress
+		if address == "" {
+			address = defaultGPSDAddress
+		}
+		lat, lon, err = gpsdLocation(address, sys.gpsdTimeout)
+	case conf.LocationSourceModemManager:
+		modem := location.Modem
+		if modem == "" {
+			modem = defaultLocationModem
+		}
+		lat, lon, err = modemManagerLocation(sys.mmcliCommand, modem)
+	case conf.LocationSourceStatic:
+		lat, lon = location.Latitude, location.Longitude
+	default:
+		return nil, errors.Errorf("unsupported location source %q", location.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	precision := location.PrecisionDecimals
+	if precision <= 0 {
+		precision = defaultLocationPrecision
+	}
+	return map[string][]string{
+		"location_latitude":  {roundCoordinate(lat, precision)},
+		"location_longitude": {roundCoordinate(lon, precision)},
+		"location_source":    {location.Source},
+	}, nil
+}
+
+func roundCoordinate(value float64, decimals int) string {
+	scale := math.Pow(10, float64(decimals))
+	return strconv.FormatFloat(math.Round(value*scale)/scale, 'f', decimals, 64)
+}
+
+// gpsdReport is the part of the reports of gpsd which the location is
+// taken from.
+type gpsdReport struct {
+	Class string   `json:"class"`
+	Mode  int      `json:"mode"`
+	Lat   *float64 `json:"lat"`
+	Lon   *float64 `json:"lon"`
+}
+
+// gpsdLocation has gpsd report the position, and waits for a report with a
+// fix, up to timeout.
+func gpsdLocation(address string, timeout time.Duration) (float64, float64, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to connect to gpsd")
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, 0, err
+	}
+	if _, err = conn.Write([]byte("?WATCH={\"enable\":true,\"json\":true};\n")); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to send the request to gpsd")
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var report gpsdReport
+		if json.Unmarshal(scanner.Bytes(), &report) != nil || report.Class != "TPV" {
+			continue
+		}
+		// Mode 2 and 3 are 2D and 3D fixes.
+		if report.Mode >= 2 && report.Lat != nil && report.Lon != nil {
+			return *report.Lat, *report.Lon, nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, 0, errors.Wrap(err, "no position from gpsd")
+	}
+	return 0, 0, errors.New("gpsd closed the connection without a position")
+}
+
+// modemManagerLocation reads the GPS position of the modem from
+// ModemManager, with the mmcli command.
+func modemManagerLocation(mmcliCommand, modem string) (float64, float64, error) {
+	out, err := exec.Command(mmcliCommand, "--modem", modem,
+		"--location-get", "--output-keyvalue").CombinedOutput()
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "%s failed: %s", mmcliCommand,
+			strings.TrimSpace(string(out)))
+	}
+	var lat, lon *float64
+	for _, line := range strings.Split(string(out), "\n") {
+		name, value, ok := cutField(line, ":")
+		if !ok {
+			continue
+		}
+		var coordinate **float64
+		switch name {
+		case "modem.location.gps.latitude":
+			coordinate = &lat
+		case "modem.location.gps.longitude":
+			coordinate = &lon
+		default:
+			continue
+		}
+		// Unknown values are "--".
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			*coordinate = &v
+		}
+	}
+	if lat == nil || lon == nil {
+		return 0, 0, errors.New("the modem has no GPS position")
+	}
+	return *lat, *lon, nil
+}
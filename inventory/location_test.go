@@ -0,0 +1,119 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const fakeMmcli = `#!/bin/sh
+cat <<EOF
+modem.location.3gpp.mcc                : 242
+modem.location.gps.utc                 : 102030.00
+modem.location.gps.longitude           : 10.752245
+modem.location.gps.latitude            : 59.913868
+modem.location.gps.altitude            : --
+EOF
+`
+
+func TestLocationInventory(t *testing.T) {
+	config := conf.NewMenderConfig()
+	config.Location = conf.Location{
+		Source:    conf.LocationSourceStatic,
+		Latitude:  59.913868,
+		Longitude: -10.752245,
+	}
+	sys := newBuiltinSystem()
+	attrs, err := sys.locationInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"location_latitude":  {"59.91"},
+		"location_longitude": {"-10.75"},
+		"location_source":    {"static"},
+	}, attrs)
+
+	// ModemManager
+	tmpDir, err := ioutil.TempDir("", "mender-location")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	mmcli := path.Join(tmpDir, "mmcli")
+	require.NoError(t, ioutil.WriteFile(mmcli, []byte(fakeMmcli), 0755))
+	sys.mmcliCommand = mmcli
+
+	config.Location = conf.Location{
+		Source:            conf.LocationSourceModemManager,
+		PrecisionDecimals: 4,
+	}
+	attrs, err = sys.locationInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"59.9139"}, attrs["location_latitude"])
+	assert.Equal(t, []string{"10.7522"}, attrs["location_longitude"])
+
+	require.NoError(t, ioutil.WriteFile(mmcli,
+		[]byte("#!/bin/sh\necho 'modem.location.gps.latitude : --'\n"), 0755))
+	_, err = sys.locationInventory(config)
+	assert.Error(t, err)
+
+	// gpsd, which only has a fix after a while.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(`{"class":"VERSION","release":"3.22"}` + "\n"))
+		request, _ := bufio.NewReader(conn).ReadString('\n')
+		assert.Contains(t, request, "?WATCH=")
+		_, _ = conn.Write([]byte(`{"class":"TPV","mode":1}` + "\n" +
+			`{"class":"SKY","satellites":[]}` + "\n" +
+			`{"class":"TPV","mode":3,"lat":59.913868,"lon":10.752245,"alt":20.1}` + "\n"))
+	}()
+	config.Location = conf.Location{
+		Source:      conf.LocationSourceGPSD,
+		GPSDAddress: listener.Addr().String(),
+	}
+	attrs, err = sys.locationInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"59.91"}, attrs["location_latitude"])
+	assert.Equal(t, []string{"10.75"}, attrs["location_longitude"])
+	assert.Equal(t, []string{"gpsd"}, attrs["location_source"])
+
+	// No fix before the timeout.
+	sys.gpsdTimeout = 100 * time.Millisecond
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(`{"class":"TPV","mode":1}` + "\n"))
+		time.Sleep(time.Second)
+	}()
+	_, err = sys.locationInventory(config)
+	assert.Error(t, err)
+}
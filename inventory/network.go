@@ -0,0 +1,132 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// routeMessages reads the routing table from the kernel.
+func routeMessages() ([]syscall.NetlinkMessage, error) {
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	return syscall.ParseNetlinkMessage(data)
+}
+
+// networkInventory gives the interfaces, their MAC and IP addresses, and the
+// default gateways. Everything is read from the kernel over netlink, so it
+// doesn't depend on the naming of the interfaces, or on the output of ip.
+func (sys *builtinSystem) networkInventory(*conf.MenderConfig) (map[string][]string, error) {
+	ifaces, err := sys.netInterfaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the network interfaces")
+	}
+	attrs := map[string][]string{}
+	names := map[int]string{}
+	for i := range ifaces {
+		iface := &ifaces[i]
+		names[iface.Index] = iface.Name
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		attrs["network_interfaces"] = append(attrs["network_interfaces"], iface.Name)
+		if len(iface.HardwareAddr) > 0 {
+			attrs["mac_"+iface.Name] = []string{iface.HardwareAddr.String()}
+		}
+		addrs, err := sys.interfaceAddrs(iface)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the addresses of %s", iface.Name)
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			name := "ipv6_" + iface.Name
+			if ipNet.IP.To4() != nil {
+				name = "ipv4_" + iface.Name
+			}
+			attrs[name] = append(attrs[name], ipNet.String())
+		}
+	}
+
+	msgs, err := sys.routeMessages()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the routing table")
+	}
+	for family, gateways := range defaultGateways(msgs, names) {
+		prefix := "ipv4_"
+		if family == syscall.AF_INET6 {
+			prefix = "ipv6_"
+		}
+		for _, gw := range gateways {
+			attrs[prefix+"gateway"] = append(attrs[prefix+"gateway"], gw.ip.String())
+			attrs[prefix+"gateway_interface"] = append(attrs[prefix+"gateway_interface"],
+				gw.iface)
+		}
+	}
+	return attrs, nil
+}
+
+type gateway struct {
+	ip    net.IP
+	iface string
+}
+
+// defaultGateways returns the gateways of the default routes in the main
+// routing table, by address family. names maps interface indexes to names.
+func defaultGateways(
+	msgs []syscall.NetlinkMessage,
+	names map[int]string,
+) map[uint8][]gateway {
+	gateways := map[uint8][]gateway{}
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Header.Type != syscall.RTM_NEWROUTE || len(msg.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		rtm := (*syscall.RtMsg)(unsafe.Pointer(&msg.Data[0]))
+		if rtm.Dst_len != 0 || rtm.Table != syscall.RT_TABLE_MAIN ||
+			rtm.Type != syscall.RTN_UNICAST {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			continue
+		}
+		var gw gateway
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_GATEWAY:
+				gw.ip = net.IP(attr.Value)
+			case syscall.RTA_OIF:
+				if len(attr.Value) == 4 {
+					gw.iface = names[int(*(*uint32)(unsafe.Pointer(&attr.Value[0])))]
+				}
+			}
+		}
+		if gw.ip != nil {
+			gateways[rtm.Family] = append(gateways[rtm.Family], gw)
+		}
+	}
+	return gateways
+}
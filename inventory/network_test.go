@@ -0,0 +1,125 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+// routeMessage returns a route message, as the kernel sends them over
+// netlink, with the given attributes.
+func routeMessage(rtm syscall.RtMsg, attrs map[uint16][]byte) syscall.NetlinkMessage {
+	data := append([]byte(nil), (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(&rtm))[:]...)
+	for attrType, value := range attrs {
+		attr := syscall.RtAttr{
+			Len:  uint16(syscall.SizeofRtAttr + len(value)),
+			Type: attrType,
+		}
+		data = append(data, (*[syscall.SizeofRtAttr]byte)(unsafe.Pointer(&attr))[:]...)
+		data = append(data, value...)
+		for len(data)%syscall.RTA_ALIGNTO != 0 {
+			data = append(data, 0)
+		}
+	}
+	return syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE},
+		Data:   data,
+	}
+}
+
+func interfaceIndex(index uint32) []byte {
+	return append([]byte(nil), (*[4]byte)(unsafe.Pointer(&index))[:]...)
+}
+
+func TestNetworkInventory(t *testing.T) {
+	sys := newBuiltinSystem()
+	mac, _ := net.ParseMAC("dc:a6:32:01:02:03")
+	sys.netInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Index: 1, Name: "lo", Flags: net.FlagLoopback | net.FlagUp},
+			{Index: 2, Name: "enp1s0", HardwareAddr: mac, Flags: net.FlagUp},
+			{Index: 3, Name: "enp1s0.100", HardwareAddr: mac, Flags: net.FlagUp},
+		}, nil
+	}
+	sys.interfaceAddrs = func(iface *net.Interface) ([]net.Addr, error) {
+		switch iface.Name {
+		case "enp1s0":
+			_, v4, _ := net.ParseCIDR("192.168.1.10/24")
+			v4.IP = net.ParseIP("192.168.1.10")
+			_, v6, _ := net.ParseCIDR("fe80::1/64")
+			v6.IP = net.ParseIP("fe80::1")
+			return []net.Addr{v4, v6}, nil
+		case "enp1s0.100":
+			_, v4, _ := net.ParseCIDR("10.0.100.2/16")
+			v4.IP = net.ParseIP("10.0.100.2")
+			return []net.Addr{v4}, nil
+		}
+		return nil, nil
+	}
+	sys.routeMessages = func() ([]syscall.NetlinkMessage, error) {
+		return []syscall.NetlinkMessage{
+			// default via 192.168.1.1 dev enp1s0
+			routeMessage(syscall.RtMsg{
+				Family: syscall.AF_INET,
+				Table:  syscall.RT_TABLE_MAIN,
+				Type:   syscall.RTN_UNICAST,
+			}, map[uint16][]byte{
+				syscall.RTA_GATEWAY: net.ParseIP("192.168.1.1").To4(),
+				syscall.RTA_OIF:     interfaceIndex(2),
+			}),
+			// 192.168.1.0/24 dev enp1s0
+			routeMessage(syscall.RtMsg{
+				Family:  syscall.AF_INET,
+				Dst_len: 24,
+				Table:   syscall.RT_TABLE_MAIN,
+				Type:    syscall.RTN_UNICAST,
+			}, map[uint16][]byte{
+				syscall.RTA_DST: net.ParseIP("192.168.1.0").To4(),
+				syscall.RTA_OIF: interfaceIndex(2),
+			}),
+			// default via fe80::fe dev enp1s0.100
+			routeMessage(syscall.RtMsg{
+				Family: syscall.AF_INET6,
+				Table:  syscall.RT_TABLE_MAIN,
+				Type:   syscall.RTN_UNICAST,
+			}, map[uint16][]byte{
+				syscall.RTA_GATEWAY: net.ParseIP("fe80::fe"),
+				syscall.RTA_OIF:     interfaceIndex(3),
+			}),
+		}, nil
+	}
+
+	attrs, err := sys.networkInventory(conf.NewMenderConfig())
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"network_interfaces":     {"enp1s0", "enp1s0.100"},
+		"mac_enp1s0":             {"dc:a6:32:01:02:03"},
+		"mac_enp1s0.100":         {"dc:a6:32:01:02:03"},
+		"ipv4_enp1s0":            {"192.168.1.10/24"},
+		"ipv6_enp1s0":            {"fe80::1/64"},
+		"ipv4_enp1s0.100":        {"10.0.100.2/16"},
+		"ipv4_gateway":           {"192.168.1.1"},
+		"ipv4_gateway_interface": {"enp1s0"},
+		"ipv6_gateway":           {"fe80::fe"},
+		"ipv6_gateway_interface": {"enp1s0.100"},
+	}, attrs)
+}
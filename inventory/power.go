@@ -0,0 +1,106 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+const defaultPowerSupplyPath = "/sys/class/power_supply"
+
+// powerInventory gives the charge and status of the battery, and what the
+// device is powered from, from the power supplies of the kernel, which
+// upower reads as well.
+func (sys *builtinSystem) powerInventory(*conf.MenderConfig) (map[string][]string, error) {
+	entries, err := ioutil.ReadDir(sys.powerSupplyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the power supplies")
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	attrs := map[string][]string{}
+	var source string
+	for _, name := range names {
+		supply := func(file string) string {
+			data, _ := ioutil.ReadFile(path.Join(sys.powerSupplyPath, name, file))
+			return strings.TrimSpace(string(data))
+		}
+		// Batteries of peripherals, such as a wireless mouse, don't
+		// power the device.
+		if supply("scope") == "Device" {
+			continue
+		}
+		kind := supply("type")
+		if kind != "Battery" {
+			if source == "" && supply("online") == "1" {
+				source = strings.ToLower(kind)
+			}
+			continue
+		}
+		if _, ok := attrs["battery_percentage"]; ok || supply("present") == "0" {
+			continue
+		}
+		if percentage, ok := batteryPercentage(supply); ok {
+			attrs["battery_percentage"] = []string{strconv.Itoa(percentage)}
+		}
+		if status := supply("status"); status != "" {
+			attrs["battery_status"] = []string{
+				strings.ReplaceAll(strings.ToLower(status), " ", "_")}
+		}
+	}
+	if source == "" && len(attrs) > 0 {
+		source = "battery"
+	}
+	if source == "" {
+		return nil, errors.New("found no power supply")
+	}
+	attrs["power_source"] = []string{source}
+	return attrs, nil
+}
+
+// batteryPercentage is the capacity of the battery if the driver gives it,
+// or else what is left of its energy or charge.
+func batteryPercentage(supply func(string) string) (int, bool) {
+	if capacity, err := strconv.Atoi(supply("capacity")); err == nil {
+		return capacity, true
+	}
+	for _, unit := range []string{"energy", "charge"} {
+		now, err := strconv.ParseFloat(supply(unit+"_now"), 64)
+		if err != nil {
+			continue
+		}
+		full, err := strconv.ParseFloat(supply(unit+"_full"), 64)
+		if err != nil || full <= 0 {
+			continue
+		}
+		percentage := int(now/full*100 + 0.5)
+		if percentage > 100 {
+			percentage = 100
+		}
+		return percentage, true
+	}
+	return 0, false
+}
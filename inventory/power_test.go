@@ -0,0 +1,89 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/conf"
+)
+
+func TestPowerInventory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mender-power")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	sys := newBuiltinSystem()
+	sys.powerSupplyPath = tmpDir
+
+	writeSupply := func(name string, files map[string]string) {
+		dir := path.Join(tmpDir, name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		for file, content := range files {
+			require.NoError(t, ioutil.WriteFile(path.Join(dir, file),
+				[]byte(content+"\n"), 0644))
+		}
+	}
+	config := conf.NewMenderConfig()
+
+	_, err = sys.powerInventory(config)
+	assert.Error(t, err)
+
+	writeSupply("AC", map[string]string{"type": "Mains", "online": "0"})
+	writeSupply("BAT0", map[string]string{
+		"type":     "Battery",
+		"present":  "1",
+		"status":   "Discharging",
+		"capacity": "42",
+	})
+	writeSupply("hidpp_battery_0", map[string]string{
+		"type":     "Battery",
+		"scope":    "Device",
+		"status":   "Charging",
+		"capacity": "90",
+	})
+	attrs, err := sys.powerInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"power_source":       {"battery"},
+		"battery_percentage": {"42"},
+		"battery_status":     {"discharging"},
+	}, attrs)
+
+	writeSupply("AC", map[string]string{"online": "1"})
+	writeSupply("BAT0", map[string]string{"status": "Not charging"})
+	require.NoError(t, os.Remove(path.Join(tmpDir, "BAT0", "capacity")))
+	writeSupply("BAT0", map[string]string{
+		"energy_now":  "30000000",
+		"energy_full": "40000000",
+	})
+	attrs, err = sys.powerInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"power_source":       {"mains"},
+		"battery_percentage": {"75"},
+		"battery_status":     {"not_charging"},
+	}, attrs)
+
+	// No battery
+	require.NoError(t, os.RemoveAll(path.Join(tmpDir, "BAT0")))
+	attrs, err = sys.powerInventory(config)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"power_source": {"mains"}}, attrs)
+}
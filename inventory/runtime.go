@@ -0,0 +1,97 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+// LoadRuntimeAttributes returns the attributes set with
+// SetRuntimeAttribute.
+func LoadRuntimeAttributes(dbStore store.Store) (map[string][]string, error) {
+	return loadRuntimeAttributes(dbStore)
+}
+
+func loadRuntimeAttributes(txn store.Transaction) (map[string][]string, error) {
+	attrs := map[string][]string{}
+	data, err := txn.ReadAll(datastore.RuntimeInventoryKey)
+	if err == os.ErrNotExist {
+		return attrs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &attrs); err != nil {
+		return nil, errors.Wrap(err, "invalid runtime inventory attributes")
+	}
+	return attrs, nil
+}
+
+// SetRuntimeAttribute stores the values of an attribute, submitted with the
+// inventory from then on, in place of any the scripts collect, until it is
+// removed with RemoveRuntimeAttribute.
+func SetRuntimeAttribute(dbStore store.Store, name string, values []string) error {
+	if name == "" {
+		return errors.New("the inventory attribute has no name")
+	}
+	if len(values) == 0 {
+		return errors.Errorf("the inventory attribute %s has no value", name)
+	}
+	return dbStore.WriteTransaction(func(txn store.Transaction) error {
+		attrs, err := loadRuntimeAttributes(txn)
+		if err != nil {
+			return err
+		}
+		attrs[name] = values
+		return storeRuntimeAttributes(txn, attrs)
+	})
+}
+
+// RemoveRuntimeAttribute removes an attribute stored with
+// SetRuntimeAttribute, and returns whether there was one.
+func RemoveRuntimeAttribute(dbStore store.Store, name string) (bool, error) {
+	removed := false
+	err := dbStore.WriteTransaction(func(txn store.Transaction) error {
+		attrs, err := loadRuntimeAttributes(txn)
+		if err != nil {
+			return err
+		}
+		if _, removed = attrs[name]; !removed {
+			return nil
+		}
+		delete(attrs, name)
+		return storeRuntimeAttributes(txn, attrs)
+	})
+	return removed, err
+}
+
+func storeRuntimeAttributes(txn store.Transaction, attrs map[string][]string) error {
+	if len(attrs) == 0 {
+		err := txn.Remove(datastore.RuntimeInventoryKey)
+		if err == os.ErrNotExist {
+			err = nil
+		}
+		return err
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	return txn.WriteAll(datastore.RuntimeInventoryKey, data)
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/datastore"
+	"github.com/mendersoftware/mender/store"
+)
+
+func TestRuntimeAttributes(t *testing.T) {
+	dbStore := store.NewMemStore()
+	attrs, err := LoadRuntimeAttributes(dbStore)
+	require.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	require.NoError(t, SetRuntimeAttribute(dbStore, "app_version", []string{"1.2"}))
+	require.NoError(t, SetRuntimeAttribute(dbStore, "sensors", []string{"a", "b"}))
+	require.NoError(t, SetRuntimeAttribute(dbStore, "app_version", []string{"1.3"}))
+	assert.Error(t, SetRuntimeAttribute(dbStore, "", []string{"x"}))
+	assert.Error(t, SetRuntimeAttribute(dbStore, "empty", nil))
+	attrs, err = LoadRuntimeAttributes(dbStore)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"app_version": {"1.3"},
+		"sensors":     {"a", "b"},
+	}, attrs)
+
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"test"),
+		[]byte("#!/bin/sh\necho app_version=1.0\necho os=Debian\n"), 0755))
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetRuntimeStore(dbStore)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "app_version", Value: "1.3"},
+		{Name: "sensors", Value: []string{"a", "b"}},
+		{Name: "os", Value: "Debian"},
+	}, data)
+
+	removed, err := RemoveRuntimeAttribute(dbStore, "app_version")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = RemoveRuntimeAttribute(dbStore, "app_version")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	removed, err = RemoveRuntimeAttribute(dbStore, "sensors")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	_, err = dbStore.ReadAll(datastore.RuntimeInventoryKey)
+	assert.Equal(t, os.ErrNotExist, err)
+}
@@ -0,0 +1,336 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/client"
+)
+
+// inventorySchema is the subset of JSON Schema which applies to inventory,
+// an object of attributes whose values are strings or lists of strings.
+type inventorySchema struct {
+	// false schemas reject everything, true and empty schemas accept
+	// everything.
+	reject bool
+
+	types     []string
+	enum      []interface{}
+	pattern   *regexp.Regexp
+	minLength *int
+	maxLength *int
+	items     *inventorySchema
+	minItems  *int
+	maxItems  *int
+
+	properties           map[string]*inventorySchema
+	patternProperties    map[*regexp.Regexp]*inventorySchema
+	additionalProperties *inventorySchema
+	required             []string
+}
+
+// Keywords which are only annotations, and don't affect validation.
+var schemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"examples":    true,
+	"default":     true,
+}
+
+// loadInventorySchema reads a JSON schema for the inventory from a file.
+func loadInventorySchema(file string) (*inventorySchema, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, errors.Wrapf(err, "invalid JSON in %s", file)
+	}
+	schema, err := compileSchema(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid inventory schema %s", file)
+	}
+	return schema, nil
+}
+
+func compileSchema(value interface{}) (*inventorySchema, error) {
+	switch v := value.(type) {
+	case bool:
+		return &inventorySchema{reject: !v}, nil
+	case map[string]interface{}:
+		s := &inventorySchema{}
+		for keyword, arg := range v {
+			if err := s.compileKeyword(keyword, arg); err != nil {
+				return nil, errors.Wrap(err, keyword)
+			}
+		}
+		return s, nil
+	}
+	return nil, errors.New("a schema must be an object or a boolean")
+}
+
+func (s *inventorySchema) compileKeyword(keyword string, arg interface{}) error {
+	var err error
+	switch keyword {
+	case "type":
+		switch t := arg.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, elem := range t {
+				name, ok := elem.(string)
+				if !ok {
+					return errors.New("types must be strings")
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return errors.New("must be a string or a list of strings")
+		}
+		for _, t := range s.types {
+			if t != "string" && t != "array" {
+				return errors.Errorf("unsupported type %q, inventory values are "+
+					"strings or arrays of strings", t)
+			}
+		}
+	case "enum":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return errors.New("must be a list")
+		}
+		for _, elem := range list {
+			value, ok := schemaValue(elem)
+			if !ok {
+				return errors.New("values must be strings or lists of strings")
+			}
+			s.enum = append(s.enum, value)
+		}
+	case "const":
+		value, ok := schemaValue(arg)
+		if !ok {
+			return errors.New("must be a string or a list of strings")
+		}
+		s.enum = []interface{}{value}
+	case "pattern":
+		pattern, ok := arg.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		s.pattern, err = regexp.Compile(pattern)
+	case "minLength":
+		s.minLength, err = schemaCount(arg)
+	case "maxLength":
+		s.maxLength, err = schemaCount(arg)
+	case "minItems":
+		s.minItems, err = schemaCount(arg)
+	case "maxItems":
+		s.maxItems, err = schemaCount(arg)
+	case "items":
+		s.items, err = compileSchema(arg)
+	case "additionalProperties":
+		s.additionalProperties, err = compileSchema(arg)
+	case "properties", "patternProperties":
+		props, ok := arg.(map[string]interface{})
+		if !ok {
+			return errors.New("must be an object")
+		}
+		if keyword == "properties" {
+			s.properties = map[string]*inventorySchema{}
+		} else {
+			s.patternProperties = map[*regexp.Regexp]*inventorySchema{}
+		}
+		for name, propArg := range props {
+			prop, err := compileSchema(propArg)
+			if err != nil {
+				return errors.Wrap(err, name)
+			}
+			if keyword == "properties" {
+				s.properties[name] = prop
+				continue
+			}
+			re, err := regexp.Compile(name)
+			if err != nil {
+				return err
+			}
+			s.patternProperties[re] = prop
+		}
+	case "required":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return errors.New("must be a list")
+		}
+		for _, elem := range list {
+			name, ok := elem.(string)
+			if !ok {
+				return errors.New("names must be strings")
+			}
+			s.required = append(s.required, name)
+		}
+	default:
+		if !schemaAnnotations[keyword] {
+			return errors.New("unsupported keyword")
+		}
+	}
+	return err
+}
+
+// schemaValue converts a JSON value of a schema to an inventory value.
+func schemaValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, elem := range v {
+			s, ok := elem.(string)
+			if !ok {
+				return nil, false
+			}
+			values[i] = s
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func schemaCount(arg interface{}) (*int, error) {
+	n, ok := arg.(float64)
+	if !ok || n < 0 || n != float64(int(n)) {
+		return nil, errors.New("must be a non-negative integer")
+	}
+	count := int(n)
+	return &count, nil
+}
+
+// validate checks an inventory value, a string or a list of strings.
+func (s *inventorySchema) validate(value interface{}) error {
+	if s.reject {
+		return errors.New("not allowed")
+	}
+	list, isList := value.([]string)
+	if len(s.types) > 0 {
+		valueType := "string"
+		if isList {
+			valueType = "array"
+		}
+		matched := false
+		for _, t := range s.types {
+			matched = matched || t == valueType
+		}
+		if !matched {
+			return errors.Errorf("is not of type %v", s.types)
+		}
+	}
+	if len(s.enum) > 0 {
+		matched := false
+		for _, allowed := range s.enum {
+			matched = matched || reflect.DeepEqual(allowed, value)
+		}
+		if !matched {
+			return errors.New("is not one of the allowed values")
+		}
+	}
+	if str, ok := value.(string); ok {
+		return s.validateString(str)
+	}
+	if s.minItems != nil && len(list) < *s.minItems {
+		return errors.Errorf("has fewer than %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(list) > *s.maxItems {
+		return errors.Errorf("has more than %d items", *s.maxItems)
+	}
+	for i, item := range list {
+		if s.items != nil {
+			if err := s.items.validate(item); err != nil {
+				return errors.Wrapf(err, "item %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *inventorySchema) validateString(str string) error {
+	length := utf8.RuneCountInString(str)
+	if s.minLength != nil && length < *s.minLength {
+		return errors.Errorf("is shorter than %d characters", *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		return errors.Errorf("is longer than %d characters", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return errors.Errorf("does not match %q", s.pattern.String())
+	}
+	return nil
+}
+
+// validateAttribute checks an attribute against the schemas of the
+// properties it matches, or against additionalProperties if none.
+func (s *inventorySchema) validateAttribute(attr client.InventoryAttribute) error {
+	if s.reject {
+		return errors.New("not allowed")
+	}
+	var schemas []*inventorySchema
+	if prop, ok := s.properties[attr.Name]; ok {
+		schemas = append(schemas, prop)
+	}
+	for re, prop := range s.patternProperties {
+		if re.MatchString(attr.Name) {
+			schemas = append(schemas, prop)
+		}
+	}
+	if len(schemas) == 0 && s.additionalProperties != nil {
+		schemas = append(schemas, s.additionalProperties)
+	}
+	for _, prop := range schemas {
+		if err := prop.validate(attr.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeInvalid removes the attributes which the schema rejects, logging
+// them, and logs the required attributes which are missing.
+func (id *InventoryDataDecoder) removeInvalid(s *inventorySchema) {
+	names := make([]string, 0, len(id.data))
+	for name := range id.data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := s.validateAttribute(id.data[name]); err != nil {
+			log.Errorf("Leaving out the inventory attribute %s, which the schema rejects: "+
+				"%q %s", name, id.data[name].Value, err.Error())
+			delete(id.data, name)
+		}
+	}
+	for _, name := range s.required {
+		if _, ok := id.data[name]; !ok {
+			log.Warnf("The required inventory attribute %s is missing", name)
+		}
+	}
+}
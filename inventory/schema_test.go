@@ -0,0 +1,107 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+	"github.com/mendersoftware/mender/conf"
+)
+
+const testInventorySchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Device inventory",
+  "properties": {
+    "hostname": {"type": "string", "pattern": "^[a-z0-9-]+$", "maxLength": 16},
+    "role": {"enum": ["gateway", "sensor"]},
+    "tags": {"type": "array", "maxItems": 2, "items": {"minLength": 1}}
+  },
+  "patternProperties": {
+    "^ipv4_": {"pattern": "^[0-9./]+$"}
+  },
+  "additionalProperties": {"type": "string"},
+  "required": ["hostname", "serial"]
+}`
+
+func TestInventorySchema(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	schemaFile := path.Join(tmpDir, "schema.json")
+	require.NoError(t, ioutil.WriteFile(schemaFile, []byte(testInventorySchema), 0600))
+
+	schema, err := loadInventorySchema(schemaFile)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		attr  client.InventoryAttribute
+		valid bool
+	}{
+		{client.InventoryAttribute{Name: "hostname", Value: "device-1"}, true},
+		{client.InventoryAttribute{Name: "hostname", Value: "Device 1"}, false},
+		{client.InventoryAttribute{Name: "hostname", Value: "a-very-long-hostname"}, false},
+		{client.InventoryAttribute{Name: "hostname", Value: []string{"a", "b"}}, false},
+		{client.InventoryAttribute{Name: "role", Value: "sensor"}, true},
+		{client.InventoryAttribute{Name: "role", Value: "printer"}, false},
+		{client.InventoryAttribute{Name: "tags", Value: []string{"a", "b"}}, true},
+		{client.InventoryAttribute{Name: "tags", Value: []string{"a", "b", "c"}}, false},
+		{client.InventoryAttribute{Name: "tags", Value: []string{"a", ""}}, false},
+		{client.InventoryAttribute{Name: "tags", Value: "a"}, false},
+		{client.InventoryAttribute{Name: "ipv4_eth0", Value: "10.0.0.2/24"}, true},
+		{client.InventoryAttribute{Name: "ipv4_eth0", Value: "garbage"}, false},
+		{client.InventoryAttribute{Name: "other", Value: "value"}, true},
+		{client.InventoryAttribute{Name: "other", Value: []string{"a", "b"}}, false},
+	} {
+		err := schema.validateAttribute(tc.attr)
+		if tc.valid {
+			assert.NoError(t, err, tc.attr)
+		} else {
+			assert.Error(t, err, tc.attr)
+		}
+	}
+
+	for _, invalid := range []string{
+		`[]`,
+		`{"type": "number"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"properties": {"a": {"format": "ipv4"}}}`,
+		`{"enum": [1]}`,
+	} {
+		require.NoError(t, ioutil.WriteFile(schemaFile, []byte(invalid), 0600))
+		_, err = loadInventorySchema(schemaFile)
+		assert.Error(t, err, invalid)
+	}
+
+	require.NoError(t, ioutil.WriteFile(schemaFile, []byte(testInventorySchema), 0600))
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, inventoryToolPrefix+"test"),
+		[]byte("#!/bin/sh\necho hostname=device-1\necho role=printer\n"+
+			"echo tags=a\necho tags=b\n"), 0755))
+	config := conf.NewMenderConfig()
+	config.InventorySchemaFile = schemaFile
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.SetBuiltinProviders(config)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "hostname", Value: "device-1"},
+		{Name: "tags", Value: []string{"a", "b"}},
+	}, data)
+}
@@ -0,0 +1,369 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/system"
+	"github.com/mendersoftware/mender/utils"
+)
+
+// Inventory tools get the version of the protocol the client supports in
+// MENDER_INVENTORY_PROTOCOL. Those using version 2 write JSON documents,
+// see toolDocument, and the others lines of key=value, or JSON, see
+// parseJSONInventory.
+//
+// With protocol 2, what a tool writes to stderr is logged as errors, and its
+// exit code means:
+//   - 0: its attributes are submitted
+//   - 75 (EX_TEMPFAIL): it failed, but its attributes from its last
+//     successful run are still valid, and are submitted
+//   - anything else: it failed, and it has no attributes
+const (
+	inventoryProtocolEnv     = "MENDER_INVENTORY_PROTOCOL"
+	inventoryProtocolVersion = "2"
+	inventoryProtocolV2      = "mender-inventory/2"
+	inventoryExitKeepLast    = 75
+
+	// Only so much of stderr is kept until it is logged.
+	maxToolStderr = 64 * 1024
+)
+
+// toolDocument is the output of a protocol 2 tool, e.g.
+//
+//	{"protocol": "mender-inventory/2", "attributes": {"app_version": "1.2"},
+//	 "refresh_interval_seconds": 3600}
+//
+// attributes are flattened like any JSON output. The tool is run again after
+// refresh_interval_seconds, unless InventoryIntervalSeconds has one for it.
+// If stream is set, the tool keeps running, and writes a new document
+// whenever its attributes change, each replacing the previous one. It is
+// restarted if it exits.
+type toolDocument struct {
+	Protocol               string          `json:"protocol"`
+	Attributes             json.RawMessage `json:"attributes,omitempty"`
+	RefreshIntervalSeconds int             `json:"refresh_interval_seconds,omitempty"`
+	Stream                 bool            `json:"stream,omitempty"`
+}
+
+// parseToolDocument returns the document, if the value is one.
+func parseToolDocument(raw json.RawMessage) (*toolDocument, bool) {
+	var doc toolDocument
+	if err := json.Unmarshal(raw, &doc); err != nil || doc.Protocol != inventoryProtocolV2 {
+		return nil, false
+	}
+	return &doc, true
+}
+
+func (doc *toolDocument) attributes() (map[string][]string, error) {
+	if len(doc.Attributes) == 0 || string(doc.Attributes) == "null" {
+		return map[string][]string{}, nil
+	}
+	return parseJSONInventory(doc.Attributes)
+}
+
+// toolOutput is the parsed output of a tool.
+type toolOutput struct {
+	attrs map[string][]string
+	// The last document of a protocol 2 tool.
+	doc *toolDocument
+	// Where the following documents of a streaming tool come from.
+	decoder *json.Decoder
+}
+
+// parseToolOutput parses the output of an inventory tool. Unless it streams,
+// all of it is read.
+func parseToolOutput(out *bufio.Reader) (*toolOutput, error) {
+	if !startsWithJSON(out) {
+		p := utils.KeyValParser{}
+		if err := p.Parse(out); err != nil {
+			return nil, err
+		}
+		return &toolOutput{attrs: p.Collect()}, nil
+	}
+
+	decoder := json.NewDecoder(out)
+	decoder.UseNumber()
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	doc, ok := parseToolDocument(raw)
+	if !ok {
+		if decoder.More() {
+			return nil, errors.New("invalid JSON: more than one value")
+		}
+		attrs, err := parseJSONInventory(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &toolOutput{attrs: attrs}, nil
+	}
+
+	// Only the last document counts.
+	for !doc.Stream && decoder.More() {
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, errors.Wrap(err, "invalid JSON")
+		}
+		if doc, ok = parseToolDocument(raw); !ok {
+			return nil, errors.New("a document is not of " + inventoryProtocolV2)
+		}
+	}
+	attrs, err := doc.attributes()
+	if err != nil {
+		return nil, err
+	}
+	return &toolOutput{attrs: attrs, doc: doc, decoder: decoder}, nil
+}
+
+// startsWithJSON tells whether the output is an object or array, without
+// consuming it.
+func startsWithJSON(out *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		peeked, err := out.Peek(n)
+		if len(peeked) < n {
+			return false
+		}
+		switch peeked[n-1] {
+		case ' ', '\t', '\r', '\n':
+			if err != nil {
+				return false
+			}
+			continue
+		case '{', '[':
+			return true
+		}
+		return false
+	}
+}
+
+// toolResult is what a run of a tool gives.
+type toolResult struct {
+	attrs map[string][]string
+	// The interval the tool declared, if any.
+	interval time.Duration
+	// The attributes of the last successful run are to be used.
+	keepLast bool
+	// If the tool keeps running.
+	stream *toolStream
+}
+
+// runTool runs the inventory tool and returns its attributes. The tool, and
+// the processes it started, are killed if it runs longer than the timeout,
+// unless it streams. Errors are logged here.
+func (id *InventoryDataRunner) runTool(t string, timeout time.Duration) (toolResult, error) {
+	cmd := id.cmd.Command(t)
+	cmd.Env = append(os.Environ(), inventoryProtocolEnv+"="+inventoryProtocolVersion)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Errorf("Failed to open stdout for inventory tool %s: %v", t, err)
+		return toolResult{}, err
+	}
+	stderr := &toolStderr{tool: t}
+	cmd.Stderr = stderr
+	// In a process group of its own, so that it can be killed with its
+	// children, without killing the client. A streaming tool must not
+	// outlive the client.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Inventory tool %s failed with status: %v", t, err)
+		return toolResult{}, err
+	}
+
+	var timedOut int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	defer timer.Stop()
+
+	output, parseErr := parseToolOutput(bufio.NewReader(out))
+	if parseErr == nil && output.doc != nil && output.doc.Stream &&
+		timer.Stop() {
+		stderr.setLive()
+		stream := &toolStream{
+			attrs: output.attrs,
+			done:  make(chan struct{}),
+		}
+		go stream.follow(t, cmd, output.decoder)
+		return toolResult{
+			attrs:  output.attrs,
+			stream: stream,
+		}, nil
+	}
+
+	if parseErr != nil {
+		// What is left is of no use, and the tool may be blocked writing
+		// it.
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	err = cmd.Wait()
+	protocol2 := output != nil && output.doc != nil
+	stderr.flush(protocol2)
+	if atomic.LoadInt32(&timedOut) != 0 {
+		log.Errorf("Inventory tool %s did not finish within %s, and was killed", t, timeout)
+		return toolResult{}, errors.Errorf("inventory tool %s timed out", t)
+	}
+	if parseErr != nil {
+		log.Warnf("Inventory tool %s returned unparsable output: %v", t, parseErr)
+		return toolResult{}, parseErr
+	}
+
+	if !protocol2 {
+		if err != nil {
+			log.Warnf("Inventory tool %s wait failed: %v", t, err)
+		}
+		return toolResult{attrs: output.attrs}, nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok &&
+		exitErr.ExitCode() == inventoryExitKeepLast {
+		log.Warnf("Inventory tool %s failed, keeping its attributes from its last run", t)
+		return toolResult{keepLast: true}, nil
+	} else if err != nil {
+		log.Errorf("Inventory tool %s failed: %v", t, err)
+		return toolResult{}, err
+	}
+	return toolResult{
+		attrs:    output.attrs,
+		interval: time.Duration(output.doc.RefreshIntervalSeconds) * time.Second,
+	}, nil
+}
+
+// toolStream holds the latest attributes of a streaming tool.
+type toolStream struct {
+	lock  sync.Mutex
+	attrs map[string][]string
+	// Closed when the tool has exited.
+	done chan struct{}
+}
+
+// attributes returns the latest attributes, and whether the tool is still
+// running.
+func (s *toolStream) attributes() (map[string][]string, bool) {
+	s.lock.Lock()
+	attrs := s.attrs
+	s.lock.Unlock()
+	select {
+	case <-s.done:
+		return attrs, false
+	default:
+		return attrs, true
+	}
+}
+
+// follow reads the documents of the tool until it exits.
+func (s *toolStream) follow(t string, cmd *system.Cmd, decoder *json.Decoder) {
+	defer close(s.done)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Streaming inventory tool %s returned invalid JSON: %v", t, err)
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			break
+		}
+		doc, ok := parseToolDocument(raw)
+		if !ok {
+			log.Errorf("Streaming inventory tool %s returned a document which is not of %s",
+				t, inventoryProtocolV2)
+			continue
+		}
+		attrs, err := doc.attributes()
+		if err != nil {
+			log.Errorf("Streaming inventory tool %s returned invalid attributes: %v", t, err)
+			continue
+		}
+		s.lock.Lock()
+		s.attrs = attrs
+		s.lock.Unlock()
+	}
+	err := cmd.Wait()
+	log.Warnf("Streaming inventory tool %s exited: %v", t, err)
+}
+
+// toolStderr collects what a tool writes to stderr, which is logged as
+// errors for protocol 2 tools, and only for debugging for the others.
+type toolStderr struct {
+	tool string
+	lock sync.Mutex
+	buf  bytes.Buffer
+	// Whether lines are logged as soon as they are written, for streaming
+	// tools.
+	live bool
+}
+
+func (s *toolStderr) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.buf.Len() < maxToolStderr {
+		s.buf.Write(p)
+	}
+	if s.live {
+		s.logLines(log.ErrorLevel, false)
+	}
+	return len(p), nil
+}
+
+func (s *toolStderr) setLive() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.live = true
+	s.logLines(log.ErrorLevel, false)
+}
+
+// flush logs all that was written, once the tool has exited.
+func (s *toolStderr) flush(protocol2 bool) {
+	level := log.DebugLevel
+	if protocol2 {
+		level = log.ErrorLevel
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.logLines(level, true)
+}
+
+// logLines logs the complete lines written, and what is left if all is set.
+func (s *toolStderr) logLines(level log.Level, all bool) {
+	for {
+		line, err := s.buf.ReadString('\n')
+		if err != nil {
+			// No newline, put it back unless it is all.
+			if all && line != "" {
+				log.StandardLogger().Logf(level, "Inventory tool %s: %s", s.tool, line)
+			} else if line != "" {
+				s.buf.WriteString(line)
+			}
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		log.StandardLogger().Logf(level, "Inventory tool %s: %s", s.tool, line)
+	}
+}
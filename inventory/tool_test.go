@@ -0,0 +1,139 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender/client"
+)
+
+func writeTool(t *testing.T, dir, name, script string) {
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, inventoryToolPrefix+name),
+		[]byte("#!/bin/sh\n"+script), 0755))
+}
+
+func TestInventoryProtocol2(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// Fails with 75 from its second run on, or 1 once told to.
+	writeTool(t, tmpDir, "app", `
+runs=$(cat `+tmpDir+`/runs 2>/dev/null || echo 0)
+echo $((runs + 1)) > `+tmpDir+`/runs
+if [ -e `+tmpDir+`/fail ]; then
+	echo '{"protocol": "mender-inventory/2", "attributes": {"partial": "yes"}}'
+	echo "broken" >&2
+	exit 1
+fi
+echo '{"protocol": "mender-inventory/2", "attributes": {"stale": "yes"}}'
+echo '{"protocol": "mender-inventory/2",
+	"attributes": {"app": {"version": "1.2"}, "protocol": "'$MENDER_INVENTORY_PROTOCOL'"}}'
+if [ $runs -gt 0 ]; then
+	echo "temporary failure" >&2
+	exit 75
+fi
+`)
+	writeTool(t, tmpDir, "hourly", `
+echo x >> `+tmpDir+`/hourly.runs
+echo '{"protocol": "mender-inventory/2", "attributes": {"hourly": "'$(wc -l < `+tmpDir+`/hourly.runs)'"},
+	"refresh_interval_seconds": 3600}'
+`)
+
+	now := time.Now()
+
+	inventory := NewInventoryDataRunner(tmpDir)
+	inventory.now = func() time.Time { return now }
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "app_version", Value: "1.2"},
+		{Name: "protocol", Value: "2"},
+		{Name: "hourly", Value: "1"},
+	}, data)
+
+	// The attributes of the last run are kept, and the interval declared
+	// is respected.
+	now = now.Add(time.Minute)
+	data, err = inventory.Get()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, client.InventoryData{
+		{Name: "app_version", Value: "1.2"},
+		{Name: "protocol", Value: "2"},
+		{Name: "hourly", Value: "1"},
+	}, data)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, ioutil.WriteFile(path.Join(tmpDir, "fail"), nil, 0600))
+	data, err = inventory.Get()
+	require.NoError(t, err)
+	assert.Equal(t, client.InventoryData{{Name: "hourly", Value: "2"}}, data)
+}
+
+func TestInventoryProtocol2Stream(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// Writes a document whenever the value file changes, until it is
+	// removed.
+	value := path.Join(tmpDir, "value")
+	require.NoError(t, ioutil.WriteFile(value, []byte("1"), 0600))
+	writeTool(t, tmpDir, "stream", `
+last=
+while [ -e `+value+` ]; do
+	current=$(cat `+value+`)
+	if [ "$current" != "$last" ]; then
+		echo '{"protocol": "mender-inventory/2", "stream": true,
+			"attributes": {"value": "'$current'"}}'
+		last=$current
+	fi
+	sleep 0.1
+done
+`)
+
+	inventory := NewInventoryDataRunner(tmpDir)
+	data, err := inventory.Get()
+	require.NoError(t, err)
+	assert.Equal(t, client.InventoryData{{Name: "value", Value: "1"}}, data)
+	stream := inventory.streams[path.Join(tmpDir, inventoryToolPrefix+"stream")]
+	require.NotNil(t, stream)
+
+	require.NoError(t, ioutil.WriteFile(value, []byte("2"), 0600))
+	assert.Eventually(t, func() bool {
+		data, err = inventory.Get()
+		return err == nil && assert.ObjectsAreEqual(
+			client.InventoryData{{Name: "value", Value: "2"}}, data)
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// Once it exits, it is run again.
+	require.NoError(t, os.Remove(value))
+	select {
+	case <-stream.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the streaming tool did not exit")
+	}
+	data, err = inventory.Get()
+	require.NoError(t, err)
+	assert.Empty(t, data)
+	assert.Empty(t, inventory.streams)
+}
@@ -31,8 +31,9 @@ var termSignalChan = make(chan os.Signal, 1)
 func init() {
 	// SIGUSR1 forces an update check.
 	// SIGUSR2 forces an inventory update.
+	// SIGHUP forces a new authorization.
 	// SIGTERM marks the exit.
-	signal.Notify(cli.SignalHandlerChan, syscall.SIGUSR1, syscall.SIGUSR2)
+	signal.Notify(cli.SignalHandlerChan, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
 	signal.Notify(termSignalChan, syscall.SIGTERM)
 }
 
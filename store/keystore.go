@@ -17,17 +17,54 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"strings"
 
 	"github.com/mendersoftware/openssl"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/mender/utils"
 )
 
 const (
 	RsaKeyLength = 3072
+	// Smallest RSA key length accepted by SetKeyLength.
+	MinRsaKeyLength = 2048
+)
+
+// Where the device key is kept.
+const (
+	KeyBackendFile     = "file"
+	KeyBackendTPM2     = "tpm2"
+	KeyBackendATECC608 = "atecc608"
+)
+
+// Types of keys generated. By default RSA keys are generated, except in a
+// TPM, see generateTPM2.
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeED25519 = "ed25519"
+)
+
+// Curves of the ECDSA keys generated.
+const (
+	KeyCurveP256 = "P-256"
+	KeyCurveP384 = "P-384"
+	KeyCurveP521 = "P-521"
 )
 
+var keyCurves = map[string]openssl.EllipticCurve{
+	KeyCurveP256: openssl.Prime256v1,
+	KeyCurveP384: openssl.Secp384r1,
+	KeyCurveP521: openssl.Secp521r1,
+}
+
+// RotationKeySuffix is appended to the name of the key, to store the key
+// which is to replace it.
+const RotationKeySuffix = ".rotation"
+
 var (
 	errNoKeys    = errors.New("no keys")
 	errNoEngines = errors.New("no engines loaded")
@@ -41,6 +78,23 @@ type Keystore struct {
 	keyPassphrase string
 	sslEngine     string
 	staticKey     bool
+	backend       string
+	keyType       string
+	keyCurve      string
+	keyLength     int
+	fipsMode      bool
+	// Command printing the passphrase, used instead of keyPassphrase.
+	passphraseCommand []string
+	passphrase        *string
+	// The stored form of keys which can't be marshaled, such as TPM keys.
+	blob []byte
+	// The tool generating keys in the TPM, see generateTPM2, and the one
+	// writing keys to PKCS#11 tokens, see ExportToPKCS11.
+	tpm2GenKeyCommand string
+	p11toolCommand    string
+	// Load keys from OpenSSL engines, see loadPKCS11.
+	engineByID           func(string) (*openssl.Engine, error)
+	engineLoadPrivateKey func(*openssl.Engine, string) (openssl.PrivateKey, error)
 }
 
 func (k *Keystore) GetStore() Store {
@@ -68,25 +122,158 @@ func NewKeystore(
 		sslEngine:     sslEngine,
 		staticKey:     static,
 		keyPassphrase: passphrase,
+
+		tpm2GenKeyCommand: defaultTPM2GenKeyCommand,
+		p11toolCommand:    defaultP11toolCommand,
+
+		engineByID:           openssl.EngineById,
+		engineLoadPrivateKey: openssl.EngineLoadPrivateKey,
 	}
 }
 
-func (k *Keystore) Load() error {
-	if strings.HasPrefix(k.keyName, "pkcs11:") {
-		engine, err := openssl.EngineById(k.sslEngine)
-		if err != nil {
-			log.Errorf("Failed to Load '%s' engine. Err %s",
-				k.sslEngine, err.Error())
-			return errNoEngines
+// SetKeyBackend selects where the key is generated and kept, one of the
+// KeyBackend values. Empty is the same as KeyBackendFile.
+func (k *Keystore) SetKeyBackend(backend string) error {
+	switch backend {
+	case "", KeyBackendFile:
+		k.backend = ""
+	case KeyBackendTPM2:
+		k.backend = backend
+	case KeyBackendATECC608:
+		if err := validateATECC608Key(k.keyName); err != nil {
+			return err
 		}
+		k.backend = backend
+		k.staticKey = true
+	default:
+		return errors.Errorf("unsupported KeyBackend %q", backend)
+	}
+	return nil
+}
 
-		k.private, err = openssl.EngineLoadPrivateKey(engine, k.keyName)
-		if err != nil {
-			log.Errorf("Failed to Load private key from engine '%s'. Err %s",
-				k.sslEngine, err.Error())
-			return errNoKeys
+// SetKeyType selects the type of keys generated, one of the KeyType values.
+// Empty leaves the choice to the backend.
+func (k *Keystore) SetKeyType(keyType string) error {
+	switch keyType {
+	case "", KeyTypeRSA, KeyTypeECDSA, KeyTypeED25519:
+		k.keyType = keyType
+	default:
+		return errors.Errorf("unsupported KeyType %q", keyType)
+	}
+	return nil
+}
+
+// SetKeyCurve selects the curve of generated ECDSA keys, one of the KeyCurve
+// values. Empty is the same as KeyCurveP256.
+func (k *Keystore) SetKeyCurve(curve string) error {
+	if curve == "" {
+		k.keyCurve = ""
+		return nil
+	}
+	curve = strings.ToUpper(curve)
+	if _, ok := keyCurves[curve]; !ok {
+		return errors.Errorf("unsupported KeyCurve %q, use one of %q, %q or %q",
+			curve, KeyCurveP256, KeyCurveP384, KeyCurveP521)
+	}
+	k.keyCurve = curve
+	return nil
+}
+
+// SetKeyLength selects the length in bits of generated RSA keys. Zero is the
+// same as RsaKeyLength.
+func (k *Keystore) SetKeyLength(bits int) error {
+	if bits != 0 && bits < MinRsaKeyLength {
+		return errors.Errorf("KeyLength %d is too short, it must be at least %d",
+			bits, MinRsaKeyLength)
+	}
+	k.keyLength = bits
+	return nil
+}
+
+// SetFIPSMode restricts the keys which are generated, or loaded, to those
+// approved by FIPS 140. A stored key of any other type fails to load.
+func (k *Keystore) SetFIPSMode(enabled bool) error {
+	if enabled && k.keyType == KeyTypeED25519 {
+		return errors.New("KeyType \"ed25519\" is not allowed in FIPS mode")
+	}
+	k.fipsMode = enabled
+	return nil
+}
+
+func (k *Keystore) curve() string {
+	if k.keyCurve == "" {
+		return KeyCurveP256
+	}
+	return k.keyCurve
+}
+
+func (k *Keystore) rsaKeyLength() int {
+	if k.keyLength == 0 {
+		return RsaKeyLength
+	}
+	return k.keyLength
+}
+
+// SetPassphraseCommand has the passphrase of the key read from the output of
+// the command, instead of from the passphrase file given to NewKeystore.
+func (k *Keystore) SetPassphraseCommand(command []string) {
+	k.passphraseCommand = command
+	k.passphrase = nil
+}
+
+// getPassphrase returns the passphrase of the key, empty if there is none.
+// It is only read once, since it may come from stdin.
+func (k *Keystore) getPassphrase() (string, error) {
+	if k.passphrase != nil {
+		return *k.passphrase, nil
+	}
+	var passphrase string
+	var err error
+	if len(k.passphraseCommand) > 0 {
+		passphrase, err = runPassphraseCommand(k.passphraseCommand)
+	} else {
+		passphrase, err = loadPassphrase(k.keyPassphrase)
+	}
+	if err != nil {
+		return "", err
+	}
+	k.passphrase = &passphrase
+	return passphrase, nil
+}
+
+func (k *Keystore) Load() error {
+	if err := k.load(); err != nil {
+		return err
+	}
+	if k.fipsMode {
+		if err := k.checkFIPSKey(); err != nil {
+			log.Errorf("Refusing to use the device key: %s", err)
+			k.private, k.blob = nil, nil
+			return err
 		}
 	}
+	return nil
+}
+
+// checkFIPSKey returns an error unless the key is approved by FIPS 140.
+func (k *Keystore) checkFIPSKey() error {
+	der, err := k.private.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the public key")
+	}
+	return utils.CheckFIPSPublicKey(der)
+}
+
+func (k *Keystore) load() error {
+	switch k.backend {
+	case KeyBackendTPM2:
+		return k.loadTPM2()
+	case KeyBackendATECC608:
+		return k.loadPKCS11(k.atecc608Engine())
+	}
+	if strings.HasPrefix(k.keyName, "pkcs11:") {
+		return k.loadPKCS11(k.sslEngine)
+	}
 	inf, err := k.store.OpenRead(k.keyName)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -97,9 +284,9 @@ func (k *Keystore) Load() error {
 	}
 	defer inf.Close()
 
-	passphrase, err := loadPassphrase(k.keyPassphrase)
+	passphrase, err := k.getPassphrase()
 	if err != nil {
-		log.Errorf("Failed to load passphrase-file parameter: %s", err)
+		log.Errorf("Failed to load passphrase: %s", err)
 		return err
 	}
 
@@ -112,18 +299,57 @@ func (k *Keystore) Load() error {
 	return nil
 }
 
+// loadPKCS11 loads the key with the PKCS#11 URI of the keystore through the
+// given OpenSSL engine.
+func (k *Keystore) loadPKCS11(engineID string) error {
+	engine, err := k.engineByID(engineID)
+	if err != nil {
+		log.Errorf("Failed to Load '%s' engine. Err %s",
+			engineID, err.Error())
+		return errNoEngines
+	}
+
+	key, err := k.engineLoadPrivateKey(engine, k.keyName)
+	if err != nil {
+		log.Errorf("Failed to Load private key from engine '%s'. Err %s",
+			engineID, err.Error())
+		return errNoKeys
+	}
+	k.private = key
+	return nil
+}
+
 func (k *Keystore) Save() error {
 	if k.private == nil {
 		return errNoKeys
 	}
 
+	var passphrase string
+	if k.blob == nil {
+		var err error
+		if passphrase, err = k.getPassphrase(); err != nil {
+			log.Errorf("Failed to load passphrase: %s", err)
+			return err
+		}
+	}
+
 	outf, err := k.store.OpenWrite(k.keyName)
 	if err != nil {
 		return err
 	}
 	defer outf.Close()
 
-	err = saveToPem(outf, k.private)
+	if k.blob != nil {
+		_, err = outf.Write(k.blob)
+	} else if passphrase != "" {
+		// the key is only ever decrypted in memory
+		var data []byte
+		if data, err = encryptToPem(k.private, passphrase); err == nil {
+			_, err = outf.Write(data)
+		}
+	} else {
+		err = saveToPem(outf, k.private)
+	}
 	if err != nil {
 		// make sure to close the file
 		return err
@@ -138,7 +364,20 @@ func (k *Keystore) Generate() error {
 		return errStaticKey
 	}
 
-	key, err := openssl.GenerateRSAKey(RsaKeyLength)
+	if k.backend == KeyBackendTPM2 {
+		return k.generateTPM2()
+	}
+
+	var key openssl.PrivateKey
+	var err error
+	switch k.keyType {
+	case KeyTypeECDSA:
+		key, err = openssl.GenerateECKey(keyCurves[k.curve()])
+	case KeyTypeED25519:
+		key, err = openssl.GenerateED25519Key()
+	default:
+		key, err = openssl.GenerateRSAKey(k.rsaKeyLength())
+	}
 	if err != nil {
 		return err
 	}
@@ -148,6 +387,66 @@ func (k *Keystore) Generate() error {
 	return nil
 }
 
+// RotationKeystore returns a keystore, with the same settings, for the key
+// which is to replace this key when it is rotated. Static keys can't be
+// rotated.
+func (k *Keystore) RotationKeystore() (*Keystore, error) {
+	if k.staticKey {
+		return nil, errStaticKey
+	}
+	rotation := *k
+	rotation.keyName = k.keyName + RotationKeySuffix
+	rotation.private = nil
+	rotation.blob = nil
+	return &rotation, nil
+}
+
+// Replace makes the key of other the key of this keystore, and removes it
+// from other. The stored key is replaced atomically, so a crash leaves
+// either the old or the new key in place.
+func (k *Keystore) Replace(other *Keystore) error {
+	if other.private == nil {
+		return errNoKeys
+	}
+	private, blob := k.private, k.blob
+	k.private, k.blob = other.private, other.blob
+	if err := k.Save(); err != nil {
+		k.private, k.blob = private, blob
+		return err
+	}
+	if err := other.store.Remove(other.keyName); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove the replaced key: %s", err)
+	}
+	other.private, other.blob = nil, nil
+	return nil
+}
+
+// Reload loads the key again, in case another process has replaced it.
+// The current key is kept if loading fails. Tells whether the key changed.
+func (k *Keystore) Reload() (bool, error) {
+	if k.staticKey {
+		// only generated keys are replaced
+		return false, nil
+	}
+	private, blob := k.private, k.blob
+	if err := k.Load(); err != nil {
+		k.private, k.blob = private, blob
+		return false, err
+	}
+	if private == nil {
+		return k.private != nil, nil
+	}
+	before, err := private.MarshalPKIXPublicKeyPEM()
+	if err != nil {
+		return false, err
+	}
+	after, err := k.private.MarshalPKIXPublicKeyPEM()
+	if err != nil {
+		return false, err
+	}
+	return string(before) != string(after), nil
+}
+
 func (k *Keystore) Private() openssl.PrivateKey {
 	return k.private
 }
@@ -172,7 +471,26 @@ func (k *Keystore) PublicPEM() (string, error) {
 	return string(data), err
 }
 
+// Sign signs the data. If the key is in a token, such as a smartcard or a
+// TPM, and signing fails, the key is loaded again and signing retried once,
+// since the session with the token is lost when it is removed and inserted
+// again, or its slot is reset.
 func (k *Keystore) Sign(data []byte) ([]byte, error) {
+	sig, err := k.sign(data)
+	if err == nil || !k.inEngine() {
+		return sig, err
+	}
+
+	log.Warnf("Failed to sign with the device key, loading it again: %s", err.Error())
+	private, blob := k.private, k.blob
+	if lerr := k.Load(); lerr != nil {
+		k.private, k.blob = private, blob
+		return nil, errors.Wrapf(err, "failed to load the key again (%s)", lerr.Error())
+	}
+	return k.sign(data)
+}
+
+func (k *Keystore) sign(data []byte) ([]byte, error) {
 	method := openssl.SHA256_Method
 	if k.private.KeyType() == openssl.KeyTypeED25519 {
 		method = nil
@@ -180,6 +498,11 @@ func (k *Keystore) Sign(data []byte) ([]byte, error) {
 	return k.private.SignPKCS1v15(method, data)
 }
 
+// inEngine tells whether the key is used through an OpenSSL engine.
+func (k *Keystore) inEngine() bool {
+	return k.backend != "" || strings.HasPrefix(k.keyName, "pkcs11:")
+}
+
 func IsNoKeys(e error) bool {
 	return e == errNoKeys
 }
@@ -218,6 +541,19 @@ func saveToPem(out io.Writer, key openssl.PrivateKey) error {
 	return err
 }
 
+func runPassphraseCommand(command []string) (string, error) {
+	log.Debugf("Read passphrase from command %q.", command[0])
+	out, err := exec.Command(command[0], command[1:]...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", errors.Wrapf(err, "passphrase command failed: %s",
+				strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", errors.Wrap(err, "passphrase command failed")
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
 func loadPassphrase(passphrase_file string) (passphrase string, err error) {
 	var fi *os.File
 	var dat []byte
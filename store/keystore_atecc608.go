@@ -0,0 +1,44 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package store
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ATECC608 secure elements are used through the PKCS#11 module of Microchip's
+// cryptoauthlib, and the OpenSSL PKCS#11 engine. The key is generated in a
+// slot of the element when it is provisioned, and never leaves it, so it is
+// always static.
+const atecc608DefaultEngine = "pkcs11"
+
+func validateATECC608Key(keyName string) error {
+	if !strings.HasPrefix(keyName, "pkcs11:") {
+		return errors.Errorf("KeyBackend %q needs AuthPrivateKey to be the "+
+			"PKCS#11 URI of the key in the secure element, not %q",
+			KeyBackendATECC608, keyName)
+	}
+	return nil
+}
+
+// atecc608Engine returns the OpenSSL engine through which the key in the
+// secure element is loaded.
+func (k *Keystore) atecc608Engine() string {
+	if k.sslEngine == "" {
+		return atecc608DefaultEngine
+	}
+	return k.sslEngine
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package store
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+)
+
+var (
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// PKCS#10 CertificationRequestInfo, with the parts which are already encoded
+// as they are.
+type certificationRequestInfo struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes asn1.RawValue
+}
+
+type certificationRequest struct {
+	Info      asn1.RawValue
+	Algorithm pkix.AlgorithmIdentifier
+	Signature asn1.BitString
+}
+
+// CertificateRequest returns a DER encoded PKCS#10 certificate request for
+// the key, with the common name as the subject. It is made here, rather than
+// with crypto/x509, since the key may be in an engine, and can only be used
+// through Sign.
+func (k *Keystore) CertificateRequest(commonName string) ([]byte, error) {
+	if k.private == nil {
+		return nil, errNoKeys
+	}
+
+	var algorithm pkix.AlgorithmIdentifier
+	switch k.private.KeyType() {
+	case openssl.KeyTypeRSA:
+		algorithm.Algorithm = oidSHA256WithRSA
+		algorithm.Parameters = asn1.NullRawValue
+	case openssl.KeyTypeEC:
+		algorithm.Algorithm = oidECDSAWithSHA256
+	case openssl.KeyTypeED25519:
+		algorithm.Algorithm = oidEd25519
+	default:
+		return nil, errors.New("certificate requests can't be made for the type of the key")
+	}
+
+	publicKey, err := k.private.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the public key")
+	}
+	subject, err := asn1.Marshal(pkix.Name{CommonName: commonName}.ToRDNSequence())
+	if err != nil {
+		return nil, err
+	}
+	info, err := asn1.Marshal(certificationRequestInfo{
+		Subject:   asn1.RawValue{FullBytes: subject},
+		PublicKey: asn1.RawValue{FullBytes: publicKey},
+		// No attributes, an empty [0] IMPLICIT SET.
+		Attributes: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := k.Sign(info)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign the certificate request")
+	}
+	return asn1.Marshal(certificationRequest{
+		Info:      asn1.RawValue{FullBytes: info},
+		Algorithm: algorithm,
+		Signature: asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+}
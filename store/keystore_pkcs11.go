@@ -0,0 +1,120 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package store
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Keys are written to, and generated in, PKCS#11 tokens with p11tool from
+// GnuTLS, since neither OpenSSL nor its PKCS#11 engine can do it. The PIN of
+// the token is taken from the GNUTLS_PIN environment variable.
+
+const defaultP11toolCommand = "p11tool"
+
+// p11toolKeyCurves are the p11tool names of the curves.
+var p11toolKeyCurves = map[string]string{
+	KeyCurveP256: "secp256r1",
+	KeyCurveP384: "secp384r1",
+	KeyCurveP521: "secp521r1",
+}
+
+// PKCS11KeyURI returns the URI of the private key with the given label in
+// the token.
+func PKCS11KeyURI(tokenURI, label string) string {
+	uri := strings.TrimSuffix(tokenURI, ";")
+	if uri != "pkcs11:" {
+		uri += ";"
+	}
+	return uri + "object=" + url.PathEscape(label) + ";type=private"
+}
+
+func (k *Keystore) runP11tool(tokenURI string, args ...string) error {
+	if !strings.HasPrefix(tokenURI, "pkcs11:") {
+		return errors.Errorf("%q is not a PKCS#11 URI", tokenURI)
+	}
+	args = append([]string{"--login"}, append(args, tokenURI)...)
+	out, err := exec.Command(k.p11toolCommand, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s failed: %s", k.p11toolCommand,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ExportToPKCS11 writes the key, which must be kept in a file, to the
+// PKCS#11 token, under the label, and returns the URI of the key in the
+// token. The key is marked sensitive, so it can't be read back from the
+// token.
+func (k *Keystore) ExportToPKCS11(tokenURI, label string) (string, error) {
+	if k.private == nil {
+		return "", errNoKeys
+	}
+	if k.inEngine() || k.blob != nil {
+		return "", errors.New("only keys kept in files can be exported")
+	}
+
+	dir, err := ioutil.TempDir("", "mender-pkcs11")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "key")
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = saveToPem(f, k.private)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = k.runP11tool(tokenURI, "--write", "--mark-sensitive", "--mark-private",
+		"--label", label, "--load-privkey", file)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to write the key to the PKCS#11 token")
+	}
+	return PKCS11KeyURI(tokenURI, label), nil
+}
+
+// GenerateInPKCS11 has the PKCS#11 token generate a key of the configured
+// type, under the label, and returns the URI of the key in the token.
+func (k *Keystore) GenerateInPKCS11(tokenURI, label string) (string, error) {
+	var args []string
+	switch k.keyType {
+	case KeyTypeECDSA:
+		args = []string{"--generate-privkey", "ECDSA", "--curve", p11toolKeyCurves[k.curve()]}
+	case KeyTypeED25519:
+		args = []string{"--generate-privkey", "Ed25519"}
+	default:
+		args = []string{"--generate-privkey", "RSA", "--bits", strconv.Itoa(k.rsaKeyLength())}
+	}
+	err := k.runP11tool(tokenURI, append(args, "--mark-sensitive", "--mark-private",
+		"--label", label)...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate a key in the PKCS#11 token")
+	}
+	return PKCS11KeyURI(tokenURI, label), nil
+}
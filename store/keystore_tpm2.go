@@ -0,0 +1,135 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+)
+
+// TPM 2.0 keys are used through the OpenSSL engine of tpm2-tss-engine, and
+// generated with its tool. The key is generated inside the TPM, and what is
+// stored is a blob which only the same TPM can use.
+const (
+	tpm2Engine = "tpm2tss"
+	// Keys made persistent in the TPM, with tpm2_evictcontrol, are
+	// referred to by their handle instead of by a blob.
+	tpm2PersistentHandlePrefix = "0x81"
+
+	defaultTPM2GenKeyCommand = "tpm2tss-genkey"
+)
+
+func loadTPM2Key(id string) (openssl.PrivateKey, error) {
+	engine, err := openssl.EngineById(tpm2Engine)
+	if err != nil {
+		return nil, errors.Wrap(err, "TPM 2.0 support is not available")
+	}
+	key, err := openssl.EngineLoadPrivateKey(engine, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the key from the TPM")
+	}
+	return key, nil
+}
+
+// loadTPM2Blob loads a key from a blob. The engine only reads blobs from
+// files, which isn't a problem since the blob is encrypted by the TPM.
+func loadTPM2Blob(blob []byte) (openssl.PrivateKey, error) {
+	f, err := ioutil.TempFile("", "mender-tpm2-key")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(blob)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadTPM2Key(f.Name())
+}
+
+func (k *Keystore) loadTPM2() error {
+	if strings.HasPrefix(k.keyName, tpm2PersistentHandlePrefix) {
+		key, err := loadTPM2Key(k.keyName)
+		if err != nil {
+			return err
+		}
+		k.private = key
+		return nil
+	}
+
+	blob, err := k.store.ReadAll(k.keyName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errNoKeys
+		}
+		return err
+	}
+	key, err := loadTPM2Blob(blob)
+	if err != nil {
+		return err
+	}
+	k.private, k.blob = key, blob
+	return nil
+}
+
+// tpm2KeyCurves are the tpm2tss-genkey names of the curves.
+var tpm2KeyCurves = map[string]string{
+	KeyCurveP256: "nist_p256",
+	KeyCurveP384: "nist_p384",
+	KeyCurveP521: "nist_p521",
+}
+
+// generateTPM2 has the TPM generate an ECDSA key, P-256 unless another curve
+// is chosen, which every TPM 2.0 supports, unlike RSA keys of the size used
+// for software keys. RSA keys are only generated if asked for.
+func (k *Keystore) generateTPM2() error {
+	args := []string{"-a", "ecdsa", "-c", tpm2KeyCurves[k.curve()]}
+	switch k.keyType {
+	case KeyTypeED25519:
+		return errors.New("a TPM 2.0 can't generate Ed25519 keys")
+	case KeyTypeRSA:
+		args = []string{"-a", "rsa", "-s", strconv.Itoa(k.rsaKeyLength())}
+	}
+	dir, err := ioutil.TempDir("", "mender-tpm2")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "key")
+
+	out, err := exec.Command(k.tpm2GenKeyCommand, append(args, file)...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to generate a key in the TPM: %s",
+			strings.TrimSpace(string(out)))
+	}
+	blob, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	key, err := loadTPM2Key(file)
+	if err != nil {
+		return err
+	}
+	k.private, k.blob = key, blob
+	return nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mendersoftware/openssl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sealed entries start with this, followed by the nonce and the AES-256-GCM
+// sealed data.
+var sealedEntryMagic = []byte("mender-sealed-1\n")
+
+// Mixed into the key derived from the device key, so that it isn't the same
+// as anything else derived from it.
+const sealingKeyLabel = "mender sealed store"
+
+var errSealedEntry = errors.New("sealed entries can only be read and written whole")
+
+// SealingKey derives an AES-256 key from the device key. When the key is kept
+// in a TPM or a secure element, so is the derived key, in the sense that it
+// can't be derived on any other device, even from a copy of the storage. The
+// key is derived with ECDH, of the key with itself, for EC keys, and from a
+// signature of a fixed message otherwise, which is deterministic for RSA
+// and Ed25519 keys.
+func (k *Keystore) SealingKey() ([]byte, error) {
+	if k.private == nil {
+		return nil, errNoKeys
+	}
+	var secret []byte
+	var err error
+	if k.private.KeyType() == openssl.KeyTypeEC {
+		secret, err = openssl.DeriveSharedSecret(k.private, k.private)
+	} else {
+		secret, err = k.Sign([]byte(sealingKeyLabel))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive a key from the device key")
+	}
+	hash := sha256.New()
+	hash.Write([]byte(sealingKeyLabel))
+	hash.Write(secret)
+	return hash.Sum(nil), nil
+}
+
+// SealedStore encrypts the named entries of a store with a key derived from
+// the device key, see Keystore.SealingKey, so that they are only useful on the
+// device which wrote them. Other entries are passed through as they are.
+//
+// Entries written before sealing was enabled are read as they are, and sealed
+// when read. Entries which can't be decrypted, because they were sealed on
+// another device, or with an earlier device key, are treated as missing.
+type SealedStore struct {
+	Store
+	keyStore *Keystore
+	names    map[string]bool
+
+	lock sync.Mutex
+	// The key, and the device key it was derived from.
+	key    []byte
+	keyFor openssl.PrivateKey
+}
+
+func NewSealedStore(s Store, keyStore *Keystore, names ...string) *SealedStore {
+	sealed := &SealedStore{
+		Store:    s,
+		keyStore: keyStore,
+		names:    map[string]bool{},
+	}
+	for _, name := range names {
+		sealed.names[name] = true
+	}
+	if !keyStore.inEngine() {
+		log.Warn("The device key is not kept in hardware, so the authorization " +
+			"state is only as safe as the key file.")
+	}
+	return sealed
+}
+
+// aead returns the cipher for the current device key, deriving the key when
+// the device key has changed.
+func (s *SealedStore) aead() (cipher.AEAD, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	private := s.keyStore.Private()
+	if s.key == nil || private != s.keyFor {
+		key, err := s.keyStore.SealingKey()
+		if err != nil {
+			return nil, err
+		}
+		s.key, s.keyFor = key, private
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *SealedStore) seal(name string, data []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, sealedEntryMagic...), nonce...)
+	return aead.Seal(sealed, nonce, data, []byte(name)), nil
+}
+
+// unseal tells whether the data was sealed, so that data which wasn't can
+// be sealed.
+func (s *SealedStore) unseal(name string, data []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, sealedEntryMagic) {
+		return data, false, nil
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, true, err
+	}
+	data = data[len(sealedEntryMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, true, errors.Errorf("sealed entry %q is truncated", name)
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():],
+		[]byte(name))
+	if err != nil {
+		log.Warnf("Ignoring %q, which can't be decrypted with the device key. "+
+			"Either it was written on another device, or with an earlier key.", name)
+		return nil, true, os.ErrNotExist
+	}
+	return plain, true, nil
+}
+
+func (s *SealedStore) readAll(txn Transaction, name string) ([]byte, bool, error) {
+	data, err := txn.ReadAll(name)
+	if err != nil || !s.names[name] {
+		return data, true, err
+	}
+	return s.unseal(name, data)
+}
+
+func (s *SealedStore) writeAll(txn Transaction, name string, data []byte) error {
+	if s.names[name] {
+		var err error
+		if data, err = s.seal(name, data); err != nil {
+			return errors.Wrapf(err, "failed to seal %q", name)
+		}
+	}
+	return txn.WriteAll(name, data)
+}
+
+func (s *SealedStore) ReadAll(name string) ([]byte, error) {
+	data, sealed, err := s.readAll(s.Store, name)
+	if err == nil && !sealed {
+		if err := s.writeAll(s.Store, name, data); err != nil {
+			log.Warnf("Failed to seal %q: %s", name, err.Error())
+		}
+	}
+	return data, err
+}
+
+func (s *SealedStore) WriteAll(name string, data []byte) error {
+	return s.writeAll(s.Store, name, data)
+}
+
+func (s *SealedStore) OpenRead(name string) (io.ReadCloser, error) {
+	if s.names[name] {
+		return nil, errSealedEntry
+	}
+	return s.Store.OpenRead(name)
+}
+
+func (s *SealedStore) OpenWrite(name string) (WriteCloserCommitter, error) {
+	if s.names[name] {
+		return nil, errSealedEntry
+	}
+	return s.Store.OpenWrite(name)
+}
+
+func (s *SealedStore) WriteTransaction(txnFunc func(txn Transaction) error) error {
+	return s.Store.WriteTransaction(func(txn Transaction) error {
+		return txnFunc(sealedTransaction{s, txn})
+	})
+}
+
+func (s *SealedStore) ReadTransaction(txnFunc func(txn Transaction) error) error {
+	return s.Store.ReadTransaction(func(txn Transaction) error {
+		return txnFunc(sealedTransaction{s, txn})
+	})
+}
+
+// Reseal runs the function, which replaces the device key, and seals the
+// entries again with the new key. The entries are kept as they were if the
+// function fails.
+func (s *SealedStore) Reseal(replaceKey func() error) error {
+	entries := map[string][]byte{}
+	for name := range s.names {
+		data, _, err := s.readAll(s.Store, name)
+		if err == nil {
+			entries[name] = data
+		} else if err != os.ErrNotExist && !IsNoKeys(err) {
+			log.Warnf("Failed to read %q, it won't be readable with the new "+
+				"device key: %s", name, err.Error())
+		}
+	}
+	if err := replaceKey(); err != nil {
+		return err
+	}
+	for name, data := range entries {
+		if err := s.writeAll(s.Store, name, data); err != nil {
+			log.Errorf("Failed to seal %q with the new device key: %s",
+				name, err.Error())
+		}
+	}
+	return nil
+}
+
+type sealedTransaction struct {
+	s   *SealedStore
+	txn Transaction
+}
+
+func (t sealedTransaction) ReadAll(name string) ([]byte, error) {
+	data, _, err := t.s.readAll(t.txn, name)
+	return data, err
+}
+
+func (t sealedTransaction) WriteAll(name string, data []byte) error {
+	return t.s.writeAll(t.txn, name, data)
+}
+
+func (t sealedTransaction) Remove(name string) error {
+	return t.txn.Remove(name)
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package store
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealedStore(t *testing.T) {
+	for _, keyType := range []string{KeyTypeECDSA, KeyTypeED25519, KeyTypeRSA} {
+		t.Run(keyType, func(t *testing.T) {
+			ms := NewMemStore()
+			k := NewKeystore(ms, "key", "", false, "")
+			require.NoError(t, k.SetKeyType(keyType))
+			require.NoError(t, k.SetKeyLength(MinRsaKeyLength))
+			require.NoError(t, k.Generate())
+
+			key, err := k.SealingKey()
+			require.NoError(t, err)
+			again, err := k.SealingKey()
+			require.NoError(t, err)
+			assert.Equal(t, key, again)
+			assert.Len(t, key, 32)
+
+			s := NewSealedStore(ms, k, "secret")
+			assert.NoError(t, s.WriteAll("secret", []byte("token")))
+			assert.NoError(t, s.WriteAll("public", []byte("value")))
+
+			raw, err := ms.ReadAll("secret")
+			assert.NoError(t, err)
+			assert.False(t, bytes.Contains(raw, []byte("token")))
+			raw, err = ms.ReadAll("public")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("value"), raw)
+
+			data, err := s.ReadAll("secret")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("token"), data)
+
+			assert.NoError(t, s.ReadTransaction(func(txn Transaction) error {
+				data, err := txn.ReadAll("secret")
+				assert.Equal(t, []byte("token"), data)
+				return err
+			}))
+			_, err = s.OpenRead("secret")
+			assert.Error(t, err)
+
+			// An entry copied to another device can't be read.
+			other := NewKeystore(NewMemStore(), "key", "", false, "")
+			require.NoError(t, other.SetKeyType(keyType))
+			require.NoError(t, other.SetKeyLength(MinRsaKeyLength))
+			require.NoError(t, other.Generate())
+			_, err = NewSealedStore(ms, other, "secret").ReadAll("secret")
+			assert.Equal(t, os.ErrNotExist, err)
+
+			// Entries are sealed again when the key is replaced.
+			assert.NoError(t, s.Reseal(k.Generate))
+			data, err = s.ReadAll("secret")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("token"), data)
+
+			// Entries written before sealing are sealed when read.
+			assert.NoError(t, ms.WriteAll("secret", []byte("plain")))
+			data, err = s.ReadAll("secret")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("plain"), data)
+			raw, err = ms.ReadAll("secret")
+			assert.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(raw, sealedEntryMagic))
+		})
+	}
+}
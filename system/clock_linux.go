@@ -0,0 +1,36 @@
+// Copyright 2023 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package system
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// From <sys/timex.h>.
+	timeError = 5
+	staUnsync = 0x0040
+)
+
+// ClockSynchronized tells whether the kernel considers the system clock
+// synchronized, which an NTP daemon, for example, tells it.
+func ClockSynchronized() (bool, error) {
+	var timex unix.Timex
+	state, err := unix.Adjtimex(&timex)
+	if err != nil {
+		return false, err
+	}
+	return state != timeError && timex.Status&staUnsync == 0, nil
+}
@@ -0,0 +1,3 @@
+module github.com/mendersoftware/openssl
+
+go 1.17
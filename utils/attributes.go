@@ -0,0 +1,87 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// AttributeFilter hashes or omits identity and inventory attributes before
+// they are sent to the server. Values are hashed with HMAC-SHA256, so that
+// the same value always gives the same hash, and a device keeps the same
+// identity, as long as the key is the same. A nil filter keeps everything.
+type AttributeFilter struct {
+	hash map[string]bool
+	omit map[string]bool
+	key  []byte
+}
+
+// NewAttributeFilter returns a filter hashing, or omitting, the attributes
+// with the given names, or nil if there are none.
+func NewAttributeFilter(hash, omit []string, key string) *AttributeFilter {
+	if len(hash) == 0 && len(omit) == 0 {
+		return nil
+	}
+	f := &AttributeFilter{
+		hash: map[string]bool{},
+		omit: map[string]bool{},
+		key:  []byte(key),
+	}
+	for _, name := range hash {
+		f.hash[name] = true
+	}
+	for _, name := range omit {
+		f.omit[name] = true
+	}
+	return f
+}
+
+// Filter returns the value to send for the attribute, and false if it is
+// not to be sent at all. The elements of lists are hashed one by one.
+func (f *AttributeFilter) Filter(name string, value interface{}) (interface{}, bool) {
+	if f == nil {
+		return value, true
+	}
+	if f.omit[name] {
+		return nil, false
+	}
+	if !f.hash[name] {
+		return value, true
+	}
+	switch value := value.(type) {
+	case []string:
+		hashed := make([]string, len(value))
+		for i, v := range value {
+			hashed[i] = f.hashValue(v)
+		}
+		return hashed, true
+	case []interface{}:
+		hashed := make([]interface{}, len(value))
+		for i, v := range value {
+			hashed[i] = f.hashValue(fmt.Sprint(v))
+		}
+		return hashed, true
+	default:
+		return f.hashValue(fmt.Sprint(value)), true
+	}
+}
+
+func (f *AttributeFilter) hashValue(value string) string {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributeFilter(t *testing.T) {
+	f := NewAttributeFilter(nil, nil, "key")
+	assert.Nil(t, f)
+	value, ok := f.Filter("mac", "de:ad:be:ef:00:01")
+	assert.True(t, ok)
+	assert.Equal(t, "de:ad:be:ef:00:01", value)
+
+	f = NewAttributeFilter([]string{"mac", "ipv4"}, []string{"hostname"}, "key")
+	_, ok = f.Filter("hostname", "device-1")
+	assert.False(t, ok)
+	value, ok = f.Filter("device_type", "raspberrypi4")
+	assert.True(t, ok)
+	assert.Equal(t, "raspberrypi4", value)
+
+	mac, ok := f.Filter("mac", "de:ad:be:ef:00:01")
+	assert.True(t, ok)
+	assert.Len(t, mac, 64)
+	assert.NotContains(t, mac, "de:ad")
+	again, _ := f.Filter("mac", "de:ad:be:ef:00:01")
+	assert.Equal(t, mac, again)
+	other, _ := NewAttributeFilter([]string{"mac"}, nil, "other").
+		Filter("mac", "de:ad:be:ef:00:01")
+	assert.NotEqual(t, mac, other)
+
+	list, _ := f.Filter("ipv4", []string{"10.0.0.1/24", "de:ad:be:ef:00:01"})
+	assert.Equal(t, []string{f.hashValue("10.0.0.1/24"), mac.(string)}, list)
+	list, _ = f.Filter("ipv4", []interface{}{"de:ad:be:ef:00:01"})
+	assert.Equal(t, []interface{}{mac}, list)
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+// FIPSMinRSAKeyLength is the shortest RSA key approved by FIPS 186-4.
+const FIPSMinRSAKeyLength = 2048
+
+// ErrNotFIPSApproved is the cause of errors about algorithms, or keys, which
+// are not allowed in FIPS mode.
+var ErrNotFIPSApproved = errors.New("not approved in FIPS mode")
+
+// CheckFIPSPublicKey returns an error unless the DER encoded
+// SubjectPublicKeyInfo holds a key of a type and size approved by FIPS 140:
+// RSA of at least FIPSMinRSAKeyLength bits, or ECDSA on one of the NIST
+// curves P-256, P-384 or P-521.
+func CheckFIPSPublicKey(der []byte) error {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the public key")
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < FIPSMinRSAKeyLength {
+			return errors.Wrapf(ErrNotFIPSApproved, "RSA key of %d bits, "+
+				"at least %d are needed", bits, FIPSMinRSAKeyLength)
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return errors.Wrapf(ErrNotFIPSApproved, "ECDSA key on curve %s",
+				key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		return errors.Wrap(ErrNotFIPSApproved, "Ed25519 key")
+	default:
+		return errors.Wrapf(ErrNotFIPSApproved, "%T key", key)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFIPSPublicKey(t *testing.T) {
+	marshal := func(key interface{}) []byte {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		require.NoError(t, err)
+		return der
+	}
+
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	assert.NoError(t, CheckFIPSPublicKey(marshal(&rsa2048.PublicKey)))
+
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	err = CheckFIPSPublicKey(marshal(&rsa1024.PublicKey))
+	assert.Equal(t, ErrNotFIPSApproved, errors.Cause(err))
+	assert.Contains(t, err.Error(), "1024 bits")
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	assert.NoError(t, CheckFIPSPublicKey(marshal(&p384.PublicKey)))
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	err = CheckFIPSPublicKey(marshal(edKey))
+	assert.Equal(t, ErrNotFIPSApproved, errors.Cause(err))
+
+	assert.Error(t, CheckFIPSPublicKey([]byte("not a key")))
+}